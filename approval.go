@@ -0,0 +1,45 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+)
+
+// Environment variables which pass to the task binary the webhook notified of
+// the approvals waited for by the tasks, and the token to give them.
+const (
+	ENV_APPROVAL_WEBHOOK = "GAKE_APPROVAL_WEBHOOK"
+	ENV_APPROVAL_TOKEN   = "GAKE_APPROVAL_TOKEN"
+)
+
+// approvalEnv returns the environment which sets the approvals of the tasks,
+// set into the table "approval" of the configuration:
+//
+//	[approval]
+//	webhook = "https://chat.example.com/hooks/deploys" # notified by a POST
+//	token_env = "GAKE_APPROVAL_TOKEN"                   # token to approve at the dashboard
+func approvalEnv(cfg *config) ([]string, error) {
+	var env []string
+
+	if webhook := cfg.Get("approval", "webhook"); webhook != "" {
+		if u, err := url.Parse(webhook); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, fmt.Errorf("%s: invalid approval.webhook %q: want an HTTP URL", cfg.path, webhook)
+		}
+		env = append(env, ENV_APPROVAL_WEBHOOK+"="+webhook)
+	}
+	if name := cfg.Get("approval", "token_env"); name != "" {
+		token := os.Getenv(name)
+		if token == "" {
+			return nil, fmt.Errorf("%s: approval.token_env: the variable %s is not set", cfg.path, name)
+		}
+		env = append(env, ENV_APPROVAL_TOKEN+"="+token)
+	}
+	return env, nil
+}
@@ -0,0 +1,179 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// SOURCE_FILE is the name of the file, beside a kept binary, which has the
+// directory of its task files.
+const SOURCE_FILE = "source"
+
+var cmdBin = &command{
+	Name:      "bin",
+	UsageLine: "list | path [dir]",
+	Short:     "manage the kept task binaries",
+	Long: `Bin manages the task binaries kept by the flag -keep into the cache of gake.
+
+"gake bin list" prints every kept binary with the directory of its task files,
+its size and the time when it was built. The directory of the binaries built by
+older versions of gake is unknown, shown as "?".
+
+"gake bin path" prints the path of the binary kept for the task files into the
+directory, by default the current one, so that it can be run directly or
+shipped. It fails if there is not such binary, and it warns if the binary was
+built by other versions of gake or Go than the current ones.`,
+	Run: runBin,
+}
+
+func runBin(cmd *command, args []string) error {
+	fs := cmd.FlagSet()
+	fs.Parse(args)
+
+	home, err := gakeHome()
+	if err != nil {
+		return err
+	}
+
+	switch fs.Arg(0) {
+	case "list":
+		if fs.NArg() > 1 {
+			fs.Usage()
+			os.Exit(EXIT_INFRA)
+		}
+		return listBinaries(home)
+	case "path":
+		dir := "."
+		if fs.NArg() > 2 {
+			fs.Usage()
+			os.Exit(EXIT_INFRA)
+		} else if fs.NArg() == 2 {
+			dir = fs.Arg(1)
+		}
+		if dir, err = resolveDir(dir); err != nil {
+			return err
+		}
+		cmdPath, err := cachedBinaryPath(home, dir)
+		if err != nil {
+			return err
+		}
+		if _, err = os.Stat(cmdPath); err != nil {
+			if os.IsNotExist(err) {
+				return fmt.Errorf("no binary kept for %s; build it with \"gake -keep\"", dir)
+			}
+			return err
+		}
+		warnStaleVersions(cmdPath, dir)
+		fmt.Println(cmdPath)
+		return nil
+	case "":
+		fs.Usage()
+		os.Exit(EXIT_INFRA)
+	}
+	return fmt.Errorf("unknown subcommand %q: want list or path", fs.Arg(0))
+}
+
+// keptBinary represents a binary kept into the cache.
+type keptBinary struct {
+	path   string
+	source string // Directory of the task files.
+	size   int64
+	built  time.Time
+}
+
+// listBinaries prints the binaries kept into home, sorted by their source.
+func listBinaries(home string) error {
+	entries, err := os.ReadDir(home)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	binName := BIN_NAME
+	if runtime.GOOS == "windows" {
+		binName += ".exe"
+	}
+
+	bins := make([]keptBinary, 0)
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		dir := filepath.Join(home, e.Name())
+		bin := filepath.Join(dir, binName)
+		info, err := os.Stat(bin)
+		if err != nil {
+			continue // Not a kept binary, like the data of the tasks.
+		}
+
+		source := "?"
+		if data, err := os.ReadFile(filepath.Join(dir, SOURCE_FILE)); err == nil {
+			source = strings.TrimSpace(string(data))
+		}
+		bins = append(bins, keptBinary{bin, source, info.Size(), info.ModTime()})
+	}
+	sort.Slice(bins, func(i, j int) bool { return bins[i].source < bins[j].source })
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "SOURCE\tSIZE\tBUILT\tBINARY")
+	for _, b := range bins {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", b.source, formatSize(b.size),
+			b.built.Format("2006-01-02 15:04:05"), b.path)
+	}
+	return w.Flush()
+}
+
+// warnStaleVersions warns when the binary kept at cmdPath for the task files in
+// dir was built by other versions of gake or Go than the ones which would build
+// it now, since running it directly would not rebuild it.
+func warnStaleVersions(cmdPath, dir string) {
+	info, ok, err := readBuildInfo(cmdPath)
+	if err != nil || !ok {
+		return
+	}
+	cfg, err := loadConfig(dir)
+	if err != nil {
+		return
+	}
+	goCmd, err := goTool(cfg)
+	if err != nil {
+		return
+	}
+	if reason := staleVersions(info, newBuildInfo(goCmd)); reason != "" {
+		fmt.Fprintf(os.Stderr, "gake bin: warning: %s: %s; it is rebuilt by the next run of gake\n", cmdPath, reason)
+	}
+}
+
+// writeSource records the directory of the task files beside the kept binary.
+func writeSource(cmdPath, dir string) error {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(filepath.Dir(cmdPath), SOURCE_FILE), []byte(absDir+"\n"), 0644)
+}
+
+// formatSize returns the size in bytes with a binary unit, like "2.5M".
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%c", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,46 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ENV_BUDGETS is the environment variable which passes the time budgets of the
+// groups of tasks to the task binary.
+const ENV_BUDGETS = "GAKE_BUDGETS"
+
+// budgetsEnv returns the environment which sets the time budgets of the groups
+// of tasks, declared by the directive "gake:group", set into the table "budgets"
+// of the configuration:
+//
+//	[budgets]
+//	lint = "2m"
+//	e2e = "20m"
+func budgetsEnv(cfg *config) ([]string, error) {
+	groups := cfg.Keys("budgets")
+	if len(groups) == 0 {
+		return nil, nil
+	}
+	sort.Strings(groups)
+
+	budgets := make([]string, 0, len(groups))
+	for _, g := range groups {
+		v := cfg.Get("budgets", g)
+		if d, err := time.ParseDuration(v); err != nil || d <= 0 {
+			return nil, fmt.Errorf("%s: invalid budgets.%s %q: want a positive duration, like \"2m\"", cfg.path, g, v)
+		}
+		if strings.ContainsAny(g, "=,") {
+			return nil, fmt.Errorf("%s: invalid group name %q into budgets", cfg.path, g)
+		}
+		budgets = append(budgets, g+"="+v)
+	}
+	return []string{ENV_BUDGETS + "=" + strings.Join(budgets, ",")}, nil
+}
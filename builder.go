@@ -7,29 +7,85 @@
 package main
 
 import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"hash/adler32"
+	"io"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"runtime"
+	"sort"
+	"strconv"
+	"strings"
 	"text/template"
+	"time"
 )
 
-// BuildAndRun uses the tool "go build" to compile the task files to file "cmdPath".
-func BuildAndRun(pkg *taskPackage, cmdPath string) error {
-	file, err := os.CreateTemp("", "gake-")
+// BUILD_LOG is the name of the file, into the output directory, where the build
+// is logged when the flag -buildlog is set.
+const BUILD_LOG = "gake-build.log"
+
+// BuildAndRun uses the tool "go build" to compile the task files to file "cmdPath",
+// and runs it like Run. The binary is built into a temporary
+// directory unless it has to be kept or the flag -c is set.
+//
+// The errors previous to run the binary are of type InfraError.
+func BuildAndRun(pkg *taskPackage, cmdPath string, keep bool, stdin io.Reader, stdout, stderr io.Writer) error {
+	workDir, err := newWorkDir(pkg.GoCmd, pkg.Dir)
 	if err != nil {
-		return err
+		return infraError(INFRA_BUILD, err)
+	}
+	xtrace("WORK=%s", workDir)
+
+	defer func() {
+		xtrace("rm -r $WORK")
+		os.RemoveAll(workDir)
+	}()
+
+	if !*taskC && !keep {
+		cmdPath = workDir + string(os.PathSeparator) + BIN_NAME
+		if runtime.GOOS == "windows" {
+			cmdPath += ".exe"
+		}
 	}
-	workDir := file.Name()
 
-	defer os.RemoveAll(workDir)
+	start := time.Now()
+	err = buildPackage(pkg, workDir, cmdPath, stderr)
+	timePhase(PHASE_BUILDING, start)
+	if err != nil {
+		return infraError(INFRA_BUILD, err)
+	}
+	xtrace("# build: %v", time.Since(start).Round(time.Millisecond))
+	if !*taskC && keep {
+		if err = writeSource(cmdPath, pkg.Dir); err != nil {
+			return infraError(INFRA_CACHE, err)
+		}
+		if err = writeBuildInfo(cmdPath, pkg.BuildInfo); err != nil {
+			return infraError(INFRA_CACHE, err)
+		}
+	}
+	return Run(cmdPath, pkg.Args, pkg.Env, stdin, stdout, stderr)
+}
 
+// buildPackage compiles the package to cmdPath, into the work directory.
+func buildPackage(pkg *taskPackage, workDir, cmdPath string, stderr io.Writer) error {
 	// Copy all files to the temporary directory.
 	for _, f := range pkg.Files {
 		src, err := os.ReadFile(f.Name)
 		if err != nil {
 			return err
 		}
+		xtrace("cp %s $WORK%c%s", f.Name, os.PathSeparator, filepath.Base(f.Name))
+		if pkg.Name != "main" {
+			if src, err = renameToMain(f.Name, src); err != nil {
+				return err
+			}
+		}
 		err = os.WriteFile(workDir+string(os.PathSeparator)+filepath.Base(f.Name), src, 0644)
 		if err != nil {
 			return err
@@ -37,80 +93,477 @@ func BuildAndRun(pkg *taskPackage, cmdPath string) error {
 	}
 
 	// Write the main file.
+	if *taskReproducible {
+		// The source files are given by their name, like with -trimpath.
+		for i := range pkg.Files {
+			for j := range pkg.Files[i].TaskFuncs {
+				task := &pkg.Files[i].TaskFuncs[j]
+				task.File = filepath.Base(task.File)
+			}
+		}
+	}
+	xtrace("cat >$WORK%cmain_.go << 'EOF' # internal", os.PathSeparator)
 	f, err := os.Create(workDir + string(os.PathSeparator) + "main_.go")
 	if err != nil {
 		return err
 	}
 	defer f.Close()
-	if err = taskmainTmpl.Execute(f, pkg); err != nil {
+	tmpl, err := mainTemplate()
+	if err != nil {
+		return err
+	}
+	if err = tmpl.Execute(f, pkg); err != nil {
 		return err
 	}
 
 	// == Build
-	if !*taskC && !*taskKeepBinary {
-		cmdPath = workDir + string(os.PathSeparator) + BIN_NAME
-		if runtime.GOOS == "windows" {
-			cmdPath += ".exe"
-		}
+	cmd := exec.Command(pkg.GoCmd, "build", "--tags", "gake", "-o", cmdPath)
+	if *taskMod != "" {
+		cmd.Args = append(cmd.Args, "-mod="+*taskMod)
 	}
-
-	cmd := new(exec.Cmd)
-	if !*taskX {
-		cmd = exec.Command("go", "build", "--tags", "gake", "-o", cmdPath)
-	} else {
-		cmd = exec.Command("go", "build", "--tags", "gake", "-o", cmdPath, "-x")
+	if *taskReproducible {
+		cmd.Args = append(cmd.Args, reproducibleArgs(pkg.BuildInfo.GoVersion)...)
+	}
+	if overlay, err := writeOverlay(pkg, workDir); err != nil {
+		return err
+	} else if overlay != "" {
+		cmd.Args = append(cmd.Args, "-overlay="+overlay)
+	}
+	if *taskVendor {
+		args, err := vendorTasking(pkg, workDir)
+		if err != nil {
+			return err
+		}
+		cmd.Args = append(cmd.Args, args...)
 	}
 	cmd.Dir = workDir
-	cmd.Stderr = os.Stderr
+	cmd.Env = goCacheEnv(pkg.GoCache)
+	cmd.Stderr = stderr
+	if pkg.GoCache != "" {
+		xtrace("GOCACHE=%s", pkg.GoCache)
+	}
+	xtrace("cd $WORK\n%s", strings.Join(cmd.Args, " "))
+	start := time.Now()
+	defer func() {
+		xtrace("# go build: %v", time.Since(start).Round(time.Millisecond))
+	}()
+
+	if *taskBuildLog {
+		cmd.Args = append(cmd.Args, "-x")
 
-	if err = cmd.Run(); err != nil {
+		logFile, err := createBuildLog(pkg.BuildLog, cmd)
+		if err != nil {
+			return err
+		}
+		defer logFile.Close()
+		cmd.Stdout = logFile
+		cmd.Stderr = logFile
+
+		if err = cmd.Run(); err != nil {
+			return fmt.Errorf("%s; see build log in %s", err, logFile.Name())
+		}
+	} else if err = cmd.Run(); err != nil {
 		return err
 	}
 	// ==
 
-	Run(cmdPath)
 	return nil
 }
 
-func Run(path string) {
-	if *taskC {
-		return
+// renameToMain returns the source of a task file of an external package, like
+// "foo_tasks", declaring the package "main" so that it is built as a command.
+// The position of the rest of the code is not changed.
+func renameToMain(filename string, src []byte) ([]byte, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filename, src, parser.PackageClauseOnly)
+	if err != nil {
+		return nil, err
 	}
-	cmd := exec.Command(path, getTaskArgs()...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Run()
-}
+	start := fset.Position(file.Name.Pos()).Offset
+	end := fset.Position(file.Name.End()).Offset
 
-var taskmainTmpl = template.Must(template.New("main").Parse(`
-package main
+	out := make([]byte, 0, len(src))
+	out = append(out, src[:start]...)
+	out = append(out, "main"...)
+	return append(out, src[end:]...), nil
+}
 
-import (
-	"regexp"
+// writeOverlay writes into the work directory the overlay for the go command
+// which hides the task files got by hiddenTaskFiles. It returns the path of the
+// overlay, or "" if there is no file to hide.
+func writeOverlay(pkg *taskPackage, workDir string) (string, error) {
+	files, err := hiddenTaskFiles(pkg)
+	if err != nil || len(files) == 0 {
+		return "", err
+	}
+	return overlayFiles(files, workDir)
+}
 
-	"github.com/tredoe/gake/tasking"
-)
+// overlayFiles writes into the directory the overlay which replaces the files
+// by a file ignored by the build. It returns the path of the overlay.
+func overlayFiles(files []string, dir string) (string, error) {
+	// The directories starting with '_' are ignored by the go tool.
+	hiddenDir := filepath.Join(dir, "_overlay")
+	if err := os.MkdirAll(hiddenDir, 0755); err != nil {
+		return "", err
+	}
+	// The package clause of a file excluded by its build constraints is not
+	// checked against the one of its directory.
+	hidden := filepath.Join(hiddenDir, "ignored.go")
+	if err := os.WriteFile(hidden, []byte("// +build ignore\n\npackage ignored\n"), 0644); err != nil {
+		return "", err
+	}
 
-var tasks = []tasking.InternalTask{
-{{range $_, $f := .Files}}{{range $f.TaskFuncs}}
-	{"{{.Name}}", {{.Name}}},{{end}}{{end}}
+	replace := make(map[string]string, len(files))
+	for _, name := range files {
+		replace[name] = hidden
+	}
+	data, err := json.Marshal(struct{ Replace map[string]string }{replace})
+	if err != nil {
+		return "", err
+	}
+	overlay := filepath.Join(hiddenDir, "overlay.json")
+	xtrace("cat >%s << 'EOF' # internal", overlay)
+	return overlay, os.WriteFile(overlay, data, 0644)
 }
 
-var matchPat string
-var matchRe *regexp.Regexp
+// hiddenTaskFiles returns the task files which the tag "gake" would add to the
+// packages of the main module built with the tasks: the ones of an external
+// package, like "foo_tasks", into the directory of the package "foo", and the
+// ones into the directories of the packages imported by the tasks, directly or
+// not, which are got through "go list -deps". Since the go tool does not list
+// the imports of a directory with files of several packages, the packages are
+// listed again, with the files found hidden, until no directory is added.
+//
+// The files are found once, and kept into the package.
+func hiddenTaskFiles(pkg *taskPackage) ([]string, error) {
+	if pkg.Hidden != nil {
+		return pkg.Hidden, nil
+	}
+	imports, err := taskImports(pkg)
+	if err != nil {
+		return nil, err
+	}
+	tmpDir, err := os.MkdirTemp("", "gake-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	hidden := make([]string, 0)
+	seen := make(map[string]bool) // Directories whose files are checked.
+	dirs := []string{pkg.Dir}
+	if pkg.Name == "main" {
+		// The task files are the package built.
+		seen[pkg.Dir] = true
+		dirs = nil
+	}
+	for {
+		for _, dir := range dirs {
+			seen[dir] = true
+			files, err := taskFilesIn(dir)
+			if err != nil {
+				return nil, err
+			}
+			hidden = append(hidden, files...)
+		}
+
+		args := []string{"list", "-e", "-deps", "-tags", "gake",
+			"-f", "{{if .Module}}{{if .Module.Main}}{{.Dir}}{{end}}{{end}}"}
+		if *taskMod != "" {
+			args = append(args, "-mod="+*taskMod)
+		}
+		if len(hidden) != 0 {
+			overlay, err := overlayFiles(hidden, tmpDir)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, "-overlay="+overlay)
+		}
+		cmd := exec.Command(pkg.GoCmd, append(args, imports...)...)
+		cmd.Dir = pkg.Dir
+		cmd.Env = goCacheEnv(pkg.GoCache)
+		var stderr strings.Builder
+		cmd.Stderr = &stderr
+		xtrace("cd %s\n%s", pkg.Dir, strings.Join(cmd.Args, " "))
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", err, strings.TrimSpace(stderr.String()))
+		}
+
+		dirs = nil
+		for _, dir := range strings.Split(string(out), "\n") {
+			if dir != "" && !seen[dir] {
+				dirs = append(dirs, dir)
+			}
+		}
+		if len(dirs) == 0 {
+			break
+		}
+	}
+	sort.Strings(hidden)
+	pkg.Hidden = hidden
+	return hidden, nil
+}
 
-func matchString(pat, str string) (result bool, err error) {
-	if matchRe == nil || matchPat != pat {
-		matchPat = pat
-		matchRe, err = regexp.Compile(matchPat)
+// taskFilesIn returns the absolute paths of the task files into the directory,
+// of the package "main" or of a package with the suffix SUFFIX_TASKPKG.
+func taskFilesIn(dir string) ([]string, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*"+SUFFIX_TASKFILE))
+	if err != nil {
+		return nil, err
+	}
+	files := make([]string, 0, len(names))
+	fset := token.NewFileSet()
+	for _, name := range names {
+		file, err := parser.ParseFile(fset, name, nil, parser.PackageClauseOnly)
 		if err != nil {
-			return
+			continue
+		}
+		if pkgName := file.Name.Name; pkgName != "main" && !strings.HasSuffix(pkgName, SUFFIX_TASKPKG) {
+			continue
+		}
+		if name, err = filepath.Abs(name); err != nil {
+			return nil, err
+		}
+		files = append(files, name)
+	}
+	return files, nil
+}
+
+// newWorkDir creates the temporary directory where the task binary is built.
+// When the task files are into a module, it is created into their directory so
+// the build honors the module's requirements, replacements and vendor directory;
+// else it is created into the system's temporary directory.
+//
+// With the flag -reproducible, its path is always the same, since it is into
+// the binary.
+func newWorkDir(goCmd, dir string) (string, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	cmd := exec.Command(goCmd, "env", "GOMOD")
+	cmd.Dir = dir
+	if out, err := cmd.Output(); err == nil {
+		gomod := strings.TrimSpace(string(out))
+		if gomod != "" && gomod != os.DevNull {
+			if *taskReproducible {
+				return mkdirFixed(filepath.Join(absDir, ".gake-build"))
+			}
+			return os.MkdirTemp(absDir, ".gake-")
+		}
+	}
+	if *taskReproducible {
+		crc := adler32.Checksum([]byte(absDir))
+		return mkdirFixed(filepath.Join(os.TempDir(), "gake-build-"+strconv.FormatUint(uint64(crc), 10)))
+	}
+	return os.MkdirTemp("", "gake-")
+}
+
+// mkdirFixed creates the work directory at path, which fails if it exists since
+// another build would be using it.
+func mkdirFixed(path string) (string, error) {
+	if err := os.Mkdir(path, 0700); err != nil {
+		if os.IsExist(err) {
+			return "", fmt.Errorf("%s exists: another build is running, or it was left by an interrupted one", path)
+		}
+		return "", err
+	}
+	return path, nil
+}
+
+// reproducibleArgs returns the arguments of "go build" which leave out of the
+// binary the paths of the machine and the identifiers of the build, for the
+// flag -reproducible.
+func reproducibleArgs(goVersion string) []string {
+	args := []string{"-trimpath", "-ldflags=-buildid="}
+	// The information of the version control is added since Go 1.18.
+	if minor, ok := goMinor(goVersion); ok && minor >= 18 {
+		args = append(args, "-buildvcs=false")
+	}
+	return args
+}
+
+// packageBuildLog returns the name of the build log of the package with the
+// label, into a run of several ones, like "gake-build-cmd_server.log" for
+// "./cmd/server", so that their builds do not write to the same file. The one
+// of the current directory is "gake-build-_.log".
+func packageBuildLog(label string) string {
+	label = strings.TrimPrefix(filepath.ToSlash(label), "./")
+	if label == "." {
+		label = "_"
+	}
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9', r == '-', r == '.':
+			return r
+		}
+		return '_'
+	}, strings.Trim(label, "/"))
+	return strings.TrimSuffix(BUILD_LOG, ".log") + "-" + name + ".log"
+}
+
+// createBuildLog creates the build log with the name, or BUILD_LOG if it is
+// empty, into the output directory, writing the command to run and the names of
+// the variables of its environment. Their values are not written, since they
+// can hold secrets, like tokens of the cloud, and the output directory is
+// usually uploaded as an artifact of the CI.
+func createBuildLog(name string, cmd *exec.Cmd) (*os.File, error) {
+	if name == "" {
+		name = BUILD_LOG
+	}
+	logFile, err := os.Create(filepath.Join(taskValues.string("outputdir"), name))
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(logFile, "# dir: %s\n# command: %s\n# environment:\n",
+		cmd.Dir, strings.Join(cmd.Args, " "))
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	for _, v := range env {
+		if i := strings.IndexByte(v, '='); i > 0 {
+			v = v[:i]
+		}
+		fmt.Fprintf(logFile, "#\t%s\n", v)
+	}
+	fmt.Fprintf(logFile, "\n")
+
+	return logFile, nil
+}
+
+// Run executes the compiled program at path reading from stdin, if it is not
+// nil, and writing to stdout and stderr, unless the -c flag is set; with the
+// -pty flag, it is run into a pseudo-terminal whose output goes to stdout. The
+// variables of env are added to its environment, and args are its arguments,
+// or the ones of the command line if nil.
+func Run(path string, args, env []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	if *taskC {
+		return nil
+	}
+	if args == nil {
+		args = getTaskArgs()
+	}
+	cmd := exec.Command(path, args...)
+	cmd.Env = taskEnviron(env)
+	if *taskSandbox {
+		if err := sandboxCommand(cmd); err != nil {
+			return infraError(INFRA_CONFIG, err)
+		}
+	}
+	xtrace("%s", strings.Join(cmd.Args, " "))
+
+	// The interrupt of the terminal is got by the task binary too, which stops
+	// its tasks and reports the ones not run, so gake waits for it.
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	defer signal.Stop(sig)
+	defer timePhase(PHASE_RUNNING, time.Now())
+
+	if taskValues.bool("pty") {
+		return runTerminal(cmd, stdin, stdout)
+	}
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	return cmd.Run()
+}
+
+// taskEnviron returns the environment of the task binary: the environment of
+// gake, or only its variables in cleanEnv with the flag -env-clean, followed by
+// the variables of env and of the flag -env. The command line of gake and the
+// names of the variables which it sets are passed too, for the run report.
+func taskEnviron(env []string) []string {
+	var environ []string
+	if *taskEnvClean {
+		for _, v := range os.Environ() {
+			name := v
+			if i := strings.IndexByte(v, '='); i > 0 {
+				name = v[:i]
+			}
+			for _, keep := range cleanEnv {
+				if name == keep || (runtime.GOOS == "windows" && strings.EqualFold(name, keep)) {
+					environ = append(environ, v)
+					break
+				}
+			}
+		}
+		// Directory where the task binary keeps its data.
+		environ = append(environ, ENV_CACHE+"="+os.Getenv(ENV_CACHE))
+	} else {
+		environ = os.Environ()
+	}
+	environ = append(environ, env...)
+
+	// A variable without value is passed from the environment of gake.
+	for _, v := range taskEnv {
+		if strings.IndexByte(v, '=') == -1 {
+			value, ok := os.LookupEnv(v)
+			if !ok {
+				continue
+			}
+			v += "=" + value
 		}
+		environ = append(environ, v)
 	}
-	return matchRe.MatchString(str), nil
+
+	names := []string{ENV_CACHE}
+	for _, v := range env {
+		names = append(names, strings.SplitN(v, "=", 2)[0])
+	}
+	for _, v := range taskEnv {
+		names = append(names, strings.SplitN(v, "=", 2)[0])
+	}
+	return append(environ,
+		ENV_COMMAND+"="+commandLine(append([]string{"gake"}, os.Args[1:]...)),
+		ENV_INJECTED+"="+strings.Join(names, ","),
+	)
+}
+
+// commandLine returns the arguments as a command line, quoting the arguments
+// which are not plain words.
+func commandLine(args []string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		if a == "" || strings.ContainsAny(a, " \t\n\"'\\$`;&|<>*?()[]{}#~") {
+			a = strconv.Quote(a)
+		}
+		quoted[i] = a
+	}
+	return strings.Join(quoted, " ")
+}
+
+// xtrace prints the command line to standard error if the -x flag is set.
+func xtrace(format string, args ...interface{}) {
+	if *taskX {
+		fmt.Fprintf(os.Stderr, format+"\n", args...)
+	}
+}
+
+// taskmainSrc is the default template of the main file of the task binary.
+//
+//go:embed taskmain.tmpl
+var taskmainSrc string
+
+var taskmainFuncs = template.FuncMap{
+	"quote": strconv.Quote,
 }
 
-func main() {
-	tasking.Main(matchString, tasks)
+var taskmainTmpl = template.Must(template.New("main").Funcs(taskmainFuncs).Parse(taskmainSrc))
+
+// mainTemplate returns the template of the main file, which is the file given by
+// the flag -main-template, if any.
+func mainTemplate() (*template.Template, error) {
+	if *taskMainTemplate == "" {
+		return taskmainTmpl, nil
+	}
+	src, err := os.ReadFile(*taskMainTemplate)
+	if err != nil {
+		return nil, err
+	}
+	return template.New(filepath.Base(*taskMainTemplate)).Funcs(taskmainFuncs).Parse(string(src))
 }
-`))
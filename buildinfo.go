@@ -0,0 +1,229 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// BUILDINFO_MARK starts the build information embedded into the task
+	// binaries, which is ended by the byte 0xff.
+	BUILDINFO_MARK = "\xff gake buildinfo:"
+
+	// BUILDINFO_EXT is the extension of the file, beside a kept binary, with
+	// its build information, which is read instead of the binary.
+	BUILDINFO_EXT = ".buildinfo"
+
+	// MAX_BUILDINFO is the maximum size of the build information.
+	MAX_BUILDINFO = 1024
+)
+
+// buildInfo represents the information about how a task binary was built.
+type buildInfo struct {
+	GakeVersion string // Version of gake.
+	GakeSum     string // Hash of the executable of gake, when its version is "(devel)".
+	GoVersion   string // Version of the Go toolchain.
+	Inputs      string // Hash of the inputs of the build; see inputsHash.
+}
+
+// String returns the build information to embed into a task binary.
+func (b buildInfo) String() string {
+	s := fmt.Sprintf("%sgake=%s go=%s inputs=%s", BUILDINFO_MARK, b.GakeVersion, b.GoVersion, b.Inputs)
+	if b.GakeSum != "" {
+		s += " gakesum=" + b.GakeSum
+	}
+	return s + "\xff"
+}
+
+// newBuildInfo returns the information of a binary built by goCmd.
+func newBuildInfo(goCmd string) buildInfo {
+	info := buildInfo{GakeVersion: gakeVersion(), GoVersion: goVersion(goCmd)}
+	if info.GakeVersion == "(devel)" {
+		info.GakeSum = gakeSum()
+	}
+	return info
+}
+
+var (
+	gakeSumOnce  sync.Once
+	gakeSumValue string
+)
+
+// gakeSum returns the hash of the executable of gake, which tells apart its
+// builds without a version, or "" if it can not be read.
+func gakeSum() string {
+	gakeSumOnce.Do(func() {
+		path, err := os.Executable()
+		if err != nil {
+			return
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return
+		}
+		defer f.Close()
+		h := sha256.New()
+		if _, err = io.Copy(h, f); err == nil {
+			gakeSumValue = hex.EncodeToString(h.Sum(nil))[:16]
+		}
+	})
+	return gakeSumValue
+}
+
+// goVersion returns the version of the Go toolchain run by goCmd.
+func goVersion(goCmd string) string {
+	out, err := exec.Command(goCmd, "env", "GOVERSION").Output()
+	if err != nil {
+		return "unknown"
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// goMinor returns the minor number of a Go release like "go1.21.5". It reports
+// false if the version is not of a release.
+func goMinor(version string) (int, bool) {
+	v := strings.TrimPrefix(version, "go1.")
+	if v == version {
+		return 0, false
+	}
+	end := 0
+	for end < len(v) && v[end] >= '0' && v[end] <= '9' {
+		end++
+	}
+	minor, err := strconv.Atoi(v[:end])
+	return minor, err == nil
+}
+
+// readBuildInfo returns the build information of the task binary at path, from
+// the file written beside it when it is kept, or else embedded into it. It
+// reports false if the binary has no build information.
+func readBuildInfo(path string) (buildInfo, bool, error) {
+	var info buildInfo
+
+	if _, err := os.Stat(path); err != nil {
+		return info, false, err
+	}
+	bin, err := os.ReadFile(path + BUILDINFO_EXT)
+	if os.IsNotExist(err) {
+		if bin, err = scanBuildInfo(path); bin == nil {
+			return info, false, err
+		}
+	} else if err != nil {
+		return info, false, err
+	}
+
+	for _, field := range strings.Fields(string(bin)) {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "gake":
+			info.GakeVersion = kv[1]
+		case "go":
+			info.GoVersion = kv[1]
+		case "inputs":
+			info.Inputs = kv[1]
+		case "gakesum":
+			info.GakeSum = kv[1]
+		}
+	}
+	return info, true, nil
+}
+
+// scanBuildInfo returns the build information embedded into the binary at path,
+// without its mark, or nil if there is not. The binary is read by chunks, since
+// it can be large.
+func scanBuildInfo(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	mark := []byte(BUILDINFO_MARK)
+	chunk := make([]byte, 64*1024)
+	var data []byte // Chunk read, after the end of the previous one.
+	for {
+		n, err := f.Read(chunk)
+		data = append(data, chunk[:n]...)
+
+		if i := bytes.Index(data, mark); i != -1 {
+			info := data[i+len(mark):]
+			if j := bytes.IndexByte(info, 0xff); j != -1 {
+				return info[:j], nil
+			}
+			if len(info) > MAX_BUILDINFO {
+				return nil, nil
+			}
+			data = data[i:] // The end of the information is into the next chunk.
+		} else if len(data) >= len(mark) {
+			data = append(data[:0], data[len(data)-len(mark)+1:]...)
+		}
+
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// writeBuildInfo writes the build information beside the kept binary at path.
+func writeBuildInfo(path string, info buildInfo) error {
+	s := strings.TrimSuffix(strings.TrimPrefix(info.String(), BUILDINFO_MARK), "\xff")
+	return os.WriteFile(path+BUILDINFO_EXT, []byte(s+"\n"), 0644)
+}
+
+// isStaleBinary reports whether the task binary at path has to be rebuilt, since
+// it does not exist, or it was built by another version of gake or Go or from
+// other inputs than the ones of want. The rebuilds for other versions are
+// reported, since the binary would run with the behavior of the old ones.
+func isStaleBinary(path string, want buildInfo) bool {
+	info, ok, err := readBuildInfo(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "isStaleBinary(): %s\n", err)
+		}
+		return true
+	}
+
+	if !ok {
+		fmt.Fprintf(os.Stderr, "gake: rebuilding %s: no build information\n", path)
+		return true
+	}
+	if reason := staleVersions(info, want); reason != "" {
+		fmt.Fprintf(os.Stderr, "gake: rebuilding %s: %s\n", path, reason)
+		return true
+	}
+	return info.Inputs != want.Inputs
+}
+
+// staleVersions returns why the binary built as given by info is not of the
+// versions of gake and Go of want, or "" if it is.
+func staleVersions(info, want buildInfo) string {
+	switch {
+	case info.GakeVersion != want.GakeVersion:
+		return fmt.Sprintf("built by gake %s, running gake %s", info.GakeVersion, want.GakeVersion)
+	case info.GakeSum != want.GakeSum:
+		return fmt.Sprintf("built by another build of gake %s", want.GakeVersion)
+	case info.GoVersion != want.GoVersion:
+		return fmt.Sprintf("built by Go %s, building with Go %s", info.GoVersion, want.GoVersion)
+	}
+	return ""
+}
@@ -0,0 +1,59 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// A command is a subcommand of gake, like "gake update". The name of a command
+// has precedence over a directory with the same name; use "./name" to run the
+// tasks into such directory.
+type command struct {
+	Name      string
+	UsageLine string // Arguments of the command.
+	Short     string // Short description shown in the gake help.
+	Long      string // Description shown in the command help.
+
+	// Run runs the command with the arguments after its name.
+	Run func(cmd *command, args []string) error
+}
+
+// commands lists the available commands.
+var commands = []*command{
+	cmdBin,
+	cmdDoctor,
+	cmdEnv,
+	cmdFix,
+	cmdGraph,
+	cmdReplay,
+	cmdUpdate,
+	cmdVet,
+	cmdWarm,
+}
+
+// lookupCommand returns the command with the given name, or nil.
+func lookupCommand(name string) *command {
+	for _, c := range commands {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// FlagSet returns a set of flags for the command, whose usage prints its help.
+func (c *command) FlagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet(c.Name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: gake %s %s\n\n%s\n\n", c.Name, c.UsageLine, c.Long)
+		fs.PrintDefaults()
+	}
+	return fs
+}
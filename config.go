@@ -0,0 +1,250 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// CONFIG_FILE is the name of the configuration file of a task suite. It is
+// searched into the directory of the task files and its parents, until the
+// root of the module.
+const CONFIG_FILE = "gake.toml"
+
+// config represents the configuration of a task suite, written in a subset of
+// TOML: tables, and keys with string, integer, boolean or string array values.
+type config struct {
+	path   string                         // File read; empty if there is none.
+	tables map[string]map[string][]string // Values by table and key; "" is the root table.
+}
+
+// Get returns the value of the key into the table, or the empty string if it
+// is not set. The root table is "".
+func (c *config) Get(table, key string) string {
+	if v := c.tables[table][key]; len(v) != 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// GetList returns the values of the key into the table.
+func (c *config) GetList(table, key string) []string {
+	return c.tables[table][key]
+}
+
+// Keys returns the keys set into the table.
+func (c *config) Keys(table string) []string {
+	keys := make([]string, 0, len(c.tables[table]))
+	for k := range c.tables[table] {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// loadConfig reads the configuration file for the task files in dir; it returns
+// an empty configuration if there is none.
+func loadConfig(dir string) (*config, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for d := absDir; ; d = filepath.Dir(d) {
+		path := filepath.Join(d, CONFIG_FILE)
+		f, err := os.Open(path)
+		if err == nil {
+			defer f.Close()
+			return parseConfig(path, f)
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		// Stop at the root of the module or of the filesystem.
+		if _, err = os.Stat(filepath.Join(d, "go.mod")); err == nil || filepath.Dir(d) == d {
+			break
+		}
+	}
+	return &config{tables: map[string]map[string][]string{}}, nil
+}
+
+// parseConfig parses the configuration read from r, whose file name is path.
+func parseConfig(path string, r io.Reader) (*config, error) {
+	c := &config{path, map[string]map[string][]string{"": {}}}
+	table := ""
+	nLine := 0
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		nLine++
+		line := strings.TrimSpace(stripComment(s.Text()))
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, ConfigError{path, nLine, "unclosed table header"}
+			}
+			table = strings.TrimSpace(line[1 : len(line)-1])
+			if table == "" {
+				return nil, ConfigError{path, nLine, "empty table name"}
+			}
+			if c.tables[table] == nil {
+				c.tables[table] = make(map[string][]string)
+			}
+			continue
+		}
+
+		i := strings.IndexByte(line, '=')
+		if i == -1 {
+			return nil, ConfigError{path, nLine, "want key = value"}
+		}
+		key := strings.TrimSpace(line[:i])
+		if k, err := strconv.Unquote(key); err == nil {
+			key = k
+		}
+		if key == "" {
+			return nil, ConfigError{path, nLine, "empty key"}
+		}
+
+		value, err := parseConfigValue(strings.TrimSpace(line[i+1:]))
+		if err != nil {
+			return nil, ConfigError{path, nLine, err.Error()}
+		}
+		c.tables[table][key] = value
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// parseConfigValue parses a string, integer, boolean or array of strings.
+func parseConfigValue(v string) ([]string, error) {
+	switch {
+	case v == "":
+		return nil, fmt.Errorf("missing value")
+	case strings.HasPrefix(v, "["):
+		if !strings.HasSuffix(v, "]") {
+			return nil, fmt.Errorf("unclosed array")
+		}
+		elems, err := splitConfigArray(v[1 : len(v)-1])
+		if err != nil {
+			return nil, err
+		}
+		values := make([]string, 0, len(elems))
+		for _, elem := range elems {
+			if strings.HasPrefix(elem, "[") {
+				return nil, fmt.Errorf("nested array %s", elem)
+			}
+			s, err := parseConfigValue(elem)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, s...)
+		}
+		return values, nil
+	case strings.HasPrefix(v, `"`) || strings.HasPrefix(v, "'"):
+		if v[0] == '\'' && len(v) > 1 && v[len(v)-1] == '\'' {
+			return []string{v[1 : len(v)-1]}, nil
+		}
+		s, err := strconv.Unquote(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid string %s", v)
+		}
+		return []string{s}, nil
+	case v == "true" || v == "false":
+		return []string{v}, nil
+	default:
+		if _, err := strconv.ParseFloat(strings.Replace(v, "_", "", -1), 64); err != nil {
+			return nil, fmt.Errorf("invalid value %s", v)
+		}
+		return []string{v}, nil
+	}
+}
+
+// splitConfigArray splits the elements of an array, without its brackets, by
+// the commas out of the strings. The last element can be followed by a comma,
+// but the rest can not be empty.
+func splitConfigArray(s string) ([]string, error) {
+	elems := make([]string, 0)
+	quote := byte(0)
+	start := 0
+	for i := 0; i <= len(s); i++ {
+		if i == len(s) {
+			if quote != 0 {
+				return nil, fmt.Errorf("unclosed string %s", s[start:])
+			}
+		} else {
+			c := s[i]
+			if quote != 0 {
+				if c == '\\' && quote == '"' {
+					i++
+				} else if c == quote {
+					quote = 0
+				}
+				continue
+			}
+			if c == '"' || c == '\'' {
+				quote = c
+				continue
+			}
+			if c != ',' {
+				continue
+			}
+		}
+
+		elem := strings.TrimSpace(s[start:i])
+		start = i + 1
+		if elem == "" {
+			if i == len(s) && (len(elems) != 0 || strings.TrimSpace(s) == "") {
+				break // Trailing comma, or empty array.
+			}
+			return nil, fmt.Errorf("empty element in array [%s]", s)
+		}
+		elems = append(elems, elem)
+	}
+	return elems, nil
+}
+
+// stripComment removes the comment, out of the strings, from the line.
+func stripComment(line string) string {
+	quote := byte(0)
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// ConfigError represents an error into the configuration file.
+type ConfigError struct {
+	path string
+	line int
+	msg  string
+}
+
+func (e ConfigError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.path, e.line, e.msg)
+}
@@ -0,0 +1,163 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+var cmdDoctor = &command{
+	Name:      "doctor",
+	UsageLine: "[dir]",
+	Short:     "check that the environment can build and run tasks",
+	Long: `Doctor checks the environment of gake for the task files into the directory,
+by default the current one: the Go toolchain and its version, the directory of
+the kept binaries, the resolution of the module, whether the package tasking
+can be imported, and the optional tools which tasks use often, like docker and
+git. Every problem is printed with a way to fix it.
+
+The exit status is 1 if any required check fails.`,
+	Run: runDoctor,
+}
+
+// MIN_GO_MINOR is the minor number of the oldest Go release which can build the
+// tasks.
+const MIN_GO_MINOR = 16
+
+// doctorCheck is the result of a check of "gake doctor".
+type doctorCheck struct {
+	status string // "ok", "warn" or "FAIL".
+	name   string
+	detail string
+	fix    string // Way to fix a problem.
+}
+
+func runDoctor(cmd *command, args []string) error {
+	fs := cmd.FlagSet()
+	fs.Parse(args)
+
+	dir := "."
+	if fs.NArg() > 0 {
+		dir = fs.Arg(0)
+	}
+
+	checks := make([]doctorCheck, 0)
+	add := func(status, name, detail, fix string) {
+		checks = append(checks, doctorCheck{status, name, detail, fix})
+	}
+
+	goCmd := "go"
+	if cfg, err := loadConfig(dir); err != nil {
+		add("FAIL", "config", err.Error(), "fix the file gake.toml")
+	} else {
+		if cfg.path != "" {
+			add("ok", "config", cfg.path, "")
+		}
+		if goCmd, err = goTool(cfg); err != nil {
+			add("FAIL", "toolchain", err.Error(), "install the release, or remove the key \"toolchain\" of gake.toml")
+		}
+	}
+
+	goOK := false
+	if path, err := exec.LookPath(goCmd); err != nil {
+		add("FAIL", "go", "command not found", "install Go from https://go.dev/dl/ and add its bin directory to PATH")
+	} else if v := goVersion(goCmd); v == "unknown" {
+		add("FAIL", "go", path+": can't get its version", "check the installation of Go with \"go env\"")
+	} else if minor, ok := goMinor(v); ok && minor < MIN_GO_MINOR {
+		add("FAIL", "go", fmt.Sprintf("%s (%s)", v, path), fmt.Sprintf("install Go 1.%d or later", MIN_GO_MINOR))
+	} else {
+		add("ok", "go", fmt.Sprintf("%s (%s)", v, path), "")
+		goOK = true
+	}
+
+	if home, err := gakeHome(); err != nil {
+		add("FAIL", "cache", err.Error(), "set the variable "+ENV_HOME)
+	} else if err = checkWritable(home); err != nil {
+		add("FAIL", "cache", err.Error(), "make the directory writable, or set "+ENV_HOME+" to another directory")
+	} else {
+		add("ok", "cache", home, "")
+	}
+
+	if goOK {
+		gomod, err := goOutput(goCmd, dir, "env", "GOMOD")
+		switch {
+		case err != nil:
+			add("FAIL", "module", err.Error(), "check the directory and the variables GO111MODULE and GOFLAGS")
+		case gomod == "" || gomod == os.DevNull:
+			add("warn", "module", "the directory is not into a module", "run \"go mod init\" to resolve the imports of the tasks")
+		default:
+			if _, err = goOutput(goCmd, dir, "list", "-m"); err != nil {
+				add("FAIL", "module", err.Error(), "fix "+gomod+", or run \"go mod tidy\"")
+			} else {
+				add("ok", "module", gomod, "")
+			}
+		}
+
+		tasking := MODULE_PATH + "/tasking"
+		if _, err = goOutput(goCmd, dir, "list", "-tags", "gake", tasking); err != nil {
+			add("FAIL", "tasking", err.Error(),
+				"run \"go get "+tasking+"\", or build with -vendor-tasking to use the package embedded into gake")
+		} else {
+			add("ok", "tasking", "importable", "")
+		}
+	}
+
+	for _, tool := range []string{"git", "docker"} {
+		if path, err := exec.LookPath(tool); err != nil {
+			add("warn", tool, "not found; only needed by the tasks which run it", "install "+tool+" if the tasks use it")
+		} else {
+			add("ok", tool, path, "")
+		}
+	}
+
+	failed := 0
+	for _, c := range checks {
+		fmt.Printf("%-4s  %-9s %s\n", c.status, c.name, c.detail)
+		if c.fix != "" {
+			fmt.Printf("      %-9s fix: %s\n", "", c.fix)
+		}
+		if c.status == "FAIL" {
+			failed++
+		}
+	}
+	if failed != 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(checks))
+	}
+	return nil
+}
+
+// goOutput runs the go command with the arguments into dir, and returns its
+// output, or an error with the standard error.
+func goOutput(goCmd, dir string, args ...string) (string, error) {
+	cmd := exec.Command(goCmd, args...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		if e, ok := err.(*exec.ExitError); ok && len(e.Stderr) != 0 {
+			return "", fmt.Errorf("%s", strings.TrimSpace(string(e.Stderr)))
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// checkWritable checks that a file can be created into the directory, creating
+// it if it does not exist.
+func checkWritable(dir string) error {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".doctor-")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
@@ -0,0 +1,72 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+var cmdEnv = &command{
+	Name:      "env",
+	UsageLine: "[dir]",
+	Short:     "print gake environment information",
+	Long: `Env prints the effective configuration of gake for the task files into
+the directory, by default the current one: the version of gake, the directory
+of the kept binaries, the configuration file in use, the Go toolchain, the
+build cache given to it, if any, the build tags and the flags passed to the
+task binary.`,
+	Run: runEnv,
+}
+
+func runEnv(cmd *command, args []string) error {
+	fs := cmd.FlagSet()
+	fs.Parse(args)
+
+	dir := "."
+	if fs.NArg() > 0 {
+		dir = fs.Arg(0)
+	}
+	dir, err := resolveDir(dir)
+	if err != nil {
+		return err
+	}
+
+	home, err := gakeHome()
+	if err != nil {
+		return err
+	}
+	cmdPath, err := cachedBinaryPath(home, dir)
+	if err != nil {
+		return err
+	}
+	cfg, err := loadConfig(dir)
+	if err != nil {
+		return err
+	}
+	goCmd, err := goTool(cfg)
+	if err != nil {
+		return err
+	}
+
+	vars := [][2]string{
+		{"GAKEVERSION", gakeVersion()},
+		{"GAKECACHE", home},
+		{"GAKEBIN", cmdPath},
+		{"GAKECONFIG", cfg.path},
+		{"GAKEGO", goCmd},
+		{"GOVERSION", goVersion(goCmd)},
+		{"GAKEGOCACHE", goCache(goCmd, home)},
+		{"GAKETAGS", "gake"},
+		{"GAKEMOD", *taskMod},
+		{"GAKEFLAGS", strings.Join(getTaskFlags(), " ")},
+	}
+	for _, v := range vars {
+		fmt.Printf("%s=%q\n", v[0], v[1])
+	}
+	return nil
+}
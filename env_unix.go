@@ -10,3 +10,8 @@ package main
 
 // ENV_HOME is the environment variable to get the user home's directory.
 const ENV_HOME = "HOME"
+
+// cleanEnv are the environment variables kept with the flag -env-clean.
+var cleanEnv = []string{
+	"PATH", "HOME", "USER", "LOGNAME", "SHELL", "TMPDIR", "TERM", "LANG", "LC_ALL", "TZ",
+}
@@ -8,3 +8,9 @@ package main
 
 // ENV_HOME is the environment variable to get the user home's directory.
 const ENV_HOME = "USERPROFILE"
+
+// cleanEnv are the environment variables kept with the flag -env-clean.
+var cleanEnv = []string{
+	"PATH", "PATHEXT", "SYSTEMROOT", "SYSTEMDRIVE", "WINDIR", "COMSPEC", "TEMP", "TMP",
+	"USERPROFILE", "USERNAME", "APPDATA", "LOCALAPPDATA", "PROGRAMDATA", "PROGRAMFILES",
+}
@@ -0,0 +1,338 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var cmdFix = &command{
+	Name:      "fix",
+	UsageLine: "[-y] [dir]",
+	Short:     "rewrite the task files to meet the requirements of gake",
+	Long: `Fix rewrites the task files into the directory, by default the current one,
+so that gake can build them:
+
+	- it adds the build constraint "//go:build gake", with its line
+	  "// +build gake" for Go 1.16, before the "package" clause, moving it
+	  there if it is after, or adding the line which lacks;
+	- it adds the import of the package tasking to the files which use it;
+	- it renames the functions which are almost task functions, since they
+	  take a *tasking.T, like "taskDeploy" or "Taskdeploy" to "TaskDeploy",
+	  with their uses, once confirmed.
+
+The files of other packages than the one of the tasks are left out. The files
+are formatted like by gofmt once changed.`,
+	Run: runFix,
+}
+
+// fixEdit replaces the bytes of a source file from start to end by text.
+type fixEdit struct {
+	start, end int
+	text       string
+}
+
+// fixFile represents a task file to fix.
+type fixFile struct {
+	name  string
+	src   []byte
+	fset  *token.FileSet
+	file  *ast.File
+	edits []fixEdit
+	notes []string // Changes done.
+}
+
+func runFix(cmd *command, args []string) error {
+	fs := cmd.FlagSet()
+	yes := fs.Bool("y", false, "rename the functions without asking")
+	fs.Parse(args)
+
+	dir := "."
+	if fs.NArg() > 0 {
+		dir = fs.Arg(0)
+	}
+	dir, err := resolveDir(dir)
+	if err != nil {
+		return err
+	}
+
+	files, err := loadFixFiles(dir)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return ErrNoTaskfile
+	}
+
+	// The renames are confirmed before any file is changed, since the uses of
+	// a function can be in other files.
+	in := bufio.NewReader(os.Stdin)
+	renames := make(map[string]string)
+	for _, f := range files {
+		for _, fn := range nearTaskFuncs(f.file) {
+			name := fn.Name.Name
+			to := taskName(name)
+			if _, ok := renames[name]; ok || declared(files, to) {
+				continue
+			}
+			if !*yes {
+				fmt.Printf("%s: rename %s to %s? [y/N] ", f.fset.Position(fn.Pos()), name, to)
+				answer, _ := in.ReadString('\n')
+				if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+					continue
+				}
+			}
+			renames[name] = to
+		}
+	}
+
+	for _, f := range files {
+		f.fixConstraint()
+		f.fixImport()
+		f.rename(renames)
+		if len(f.edits) == 0 {
+			continue
+		}
+		if err = f.write(); err != nil {
+			return err
+		}
+		for _, note := range f.notes {
+			fmt.Printf("%s: %s\n", f.name, note)
+		}
+	}
+	return nil
+}
+
+// loadFixFiles parses the task files of the directory which are of the package
+// of the tasks: the package of the files with the gake build constraint, or else
+// "main" or a package with the suffix SUFFIX_TASKPKG.
+func loadFixFiles(dir string) ([]*fixFile, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*"+SUFFIX_TASKFILE))
+	if err != nil {
+		return nil, err
+	}
+
+	all := make([]*fixFile, 0, len(names))
+	pkgName := ""
+	for _, name := range names {
+		src, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		fset := token.NewFileSet()
+		file, err := parser.ParseFile(fset, name, src, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		if gakeConstraint(file) != nil && pkgName == "" {
+			pkgName = file.Name.Name
+		}
+		all = append(all, &fixFile{name: name, src: src, fset: fset, file: file})
+	}
+
+	files := make([]*fixFile, 0, len(all))
+	for _, f := range all {
+		name := f.file.Name.Name
+		if (pkgName != "" && name == pkgName) ||
+			(pkgName == "" && (name == "main" || strings.HasSuffix(name, SUFFIX_TASKPKG))) {
+			files = append(files, f)
+		}
+	}
+	return files, nil
+}
+
+// fixConstraint adds the lines of the build constraint which lack before the
+// "package" clause, and removes the ones after it.
+func (f *fixFile) fixConstraint() {
+	var goLine, plusLine *ast.Comment // Lines before "package".
+	moved := false
+	for _, c := range f.file.Comments {
+		for _, line := range c.List {
+			text := strings.TrimSpace(line.Text)
+			if text != GO_BUILD_LINE && strings.Join(strings.Fields(text), " ") != PLUS_BUILD_LINE {
+				continue
+			}
+			if c.Pos() > f.file.Package {
+				start, end := f.lineBounds(line.Pos())
+				f.edits = append(f.edits, fixEdit{start, end, ""})
+				moved = true
+			} else if text == GO_BUILD_LINE {
+				goLine = line
+			} else {
+				plusLine = line
+			}
+		}
+	}
+
+	switch {
+	case goLine == nil && plusLine == nil:
+		f.edits = append(f.edits, fixEdit{0, 0, GO_BUILD_LINE + "\n" + PLUS_BUILD_LINE + "\n\n"})
+		if moved {
+			f.notes = append(f.notes, "moved the build constraint before \"package\"")
+		} else {
+			f.notes = append(f.notes, "added the build constraint")
+		}
+	case goLine == nil:
+		start, _ := f.lineBounds(plusLine.Pos())
+		f.edits = append(f.edits, fixEdit{start, start, GO_BUILD_LINE + "\n"})
+		f.notes = append(f.notes, "added the line "+GO_BUILD_LINE)
+	case plusLine == nil:
+		_, end := f.lineBounds(goLine.Pos())
+		f.edits = append(f.edits, fixEdit{end, end, PLUS_BUILD_LINE + "\n"})
+		f.notes = append(f.notes, "added the line "+PLUS_BUILD_LINE)
+	}
+}
+
+// lineBounds returns the offsets of the start of the line at pos and of the
+// start of the next line.
+func (f *fixFile) lineBounds(pos token.Pos) (start, end int) {
+	start = f.fset.Position(pos).Offset
+	for start > 0 && f.src[start-1] != '\n' {
+		start--
+	}
+	if i := bytes.IndexByte(f.src[start:], '\n'); i != -1 {
+		return start, start + i + 1
+	}
+	return start, len(f.src)
+}
+
+// fixImport adds the import of the package tasking if the file uses it without
+// importing it.
+func (f *fixFile) fixImport() {
+	if name, _ := taskingImport(f.file); name != "" {
+		return
+	}
+	uses := false
+	ast.Inspect(f.file, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if x, ok := sel.X.(*ast.Ident); ok && x.Name == "tasking" && x.Obj == nil {
+				uses = true
+			}
+		}
+		return !uses
+	})
+	if !uses {
+		return
+	}
+
+	// After the line of the package clause.
+	_, end := f.lineBounds(f.file.Name.End())
+	f.edits = append(f.edits, fixEdit{end, end, "\nimport " + IMPORT_PATH + "\n"})
+	f.notes = append(f.notes, "added the import "+IMPORT_PATH)
+}
+
+// rename renames the functions of renames, and their uses.
+func (f *fixFile) rename(renames map[string]string) {
+	if len(renames) == 0 {
+		return
+	}
+	ast.Inspect(f.file, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.SelectorExpr:
+			// The selector is a field or a method.
+			ast.Inspect(n.X, func(n ast.Node) bool { return f.renameIdent(n, renames) })
+			return false
+		default:
+			return f.renameIdent(n, renames)
+		}
+	})
+}
+
+// renameIdent renames the node if it is an identifier of a function of renames,
+// which is not declared into the file for other thing.
+func (f *fixFile) renameIdent(n ast.Node, renames map[string]string) bool {
+	id, ok := n.(*ast.Ident)
+	if !ok {
+		return true
+	}
+	to, ok := renames[id.Name]
+	if !ok || (id.Obj != nil && id.Obj.Kind != ast.Fun) {
+		return true
+	}
+	start := f.fset.Position(id.Pos()).Offset
+	f.edits = append(f.edits, fixEdit{start, start + len(id.Name), to})
+	if id.Obj != nil {
+		if fn, ok := id.Obj.Decl.(*ast.FuncDecl); ok && fn.Name == id {
+			f.notes = append(f.notes, fmt.Sprintf("renamed %s to %s", id.Name, to))
+		}
+	}
+	return true
+}
+
+// write applies the edits to the file, and formats it.
+func (f *fixFile) write() error {
+	sort.SliceStable(f.edits, func(i, j int) bool { return f.edits[i].start > f.edits[j].start })
+	src := append([]byte(nil), f.src...)
+	for _, e := range f.edits {
+		src = append(src[:e.start], append([]byte(e.text), src[e.end:]...)...)
+	}
+
+	out, err := format.Source(src)
+	if err != nil {
+		return fmt.Errorf("%s: %s", f.name, err)
+	}
+	info, err := os.Stat(f.name)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(f.name, out, info.Mode())
+}
+
+// nearTaskFuncs returns the functions of the file which take a *tasking.T, like
+// the task functions, but whose name is not of a task function.
+func nearTaskFuncs(file *ast.File) []*ast.FuncDecl {
+	importName, _ := taskingImport(file)
+	if importName == "" {
+		importName = "tasking"
+	}
+
+	funcs := make([]*ast.FuncDecl, 0)
+	for _, decl := range file.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Recv != nil || !hasTaskingParam(fn, importName, "T") {
+			continue
+		}
+		name := fn.Name.Name
+		if len(name) <= len(PREFIX_FUNC) || !strings.EqualFold(name[:len(PREFIX_FUNC)], PREFIX_FUNC) {
+			continue
+		}
+		if taskName(name) != name {
+			funcs = append(funcs, fn)
+		}
+	}
+	return funcs
+}
+
+// taskName returns the name of task function for a name which starts with
+// PREFIX_FUNC in any case, like "taskDeploy" or "Taskdeploy" to "TaskDeploy".
+func taskName(name string) string {
+	rest := name[len(PREFIX_FUNC):]
+	r, size := utf8.DecodeRuneInString(rest)
+	return PREFIX_FUNC + string(unicode.ToUpper(r)) + rest[size:]
+}
+
+// declared reports whether a function with the name is declared into the files.
+func declared(files []*fixFile, name string) bool {
+	for _, f := range files {
+		if obj := f.file.Scope.Lookup(name); obj != nil {
+			return true
+		}
+	}
+	return false
+}
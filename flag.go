@@ -10,59 +10,109 @@ import (
 	"flag"
 	"fmt"
 	"os"
-	"time"
+	"runtime"
+	"strings"
 )
 
 var taskUsage = func() {
-	fmt.Fprintf(os.Stderr, `Usage: gake [-c] [-x] [-keep] [task flags] path 
-[extra arguments to be passed to a task]
+	fmt.Fprintf(os.Stderr, `Usage: gake [-c] [-x] [-keep] [-buildlog] [task flags] path 
+[extra arguments to be passed to a task] [-- arguments of the task binary]
+   or: gake command [arguments]
+
+The path is a directory or a Go import path, which is resolved through
+"go list" like in "go test".
+
+The flags with the prefix "task." which gake does not know, given as
+-task.name=value, and the arguments after "--" are passed as they are to the
+task binary, so that new flags of the package tasking can be used.
+
+The commands are:
+`)
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", c.Name, c.Short)
+	}
+	fmt.Fprintf(os.Stderr, `
+Use "gake command -h" for more information about a command.
+
+The exit status is 1 if some task failed, 2 if gake itself failed to parse,
+configure or build the tasks, 3 if the run exceeded -timeout, 4 if it was
+interrupted, and 5 if no task matched -run or -names, unless -allow-no-tasks is
+set for -run; the task binary exits with the same ones, and -print-exit-codes
+prints them. With -json, the last line of the output is a JSON object with the
+fields Action ("status"), Status (pass, fail, timeout, interrupted, no-tasks or
+infra-fail), and Kind and Error for the failures of gake.
 
   -c=false: compile but do not run the binary
-  -x=false: print command lines as they are executed
+  -x=false: print command lines as they are executed, and the time taken by
+     the build of the task binary
   -keep=false: keep the compiled binary
+  -mod="": module download mode passed to "go build": readonly, vendor or mod
+  -p=GOMAXPROCS: number of task packages to build and run in parallel, when the
+     path has the form "dir/..."; the standard input is connected only with -p=1
+  -workspace=false: run the task packages of every module of the go.work file
+     of the path, or of GOWORK; they are named by their module path and
+     directory, like "example.com/api/proto", so that -names selects their
+     tasks with it as prefix, like "example.com/api/proto:TaskGenerate"
+  -no-stdin=false: do not connect the standard input to the tasks, so that they
+     can not wait for an answer
+  -env-clean=false: run the task binary with a minimal environment, with only
+     the variables like PATH, HOME, USER, TMPDIR, TERM, LANG and TZ
+  -env NAME[=value]: set the environment variable of the task binary, or pass
+     it from the environment of gake if it has not value; it can be repeated
+  -sandbox=false: on Linux, run the task binary into new namespaces, without
+     network but the loopback and with the file system read-only, but the
+     directory of the tasks, the working and temporary directories, the cache,
+     the output directory and the paths "writable" of the table "sandbox" of
+     gake.toml, so that the tasks can not reach the network nor write outside
+  -vendor-tasking=false: build the tasks with the package tasking embedded into
+     gake, instead of fetching it, so that they can be built offline
+  -reproducible=false: build the task binary without the paths of the machine
+     nor build identifiers, so that the same sources and Go release give the
+     same binary on every machine, like to cache or sign the binaries of -c
+  -main-template="": build the task binary with the main file generated by this
+     template instead of the default one, "taskmain.tmpl" into the source of gake
+  -buildlog=false: write the build command, the names of its environment
+     variables and its output to "`+BUILD_LOG+`" into the output directory;
+     with several packages, to "gake-build-<package>.log"
+  -debug-timings=false: print to standard error the time spent in discovery,
+     parsing, building and running; with several packages, the times of every
+     phase are added
+  -print-exit-codes=false: print the table of the exit statuses, with the
+     Status of -json, and exit
 
   // These flags (used by gake/tasking) can be passed with or without a "task."
-  // prefix: -v or -task.v
-  -cpu="": passes -task.cpu
-  -parallel=0: passes -task.parallel
-  -run="": passes -task.run
-  -short=false: passes -task.short
-  -timeout=0: passes -task.timeout
-  -v=false: passes -task.v
+  // prefix: -v or -task.v; only the ones given are passed.
 `)
-	os.Exit(2)
+	for _, f := range taskFlags {
+		fmt.Fprint(os.Stderr, f.usageText())
+	}
+	os.Exit(EXIT_INFRA)
 }
 
 var (
 	taskC = flag.Bool("c", false, "compile but do not run the binary")
 	taskX = flag.Bool("x", false, "print command lines as they are executed")
 
-	taskCPU      string
-	taskParallel int
-	taskRun      string
-	taskShort    bool
-	taskTimeout  time.Duration
-	taskV        bool
+	taskBuildLog = flag.Bool("buildlog", false, "write the build log into the output directory")
+	taskMod      = flag.String("mod", "", "module download mode passed to \"go build\"")
+	taskP        = flag.Int("p", runtime.GOMAXPROCS(0), "number of task packages to run in parallel")
+	taskNoStdin  = flag.Bool("no-stdin", false, "do not connect the standard input to the tasks")
+	taskEnvClean = flag.Bool("env-clean", false, "run the task binary with a minimal environment")
+	taskSandbox  = flag.Bool("sandbox", false, "run the task binary without network and with a read-only file system")
+	taskVendor   = flag.Bool("vendor-tasking", false, "build with the package tasking embedded into gake")
+	taskEnv      listFlag
+
+	taskMainTemplate = flag.String("main-template", "", "template of the main file of the task binary")
+	taskReproducible = flag.Bool("reproducible", false, "build a binary which is the same on every machine")
+	taskWorkspace    = flag.Bool("workspace", false, "run the task packages of every module of the go.work file")
+	taskExitCodes    = flag.Bool("print-exit-codes", false, "print the table of the exit statuses")
+	taskDebugTimings = flag.Bool("debug-timings", false, "print the time spent in discovery, parsing, building and running")
 )
 
 func init() {
-	flag.StringVar(&taskCPU, "cpu", "", "passes -task.cpu")
-	flag.StringVar(&taskCPU, "task.cpu", "", "")
-
-	flag.IntVar(&taskParallel, "parallel", 0, "passes -task.parallel")
-	flag.IntVar(&taskParallel, "task.parallel", 0, "")
-
-	flag.StringVar(&taskRun, "run", "", "passes -task.run")
-	flag.StringVar(&taskRun, "task.run", "", "")
+	flag.Var(&taskEnv, "env", "set an environment variable of the task binary")
 
-	flag.BoolVar(&taskShort, "short", false, "passes -task.short")
-	flag.BoolVar(&taskShort, "task.short", false, "")
-
-	flag.DurationVar(&taskTimeout, "timeout", 0, "passes -task.timeout")
-	flag.DurationVar(&taskTimeout, "task.timeout", 0, "")
-
-	flag.BoolVar(&taskV, "v", false, "passes -task.v")
-	flag.BoolVar(&taskV, "task.v", false, "")
+	taskValues = registerTaskFlags(flag.CommandLine)
 
 	flag.Usage = taskUsage
 }
@@ -75,36 +125,51 @@ var (
 	//taskKillTimeout = 3 * time.Minute
 )
 
-// getTaskArgs returns the arguments to be passed to "gake/tasking".
-func getTaskArgs() []string {
-	args := make([]string, 0)
+// listFlag is a flag which can be repeated to set a list of values.
+type listFlag []string
 
-	flag.Visit(func(f *flag.Flag) {
-		isBoolean := false
+func (l *listFlag) String() string { return strings.Join(*l, ",") }
 
-		switch f.Name {
-		case "c", "x", "keep": // Flags skipped
-			return
+func (l *listFlag) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
 
-		// Rewrite known flags to have "task" before them
-		case "cpu", "parallel", "run", "short", "timeout", "v":
-			f.Name = "task." + f.Name
-			fallthrough
-		case "task.short", "task.v":
-			isBoolean = true
-		}
+// getTaskArgs returns the arguments to be passed to "gake/tasking".
+func getTaskArgs() []string {
+	return taskArgsOf(taskValues)
+}
 
-		args = append(args, "-"+f.Name)
-		if !isBoolean {
-			args = append(args, f.Value.String())
-		}
-	})
+// taskArgsOf returns the arguments to be passed to "gake/tasking" with the
+// values of the task flags.
+func taskArgsOf(tv taskFlagValues) []string {
+	var extra []string
+	if fargs := flag.Args(); len(fargs) > 1 {
+		extra = fargs[1:]
+	}
+	return taskArgs(append(tv.args(), taskUnknown...), extra)
+}
 
-	fargs := flag.Args()
-	if len(fargs) > 1 {
-		args = append(args, "-task.args")
-		args = append(args, fargs[1:]...)
+// taskArgs returns the arguments of the task binary for its flags and the extra
+// arguments given after the path. The extra arguments are passed by -task.args,
+// but the ones after "--", which are passed as they are.
+func taskArgs(flags, extra []string) []string {
+	args := append([]string(nil), flags...)
+
+	var verbatim []string
+	for i, a := range extra {
+		if a == "--" {
+			extra, verbatim = extra[:i], extra[i+1:]
+			break
+		}
+	}
+	if len(extra) != 0 {
+		args = append(args, "-task.args="+strings.Join(extra, ","))
 	}
+	return append(args, verbatim...)
+}
 
-	return args
+// getTaskFlags returns the flags to be passed to "gake/tasking".
+func getTaskFlags() []string {
+	return append(taskValues.args(), taskUnknown...)
 }
@@ -8,22 +8,177 @@
 // Go source code by reading files whose name ends '_task.go' that contains
 // the TaskXxx functions, which are run and work just like in package "testing".
 //
+// The task files are of the package "main", or of a package with the suffix
+// "_tasks", like "foo_tasks", beside the package "foo" into the same directory;
+// like an external test package, it is not compiled into the package "foo" and
+// its tasks use the exported API of "foo" importing it.
+//
+// Tasks can also be added at run time, by calls to tasking.Register from an
+// init function of the task files, like one task per service of a list.
+//
 // By default, the binary built is temporary unless it is used -c or -keep flag;
 // both flags check if the binary has to be re-compiled due to source code updated.
 //
 // "-keep" flag stores the compiled binaries into a global directory under
 // 'HOME/.task'
+//
+// A file "gake.toml" into the directory of the task files, or into a parent one
+// until the root of the module, configures the task suite. The key "toolchain"
+// pins the Go release used to build the tasks, like "go1.21.5"; it is located
+// or downloaded in the manner of golang.org/dl.
+//
+// The table "cache" sets a remote cache, an HTTP server with a GET/PUT protocol,
+// to share the state of the tasks, like their fingerprints, between machines:
+//
+//	[cache]
+//	remote = "https://cache.example.com/gake"
+//	token_env = "GAKE_CACHE_TOKEN"
+//	readonly = false
+//
+// The table "budgets" sets the time budget of the groups of tasks, declared by
+// the directive "gake:group", as the sum of the time spent by their tasks:
+//
+//	[budgets]
+//	lint = "2m"
+//	e2e = "20m"
+//
+// The time spent by every group is reported after the run, with a warning for
+// the groups which exceed their budget; with -enforce-budgets, the run fails.
+//
+// The table "needs" names the external endpoints which can be given by name to
+// the directive "gake:needs":
+//
+//	[needs]
+//	postgres = "tcp://localhost:5432"
+//	api = "http://localhost:8080/health"
+//
+// The table "log" writes a summary of every run, with the count of the tasks by
+// status and the failed ones, to the syslog on Unix and to the Application log
+// of the Windows Event Log, for the scheduled jobs:
+//
+//	[log]
+//	syslog = "local"     # or a server, like "udp://logs:514"
+//	syslog_tag = "gake"
+//	eventlog = "gake"    # source of the events
+//
+// The table "approval" sets a webhook, notified with a POST of a JSON object
+// with the fields Task, Message and Dashboard for every approval waited for,
+// and the environment variable with the token required to approve at the
+// dashboard:
+//
+//	[approval]
+//	webhook = "https://chat.example.com/hooks/deploys"
+//	token_env = "GAKE_APPROVAL_TOKEN"
+//
+// The table "sandbox" sets the paths, relative to the directory of gake.toml,
+// which can be written by the task binary run with -sandbox, besides the
+// directory of the tasks, the working and temporary directories, the cache and
+// the output directory:
+//
+//	[sandbox]
+//	writable = ["build", "/var/tmp/assets"]
+//
+// The documentation of a task function can hold directives, lines with the form
+// "// gake:name arguments", which are interpreted by gake:
+//
+//	gake:mutex name...
+//		the task is not run in parallel with other tasks which use any of
+//		the named resources; see tasking.T.Serialize.
+//	gake:weight n
+//		the task uses n units of the capacity given by -parallel;
+//		see tasking.T.SetWeight.
+//	gake:param name type [required] [default=value]
+//		the task takes the parameter, given with "-param name=value";
+//		the type is string, int, bool or duration. See tasking.T.Param.
+//	gake:limit [cpu=n] [mem=size] [nice=n]
+//		limits the CPUs, memory and scheduling priority of the processes
+//		launched by the task through tasking.T.Exec; see tasking.Limits.
+//	gake:matrix NAME=value,... ...
+//		the task is run once per combination of the values of the axes,
+//		as instances named like TaskBuild/linux-amd64; see tasking.T.Matrix.
+//	gake:xfail [reason]
+//		the task is known to be broken: its failure is reported as XFAIL
+//		and does not fail the run; see tasking.T.ExpectFail.
+//	gake:deps task...
+//		the named tasks are run, and finished, before the task; when one
+//		of them fails, the task is skipped. The flag -only runs the tasks
+//		selected by -run or -names without their dependencies, and the flag
+//		-dependents runs them with the tasks which depend on them instead.
+//		A task of another package is given as "dir:Task", with the directory
+//		relative to the one of the task file, like "./proto:TaskGenerate";
+//		it is run by gake once per run, the task fails when it fails, and
+//		its artifacts are given by tasking.T.DepArtifacts.
+//	gake:when condition...
+//		the task is only run when all the conditions hold, which have the
+//		forms GOOS=value,..., GOARCH=value,..., env:NAME (set and not empty)
+//		or env:NAME=value, negated by a prefix '!'; else it is left out of
+//		the run. "gake:onlyif" is a synonym.
+//	gake:manifest path [name=field]
+//		the task is run once per entry of the manifest, a JSON or YAML file
+//		with a list of objects, as instances named by the field of every
+//		entry, "name" by default, like TaskDeploy/api; see tasking.T.Entry.
+//		The path is relative to the directory of the task file. It can not
+//		be used with gake:matrix. The YAML files are decoded by the function
+//		given to tasking.M.SetYAMLUnmarshal.
+//	gake:group name...
+//		the task is of the named groups, whose time budgets are set into
+//		the table "budgets" of gake.toml.
+//	gake:approve [question]
+//		the task waits for a person to approve it, once its dependencies
+//		have finished, and fails if it is rejected; the approval is asked
+//		at the terminal, or at the dashboard given by -dashboard. See
+//		tasking.T.WaitForApproval.
+//	gake:isolate
+//		the task gets a private copy of the working directory, where its
+//		commands are run, so that the tasks run in parallel which write
+//		into the tree do not corrupt each other; the files which it creates
+//		or changes are copied back when it passes. See tasking.T.Isolate.
+//	gake:user name
+//		the processes launched by the task through tasking.T.Exec are run
+//		as the named user, like "root" or "nobody": on Unix, with its
+//		credentials when gake is run as root, else through sudo; on
+//		Windows, through runas. See tasking.T.RunAs.
+//	gake:needs endpoint...
+//		the task is started once the external endpoints are available:
+//		"tcp://host:port", an HTTP URL or a name of the table "needs" of
+//		gake.toml; they are probed with retries for -needs-timeout, and
+//		the task fails if any is not available, or it is skipped with
+//		-needs-skip. See tasking.T.Needs.
+//
+// The main file of the task binary is generated by a text/template, which the
+// flag -main-template replaces to customize the entry point, like with extra
+// imports, reporters or wrappers; the default one is "taskmain.tmpl" into the
+// source of gake. The data of the template is kept stable:
+//
+//	.Name         name of the package of the task files
+//	.Dir          directory of the task files
+//	.HasMain      whether the function TaskMain is declared
+//	.TaskingPath  import path of the package tasking
+//	.BuildInfo    build information; its String is passed to tasking.SetBuildInfo
+//	.Files        task files, with the fields:
+//	    .Name       path of the file
+//	    .TaskFuncs  task functions, with the fields Name, Doc, File, Line,
+//	                Mutexes, Weight, Params, Limits, Matrix, XFail, Deps,
+//	                ExtDeps, When, Manifest, Groups, Approve, Isolate, User
+//	                and Needs,
+//	                from the declaration and its directives
+//
+// and the function "quote" returns a string as a Go literal.
 package main
 
 import (
 	"flag"
 	"fmt"
 	"hash/adler32"
+	"io"
 	"os"
+	"os/exec"
 	"os/user"
 	"path/filepath"
 	"runtime"
 	"strconv"
+	"strings"
+	"time"
 )
 
 const (
@@ -34,12 +189,120 @@ const (
 
 	// SUBDIR_HOME is the directory where are stored the compiled programs
 	SUBDIR_HOME = CMD_EXT
+
+	// ENV_CACHE is the environment variable which passes the directory of
+	// SUBDIR_HOME to the task binary, where it keeps data between runs
+	ENV_CACHE = "GAKECACHE"
+
+	// ENV_COMMAND and ENV_INJECTED pass to the task binary the command line of
+	// gake and the names of the environment variables set by it, to be shown
+	// in the report of the run.
+	ENV_COMMAND  = "GAKE_COMMAND"
+	ENV_INJECTED = "GAKE_INJECTED"
+
+	// ENV_TASKDIR passes the absolute directory of the task files to the task
+	// binary, which is run from the working directory of gake.
+	ENV_TASKDIR = "GAKE_TASKDIR"
+
+	// ENV_EXECUTABLE passes the path of gake to the task binary, to run the
+	// tasks of other packages declared by "gake:deps".
+	ENV_EXECUTABLE = "GAKE_EXECUTABLE"
+
+	// ENV_PACKAGE passes to the task binary the label of its package, when
+	// several ones are run, to be set into its JSON events.
+	ENV_PACKAGE = "GAKE_PACKAGE"
 )
 
 func main() {
-	flag.Parse()
+	sandboxInit()
+
+	known, unknown := splitTaskFlags(flag.CommandLine, os.Args[1:])
+	flag.CommandLine.Parse(known)
+	taskUnknown = unknown
+
+	switch *taskMod {
+	case "", "readonly", "vendor", "mod":
+	default:
+		fmt.Fprintf(os.Stderr, "invalid -mod value %q: want readonly, vendor or mod\n", *taskMod)
+		os.Exit(EXIT_INFRA)
+	}
+
+	if *taskExitCodes {
+		printExitCodes()
+		return
+	}
+
+	args := flag.Args()
+	if len(args) == 0 {
+		args = append(args, ".")
+	}
+	if cmd := lookupCommand(args[0]); cmd != nil {
+		if err := cmd.Run(cmd, args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "gake %s: %s\n", cmd.Name, err)
+			os.Exit(EXIT_INFRA)
+		}
+		return
+	}
+
+	HOME, err := gakeHome()
+	if err != nil {
+		exit(infraError(INFRA_CACHE, err))
+	}
+	// The task binary keeps its data, like the fingerprints, into the cache.
+	if os.Getenv(ENV_CACHE) == "" {
+		os.Setenv(ENV_CACHE, HOME)
+	}
+
+	discoveryStart := time.Now()
+	dirs := make([]string, 0, 1)
+	if *taskWorkspace {
+		targets, err := workspaceTargets(strings.TrimSuffix(args[0], "/..."))
+		if err != nil {
+			exit(infraError(INFRA_RESOLVE, err))
+		}
+		if len(targets) > 1 && *taskC {
+			fmt.Fprintf(os.Stderr, "cannot use -c flag with multiple packages\n")
+			os.Exit(EXIT_INFRA)
+		}
+		timePhase(PHASE_DISCOVERY, discoveryStart)
+		exit(runPackages(HOME, targets))
+	}
+	if root := strings.TrimSuffix(args[0], "/..."); root != args[0] {
+		dir, err := resolveDir(root)
+		if err != nil {
+			exit(infraError(INFRA_RESOLVE, err))
+		}
+		if dirs, err = findTaskDirs(dir); err != nil {
+			exit(infraError(INFRA_RESOLVE, err))
+		}
+		if len(dirs) == 0 {
+			exit(infraError(INFRA_RESOLVE, ErrNoTaskfile))
+		}
+		if len(dirs) > 1 && *taskC {
+			fmt.Fprintf(os.Stderr, "cannot use -c flag with multiple packages\n")
+			os.Exit(EXIT_INFRA)
+		}
+	} else {
+		dir, err := resolveDir(args[0])
+		if err != nil {
+			exit(infraError(INFRA_RESOLVE, err))
+		}
+		dirs = append(dirs, dir)
+	}
+	timePhase(PHASE_DISCOVERY, discoveryStart)
+
+	if len(dirs) > 1 {
+		exit(runPackages(HOME, dirTargets(dirs)))
+	}
+	var stdin io.Reader = os.Stdin
+	if *taskNoStdin {
+		stdin = nil
+	}
+	exit(runPackage(HOME, dirs[0], stdin, os.Stdout, os.Stderr))
+}
 
-	// Get the home directory for the compiled programs
+// gakeHome returns the directory where the compiled programs are kept.
+func gakeHome() (string, error) {
 	HOME := os.Getenv(ENV_HOME)
 	if HOME == "" {
 		// In Unix systems, the environment variable is not set during boot init.
@@ -54,112 +317,192 @@ func main() {
 			}
 		}
 		if HOME == "" {
-			fmt.Fprintf(os.Stderr, "environment variable %s is not set\n", ENV_HOME)
-			os.Exit(1)
+			return "", fmt.Errorf("environment variable %s is not set", ENV_HOME)
 		}
 	}
-	HOME = filepath.Join(HOME, SUBDIR_HOME)
+	return filepath.Join(HOME, SUBDIR_HOME), nil
+}
 
-	args := flag.Args()
-	if len(args) == 0 {
-		args = append(args, ".")
+// cachedBinaryPath returns the path of the binary kept for the task files in dir.
+func cachedBinaryPath(home, dir string) (string, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	crc := adler32.Checksum([]byte(absDir))
+	cmdPath := home + string(os.PathSeparator) + strconv.FormatUint(uint64(crc), 10) +
+		string(os.PathSeparator) + BIN_NAME
+
+	if runtime.GOOS == "windows" {
+		cmdPath += ".exe"
+	}
+	return cmdPath, nil
+}
+
+// taskRun is a package of task files ready to be built, when its binary is not
+// already compiled from the actual code, and run.
+type taskRun struct {
+	pkg     *taskPackage
+	cmdPath string
+	keep    bool // The binary is kept into the cache of gake.
+	isNew   bool // There is not a binary kept from a previous run.
+}
+
+// runPackage builds the task files in dir, when the binary is not already
+// compiled from the actual code, and runs them. The directory where the binaries
+// are kept is home.
+func runPackage(home, dir string, stdin io.Reader, stdout, stderr io.Writer) error {
+	r, err := preparePackage(home, dir)
+	if err != nil {
+		return err
 	}
+	return r.run(stdin, stdout, stderr)
+}
+
+// preparePackage parses the task files in dir, with their configuration, and
+// hashes the inputs of their build.
+func preparePackage(home, dir string) (*taskRun, error) {
+	defer timePhase(PHASE_PARSING, time.Now())
 
-	dir := args[0]
 	cmdPath := ""
 	isNew := false
+	keep := *taskKeepBinary
 
 	// Use global directory
 	if !*taskC {
-		absDir, err := filepath.Abs(dir)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "%s\n", err)
-			os.Exit(1)
+		var err error
+		if cmdPath, err = cachedBinaryPath(home, dir); err != nil {
+			return nil, infraError(INFRA_CACHE, err)
 		}
-		crc := adler32.Checksum([]byte(absDir))
-		homeDir := HOME + string(os.PathSeparator) + strconv.FormatUint(uint64(crc), 10)
-		cmdPath = homeDir + string(os.PathSeparator) + BIN_NAME
+		homeDir := filepath.Dir(cmdPath)
 
 		if _, err = os.Stat(homeDir); err != nil {
 			if !os.IsNotExist(err) {
-				fmt.Fprintf(os.Stderr, "%s\n", err)
-				os.Exit(1)
+				return nil, infraError(INFRA_CACHE, err)
 			}
 			isNew = true
 
-			if *taskKeepBinary {
-				err = os.MkdirAll(homeDir, 0750)
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "%s\n", err)
-					os.Exit(1)
+			if keep {
+				xtrace("mkdir -p %s", homeDir)
+				if err = os.MkdirAll(homeDir, 0750); err != nil {
+					return nil, infraError(INFRA_CACHE, err)
 				}
 			}
+		} else {
+			// Update the binary kept from a previous run.
+			keep = true
 		}
 	} else {
 		// Binary is compiled in actual directory.
 		wd, err := os.Getwd()
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "%s\n", err)
-			os.Exit(1)
+			return nil, infraError(INFRA_INTERNAL, err)
 		}
 
 		cmdPath = wd + string(os.PathSeparator) + filepath.Base(dir) + CMD_EXT
-	}
-	if runtime.GOOS == "windows" {
-		cmdPath += ".exe"
-	}
-
-	if isNew || hasNewCode(dir, cmdPath) {
-		pkg, err := ParseDir(dir)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "%s\n", err)
-			os.Exit(1)
+		if runtime.GOOS == "windows" {
+			cmdPath += ".exe"
 		}
-		if err = BuildAndRun(pkg, cmdPath); err != nil {
-			fmt.Fprintf(os.Stderr, "%s\n", err)
-			os.Exit(1)
-		}
-	} else {
-		Run(cmdPath)
 	}
-}
 
-// hasNewCode checks if code in given directory has been updated; the modification
-// time has to be after than the command one.
-// Also, if the command does not exist and -taskC flag is set, then it returns true.
-func hasNewCode(dir, cmdPath string) bool {
-	files, err := filepath.Glob(dir + string(os.PathSeparator) + "*" + SUFFIX_TASKFILE)
+	cfg, err := loadConfig(dir)
+	if err != nil {
+		return nil, infraError(INFRA_CONFIG, err)
+	}
+	env, err := remoteCacheEnv(cfg, dir)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "hasNewCode(): %s\n", err)
-		return false
+		return nil, infraError(INFRA_CONFIG, err)
+	}
+	needs, err := needsEnv(cfg)
+	if err != nil {
+		return nil, infraError(INFRA_CONFIG, err)
+	}
+	env = append(env, needs...)
+	budgets, err := budgetsEnv(cfg)
+	if err != nil {
+		return nil, infraError(INFRA_CONFIG, err)
+	}
+	env = append(env, budgets...)
+	sinks, err := logSinksEnv(cfg)
+	if err != nil {
+		return nil, infraError(INFRA_CONFIG, err)
+	}
+	env = append(env, sinks...)
+	approval, err := approvalEnv(cfg)
+	if err != nil {
+		return nil, infraError(INFRA_CONFIG, err)
+	}
+	env = append(env, approval...)
+	sandbox, err := sandboxEnv(cfg, dir)
+	if err != nil {
+		return nil, infraError(INFRA_CONFIG, err)
+	}
+	env = append(env, sandbox...)
+	if absDir, err := filepath.Abs(dir); err == nil {
+		env = append(env, ENV_TASKDIR+"="+absDir)
+	}
+	if exe, err := os.Executable(); err == nil {
+		env = append(env, ENV_EXECUTABLE+"="+exe)
 	}
 
-	cmdInfo, err := os.Stat(cmdPath)
+	pkg, err := ParseDir(dir)
 	if err != nil {
-		if !os.IsNotExist(err) {
-			fmt.Fprintf(os.Stderr, "hasNewCode(): %s\n", err)
-		}
+		return nil, infraError(INFRA_PARSE, err)
+	}
+	if err = pkg.checkCycle(); err != nil {
+		return nil, infraError(INFRA_PARSE, err)
+	}
+	if pkg.GoCmd, err = goTool(cfg); err != nil {
+		return nil, infraError(INFRA_TOOLCHAIN, err)
+	}
+	pkg.BuildInfo = newBuildInfo(pkg.GoCmd)
+	pkg.GoCache = goCache(pkg.GoCmd, home)
+	if pkg.BuildInfo.Inputs, err = inputsHash(pkg); err != nil {
+		return nil, infraError(INFRA_BUILD, err)
+	}
+	pkg.Env = env
 
-		if *taskC {
-			return true
-		}
-		return false
+	return &taskRun{pkg: pkg, cmdPath: cmdPath, keep: keep, isNew: isNew}, nil
+}
+
+// run builds the binary, if it is stale, and runs it.
+func (r *taskRun) run(stdin io.Reader, stdout, stderr io.Writer) error {
+	if r.isNew || isStaleBinary(r.cmdPath, r.pkg.BuildInfo) {
+		return BuildAndRun(r.pkg, r.cmdPath, r.keep, stdin, stdout, stderr)
 	}
-	cmdModTime := cmdInfo.ModTime()
+	return Run(r.cmdPath, r.pkg.Args, r.pkg.Env, stdin, stdout, stderr)
+}
 
-	// Get last modification time for task files
-	for _, f := range files {
-		info, err := os.Stat(f)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "hasNewCode(): %s\n", err)
-			return false
-		}
+// resolveDir returns the directory of the task files given at the command line,
+// which can be a filesystem path or a Go import path. An import path is resolved
+// through "go list", so that it is found into the module, workspace or GOPATH.
+func resolveDir(arg string) (string, error) {
+	if isLocalPath(arg) {
+		return arg, nil
+	}
+	if info, err := os.Stat(arg); err == nil && info.IsDir() {
+		return arg, nil
+	}
 
-		if info.ModTime().After(cmdModTime) {
-			*taskKeepBinary = true
-			return true
-		}
+	cmd := exec.Command("go", "list", "-e", "-tags", "gake", "-f", "{{.Dir}}", arg)
+	cmd.Stderr = os.Stderr
+	xtrace("%s", strings.Join(cmd.Args, " "))
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("can't resolve import path %q: %s", arg, err)
+	}
+	dir := strings.TrimSpace(string(out))
+	if dir == "" {
+		return "", fmt.Errorf("can't find package %q", arg)
 	}
+	return dir, nil
+}
 
-	return false
+// isLocalPath reports whether the path is relative to the current directory or
+// absolute, like "." or "./ops", so it can not be an import path.
+func isLocalPath(path string) bool {
+	return path == "." || path == ".." || filepath.IsAbs(path) ||
+		strings.HasPrefix(path, "./") || strings.HasPrefix(path, "../") ||
+		strings.HasPrefix(path, "."+string(os.PathSeparator)) ||
+		strings.HasPrefix(path, ".."+string(os.PathSeparator))
 }
@@ -7,6 +7,14 @@
 package main
 
 import (
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/tredoe/goutil/cmdutil"
@@ -21,23 +29,48 @@ func TestCommand(t *testing.T) {
 
 		{
 			Args:   "./testdata/build_cons1/",
-			Stderr: BuildConsError{"testdata/build_cons1/1_test-constraint_task.go"}.Error() + "\n",
+			Stderr: errorText(BuildConsError{"testdata/build_cons1/1_test-constraint_task.go"}) + "\n",
 		},
 		{
 			Args:   "./testdata/build_cons2/",
-			Stderr: BuildConsPosError{"testdata/build_cons2/2_test-constraint_task.go"}.Error() + "\n",
+			Stderr: errorText(BuildConsPosError{"testdata/build_cons2/2_test-constraint_task.go"}) + "\n",
 		},
 		{
-			Args:   "./testdata/func_sign/",
-			Stderr: "testdata/func_sign/test-signature_task.go:3:1: main.TaskTest should have the signature func(*tasking.T)\n",
+			Args: "./testdata/ext_pkg/",
+			Out:  "Hello from ext!\nPASS\n",
+		},
+		{
+			Args:   "-run Nope ./testdata/ext_pkg/",
+			Stderr: "tasking: no tasks match -task.run \"Nope\"; the tasks are:\n\tTaskGreeting\n",
+		},
+		{
+			Args: "./testdata/ext_import/",
+			Out:  "Hello from ext!\nPASS\n",
+		},
+		{
+			Args: "./testdata/func_sign/",
+			Stderr: "testdata/func_sign/test-signature_task.go:3:1: main.TaskTest should have the signature func(*tasking.T)\n" +
+				"  7 | func TaskTest(t *tasking.N) { t.Log(\"Done\") }\n" +
+				"    | ^ wrong signature\n" +
+				"  fix: declare it as:\n\n" +
+				"\tfunc TaskTest(t *tasking.T) {\n",
 		},
 		{
 			Args:   "./testdata/import_path/",
-			Stderr: ImportPathError{"testdata/import_path/test-import_task.go"}.Error() + "\n",
+			Stderr: errorText(ImportPathError{"testdata/import_path/test-import_task.go"}) + "\n",
+		},
+		{
+			// The tasks are run in the order of the files, and of declaration.
+			Args: "./testdata/multi_file/",
+			Out:  "Zulu\nAlpha\nMike\nBravo\nYankee\nPASS\n",
 		},
 		{
 			Args:   "./testdata/multi_pkg/",
-			Stderr: "can't load package: found packages \"main\" ('testdata/multi_pkg/1_test_task.go'), \"main2\" ('testdata/multi_pkg/3_test_task.go', 'testdata/multi_pkg/2_test_task.go') in './testdata/multi_pkg/'\n",
+			Stderr: "can't load package: found packages \"main\" ('testdata/multi_pkg/1_test_task.go'), \"main2\" ('testdata/multi_pkg/2_test_task.go', 'testdata/multi_pkg/3_test_task.go') in './testdata/multi_pkg/'\n",
+		},
+		{
+			Args: "./testdata/multi_pkg_tags/",
+			Out:  "Done\nPASS\n",
 		},
 		{
 			Args:   "./testdata/no_taskfile/",
@@ -54,3 +87,313 @@ func TestCommand(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+// buildGake builds the command into a temporary directory, returning its path.
+func buildGake(t *testing.T) string {
+	t.Helper()
+	bin := filepath.Join(t.TempDir(), "gake")
+	if out, err := exec.Command("go", "build", "-o", bin, ".").CombinedOutput(); err != nil {
+		t.Fatalf("build: %s\n%s", err, out)
+	}
+	return bin
+}
+
+func TestExitStatus(t *testing.T) {
+	bin := buildGake(t)
+
+	tests := []struct {
+		args string
+		code int
+	}{
+		{"./testdata/", 0},
+		{"./testdata/task_fail/", EXIT_TASK},
+		{"-mod=nope ./testdata/", EXIT_INFRA},
+		{"./testdata/no_taskfile/", EXIT_INFRA},
+		{"./testdata/func_sign/", EXIT_INFRA},
+		{"bin nope", EXIT_INFRA},
+		{"-run Nope ./testdata/ext_pkg/", EXIT_NO_TASKS},
+	}
+	for _, tt := range tests {
+		err := exec.Command(bin, strings.Fields(tt.args)...).Run()
+		code := 0
+		if e, ok := err.(*exec.ExitError); ok {
+			code = e.ExitCode()
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if code != tt.code {
+			t.Errorf("gake %s: exit status %d; want %d", tt.args, code, tt.code)
+		}
+	}
+}
+
+func TestTaskFlags(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		// Only the given flags are passed, so the defaults are the ones of tasking.
+		{nil, ""},
+		{[]string{"-c", "-x", "-p", "2", "-mod", "vendor"}, ""},
+
+		// Booleans.
+		{[]string{"-v"}, "-task.v"},
+		{[]string{"-task.v"}, "-task.v"},
+		{[]string{"-v=false"}, "-task.v=false"},
+		{[]string{"-v", "-task.v=false"}, "-task.v=false"},
+		{[]string{"-json=1"}, "-task.json"},
+
+		// Values.
+		{[]string{"-run", "Build"}, "-task.run Build"},
+		{[]string{"-task.run=Build", "-run", "Test"}, "-task.run Test"},
+		{[]string{"-parallel", "4"}, "-task.parallel 4"},
+		{[]string{"-parallel=0"}, "-task.parallel 0"},
+		{[]string{"-timeout", "1m30s"}, "-task.timeout 1m30s"},
+		{[]string{"-outputdir", ""}, "-task.outputdir "},
+
+		// Lists.
+		{[]string{"-param", "a=1", "-task.param", "b=2"}, "-task.param a=1 -task.param b=2"},
+
+		// In the order of taskFlags.
+		{[]string{"-v", "-run", "X", "-cpu", "1,2", "-allow-no-tasks"},
+			"-task.allow-no-tasks -task.cpu 1,2 -task.run X -task.v"},
+	}
+
+	for _, tt := range tests {
+		fs := flag.NewFlagSet("gake", flag.ContinueOnError)
+		fs.Bool("c", false, "")
+		fs.Bool("x", false, "")
+		fs.Int("p", 1, "")
+		fs.String("mod", "", "")
+		values := registerTaskFlags(fs)
+
+		if err := fs.Parse(tt.args); err != nil {
+			t.Errorf("%q: %s", tt.args, err)
+			continue
+		}
+		if got := strings.Join(values.args(), " "); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.args, got, tt.want)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"-v=maybe"},
+		{"-parallel", "many"},
+		{"-timeout", "10"},
+	} {
+		fs := flag.NewFlagSet("gake", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		registerTaskFlags(fs)
+		if err := fs.Parse(args); err == nil {
+			t.Errorf("%q: want error", args)
+		}
+	}
+}
+
+func TestTaskFlagsTable(t *testing.T) {
+	for i, f := range taskFlags {
+		if i != 0 && taskFlags[i-1].Name >= f.Name {
+			t.Errorf("taskFlags: %s is not sorted after %s", f.Name, taskFlags[i-1].Name)
+		}
+		switch f.Kind {
+		case KIND_BOOL, KIND_DURATION, KIND_INT, KIND_LIST, KIND_STRING:
+		default:
+			t.Errorf("taskFlags: %s: unknown kind %q", f.Name, f.Kind)
+		}
+		if f.Default == "" {
+			t.Errorf("taskFlags: %s: no default value to show", f.Name)
+		}
+		if strings.HasPrefix(f.Name, "task.") {
+			t.Errorf("taskFlags: %s: name with the prefix \"task.\"", f.Name)
+		}
+	}
+}
+
+func TestSplitTaskFlags(t *testing.T) {
+	tests := []struct {
+		args           []string
+		known, unknown string
+	}{
+		{[]string{"-v", "."}, "-v .", ""},
+		{[]string{"-task.shuffle=on", "-v", "."}, "-v .", "-task.shuffle=on"},
+		{[]string{"--task.count=2", "-run", "-task.x", "."}, "-run -task.x .", "--task.count=2"},
+		{[]string{"-task.v", "-task.new", "."}, "-task.v .", "-task.new"},
+		{[]string{"-v=true", "-task.json=false", "."}, "-v=true -task.json=false .", ""},
+		// Only the flags before the path.
+		{[]string{".", "-task.shuffle=on"}, ". -task.shuffle=on", ""},
+		{[]string{"--", "-task.shuffle=on"}, "-- -task.shuffle=on", ""},
+		// Unknown flags without the prefix are an error of the parse.
+		{[]string{"-shuffle", "."}, "-shuffle .", ""},
+	}
+
+	for _, tt := range tests {
+		fs := flag.NewFlagSet("gake", flag.ContinueOnError)
+		registerTaskFlags(fs)
+
+		known, unknown := splitTaskFlags(fs, tt.args)
+		if got := strings.Join(known, " "); got != tt.known {
+			t.Errorf("%q: got known %q, want %q", tt.args, got, tt.known)
+		}
+		if got := strings.Join(unknown, " "); got != tt.unknown {
+			t.Errorf("%q: got unknown %q, want %q", tt.args, got, tt.unknown)
+		}
+	}
+}
+
+func TestTaskArgs(t *testing.T) {
+	tests := []struct {
+		flags, extra []string
+		want         string
+	}{
+		{nil, nil, ""},
+		{[]string{"-task.v"}, nil, "-task.v"},
+		{[]string{"-task.v"}, []string{"a", "b"}, "-task.v -task.args=a,b"},
+		{nil, []string{"--", "-task.shuffle=on", "-task.count", "2"}, "-task.shuffle=on -task.count 2"},
+		{[]string{"-task.v"}, []string{"a", "--", "-task.x=1", "--"}, "-task.v -task.args=a -task.x=1 --"},
+	}
+
+	for _, tt := range tests {
+		if got := strings.Join(taskArgs(tt.flags, tt.extra), " "); got != tt.want {
+			t.Errorf("%q %q: got %q, want %q", tt.flags, tt.extra, got, tt.want)
+		}
+	}
+}
+
+func TestJSONPackages(t *testing.T) {
+	bin := buildGake(t)
+	out, err := exec.Command(bin, "-json", "./testdata/multi_json/...").Output()
+	if err != nil {
+		t.Fatalf("%s\n%s", err, out)
+	}
+
+	var e struct {
+		Action, Package, Task, Output string
+	}
+	tasks := make(map[string]string) // Package by task.
+	printed := false
+	lines := strings.Split(strings.TrimSuffix(string(out), "\n"), "\n")
+	for _, line := range lines {
+		e.Package, e.Task, e.Output = "", "", ""
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("line %q: %s", line, err)
+		}
+		if e.Action == "status" {
+			continue
+		}
+		if e.Package == "" {
+			t.Errorf("line %q: no package", line)
+		}
+		if e.Task != "" {
+			tasks[e.Task] = filepath.Base(e.Package)
+		}
+		if e.Action == "output" && e.Task == "" && e.Output == "Printed\n" {
+			printed = e.Package != ""
+		}
+	}
+	if tasks["TaskPrint"] != "a" || tasks["TaskLog"] != "b" {
+		t.Errorf("packages of the tasks = %v; want TaskPrint in a and TaskLog in b", tasks)
+	}
+	if !printed {
+		t.Error("the line printed by TaskPrint is not an event of its package")
+	}
+}
+
+func TestInputsHashEnv(t *testing.T) {
+	pkg := &taskPackage{
+		Name:  "main",
+		Dir:   "testdata/multi_json/a",
+		Files: []taskFile{{Name: "testdata/multi_json/a/a_task.go"}},
+		GoCmd: "go",
+	}
+	hash := func() string {
+		pkg.Hidden = nil
+		h, err := inputsHash(pkg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	base := hash()
+
+	for _, tt := range []struct{ key, value string }{
+		{"GOOS", "plan9"},
+		{"GOFLAGS", "-tags=other"},
+	} {
+		old, had := os.LookupEnv(tt.key)
+		os.Setenv(tt.key, tt.value)
+		got := hash()
+		if had {
+			os.Setenv(tt.key, old)
+		} else {
+			os.Unsetenv(tt.key)
+		}
+		if got == base {
+			t.Errorf("%s=%s: the hash does not change", tt.key, tt.value)
+		}
+	}
+	if got := hash(); got != base {
+		t.Errorf("the hash changes with the same environment")
+	}
+}
+
+func TestParseConfigValue(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{`"a"`, []string{"a"}},
+		{`'a,b'`, []string{"a,b"}},
+		{"42", []string{"42"}},
+		{"true", []string{"true"}},
+		{`[]`, []string{}},
+		{`[ ]`, []string{}},
+		{`["a", "b"]`, []string{"a", "b"}},
+		{`["a", "b",]`, []string{"a", "b"}},
+		{`["a,b", "c"]`, []string{"a,b", "c"}},
+		{`['a,b', "c\",d"]`, []string{"a,b", `c",d`}},
+		{`["]", 1]`, []string{"]", "1"}},
+	}
+	for _, tt := range tests {
+		got, err := parseConfigValue(tt.in)
+		if err != nil {
+			t.Errorf("parseConfigValue(%s): %s", tt.in, err)
+		} else if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseConfigValue(%s) = %q; want %q", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{
+		"", `"a`, "nope", `["a"`,
+		`[,]`, `["a",,"b"]`, `[, "a"]`, `["a" "b"]`,
+		`["a,b]`, `['a, "b"]`, `[["a"]]`,
+	} {
+		if got, err := parseConfigValue(in); err == nil {
+			t.Errorf("parseConfigValue(%s) = %q; want error", in, got)
+		}
+	}
+}
+
+func TestIsNewerRelease(t *testing.T) {
+	tests := []struct {
+		latest, current string
+		want            bool
+	}{
+		{"v1.2.0", "v1.1.9", true},
+		{"v1.10.0", "v1.9.0", true},
+		{"v1.2.0", "v1.2.0-rc.1", true},
+		{"v1.2.0", "v1.2.0", false},
+		{"v1.2.0", "v1.3.0", false},
+		{"v1.2.0", "v1.2.1-0.20240102150405-abcdef123456", false},
+		{"v1.2.0", "v1.2.0+dirty", false},
+		{"v1.2.0", "(devel)", false},
+	}
+	for _, tt := range tests {
+		got, err := isNewerRelease(tt.latest, tt.current)
+		if err != nil || got != tt.want {
+			t.Errorf("isNewerRelease(%q, %q) = %v, %v; want %v", tt.latest, tt.current, got, err, tt.want)
+		}
+	}
+	if _, err := isNewerRelease("latest", "v1.0.0"); err == nil {
+		t.Error("isNewerRelease(\"latest\", \"v1.0.0\"): want error")
+	}
+}
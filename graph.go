@@ -0,0 +1,145 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+var cmdGraph = &command{
+	Name:      "graph",
+	UsageLine: "[-format dot|mermaid|json] [dir]",
+	Short:     "print the dependency graph of the tasks",
+	Long: `Graph prints the graph of the dependencies declared by the "gake:deps"
+directives of the task files into the directory, by default the current one,
+so that it can be rendered by Graphviz (dot) or Mermaid, or processed (json).
+The tasks of other packages are given as "dir:Task".
+
+An edge goes from a task to the one which depends on it, so in the order of
+the run. A dependency cycle is reported after the graph with its path, from
+every task to the one it depends on, and the exit status is 1.`,
+	Run: runGraph,
+}
+
+func runGraph(cmd *command, args []string) error {
+	fs := cmd.FlagSet()
+	format := fs.String("format", "dot", "output format: dot, mermaid or json")
+	fs.Parse(args)
+
+	var write func(io.Writer, []taskFunc) error
+	switch *format {
+	case "dot":
+		write = writeDot
+	case "mermaid":
+		write = writeMermaid
+	case "json":
+		write = writeGraphJSON
+	default:
+		return fmt.Errorf("invalid -format value %q: want dot, mermaid or json", *format)
+	}
+
+	dir := "."
+	if fs.NArg() > 0 {
+		dir = fs.Arg(0)
+	}
+	dir, err := resolveDir(dir)
+	if err != nil {
+		return err
+	}
+	pkg, err := ParseDir(dir)
+	if err != nil {
+		return err
+	}
+
+	tasks := pkg.taskFuncs()
+	if err = write(os.Stdout, tasks); err != nil {
+		return err
+	}
+	return pkg.checkCycle()
+}
+
+// taskFuncs returns the task functions of the package, sorted by file and in
+// the order of declaration into every file.
+func (p *taskPackage) taskFuncs() []taskFunc {
+	files := append([]taskFile(nil), p.Files...)
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+
+	tasks := make([]taskFunc, 0)
+	for _, f := range files {
+		tasks = append(tasks, f.TaskFuncs...)
+	}
+	return tasks
+}
+
+func writeDot(w io.Writer, tasks []taskFunc) error {
+	fmt.Fprintf(w, "digraph gake {\n\trankdir=LR;\n")
+	for _, task := range tasks {
+		fmt.Fprintf(w, "\t%q [tooltip=\"%s:%d\"];\n", task.Name, task.File, task.Line)
+	}
+	for _, task := range tasks {
+		for _, dep := range task.ExtDeps {
+			fmt.Fprintf(w, "\t%q [shape=box];\n", dep)
+		}
+	}
+	for _, task := range tasks {
+		for _, dep := range append(task.Deps, task.ExtDeps...) {
+			fmt.Fprintf(w, "\t%q -> %q;\n", dep, task.Name)
+		}
+	}
+	_, err := fmt.Fprintf(w, "}\n")
+	return err
+}
+
+func writeMermaid(w io.Writer, tasks []taskFunc) error {
+	fmt.Fprintf(w, "graph LR\n")
+	for _, task := range tasks {
+		fmt.Fprintf(w, "\t%s\n", task.Name)
+	}
+	// The tasks of other packages have a node identifier, since a path is
+	// not valid as one.
+	ext := make(map[string]string)
+	for _, task := range tasks {
+		for _, dep := range task.ExtDeps {
+			if _, ok := ext[dep]; !ok {
+				ext[dep] = fmt.Sprintf("ext%d", len(ext)+1)
+				fmt.Fprintf(w, "\t%s[%q]\n", ext[dep], dep)
+			}
+		}
+	}
+	for _, task := range tasks {
+		for _, dep := range task.Deps {
+			fmt.Fprintf(w, "\t%s --> %s\n", dep, task.Name)
+		}
+		for _, dep := range task.ExtDeps {
+			fmt.Fprintf(w, "\t%s --> %s\n", ext[dep], task.Name)
+		}
+	}
+	return nil
+}
+
+// graphNode is a task into the JSON output of the command graph.
+type graphNode struct {
+	Name string
+	File string
+	Line int
+	Deps []string // Tasks which have to be run before, "dir:Task" into other packages.
+}
+
+func writeGraphJSON(w io.Writer, tasks []taskFunc) error {
+	nodes := make([]graphNode, len(tasks))
+	for i, task := range tasks {
+		deps := append([]string{}, task.Deps...)
+		nodes[i] = graphNode{task.Name, task.File, task.Line, append(deps, task.ExtDeps...)}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(nodes)
+}
@@ -0,0 +1,250 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// listedPackage is the part of the output of "go list -json" used to know the
+// inputs of a build.
+type listedPackage struct {
+	ImportPath string
+	Dir        string
+	Standard   bool
+	Module     *struct {
+		Path    string
+		Version string
+		Main    bool
+		Replace *struct{ Path string }
+	}
+	Error *struct{ Err string }
+
+	GoFiles, CgoFiles, CFiles, CXXFiles, HFiles, SFiles, SysoFiles, EmbedFiles []string
+}
+
+// buildEnv are the variables of the go command which change the build of the
+// task binary, like GOFLAGS with its flags -tags or -race.
+var buildEnv = []string{
+	"GOFLAGS", "GOOS", "GOARCH", "GO386", "GOAMD64", "GOARM", "GOARM64", "GOMIPS",
+	"GOMIPS64", "GOPPC64", "GORISCV64", "GOWASM", "CGO_ENABLED", "GOEXPERIMENT",
+}
+
+// inputsHash returns the hash of the inputs of the build of the task binary: the
+// task files, the main template, the flags and the environment of the build, and
+// the source files of the packages which they import, got through
+// "go list -deps". The packages of the standard library and of a module version,
+// which do not change, are given by their path and version.
+func inputsHash(pkg *taskPackage) (string, error) {
+	var h inputList
+	h.printf("go %s\nmod %s\nvendor-tasking %v\nreproducible %v\n",
+		pkg.BuildInfo.GoVersion, *taskMod, *taskVendor, *taskReproducible)
+
+	// The values of "go env" include the ones set by "go env -w".
+	cmd := exec.Command(pkg.GoCmd, append([]string{"env"}, buildEnv...)...)
+	cmd.Dir = pkg.Dir
+	cmd.Env = goCacheEnv(pkg.GoCache)
+	env, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("go env: %s", err)
+	}
+	for i, value := range strings.Split(strings.TrimSuffix(string(env), "\n"), "\n") {
+		if i < len(buildEnv) {
+			h.printf("%s=%s\n", buildEnv[i], value)
+		}
+	}
+
+	if *taskMainTemplate != "" {
+		h.file(*taskMainTemplate)
+	}
+
+	for _, name := range taskFileNames(pkg) {
+		h.file(name)
+	}
+	imports, err := taskImports(pkg)
+	if err != nil {
+		return "", err
+	}
+
+	args := []string{"list", "-e", "-deps", "-tags", "gake", "-json"}
+	if *taskMod != "" {
+		args = append(args, "-mod="+*taskMod)
+	}
+	// The packages of the main module are listed without the task files.
+	tmpDir, err := os.MkdirTemp("", "gake-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmpDir)
+	if overlay, err := writeOverlay(pkg, tmpDir); err != nil {
+		return "", err
+	} else if overlay != "" {
+		args = append(args, "-overlay="+overlay)
+	}
+	cmd = exec.Command(pkg.GoCmd, append(args, imports...)...)
+	cmd.Dir = pkg.Dir
+	cmd.Env = goCacheEnv(pkg.GoCache)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	xtrace("cd %s\n%s", pkg.Dir, strings.Join(cmd.Args, " "))
+	start := time.Now()
+	out, err := cmd.Output()
+	xtrace("# go list: %v", time.Since(start).Round(time.Millisecond))
+	if err != nil {
+		return "", fmt.Errorf("%s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for {
+		var p listedPackage
+		if err = dec.Decode(&p); err == io.EOF {
+			break
+		} else if err != nil {
+			return "", err
+		}
+
+		h.printf("package %s\n", p.ImportPath)
+		switch {
+		case p.Error != nil:
+			// Like the package tasking embedded by -vendor-tasking.
+			h.printf("error %s\n", p.Error.Err)
+			continue
+		case p.Standard:
+			continue
+		case p.Module != nil && !p.Module.Main && p.Module.Replace == nil:
+			h.printf("module %s@%s\n", p.Module.Path, p.Module.Version)
+			continue
+		}
+
+		for _, list := range [][]string{p.GoFiles, p.CgoFiles, p.CFiles, p.CXXFiles, p.HFiles, p.SFiles, p.SysoFiles, p.EmbedFiles} {
+			for _, name := range list {
+				h.file(filepath.Join(p.Dir, name))
+			}
+		}
+	}
+	return h.sum()
+}
+
+// taskFileNames returns the names of the task files of the package, sorted.
+func taskFileNames(pkg *taskPackage) []string {
+	files := make([]string, 0, len(pkg.Files))
+	for _, f := range pkg.Files {
+		files = append(files, f.Name)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// taskImports returns the import paths of the task files of the package,
+// sorted.
+func taskImports(pkg *taskPackage) ([]string, error) {
+	importSet := make(map[string]bool)
+	fset := token.NewFileSet()
+	for _, name := range taskFileNames(pkg) {
+		file, err := parser.ParseFile(fset, name, nil, parser.ImportsOnly)
+		if err != nil {
+			return nil, err
+		}
+		for _, imp := range file.Imports {
+			if path, err := strconv.Unquote(imp.Path.Value); err == nil {
+				importSet[path] = true
+			}
+		}
+	}
+	imports := make([]string, 0, len(importSet))
+	for path := range importSet {
+		imports = append(imports, path)
+	}
+	sort.Strings(imports)
+	return imports, nil
+}
+
+// hashSem bounds the files hashed at the same time, by all the packages.
+var hashSem = make(chan bool, runtime.GOMAXPROCS(0))
+
+// inputList is the list of the inputs of a build. Its files are hashed once all
+// of them are known, at the same time.
+type inputList []input
+
+type input struct {
+	text string // Added as it is to the hash.
+	path string // File whose content is hashed, if text is empty.
+}
+
+// printf adds the formatted text.
+func (l *inputList) printf(format string, args ...interface{}) {
+	*l = append(*l, input{text: fmt.Sprintf(format, args...)})
+}
+
+// file adds the file, by its name and the hash of its content.
+func (l *inputList) file(path string) {
+	*l = append(*l, input{path: path})
+}
+
+// sum returns the hash of the inputs.
+func (l inputList) sum() (string, error) {
+	sums := make([][]byte, len(l))
+	errs := make([]error, len(l))
+	var wg sync.WaitGroup
+	for i, in := range l {
+		if in.path == "" {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, path string) {
+			defer wg.Done()
+			hashSem <- true
+			defer func() { <-hashSem }()
+			sums[i], errs[i] = hashFile(path)
+		}(i, in.path)
+	}
+	wg.Wait()
+
+	h := sha256.New()
+	for i, in := range l {
+		if in.path == "" {
+			io.WriteString(h, in.text)
+			continue
+		}
+		if errs[i] != nil {
+			return "", errs[i]
+		}
+		fmt.Fprintf(h, "file %s %x\n", filepath.Base(in.path), sums[i])
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashFile returns the hash of the content of the file.
+func hashFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
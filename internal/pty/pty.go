@@ -0,0 +1,38 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package pty opens pseudo-terminals, so that the programs run by gake and by
+// the tasks behave like in a terminal: with colors, progress bars and prompts.
+package pty
+
+import (
+	"errors"
+	"os"
+)
+
+// ErrUnsupported is returned by Open on the systems without pseudo-terminals.
+var ErrUnsupported = errors.New("pseudo-terminals are not supported on this system")
+
+// Open returns a new pseudo-terminal: its master side, which reads what is
+// written to the terminal and writes what is typed, and its slave side, to be
+// passed to a program as its terminal.
+func Open() (master, slave *os.File, err error) {
+	return open()
+}
+
+// control runs f with the descriptor of the file, without setting it in
+// blocking mode, unlike File.Fd.
+func control(f *os.File, fn func(fd uintptr) error) error {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var ferr error
+	if err = rc.Control(func(fd uintptr) { ferr = fn(fd) }); err != nil {
+		return err
+	}
+	return ferr
+}
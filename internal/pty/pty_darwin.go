@@ -0,0 +1,46 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package pty
+
+import (
+	"bytes"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+func open() (*os.File, *os.File, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	name := make([]byte, 128)
+	err = control(master, func(fd uintptr) error {
+		if err := ioctl(fd, syscall.TIOCPTYGRANT, 0); err != nil {
+			return err
+		}
+		if err := ioctl(fd, syscall.TIOCPTYUNLK, 0); err != nil {
+			return err
+		}
+		return ioctl(fd, syscall.TIOCPTYGNAME, uintptr(unsafe.Pointer(&name[0])))
+	})
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	if i := bytes.IndexByte(name, 0); i != -1 {
+		name = name[:i]
+	}
+
+	slave, err := os.OpenFile(string(name), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	return master, slave, nil
+}
@@ -0,0 +1,28 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// +build !linux,!darwin,!freebsd
+
+package pty
+
+import (
+	"os"
+	"os/exec"
+)
+
+func open() (*os.File, *os.File, error) { return nil, nil, ErrUnsupported }
+
+// InheritSize sets the size of the terminal to to the size of the terminal
+// from. It fails if from is not a terminal.
+func InheritSize(from, to *os.File) error { return ErrUnsupported }
+
+// SetSize sets the size of the terminal, in characters.
+func SetSize(f *os.File, rows, cols int) error { return ErrUnsupported }
+
+// SetControlling sets the command to be run into a new session, whose
+// controlling terminal is its standard input, which has to be the slave side of
+// a pseudo-terminal. So, the keys like Ctrl-C send signals to the command.
+func SetControlling(cmd *exec.Cmd) {}
@@ -0,0 +1,62 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// +build linux darwin freebsd
+
+package pty
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+	"unsafe"
+)
+
+type winsize struct {
+	rows, cols, xpixel, ypixel uint16
+}
+
+func ioctl(fd, req, arg uintptr) error {
+	if _, _, e := syscall.Syscall(syscall.SYS_IOCTL, fd, req, arg); e != 0 {
+		return e
+	}
+	return nil
+}
+
+// InheritSize sets the size of the terminal to to the size of the terminal
+// from. It fails if from is not a terminal.
+func InheritSize(from, to *os.File) error {
+	var ws winsize
+	err := control(from, func(fd uintptr) error {
+		return ioctl(fd, syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(&ws)))
+	})
+	if err != nil {
+		return err
+	}
+	return control(to, func(fd uintptr) error {
+		return ioctl(fd, syscall.TIOCSWINSZ, uintptr(unsafe.Pointer(&ws)))
+	})
+}
+
+// SetSize sets the size of the terminal, in characters.
+func SetSize(f *os.File, rows, cols int) error {
+	ws := winsize{rows: uint16(rows), cols: uint16(cols)}
+	return control(f, func(fd uintptr) error {
+		return ioctl(fd, syscall.TIOCSWINSZ, uintptr(unsafe.Pointer(&ws)))
+	})
+}
+
+// SetControlling sets the command to be run into a new session, whose
+// controlling terminal is its standard input, which has to be the slave side of
+// a pseudo-terminal. So, the keys like Ctrl-C send signals to the command.
+func SetControlling(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setsid = true
+	cmd.SysProcAttr.Setctty = true
+	cmd.SysProcAttr.Ctty = 0
+}
@@ -0,0 +1,188 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package semver implements the parsing, comparison and bumping of semantic
+// versions, shared by the command gake, to compare its releases, and the
+// package release.
+package semver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a semantic version, as defined at https://semver.org.
+type Version struct {
+	Major, Minor, Patch int
+
+	Pre   string // Pre-release identifiers, like "rc.1".
+	Build string // Build metadata.
+}
+
+// Parse parses a version with the form "1.2.3", optionally with a pre-release
+// and build metadata, like "1.2.3-rc.1+20240102"; the prefix "v" is allowed.
+func Parse(s string) (Version, error) {
+	var v Version
+	rest := strings.TrimPrefix(s, "v")
+
+	if i := strings.IndexByte(rest, '+'); i != -1 {
+		rest, v.Build = rest[:i], rest[i+1:]
+		if !validIdents(v.Build, false) {
+			return Version{}, fmt.Errorf("invalid version %q: bad build metadata", s)
+		}
+	}
+	if i := strings.IndexByte(rest, '-'); i != -1 {
+		rest, v.Pre = rest[:i], rest[i+1:]
+		if !validIdents(v.Pre, true) {
+			return Version{}, fmt.Errorf("invalid version %q: bad pre-release", s)
+		}
+	}
+
+	nums := strings.Split(rest, ".")
+	if len(nums) != 3 {
+		return Version{}, fmt.Errorf("invalid version %q: want MAJOR.MINOR.PATCH", s)
+	}
+	for i, p := range []*int{&v.Major, &v.Minor, &v.Patch} {
+		n, err := strconv.Atoi(nums[i])
+		if err != nil || n < 0 || (len(nums[i]) > 1 && nums[i][0] == '0') {
+			return Version{}, fmt.Errorf("invalid version %q: bad number %q", s, nums[i])
+		}
+		*p = n
+	}
+	return v, nil
+}
+
+// validIdents reports whether s is a list of identifiers separated by dots,
+// of alphanumerics and hyphens. When numeric is set, the numeric identifiers
+// can not have leading zeros.
+func validIdents(s string, numeric bool) bool {
+	for _, id := range strings.Split(s, ".") {
+		if id == "" {
+			return false
+		}
+		digits := true
+		for _, r := range id {
+			switch {
+			case '0' <= r && r <= '9':
+			case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', r == '-':
+				digits = false
+			default:
+				return false
+			}
+		}
+		if numeric && digits && len(id) > 1 && id[0] == '0' {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns the version without the prefix "v", like "1.2.3-rc.1".
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Pre != "" {
+		s += "-" + v.Pre
+	}
+	if v.Build != "" {
+		s += "+" + v.Build
+	}
+	return s
+}
+
+// Tag returns the name of the git tag of the version, like "v1.2.3".
+func (v Version) Tag() string { return "v" + v.String() }
+
+// Bump returns the next version, incrementing the part "major", "minor" or
+// "patch" and resetting the lower ones. The pre-release and build metadata are
+// removed, except that a pre-release is released as is by bumping its patch:
+// the patch bump of 1.3.0-rc.1 is 1.3.0.
+func (v Version) Bump(part string) (Version, error) {
+	pre := v.Pre
+	v.Pre, v.Build = "", ""
+
+	switch part {
+	case "major":
+		v.Major, v.Minor, v.Patch = v.Major+1, 0, 0
+	case "minor":
+		v.Minor, v.Patch = v.Minor+1, 0
+	case "patch":
+		if pre == "" {
+			v.Patch++
+		}
+	default:
+		return Version{}, fmt.Errorf("invalid part %q: want major, minor or patch", part)
+	}
+	return v, nil
+}
+
+// Compare returns -1, 0 or +1 when the precedence of v is lower, equal or
+// greater than the one of w. The build metadata are ignored.
+func (v Version) Compare(w Version) int {
+	for _, d := range []int{v.Major - w.Major, v.Minor - w.Minor, v.Patch - w.Patch} {
+		if d != 0 {
+			return sign(d)
+		}
+	}
+
+	// A pre-release has lower precedence than its normal version.
+	switch {
+	case v.Pre == w.Pre:
+		return 0
+	case v.Pre == "":
+		return 1
+	case w.Pre == "":
+		return -1
+	}
+
+	a, b := strings.Split(v.Pre, "."), strings.Split(w.Pre, ".")
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := compareIdent(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return sign(len(a) - len(b))
+}
+
+// compareIdent compares the pre-release identifiers: the numeric ones, of
+// digits only, by their value, and lower than the alphanumeric ones, which are
+// compared in ASCII order.
+func compareIdent(a, b string) int {
+	numA, numB := isNumeric(a), isNumeric(b)
+	switch {
+	case numA && numB:
+		// Without leading zeros, a longer number is greater.
+		if len(a) != len(b) {
+			return sign(len(a) - len(b))
+		}
+		return strings.Compare(a, b)
+	case numA:
+		return -1
+	case numB:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// isNumeric reports whether the identifier has only digits.
+func isNumeric(id string) bool {
+	for _, r := range id {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return id != ""
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
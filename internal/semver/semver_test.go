@@ -0,0 +1,138 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package semver
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	valid := []struct {
+		in   string
+		want Version
+	}{
+		{"1.2.3", Version{1, 2, 3, "", ""}},
+		{"v1.2.3", Version{1, 2, 3, "", ""}},
+		{"0.0.0", Version{0, 0, 0, "", ""}},
+		{"1.0.0-alpha", Version{1, 0, 0, "alpha", ""}},
+		{"1.0.0-alpha.1", Version{1, 0, 0, "alpha.1", ""}},
+		{"1.0.0-0.3.7", Version{1, 0, 0, "0.3.7", ""}},
+		{"1.0.0-x.7.z.92", Version{1, 0, 0, "x.7.z.92", ""}},
+		{"1.0.0-x-y-z.--", Version{1, 0, 0, "x-y-z.--", ""}},
+		{"1.0.0-alpha+001", Version{1, 0, 0, "alpha", "001"}},
+		{"1.0.0+20130313144700", Version{1, 0, 0, "", "20130313144700"}},
+		{"1.0.0-beta+exp.sha.5114f85", Version{1, 0, 0, "beta", "exp.sha.5114f85"}},
+		{"1.0.0+21AF26D3----117B344092BD", Version{1, 0, 0, "", "21AF26D3----117B344092BD"}},
+	}
+	for _, tt := range valid {
+		v, err := Parse(tt.in)
+		if err != nil {
+			t.Errorf("Parse(%q): %s", tt.in, err)
+			continue
+		}
+		if v != tt.want {
+			t.Errorf("Parse(%q) = %#v; want %#v", tt.in, v, tt.want)
+		}
+		if want := strings.TrimPrefix(tt.in, "v"); v.String() != want {
+			t.Errorf("Parse(%q).String() = %q; want %q", tt.in, v.String(), want)
+		}
+	}
+
+	invalid := []string{
+		"", "1", "1.2", "1.2.3.4", "01.2.3", "1.02.3", "1.2.03", "-1.2.3", "1.2.x",
+		"1.2.3-", "1.2.3+", "1.2.3-01", "1.2.3-alpha..1", "1.2.3-al_pha", "1.2.3+build..1",
+		"1.2.3-alpha+", "V1.2.3",
+	}
+	for _, s := range invalid {
+		if v, err := Parse(s); err == nil {
+			t.Errorf("Parse(%q) = %v; want error", s, v)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	// The examples of precedence of https://semver.org, in increasing order.
+	ordered := []string{
+		"1.0.0-alpha",
+		"1.0.0-alpha.1",
+		"1.0.0-alpha.beta",
+		"1.0.0-beta",
+		"1.0.0-beta.2",
+		"1.0.0-beta.11",
+		"1.0.0-rc.1",
+		"1.0.0",
+		"1.0.1",
+		"1.1.0",
+		"2.0.0",
+		"2.1.0",
+		"2.1.1",
+		"10.0.0",
+	}
+	for i, a := range ordered {
+		for j, b := range ordered {
+			want := sign(i - j)
+			if got := mustParse(t, a).Compare(mustParse(t, b)); got != want {
+				t.Errorf("%s.Compare(%s) = %d; want %d", a, b, got, want)
+			}
+		}
+	}
+
+	equal := [][2]string{
+		{"1.0.0+a", "1.0.0+b"},
+		{"1.0.0-rc.1+a", "1.0.0-rc.1"},
+		{"v1.2.3", "1.2.3"},
+	}
+	for _, p := range equal {
+		if got := mustParse(t, p[0]).Compare(mustParse(t, p[1])); got != 0 {
+			t.Errorf("%s.Compare(%s) = %d; want 0", p[0], p[1], got)
+		}
+	}
+
+	// The identifiers with a hyphen are alphanumeric, so greater than the
+	// numeric ones.
+	if got := mustParse(t, "1.0.0-1").Compare(mustParse(t, "1.0.0--1")); got != -1 {
+		t.Errorf("1.0.0-1.Compare(1.0.0--1) = %d; want -1", got)
+	}
+}
+
+func TestBump(t *testing.T) {
+	tests := []struct {
+		in, part, want string
+	}{
+		{"1.2.3", "patch", "1.2.4"},
+		{"1.2.3", "minor", "1.3.0"},
+		{"1.2.3", "major", "2.0.0"},
+		{"0.9.9+build.1", "patch", "0.9.10"},
+
+		// A pre-release is released by bumping its patch.
+		{"1.3.0-rc.1", "patch", "1.3.0"},
+		{"1.3.0-rc.1", "minor", "1.4.0"},
+		{"1.3.0-rc.1", "major", "2.0.0"},
+	}
+	for _, tt := range tests {
+		v, err := mustParse(t, tt.in).Bump(tt.part)
+		if err != nil {
+			t.Errorf("%s.Bump(%q): %s", tt.in, tt.part, err)
+		} else if v.String() != tt.want {
+			t.Errorf("%s.Bump(%q) = %s; want %s", tt.in, tt.part, v, tt.want)
+		}
+	}
+
+	if v, err := mustParse(t, "1.2.3").Bump("build"); err == nil {
+		t.Errorf("1.2.3.Bump(\"build\") = %s; want error", v)
+	}
+}
+
+func mustParse(t *testing.T, s string) Version {
+	t.Helper()
+	v, err := Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
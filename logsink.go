@@ -0,0 +1,53 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// Environment variables which pass to the task binary the system logs where
+// the summary of the run is written.
+const (
+	ENV_SYSLOG     = "GAKE_SYSLOG"
+	ENV_SYSLOG_TAG = "GAKE_SYSLOG_TAG"
+	ENV_EVENTLOG   = "GAKE_EVENTLOG"
+)
+
+// logSinksEnv returns the environment which sets the system logs where the
+// task binary writes the summary of the run, set into the table "log" of the
+// configuration:
+//
+//	[log]
+//	syslog = "local"     # Unix: "local", or a server like "udp://logs:514"
+//	syslog_tag = "gake"  # tag of the messages, "gake" by default
+//	eventlog = "gake"    # Windows: source of the events into the Application log
+//
+// The syslog is only used on Unix and the Event Log on Windows, so that the same
+// configuration serves both.
+func logSinksEnv(cfg *config) ([]string, error) {
+	var env []string
+
+	if addr := cfg.Get("log", "syslog"); addr != "" {
+		if addr != "local" {
+			u, err := url.Parse(addr)
+			if err != nil || (u.Scheme != "udp" && u.Scheme != "tcp") || u.Host == "" {
+				return nil, fmt.Errorf("%s: invalid log.syslog %q: want \"local\" or an address like \"udp://host:514\"",
+					cfg.path, addr)
+			}
+		}
+		env = append(env, ENV_SYSLOG+"="+addr)
+		if tag := cfg.Get("log", "syslog_tag"); tag != "" {
+			env = append(env, ENV_SYSLOG_TAG+"="+tag)
+		}
+	}
+	if source := cfg.Get("log", "eventlog"); source != "" {
+		env = append(env, ENV_EVENTLOG+"="+source)
+	}
+	return env, nil
+}
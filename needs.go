@@ -0,0 +1,68 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// ENV_NEEDS is the environment variable which passes to the task binary the
+// endpoints named into the configuration.
+const ENV_NEEDS = "GAKE_NEEDS"
+
+// needsEnv returns the environment which sets the endpoints which can be given
+// by name to the directive "gake:needs", set into the table "needs" of the
+// configuration:
+//
+//	[needs]
+//	postgres = "tcp://localhost:5432"
+//	api = "http://localhost:8080/health"
+func needsEnv(cfg *config) ([]string, error) {
+	names := cfg.Keys("needs")
+	if len(names) == 0 {
+		return nil, nil
+	}
+	sort.Strings(names)
+
+	needs := make([]string, 0, len(names))
+	for _, name := range names {
+		v := cfg.Get("needs", name)
+		if err := checkEndpoint(v); err != nil {
+			return nil, fmt.Errorf("%s: invalid needs.%s: %s", cfg.path, name, err)
+		}
+		if strings.ContainsAny(name, "=,") || strings.Contains(v, ",") {
+			return nil, fmt.Errorf("%s: invalid needs.%s: the name and the endpoint can not have commas", cfg.path, name)
+		}
+		needs = append(needs, name+"="+v)
+	}
+	return []string{ENV_NEEDS + "=" + strings.Join(needs, ",")}, nil
+}
+
+// checkEndpoint checks an endpoint needed by a task: "tcp://host:port", or an
+// HTTP URL.
+func checkEndpoint(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "tcp":
+		if u.Hostname() == "" || u.Port() == "" {
+			return fmt.Errorf("invalid endpoint %q: want tcp://host:port", s)
+		}
+	case "http", "https":
+		if u.Host == "" {
+			return fmt.Errorf("invalid endpoint %q: missing host", s)
+		}
+	default:
+		return fmt.Errorf("invalid endpoint %q: want tcp://host:port or an HTTP URL", s)
+	}
+	return nil
+}
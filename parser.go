@@ -10,9 +10,13 @@ import (
 	"errors"
 	"fmt"
 	"go/ast"
+	"go/build"
 	"go/parser"
 	"go/token"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"unicode"
 	"unicode/utf8"
@@ -22,12 +26,34 @@ const (
 	IMPORT_PATH     = `"github.com/tredoe/gake/tasking"`
 	PREFIX_FUNC     = "Task"
 	SUFFIX_TASKFILE = "_task.go"
+	SUFFIX_TASKPKG  = "_tasks"
+
+	// GO_BUILD_LINE and PLUS_BUILD_LINE are the lines of the build constraint
+	// of the task files, in the syntax of Go 1.17 and the previous one.
+	GO_BUILD_LINE   = "//go:build gake"
+	PLUS_BUILD_LINE = "// +build gake"
+
+	// MAIN_FUNC is the name of the function which controls the run of the tasks.
+	MAIN_FUNC = "TaskMain"
 )
 
 // taskPackage represents a package of task files.
 type taskPackage struct {
 	Name  string
+	Dir   string // Directory of the task files.
 	Files []taskFile
+
+	HasMain     bool   // The function TaskMain is declared.
+	TaskingPath string // Import path of the package tasking used by the files.
+
+	GoCmd     string    // Go command used to build the package.
+	GoCache   string    // Build cache given to the go command; "" for the one of Go.
+	BuildInfo buildInfo // Information embedded into the binary.
+	Env       []string  // Environment added to run the binary.
+	Args      []string  // Arguments of the binary; nil for the ones of the command line.
+	BuildLog  string    // Name of the build log of -buildlog; "" for BUILD_LOG.
+
+	Hidden []string // Task files hidden from the build; nil until hiddenTaskFiles.
 }
 
 // taskFile represents a set of declarations of task functions.
@@ -40,6 +66,211 @@ type taskFile struct {
 type taskFunc struct {
 	Name string
 	Doc  string
+	File string // Source file.
+	Line int    // Line of the declaration into the source file.
+
+	Mutexes []string    // Resources declared by "gake:mutex" directives.
+	Weight  int         // Cost declared by "gake:weight" directive.
+	Params  []taskParam // Parameters declared by "gake:param" directives.
+	Limits  *taskLimits // Resources declared by "gake:limit" directives.
+	Matrix  []taskAxis  // Axes declared by "gake:matrix" directives.
+	XFail   string      // Reason declared by "gake:xfail" directive.
+	Deps    []string    // Tasks declared by "gake:deps" directives.
+	ExtDeps []string    // Tasks of other packages declared by "gake:deps", like "./proto:TaskGenerate".
+	When    []string    // Conditions declared by "gake:when" directives.
+
+	Manifest *taskManifest // Manifest declared by "gake:manifest" directive.
+	Groups   []string      // Groups declared by "gake:group" directives.
+	Approve  string        // Question declared by "gake:approve" directive.
+	Isolate  bool          // Declared by "gake:isolate" directive.
+	User     string        // User declared by "gake:user" directive.
+	Needs    []string      // Endpoints declared by "gake:needs" directives.
+
+	depPos []token.Position // Position of the directive declaring every dependency.
+}
+
+// taskAxis represents an axis of the matrix of a task, which is run once per
+// combination of the values of all axes.
+type taskAxis struct {
+	Name   string
+	Values []string
+}
+
+// taskManifest represents the manifest of a task, a data file with a list of
+// entries, for which the task is run once per entry.
+type taskManifest struct {
+	Path  string // Relative to the directory of the task file, unless absolute.
+	Field string // Field with the name of the instance of every entry.
+}
+
+// taskParam represents a parameter of a task function.
+type taskParam struct {
+	Name     string
+	Type     string // string, int, bool or duration.
+	Required bool
+	Default  string
+}
+
+// taskLimits represents the resources which can be used by the processes
+// launched by a task.
+type taskLimits struct {
+	CPU    float64 // Number of CPUs.
+	Memory int64   // Bytes of memory.
+	Nice   int     // Adjustment of the scheduling priority.
+}
+
+// PREFIX_DIRECTIVE is the prefix of the comment lines, into the documentation
+// of a task function, which are interpreted by gake.
+const PREFIX_DIRECTIVE = "gake:"
+
+// docText returns the text of the documentation without the directives.
+func docText(doc *ast.CommentGroup) string {
+	lines := strings.SplitAfter(doc.Text(), "\n")
+	text := make([]string, 0, len(lines))
+
+	for _, l := range lines {
+		if !strings.HasPrefix(l, PREFIX_DIRECTIVE) {
+			text = append(text, l)
+		}
+	}
+	return strings.Join(text, "")
+}
+
+// parseDirectives extracts the gake directives from the documentation of a task
+// function, setting them in task.
+//
+// A directive is a comment line with the form "// gake:name arguments".
+func parseDirectives(fset *token.FileSet, doc *ast.CommentGroup, task *taskFunc) error {
+	if doc == nil {
+		return nil
+	}
+
+	for _, c := range doc.List {
+		line := strings.TrimSpace(strings.TrimPrefix(c.Text, "//"))
+		if !strings.HasPrefix(line, PREFIX_DIRECTIVE) {
+			continue
+		}
+		fields := strings.Fields(line[len(PREFIX_DIRECTIVE):])
+		if len(fields) == 0 {
+			return DirectiveError{fset.Position(c.Pos()), line, "missing directive name"}
+		}
+		name, args := fields[0], fields[1:]
+
+		switch name {
+		case "mutex":
+			if len(args) == 0 {
+				return DirectiveError{fset.Position(c.Pos()), line, "missing resource name"}
+			}
+			task.Mutexes = append(task.Mutexes, args...)
+		case "weight":
+			if len(args) != 1 {
+				return DirectiveError{fset.Position(c.Pos()), line, "want one weight"}
+			}
+			w, err := strconv.Atoi(args[0])
+			if err != nil || w <= 0 {
+				return DirectiveError{fset.Position(c.Pos()), line, "weight must be a positive integer"}
+			}
+			task.Weight = w
+		case "param":
+			param, err := parseParam(args)
+			if err != nil {
+				return DirectiveError{fset.Position(c.Pos()), line, err.Error()}
+			}
+			task.Params = append(task.Params, param)
+		case "matrix":
+			if task.Manifest != nil {
+				return DirectiveError{fset.Position(c.Pos()), line, "a task can not have matrix and manifest"}
+			}
+			axes, err := parseMatrix(args, task.Matrix)
+			if err != nil {
+				return DirectiveError{fset.Position(c.Pos()), line, err.Error()}
+			}
+			task.Matrix = append(task.Matrix, axes...)
+		case "manifest":
+			if task.Manifest != nil {
+				return DirectiveError{fset.Position(c.Pos()), line, "manifest declared twice"}
+			}
+			if len(task.Matrix) != 0 {
+				return DirectiveError{fset.Position(c.Pos()), line, "a task can not have matrix and manifest"}
+			}
+			m, err := parseManifest(args, filepath.Dir(fset.Position(c.Pos()).Filename))
+			if err != nil {
+				return DirectiveError{fset.Position(c.Pos()), line, err.Error()}
+			}
+			task.Manifest = m
+		case "deps":
+			if len(args) == 0 {
+				return DirectiveError{fset.Position(c.Pos()), line, "missing task name"}
+			}
+			for _, dep := range args {
+				if strings.Contains(dep, ":") {
+					dir := filepath.Dir(fset.Position(c.Pos()).Filename)
+					if err := checkExtDep(dep, dir); err != nil {
+						return DirectiveError{fset.Position(c.Pos()), line, err.Error()}
+					}
+					task.ExtDeps = append(task.ExtDeps, dep)
+					continue
+				}
+				if dep == task.Name {
+					return DirectiveError{fset.Position(c.Pos()), line, "a task can not depend on itself"}
+				}
+				task.Deps = append(task.Deps, dep)
+				task.depPos = append(task.depPos, fset.Position(c.Pos()))
+			}
+		case "when", "onlyif":
+			if err := parseConditions(args); err != nil {
+				return DirectiveError{fset.Position(c.Pos()), line, err.Error()}
+			}
+			task.When = append(task.When, args...)
+		case "group":
+			if len(args) == 0 {
+				return DirectiveError{fset.Position(c.Pos()), line, "missing group name"}
+			}
+			task.Groups = append(task.Groups, args...)
+		case "approve":
+			task.Approve = strings.Join(args, " ")
+			if task.Approve == "" {
+				task.Approve = "Run " + task.Name + "?"
+			}
+		case "isolate":
+			if len(args) != 0 {
+				return DirectiveError{fset.Position(c.Pos()), line, "isolate takes no arguments"}
+			}
+			task.Isolate = true
+		case "user":
+			if len(args) != 1 {
+				return DirectiveError{fset.Position(c.Pos()), line, "want one user name"}
+			}
+			task.User = args[0]
+		case "needs":
+			if len(args) == 0 {
+				return DirectiveError{fset.Position(c.Pos()), line, "missing endpoint"}
+			}
+			for _, e := range args {
+				if strings.Contains(e, "://") {
+					if err := checkEndpoint(e); err != nil {
+						return DirectiveError{fset.Position(c.Pos()), line, err.Error()}
+					}
+				}
+			}
+			task.Needs = append(task.Needs, args...)
+		case "xfail":
+			task.XFail = strings.Join(args, " ")
+			if task.XFail == "" {
+				task.XFail = "expected to fail"
+			}
+		case "limit":
+			if task.Limits == nil {
+				task.Limits = new(taskLimits)
+			}
+			if err := parseLimits(args, task.Limits); err != nil {
+				return DirectiveError{fset.Position(c.Pos()), line, err.Error()}
+			}
+		default:
+			return DirectiveError{fset.Position(c.Pos()), line, "unknown directive"}
+		}
+	}
+	return nil
 }
 
 // The "gake" command expects to find task functions in the "*_task.go" files.
@@ -48,12 +279,22 @@ type taskFunc struct {
 // not starting with a lower case letter) and should have the signature,
 //
 //	func TaskXXX(t *tasking.T) { ... }
+//
+// except TaskMain, which controls the run of the tasks:
+//
+//	func TaskMain(m *tasking.M) { ... }
 func ParseDir(path string) (*taskPackage, error) {
+	// The files are matched with the tag "gake" applied, like when they are
+	// built, so that the ones excluded by their build constraints are left out.
+	ctxt := build.Default
+	ctxt.BuildTags = append(append([]string(nil), ctxt.BuildTags...), "gake")
+
 	filter := func(info os.FileInfo) bool {
-		if strings.HasSuffix(info.Name(), SUFFIX_TASKFILE) {
-			return true
+		if !strings.HasSuffix(info.Name(), SUFFIX_TASKFILE) {
+			return false
 		}
-		return false
+		match, err := ctxt.MatchFile(path, info.Name())
+		return match || err != nil // The parser reports the errors.
 	}
 
 	fset := token.NewFileSet()
@@ -62,10 +303,27 @@ func ParseDir(path string) (*taskPackage, error) {
 	if err != nil {
 		return nil, err
 	}
+	if len(pkgs) > 1 {
+		// The files of other packages, whose name ends like the task files,
+		// are not task files if they have not the gake build constraint.
+		for name, pkg := range pkgs {
+			isTaskPkg := false
+			for _, file := range pkg.Files {
+				if gakeConstraint(file) != nil {
+					isTaskPkg = true
+					break
+				}
+			}
+			if !isTaskPkg {
+				delete(pkgs, name)
+			}
+		}
+		if len(pkgs) > 1 {
+			return nil, MultiPkgError{path, pkgs}
+		}
+	}
 	if len(pkgs) == 0 {
 		return nil, ErrNoTaskfile
-	} else if len(pkgs) > 1 {
-		return nil, MultiPkgError{path, pkgs}
 	}
 
 	pkgName := ""
@@ -73,11 +331,38 @@ func ParseDir(path string) (*taskPackage, error) {
 		pkgName = k
 		break
 	}
+	if pkgName != "main" && !strings.HasSuffix(pkgName, SUFFIX_TASKPKG) {
+		return nil, PkgNameError{path, pkgName}
+	}
 
 	goFiles := make([]taskFile, 0)
 
-	for filename, file := range pkgs[pkgName].Files {
+	hasMain := false
+	hasTasks := false
+	registers := false // Whether the tasks are added at run time too.
+
+	taskingPath := ""
+
+	// The files are parsed in the order of their names, since the tasks are
+	// run in the order of declaration.
+	files := pkgs[pkgName].Files
+	filenames := make([]string, 0, len(files))
+	for filename := range files {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+
+	for _, filename := range filenames {
+		file := files[filename]
 		taskFuncs := make([]taskFunc, 0)
+		fileHasMain := false
+
+		// The parameters are resolved through the import of the package
+		// tasking; without it, the error is given once the file has tasks.
+		importName, importPath := taskingImport(file)
+		if importName == "" {
+			importName = "tasking"
+		}
 
 		for _, decl := range file.Decls {
 			f, ok := decl.(*ast.FuncDecl)
@@ -96,67 +381,430 @@ func ParseDir(path string) (*taskPackage, error) {
 
 			// Check function signature
 
-			if f.Type.Results != nil || len(f.Type.Params.List) != 1 {
-				return nil, FuncSignError{fset, file, f}
+			if funcName == MAIN_FUNC {
+				if !hasTaskingParam(f, importName, "M") {
+					return nil, FuncSignError{fset, file, f, "M", importName}
+				}
+				fileHasMain = true
+				continue
 			}
-			pointerType, ok := f.Type.Params.List[0].Type.(*ast.StarExpr)
-			if !ok {
-				return nil, FuncSignError{fset, file, f}
+			if !hasTaskingParam(f, importName, "T") {
+				return nil, FuncSignError{fset, file, f, "T", importName}
+			}
+
+			pos := fset.Position(f.Pos())
+			task := taskFunc{Name: funcName, Doc: docText(f.Doc), File: pos.Filename, Line: pos.Line}
+			if err = parseDirectives(fset, f.Doc, &task); err != nil {
+				return nil, err
+			}
+			taskFuncs = append(taskFuncs, task)
+		}
+		fileRegisters := callsRegister(file, importName)
+		if len(taskFuncs) == 0 && !fileHasMain && !fileRegisters {
+			continue
+		}
+		hasTasks = hasTasks || len(taskFuncs) != 0 || fileRegisters
+		registers = registers || fileRegisters
+		hasMain = hasMain || fileHasMain
+
+		// Check import path
+		if importPath == "" {
+			return nil, ImportPathError{filename}
+		}
+		if taskingPath == "" {
+			taskingPath = importPath
+		}
+
+		// Check the build constraint
+		c := gakeConstraint(file)
+		if c == nil {
+			return nil, BuildConsError{filename}
+		}
+		// Check whether the build constraint is after of "package"
+		if c.Pos() > file.Package {
+			return nil, BuildConsPosError{filename}
+		}
+
+		goFiles = append(goFiles, taskFile{filename, taskFuncs})
+	}
+
+	if !hasTasks {
+		return nil, ErrNoTask
+	}
+	// The dependencies on the registered tasks are checked at run time.
+	if !registers {
+		if err = checkDeps(goFiles); err != nil {
+			return nil, err
+		}
+	}
+	return &taskPackage{
+		Name:        pkgName,
+		Dir:         path,
+		Files:       goFiles,
+		HasMain:     hasMain,
+		TaskingPath: taskingPath,
+		GoCmd:       "go",
+	}, nil
+}
+
+// gakeConstraint returns the comment with the build constraint "+build gake", or
+// "//go:build gake", of the file, or nil if it has not it.
+func gakeConstraint(file *ast.File) *ast.CommentGroup {
+	for _, c := range file.Comments {
+		comment := c.Text()
+		if strings.HasPrefix(comment, "+build") {
+			words := strings.Split(comment, " ")
+			if words[0] == "+build" && len(words) > 1 && words[1] == "gake\n" {
+				return c
 			}
-			selector, ok := pointerType.X.(*ast.SelectorExpr)
+		}
+		// The text of the comment has not the directives.
+		for _, line := range c.List {
+			if strings.TrimSpace(line.Text) == GO_BUILD_LINE {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+// callsRegister reports whether the file calls the function Register of the
+// package tasking, imported with the name importName, to add tasks at run time.
+func callsRegister(file *ast.File, importName string) bool {
+	found := false
+	ast.Inspect(file, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return !found
+		}
+		switch fun := call.Fun.(type) {
+		case *ast.SelectorExpr:
+			x, ok := fun.X.(*ast.Ident)
+			found = found || (ok && x.Name == importName && x.Obj == nil && fun.Sel.Name == "Register")
+		case *ast.Ident:
+			found = found || (importName == "." && fun.Name == "Register" && fun.Obj == nil)
+		}
+		return !found
+	})
+	return found
+}
+
+// checkExtDep checks the dependency on a task of another package, "dir:Task",
+// where dir is relative to the directory of the task file.
+func checkExtDep(dep, dir string) error {
+	i := strings.LastIndexByte(dep, ':')
+	path, name := dep[:i], dep[i+1:]
+	if !strings.HasPrefix(path, "./") && !strings.HasPrefix(path, "../") {
+		return fmt.Errorf("%s: the directory must be relative, starting with \"./\" or \"../\"", dep)
+	}
+	if !strings.HasPrefix(name, PREFIX_FUNC) {
+		return fmt.Errorf("%s: invalid task name %q", dep, name)
+	}
+	target := filepath.Join(dir, filepath.FromSlash(path))
+	if filepath.Clean(target) == filepath.Clean(dir) {
+		return fmt.Errorf("%s: the task is into this package; use its name", dep)
+	}
+	info, err := os.Stat(target)
+	if err != nil {
+		return fmt.Errorf("%s: %s", dep, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s: %s is not a directory", dep, path)
+	}
+	return nil
+}
+
+// checkDeps checks that the dependencies of every task are tasks of the package.
+func checkDeps(files []taskFile) error {
+	tasks := make(map[string]bool)
+	for _, f := range files {
+		for _, task := range f.TaskFuncs {
+			tasks[task.Name] = true
+		}
+	}
+
+	for _, f := range files {
+		for _, task := range f.TaskFuncs {
+			for i, dep := range task.Deps {
+				if !tasks[dep] {
+					return DepError{task.depPos[i], task.Name, dep}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// checkCycle checks that the dependencies among the tasks do not form a cycle,
+// which could not be run.
+func (p *taskPackage) checkCycle() error {
+	tasks := p.taskFuncs()
+	index := make(map[string]int, len(tasks))
+	for i, task := range tasks {
+		index[task.Name] = i
+	}
+
+	state := make([]int, len(tasks)) // 1 while its dependencies are visited, 2 once done.
+	path := make([]cycleStep, 0)
+
+	var visit func(i int) error
+	visit = func(i int) error {
+		switch state[i] {
+		case 2:
+			return nil
+		case 1:
+			for j, step := range path {
+				if step.task == tasks[i].Name {
+					return CycleError{path[j:]}
+				}
+			}
+		}
+		state[i] = 1
+		for k, dep := range tasks[i].Deps {
+			j, ok := index[dep]
 			if !ok {
-				return nil, FuncSignError{fset, file, f}
+				continue
 			}
-			if selector.X.(*ast.Ident).Name != "tasking" || selector.Sel.Name != "T" {
-				return nil, FuncSignError{fset, file, f}
+			path = append(path, cycleStep{tasks[i].depPos[k], tasks[i].Name, dep})
+			if err := visit(j); err != nil {
+				return err
 			}
+			path = path[:len(path)-1]
+		}
+		state[i] = 2
+		return nil
+	}
 
-			taskFuncs = append(taskFuncs, taskFunc{funcName, f.Doc.Text()})
+	for i := range tasks {
+		if err := visit(i); err != nil {
+			return err
 		}
-		if len(taskFuncs) == 0 {
+	}
+	return nil
+}
+
+// hasTaskingParam reports whether the function has no results and an only
+// parameter of type "*tasking.typeName", where the package tasking is imported
+// with the name importName.
+func hasTaskingParam(f *ast.FuncDecl, importName, typeName string) bool {
+	if f.Type.Results != nil || len(f.Type.Params.List) != 1 {
+		return false
+	}
+	pointerType, ok := f.Type.Params.List[0].Type.(*ast.StarExpr)
+	if !ok {
+		return false
+	}
+	if importName == "." {
+		ident, ok := pointerType.X.(*ast.Ident)
+		return ok && ident.Name == typeName
+	}
+	selector, ok := pointerType.X.(*ast.SelectorExpr)
+	if !ok {
+		return false
+	}
+	pkg, ok := selector.X.(*ast.Ident)
+	return ok && pkg.Name == importName && selector.Sel.Name == typeName
+}
+
+// taskingImport returns the name by which the file refers to the package
+// tasking, which is "." for a dot import, and its import path. The package is
+// imported by IMPORT_PATH or by a vanity import path ending in "/tasking". It
+// returns empty strings if the file does not import it.
+func taskingImport(file *ast.File) (name, path string) {
+	for _, imp := range file.Imports {
+		p, err := strconv.Unquote(imp.Path.Value)
+		if err != nil || (imp.Path.Value != IMPORT_PATH && !strings.HasSuffix(p, "/tasking")) {
 			continue
 		}
-
-		// Check import path
-		hasImportPath := false
-		for _, v := range file.Imports {
-			if v.Path.Value == IMPORT_PATH {
-				hasImportPath = true
-				break
+		name = "tasking"
+		if imp.Name != nil {
+			if imp.Name.Name == "_" {
+				continue
 			}
+			name = imp.Name.Name
 		}
-		if !hasImportPath {
-			return nil, ImportPathError{filename}
+		return name, p
+	}
+	return "", ""
+}
+
+// parseParam parses the arguments of a directive "gake:param", which have the
+// form "name type [required] [default=value]".
+func parseParam(args []string) (taskParam, error) {
+	var p taskParam
+
+	if len(args) < 2 {
+		return p, errors.New("want name and type of parameter")
+	}
+	p.Name, p.Type = args[0], args[1]
+
+	switch p.Type {
+	case "string", "int", "bool", "duration":
+	default:
+		return p, fmt.Errorf("invalid type %q: want string, int, bool or duration", p.Type)
+	}
+
+	for _, a := range args[2:] {
+		switch {
+		case a == "required":
+			p.Required = true
+		case strings.HasPrefix(a, "default="):
+			p.Default = a[len("default="):]
+		default:
+			return p, fmt.Errorf("invalid option %q: want required or default=value", a)
 		}
+	}
+	return p, nil
+}
 
-		// Check the build constraint
-		hasBuildCons := false
-		for _, c := range file.Comments {
-			comment := c.Text()
-			if strings.HasPrefix(comment, "+build") {
-				words := strings.Split(comment, " ")
-				if words[0] == "+build" && words[1] == "gake\n" {
-					// Check whether the build constraint is after of "package"
-					if c.Pos() > file.Package {
-						return nil, BuildConsPosError{filename}
-					}
+// parseLimits parses the arguments of a directive "gake:limit", which have the
+// form "cpu=n", "mem=size" or "nice=n", setting them in l.
+func parseLimits(args []string, l *taskLimits) error {
+	if len(args) == 0 {
+		return errors.New("want cpu=n, mem=size or nice=n")
+	}
 
-					hasBuildCons = true
-					break
+	for _, a := range args {
+		i := strings.IndexByte(a, '=')
+		if i == -1 {
+			return fmt.Errorf("invalid limit %q: want key=value", a)
+		}
+		key, value := a[:i], a[i+1:]
+
+		switch key {
+		case "cpu":
+			n, err := strconv.ParseFloat(value, 64)
+			if err != nil || n <= 0 {
+				return fmt.Errorf("invalid cpu %q: want a positive number", value)
+			}
+			l.CPU = n
+		case "mem":
+			n, err := parseSize(value)
+			if err != nil || n <= 0 {
+				return fmt.Errorf("invalid mem %q: want a size like 512m or 1g", value)
+			}
+			l.Memory = n
+		case "nice":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < -20 || n > 19 {
+				return fmt.Errorf("invalid nice %q: want a number from -20 to 19", value)
+			}
+			l.Nice = n
+		default:
+			return fmt.Errorf("unknown limit %q: want cpu, mem or nice", key)
+		}
+	}
+	return nil
+}
+
+// parseConditions checks the conditions of a "gake:when" directive, which
+// have the forms GOOS=value,..., GOARCH=value,..., env:NAME or env:NAME=value,
+// negated by a prefix '!'.
+func parseConditions(args []string) error {
+	if len(args) == 0 {
+		return errors.New("want GOOS=value, GOARCH=value, env:NAME or env:NAME=value")
+	}
+
+	for _, a := range args {
+		c := strings.TrimPrefix(a, "!")
+		switch {
+		case strings.HasPrefix(c, "env:"):
+			if name := c[len("env:"):]; name == "" || name[0] == '=' {
+				return fmt.Errorf("invalid condition %q: missing variable name", a)
+			}
+		case strings.HasPrefix(c, "GOOS="), strings.HasPrefix(c, "GOARCH="):
+			for _, v := range strings.Split(c[strings.IndexByte(c, '=')+1:], ",") {
+				if v == "" {
+					return fmt.Errorf("invalid condition %q: empty value", a)
 				}
 			}
+		default:
+			return fmt.Errorf("unknown condition %q: want GOOS=value, GOARCH=value or env:NAME", a)
 		}
-		if !hasBuildCons {
-			return nil, BuildConsError{filename}
+	}
+	return nil
+}
+
+// parseMatrix parses the arguments of a directive "gake:matrix", which have the
+// form "NAME=value,...". The axes already declared are in prev.
+func parseMatrix(args []string, prev []taskAxis) ([]taskAxis, error) {
+	if len(args) == 0 {
+		return nil, errors.New("want NAME=value,...")
+	}
+	axes := make([]taskAxis, 0, len(args))
+
+	for _, a := range args {
+		i := strings.IndexByte(a, '=')
+		if i <= 0 || i == len(a)-1 {
+			return nil, fmt.Errorf("invalid axis %q: want NAME=value,...", a)
 		}
+		axis := taskAxis{Name: a[:i], Values: strings.Split(a[i+1:], ",")}
 
-		goFiles = append(goFiles, taskFile{filename, taskFuncs})
+		for _, p := range append(prev, axes...) {
+			if p.Name == axis.Name {
+				return nil, fmt.Errorf("axis %q declared twice", axis.Name)
+			}
+		}
+		for _, v := range axis.Values {
+			if v == "" || strings.ContainsAny(v, "/-") {
+				return nil, fmt.Errorf("invalid value %q of axis %s: it can not be empty nor have '/' or '-'", v, axis.Name)
+			}
+		}
+		axes = append(axes, axis)
 	}
+	return axes, nil
+}
 
-	if len(goFiles) == 0 {
-		return nil, ErrNoTask
+// parseManifest parses the arguments of a directive "gake:manifest", which have
+// the form "path [name=field]", where path is relative to dir. The field with the
+// name of the instances is "name" by default.
+func parseManifest(args []string, dir string) (*taskManifest, error) {
+	if len(args) == 0 || len(args) > 2 {
+		return nil, errors.New("want path [name=field]")
+	}
+	m := &taskManifest{Path: args[0], Field: "name"}
+	switch ext := strings.ToLower(filepath.Ext(m.Path)); ext {
+	case ".json", ".yaml", ".yml":
+	default:
+		return nil, fmt.Errorf("unknown format %q: want .json, .yaml or .yml", ext)
+	}
+	if !filepath.IsAbs(m.Path) {
+		m.Path = filepath.Join(dir, m.Path)
+	}
+	if len(args) == 2 {
+		if !strings.HasPrefix(args[1], "name=") || args[1] == "name=" {
+			return nil, fmt.Errorf("invalid argument %q: want name=field", args[1])
+		}
+		m.Field = args[1][len("name="):]
 	}
-	return &taskPackage{pkgName, goFiles}, nil
+	return m, nil
+}
+
+// parseSize parses a size in bytes, which can have the suffix k, m or g for the
+// powers of 1024.
+func parseSize(s string) (int64, error) {
+	s = strings.TrimSuffix(strings.ToLower(s), "b")
+	mul := int64(1)
+
+	if n := len(s); n != 0 {
+		switch s[n-1] {
+		case 'k':
+			mul = 1 << 10
+		case 'm':
+			mul = 1 << 20
+		case 'g':
+			mul = 1 << 30
+		}
+		if mul != 1 {
+			s = s[:n-1]
+		}
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return n * mul, nil
 }
 
 // == Errors
@@ -185,18 +833,67 @@ func (e BuildConsPosError) Error() string {
 	return fmt.Sprintf("%s: build constraint after of \"package\" directive", e.filename)
 }
 
+// DirectiveError represents a directive which could not be interpreted.
+type DirectiveError struct {
+	pos  token.Position
+	line string
+	msg  string
+}
+
+func (e DirectiveError) Error() string {
+	return fmt.Sprintf("%s: %s: %q", e.pos, e.msg, e.line)
+}
+
+// DepError represents a dependency on a task which is not in the package.
+type DepError struct {
+	pos  token.Position
+	task string
+	dep  string
+}
+
+func (e DepError) Error() string {
+	return fmt.Sprintf("%s: %s depends on unknown task %q", e.pos, e.task, e.dep)
+}
+
+// CycleError represents dependencies among tasks which form a cycle.
+type CycleError struct {
+	path []cycleStep
+}
+
+// cycleStep is a dependency into a cycle, declared at pos.
+type cycleStep struct {
+	pos  token.Position
+	task string
+	dep  string
+}
+
+func (e CycleError) Error() string {
+	names := make([]string, 0, len(e.path)+1)
+	decls := make([]string, 0, len(e.path))
+	for _, step := range e.path {
+		names = append(names, step.task)
+		decls = append(decls, fmt.Sprintf("\n\t%s: %s depends on %s", step.pos, step.task, step.dep))
+	}
+	names = append(names, e.path[0].task)
+	return "dependency cycle: " + strings.Join(names, " -> ") + strings.Join(decls, "")
+}
+
 // FuncSignError represents an incorrect function signature.
 type FuncSignError struct {
 	fileSet  *token.FileSet
 	taskFile *ast.File
 	taskFunc *ast.FuncDecl
+	typeName string // Type of the parameter, T or M.
+
+	importName string // Name of the package tasking into the file.
 }
 
 func (e FuncSignError) Error() string {
-	return fmt.Sprintf("%s: %s.%s should have the signature func(*tasking.T)",
+	return fmt.Sprintf("%s: %s.%s should have the signature func(*tasking.%s)",
 		e.fileSet.Position(e.taskFile.Pos()),
 		e.taskFile.Name.Name,
 		e.taskFunc.Name.Name,
+		e.typeName,
 	)
 }
 
@@ -209,6 +906,18 @@ func (e ImportPathError) Error() string {
 	return fmt.Sprintf("%s: no import path: %s", e.filename, IMPORT_PATH)
 }
 
+// PkgNameError represents a package of task files which is not named "main" nor
+// with the suffix SUFFIX_TASKPKG.
+type PkgNameError struct {
+	path    string
+	pkgName string
+}
+
+func (e PkgNameError) Error() string {
+	return fmt.Sprintf("can't load package: package %q in '%s' should be \"main\" or end in %q",
+		e.pkgName, e.path, SUFFIX_TASKPKG)
+}
+
 // MultiPkgError represents an error due to multiple packages into a same directory.
 type MultiPkgError struct {
 	path string
@@ -227,10 +936,12 @@ func (e MultiPkgError) Error() string {
 			files[j] = "'" + fileName + "'"
 			j++
 		}
+		sort.Strings(files)
 
 		msg[i] = fmt.Sprintf("%q (%s)", pkgName, strings.Join(files, ", "))
 		i++
 	}
+	sort.Strings(msg)
 
 	return fmt.Sprintf("can't load package: found packages %s in '%s'",
 		strings.Join(msg, ", "),
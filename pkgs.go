@@ -0,0 +1,297 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// findTaskDirs returns the directories under root, itself included, which have
+// task files. Like the go tool, the directories "testdata" and "vendor", and
+// those beginning with "." or "_", are skipped.
+func findTaskDirs(root string) ([]string, error) {
+	dirs := make([]string, 0)
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if path != root {
+			name := info.Name()
+			if name == "testdata" || name == "vendor" ||
+				strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") {
+				return filepath.SkipDir
+			}
+		}
+
+		files, err := filepath.Glob(path + string(os.PathSeparator) + "*" + SUFFIX_TASKFILE)
+		if err != nil {
+			return err
+		}
+		if len(files) != 0 {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+
+	return dirs, err
+}
+
+// runPackages runs the tasks of every package, up to the number given by the
+// flag -p at the same time. Every line of output is prefixed by its label, and
+// a summary is printed at the end. With -json, the standard output is instead
+// a stream of JSON events, whose field Package is the label; the lines which
+// are not events are written as events "output", and there is no summary.
+//
+// It returns an *exec.ExitError if some task failed, so that it is not mistaken
+// for the failures of gake in other packages; else the first InfraError, if any.
+// The packages where no task matches -run or -names are reported as "none"; it
+// is a failure only if that happens in all of them.
+func runPackages(home string, targets []pkgTarget) error {
+	type result struct {
+		label    string
+		err      error
+		duration time.Duration
+	}
+	results := make([]result, len(targets))
+
+	var outMu sync.Mutex // Serializes the lines written to the standard output.
+	jsonOut := taskValues.bool("json")
+	var wg sync.WaitGroup
+	n := *taskP
+	if n < 1 {
+		n = 1
+	}
+	sem := make(chan bool, n)
+
+	// The standard input can not be shared by packages run in parallel.
+	var stdin io.Reader
+	if n == 1 && !*taskNoStdin {
+		stdin = os.Stdin
+	}
+
+	// The packages are parsed and the inputs of their builds hashed by a pool
+	// of workers, independent of -p, so that every package is ready to be
+	// built once a run can be started.
+	runs := make([]*taskRun, len(targets))
+	prepareErrs := make([]error, len(targets))
+	prepareTimes := make([]time.Duration, len(targets))
+	ready := make([]chan bool, len(targets))
+	for i := range ready {
+		ready[i] = make(chan bool)
+	}
+	jobs := make(chan int)
+	for w := 0; w < runtime.GOMAXPROCS(0) && w < len(targets); w++ {
+		go func() {
+			for i := range jobs {
+				start := time.Now()
+				runs[i], prepareErrs[i] = preparePackage(home, targets[i].dir)
+				if prepareErrs[i] == nil {
+					runs[i].pkg.Args = targets[i].args
+					runs[i].pkg.BuildLog = packageBuildLog(targets[i].label)
+					runs[i].pkg.Env = append(runs[i].pkg.Env, ENV_PACKAGE+"="+targets[i].label)
+				}
+				prepareTimes[i] = time.Since(start)
+				close(ready[i])
+			}
+		}()
+	}
+	go func() {
+		for i := range targets {
+			jobs <- i
+		}
+		close(jobs)
+	}()
+
+	for i, target := range targets {
+		wg.Add(1)
+		go func(i int, label string) {
+			defer wg.Done()
+			<-ready[i]
+			sem <- true
+			defer func() { <-sem }()
+
+			var stdout lineFlusher = &prefixWriter{w: os.Stdout, mu: &outMu, prefix: label + ": "}
+			if jsonOut {
+				stdout = &eventWriter{w: os.Stdout, mu: &outMu, pkg: label}
+			}
+			stderr := &prefixWriter{w: os.Stderr, mu: &outMu, prefix: label + ": "}
+			start := time.Now().Add(-prepareTimes[i])
+
+			err := prepareErrs[i]
+			if err == nil {
+				err = runs[i].run(stdin, stdout, stderr)
+			}
+			if _, ok := err.(*exec.ExitError); err != nil && !ok {
+				fmt.Fprintf(stderr, "%s\n", errorText(err))
+			}
+			stdout.Flush()
+			stderr.Flush()
+			results[i] = result{label, err, time.Since(start)}
+		}(i, target.label)
+	}
+	wg.Wait()
+
+	var taskErr, noTasksErr error
+	kind := ""  // Kind of the first failure of gake.
+	nInfra := 0 // Number of packages where gake failed.
+	nNone := 0  // Number of packages where no task matched.
+
+	for _, r := range results {
+		status := "ok  "
+		if isNoTasks(r.err) {
+			status = "none"
+			noTasksErr = r.err
+			nNone++
+		} else if r.err != nil {
+			status = "FAIL"
+			if _, ok := r.err.(*exec.ExitError); ok {
+				taskErr = r.err
+			} else {
+				if nInfra++; nInfra == 1 {
+					kind = INFRA_INTERNAL
+					if e, ok := r.err.(InfraError); ok {
+						kind = e.Kind
+					}
+				}
+			}
+		}
+		if !jsonOut {
+			fmt.Printf("%s\t%s\t%.3fs\n", status, r.label, r.duration.Seconds())
+		}
+	}
+
+	if taskErr != nil {
+		return taskErr
+	}
+	if nInfra != 0 {
+		return InfraError{kind, fmt.Errorf("gake failed in %d of %d packages", nInfra, len(targets))}
+	}
+	if nNone == len(targets) {
+		return noTasksErr
+	}
+	return nil
+}
+
+// lineFlusher is a writer of lines which holds the incomplete one until Flush.
+type lineFlusher interface {
+	io.Writer
+	Flush()
+}
+
+// prefixWriter writes every line with a prefix; the lines of several writers
+// which share the mutex are not interleaved.
+type prefixWriter struct {
+	w      io.Writer
+	mu     *sync.Mutex
+	prefix string
+	buf    []byte // Incomplete line.
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.buf = append(p.buf, b...)
+
+	i := bytes.LastIndexByte(p.buf, '\n')
+	if i == -1 {
+		return len(b), nil
+	}
+	lines := p.buf[:i+1]
+
+	out := make([]byte, 0, len(lines)+len(p.prefix)*bytes.Count(lines, []byte("\n")))
+	for len(lines) != 0 {
+		j := bytes.IndexByte(lines, '\n')
+		out = append(out, p.prefix...)
+		out = append(out, lines[:j+1]...)
+		lines = lines[j+1:]
+	}
+	p.buf = append(p.buf[:0], p.buf[i+1:]...)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, err := p.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Flush writes the incomplete line, if any.
+func (p *prefixWriter) Flush() {
+	if len(p.buf) != 0 {
+		p.Write([]byte("\n"))
+	}
+}
+
+// eventWriter writes the JSON events of the task binary of a package, and the
+// lines which are not events as events "output" of the package, so that the
+// standard output of a run of several packages with -json is a stream of JSON
+// objects.
+type eventWriter struct {
+	w   io.Writer
+	mu  *sync.Mutex
+	pkg string
+	buf []byte // Incomplete line.
+}
+
+// outputEvent is an event "output" of a line which is not an event.
+type outputEvent struct {
+	Time    time.Time
+	Action  string
+	Package string
+	Output  string
+}
+
+func (e *eventWriter) Write(b []byte) (int, error) {
+	e.buf = append(e.buf, b...)
+
+	i := bytes.LastIndexByte(e.buf, '\n')
+	if i == -1 {
+		return len(b), nil
+	}
+	lines := e.buf[:i+1]
+
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	for len(lines) != 0 {
+		j := bytes.IndexByte(lines, '\n')
+		line := lines[:j+1]
+		lines = lines[j+1:]
+
+		if bytes.HasPrefix(line, []byte("{")) && json.Valid(line) {
+			out.Write(line)
+		} else {
+			enc.Encode(outputEvent{time.Now(), "output", e.pkg, string(line)})
+		}
+	}
+	e.buf = append(e.buf[:0], e.buf[i+1:]...)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, err := e.w.Write(out.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Flush writes the incomplete line, if any.
+func (e *eventWriter) Flush() {
+	if len(e.buf) != 0 {
+		e.Write([]byte("\n"))
+	}
+}
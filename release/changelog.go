@@ -0,0 +1,94 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package release
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tredoe/gake/tasking"
+)
+
+// UNRELEASED is the name of the section of the changelog with the changes not
+// released yet.
+const UNRELEASED = "Unreleased"
+
+// ChangelogSection returns the text of the section of the version into the
+// changelog, in the format of "Keep a Changelog": the lines under the heading
+// "## [1.2.0]", also written "## 1.2.0" or "## v1.2.0" and optionally followed
+// by a date, until the next heading of the same level. The version can be
+// UNRELEASED.
+func ChangelogSection(path, version string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+
+	start := findSection(lines, version)
+	if start == -1 {
+		return "", fmt.Errorf("%s: no section for %s", path, version)
+	}
+	end := start + 1
+	for end < len(lines) && !strings.HasPrefix(lines[end], "## ") {
+		end++
+	}
+	return strings.TrimSpace(strings.Join(lines[start+1:end], "")), nil
+}
+
+// ReleaseChangelog renames the section UNRELEASED of the changelog to the
+// version, with the date of today, and adds a new empty section UNRELEASED
+// above it.
+func ReleaseChangelog(t *tasking.T, path string, v Version) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+
+	i := findSection(lines, UNRELEASED)
+	if i == -1 {
+		return fmt.Errorf("%s: no section for %s", path, UNRELEASED)
+	}
+	if findSection(lines, v.String()) != -1 {
+		return fmt.Errorf("%s: the section for %s already exists", path, v)
+	}
+	lines[i] = fmt.Sprintf("## [%s]\n\n## [%s] - %s\n", UNRELEASED, v, time.Now().Format("2006-01-02"))
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(path, []byte(strings.Join(lines, "")), info.Mode()); err != nil {
+		return err
+	}
+	t.Logf("%s: section %s released as %s", path, UNRELEASED, v)
+	return nil
+}
+
+// findSection returns the index of the line with the heading of the section of
+// the version, or -1 if it is not found.
+func findSection(lines []string, version string) int {
+	version = strings.TrimPrefix(version, "v")
+
+	for i, l := range lines {
+		if !strings.HasPrefix(l, "## ") {
+			continue
+		}
+		title := strings.Fields(l[3:])
+		if len(title) == 0 {
+			continue
+		}
+		name := strings.TrimPrefix(strings.Trim(title[0], "[]"), "v")
+		if strings.EqualFold(name, version) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,64 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package release
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/tredoe/gake/tasking"
+)
+
+// LatestTag returns the greatest version among the git tags with the form
+// "vX.Y.Z" of the repository into the working directory, or the zero version
+// if there is none. The tags which are not versions are ignored.
+func LatestTag(t *tasking.T) (Version, error) {
+	out, err := git(t, "tag", "--list", "v*")
+	if err != nil {
+		return Version{}, err
+	}
+
+	var latest Version
+	for _, tag := range strings.Fields(out) {
+		if v, err := Parse(tag); err == nil && v.Compare(latest) > 0 {
+			latest = v
+		}
+	}
+	return latest, nil
+}
+
+// CreateTag creates the annotated git tag of the version, with the message, on
+// the current commit. It fails if the working tree has uncommitted changes,
+// since they would not be into the tagged release.
+func CreateTag(t *tasking.T, v Version, message string) error {
+	out, err := git(t, "status", "--porcelain", "--untracked-files=no")
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(out) != "" {
+		return fmt.Errorf("can't tag %s: the working tree has uncommitted changes", v.Tag())
+	}
+	_, err = git(t, "tag", "--annotate", "--message", message, v.Tag())
+	return err
+}
+
+// PushTag pushes the git tag of the version to the remote, like "origin".
+func PushTag(t *tasking.T, remote string, v Version) error {
+	_, err := git(t, "push", remote, "refs/tags/"+v.Tag())
+	return err
+}
+
+// git runs git with the arguments, and returns its standard output.
+func git(t *tasking.T, args ...string) (string, error) {
+	var out bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Stdout = &out
+	err := t.ExecCmd(cmd)
+	return out.String(), err
+}
@@ -0,0 +1,22 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package release implements helpers for the release tasks: the parsing and
+// bumping of semantic versions, the git tags of the versions, and the sections
+// of a changelog.
+//
+// The functions which run git take the task, so that the commands and their
+// output are written to its log.
+package release
+
+import "github.com/tredoe/gake/internal/semver"
+
+// Version is a semantic version, as defined at https://semver.org.
+type Version = semver.Version
+
+// Parse parses a version with the form "1.2.3", optionally with a pre-release
+// and build metadata, like "1.2.3-rc.1+20240102"; the prefix "v" is allowed.
+func Parse(s string) (Version, error) { return semver.Parse(s) }
@@ -0,0 +1,60 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// Environment variables which pass the remote cache to the task binary.
+const (
+	ENV_REMOTE          = "GAKECACHE_REMOTE"
+	ENV_REMOTE_TOKEN    = "GAKECACHE_TOKEN"
+	ENV_REMOTE_READONLY = "GAKECACHE_READONLY"
+	ENV_REMOTE_SCOPE    = "GAKECACHE_SCOPE"
+)
+
+// remoteCacheEnv returns the environment which configures the remote cache of
+// the task binary, set into the table "cache" of the configuration:
+//
+//	[cache]
+//	remote = "https://cache.example.com/gake" # GET/PUT store
+//	token_env = "GAKE_CACHE_TOKEN"             # bearer token, read from the environment
+//	readonly = true                            # do not upload
+//
+// The data is scoped to the directory of the task files relative to the
+// configuration file, so that it is shared between machines.
+func remoteCacheEnv(cfg *config, dir string) ([]string, error) {
+	remote := cfg.Get("cache", "remote")
+	if remote == "" {
+		return nil, nil
+	}
+	if u, err := url.Parse(remote); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("%s: invalid cache.remote %q: want an HTTP URL", cfg.path, remote)
+	}
+	env := []string{ENV_REMOTE + "=" + remote}
+
+	if name := cfg.Get("cache", "token_env"); name != "" {
+		env = append(env, ENV_REMOTE_TOKEN+"="+os.Getenv(name))
+	}
+	if cfg.Get("cache", "readonly") == "true" {
+		env = append(env, ENV_REMOTE_READONLY+"=1")
+	}
+
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	scope, err := filepath.Rel(filepath.Dir(cfg.path), absDir)
+	if err != nil {
+		return nil, err
+	}
+	return append(env, ENV_REMOTE_SCOPE+"="+filepath.ToSlash(scope)), nil
+}
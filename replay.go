@@ -0,0 +1,305 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var cmdReplay = &command{
+	Name:      "replay",
+	UsageLine: "[-v] [-run regexp] [-color auto|always|never] file",
+	Short:     "print a run recorded with -json like the console",
+	Long: `Replay reads the JSON events of a run, recorded with "gake -json", and prints
+them like the console of gake, without running anything, so that a run of CI
+can be inspected locally. The file "-" is the standard input.
+
+The flag -v prints the output of all the tasks, like "gake -v"; -run prints only
+the tasks whose name matches the regular expression; and -color colors the
+status of the tasks, by default when the output is a terminal.
+
+The lines which are not JSON events are printed as they are. The exit status is
+1 if the recorded run failed.`,
+	Run: runReplay,
+}
+
+// replayEvent is the part of an event of the package tasking used by replay.
+type replayEvent struct {
+	Action   string
+	Package  string
+	Task     string
+	Elapsed  float64
+	Output   string
+	Warnings int
+	Usage    *struct {
+		UserTime, SystemTime time.Duration
+		MaxRSSDelta          int64
+		Alloc                uint64
+		NumGC                uint32
+		GCPause              time.Duration
+	}
+	Run *struct {
+		Command, Gake, Go, OS, Arch, Host, CI, Dir string
+		Args, Env                                  []string
+		CPUs                                       int
+	}
+	Group  string
+	Budget float64
+	Audit  []struct {
+		Task, Command, Dir, User string
+		Duration                 time.Duration
+		ExitCode                 int
+		Error                    string
+	}
+	Kind  string // Of the status written by gake.
+	Error string
+}
+
+// ANSI sequences to color the status.
+const (
+	COLOR_RED    = "\x1b[31m"
+	COLOR_GREEN  = "\x1b[32m"
+	COLOR_YELLOW = "\x1b[33m"
+	COLOR_RESET  = "\x1b[0m"
+)
+
+func runReplay(cmd *command, args []string) error {
+	fs := cmd.FlagSet()
+	verbose := fs.Bool("v", false, "print the output of all the tasks")
+	run := fs.String("run", "", "print only the tasks which match the regular expression")
+	color := fs.String("color", "auto", "color the status: auto, always or never")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(EXIT_INFRA)
+	}
+	var re *regexp.Regexp
+	if *run != "" {
+		var err error
+		if re, err = regexp.Compile(*run); err != nil {
+			return fmt.Errorf("invalid -run: %s", err)
+		}
+	}
+	colored := false
+	switch *color {
+	case "always":
+		colored = true
+	case "never":
+	case "auto":
+		colored = isColorTerminal(os.Stdout)
+	default:
+		return fmt.Errorf("invalid -color value %q: want auto, always or never", *color)
+	}
+
+	var r io.Reader = os.Stdin
+	if name := fs.Arg(0); name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	rp := &replayer{verbose: *verbose, match: re, colored: colored, output: make(map[string]string)}
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64<<10), 64<<20)
+	for s.Scan() {
+		line := s.Text()
+		var e replayEvent
+		if !strings.HasPrefix(line, "{") || json.Unmarshal([]byte(line), &e) != nil || e.Action == "" {
+			fmt.Println(line)
+			continue
+		}
+		rp.event(e)
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	if rp.failed {
+		os.Exit(EXIT_TASK)
+	}
+	return nil
+}
+
+// replayer prints the events of a run.
+type replayer struct {
+	verbose bool
+	match   *regexp.Regexp
+	colored bool
+
+	output map[string]string // Output of the running tasks.
+	failed bool
+}
+
+func (rp *replayer) event(e replayEvent) {
+	if e.Task != "" && rp.match != nil && !rp.match.MatchString(e.Task) {
+		return
+	}
+	if e.Package != "" && e.Task != "" {
+		// The tasks of several packages can have the same name.
+		e.Task = e.Package + ": " + e.Task
+	}
+
+	switch e.Action {
+	case "start":
+		if rp.verbose && e.Run != nil {
+			rp.printRunInfo(e)
+		}
+	case "run":
+		if rp.verbose {
+			fmt.Printf("=== RUN %s\n", e.Task)
+		}
+	case "output":
+		if e.Task == "" {
+			// A line of a package which is not an event.
+			fmt.Print(e.Output)
+			return
+		}
+		rp.output[e.Task] += e.Output
+	case "pass", "fail", "skip", "xfail":
+		if e.Task == "" {
+			// The whole run, of a package if there are several ones.
+			if e.Action == "fail" {
+				rp.failed = true
+			}
+			if e.Package != "" {
+				fmt.Printf("%s\t%s\n", rp.paint(strings.ToUpper(e.Action)), e.Package)
+			} else {
+				fmt.Println(rp.paint(strings.ToUpper(e.Action)))
+			}
+			return
+		}
+		status := strings.ToUpper(e.Action)
+		switch {
+		case e.Action == "fail":
+		case e.Action == "pass" && e.Warnings != 0:
+			status = "PASS (with warnings)"
+		case !rp.verbose:
+			delete(rp.output, e.Task)
+			return
+		}
+		fmt.Printf("--- %s: %s (%.2f seconds)\n%s", rp.paint(status), e.Task, e.Elapsed, rp.output[e.Task])
+		if rp.verbose && e.Usage != nil {
+			u := e.Usage
+			fmt.Printf("\tusage: user=%v sys=%v maxrss+=%dKB alloc=%dKB gc=%d gcpause=%v\n",
+				u.UserTime.Round(time.Millisecond), u.SystemTime.Round(time.Millisecond),
+				u.MaxRSSDelta>>10, u.Alloc>>10, u.NumGC, u.GCPause.Round(time.Microsecond))
+		}
+		delete(rp.output, e.Task)
+	case "notrun":
+		fmt.Printf("--- %s: %s\n", rp.paint("NOT RUN"), e.Task)
+	case "budget":
+		status := "BUDGET"
+		if e.Elapsed > e.Budget {
+			status = rp.paint("BUDGET EXCEEDED")
+		}
+		spent := time.Duration(e.Elapsed * float64(time.Second))
+		limit := time.Duration(e.Budget * float64(time.Second))
+		fmt.Printf("%s: %s %s of %s (%.0f%%)\n", status, e.Group,
+			spent.Round(time.Millisecond), limit, 100*e.Elapsed/e.Budget)
+	case "audit":
+		if !rp.verbose {
+			return
+		}
+		header := false
+		for _, r := range e.Audit {
+			if rp.match != nil && !rp.match.MatchString(r.Task) {
+				continue
+			}
+			if !header {
+				fmt.Println("=== AUDIT")
+				header = true
+			}
+			as := ""
+			if r.User != "" {
+				as = "as " + r.User + ", "
+			}
+			s := fmt.Sprintf("%s: $ %s (%sdir %s, %s, exit %d)", r.Task, r.Command, as, r.Dir,
+				r.Duration.Round(time.Millisecond), r.ExitCode)
+			if r.Error != "" && r.ExitCode == -1 {
+				s += ": " + r.Error
+			}
+			fmt.Printf("\t%s\n", s)
+		}
+	case "status":
+		// The failure of gake itself.
+		if e.Error != "" {
+			fmt.Printf("%s [%s]: %s\n", rp.paint("FAIL"), e.Kind, e.Error)
+			rp.failed = true
+		}
+	}
+}
+
+// printRunInfo prints the header of the run, like the flag -v.
+func (rp *replayer) printRunInfo(e replayEvent) {
+	info := e.Run
+	args := make([]string, len(info.Args))
+	for i, a := range info.Args {
+		if a == "" || strings.ContainsAny(a, " \t\n\"'\\$`;&|<>*?()[]{}#~") {
+			a = strconv.Quote(a)
+		}
+		args[i] = a
+	}
+	cpus := ""
+	if info.CPUs != 0 {
+		cpus = strconv.Itoa(info.CPUs)
+	}
+
+	fmt.Println("=== RUN INFO")
+	for _, p := range [][2]string{
+		{"command", info.Command},
+		{"args", strings.Join(args, " ")},
+		{"env", strings.Join(info.Env, ",")},
+		{"gake", info.Gake},
+		{"go", info.Go},
+		{"os", info.OS + "/" + info.Arch},
+		{"host", info.Host},
+		{"cpus", cpus},
+		{"ci", info.CI},
+		{"dir", info.Dir},
+	} {
+		if p[1] != "" {
+			fmt.Printf("\t%s: %s\n", p[0], p[1])
+		}
+	}
+}
+
+// paint colors the status, if the output is colored.
+func (rp *replayer) paint(status string) string {
+	if !rp.colored {
+		return status
+	}
+	color := COLOR_GREEN
+	switch {
+	case strings.HasPrefix(status, "FAIL"), strings.HasPrefix(status, "BUDGET"):
+		color = COLOR_RED
+	case strings.HasPrefix(status, "SKIP"), strings.HasPrefix(status, "XFAIL"),
+		strings.HasPrefix(status, "PASS ("), strings.HasPrefix(status, "NOT RUN"):
+		color = COLOR_YELLOW
+	}
+	return color + status + COLOR_RESET
+}
+
+// isColorTerminal reports whether the file is a terminal which can show colors,
+// unless the variable NO_COLOR is set.
+func isColorTerminal(f *os.File) bool {
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
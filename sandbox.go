@@ -0,0 +1,59 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ENV_SANDBOX_WRITABLE is the environment variable which passes to the sandbox
+// the paths which the task binary can write, separated by os.PathListSeparator.
+// It is not passed to the task binary.
+const ENV_SANDBOX_WRITABLE = "GAKE_SANDBOX_WRITABLE"
+
+// SANDBOX_ARG0 is the name which gake is run with to set up the sandbox, before
+// running the task binary into it.
+const SANDBOX_ARG0 = "gake-sandbox"
+
+// sandboxEnv returns the environment which sets the paths writable into the
+// sandbox of -sandbox: the directory of the task files, the working directory,
+// the temporary directory, the cache of gake and the output directory, and the
+// paths of the key "writable" into the table "sandbox" of the configuration,
+// relative to its file:
+//
+//	[sandbox]
+//	writable = ["build", "/var/cache/app"]
+func sandboxEnv(cfg *config, dir string) ([]string, error) {
+	if !*taskSandbox {
+		return nil, nil
+	}
+	paths := []string{dir, ".", os.TempDir(), os.Getenv(ENV_CACHE)}
+	if out := taskValues.string("outputdir"); out != "" {
+		paths = append(paths, out)
+	}
+	for _, p := range cfg.GetList("sandbox", "writable") {
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(filepath.Dir(cfg.path), p)
+		}
+		paths = append(paths, p)
+	}
+
+	writable := make([]string, 0, len(paths))
+	for _, p := range paths {
+		if p == "" {
+			continue
+		}
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return nil, err
+		}
+		writable = append(writable, abs)
+	}
+	return []string{ENV_SANDBOX_WRITABLE + "=" + strings.Join(writable, string(os.PathListSeparator))}, nil
+}
@@ -0,0 +1,214 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// sandboxCommand changes the command of the task binary to be run into the
+// sandbox: gake is run as SANDBOX_ARG0 into new mount and network namespaces,
+// and into a new user namespace where it is root unless it is already, to make
+// the file system read-only but the writable paths before running the binary.
+func sandboxCommand(cmd *exec.Cmd) error {
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd.Args = append([]string{SANDBOX_ARG0, cmd.Path}, cmd.Args[1:]...)
+	cmd.Path = self
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = new(syscall.SysProcAttr)
+	}
+	cmd.SysProcAttr.Cloneflags = syscall.CLONE_NEWNS | syscall.CLONE_NEWNET
+	if os.Geteuid() != 0 {
+		cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWUSER
+		cmd.SysProcAttr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Geteuid(), Size: 1}}
+		cmd.SysProcAttr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getegid(), Size: 1}}
+		cmd.SysProcAttr.GidMappingsEnableSetgroups = false
+	}
+	return nil
+}
+
+// sandboxInit sets up the sandbox and runs the task binary into it, if gake is
+// run as SANDBOX_ARG0; it does not return then.
+func sandboxInit() {
+	if len(os.Args) < 2 || os.Args[0] != SANDBOX_ARG0 {
+		return
+	}
+	var writable []string
+	for _, p := range filepath.SplitList(os.Getenv(ENV_SANDBOX_WRITABLE)) {
+		if p != "" {
+			writable = append(writable, p)
+		}
+	}
+	env := make([]string, 0, len(os.Environ()))
+	for _, v := range os.Environ() {
+		if !strings.HasPrefix(v, ENV_SANDBOX_WRITABLE+"=") {
+			env = append(env, v)
+		}
+	}
+
+	err := setupSandbox(writable)
+	if err == nil {
+		err = syscall.Exec(os.Args[1], os.Args[1:], env)
+	}
+	fmt.Fprintf(os.Stderr, "gake: sandbox: %s\n", err)
+	os.Exit(EXIT_INFRA)
+}
+
+// setupSandbox makes the mounts read-only, but the writable paths and the
+// devices, and brings up the loopback interface, the only one of the network.
+func setupSandbox(writable []string) error {
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("can't make the mounts private: %s", err)
+	}
+
+	// The writable paths are mounted apart, so that they are not changed with
+	// the mounts which they are into.
+	keep := []string{"/dev", "/proc", "/sys"}
+	for _, p := range writable {
+		if err := os.MkdirAll(p, 0755); err != nil {
+			return err
+		}
+		real, err := filepath.EvalSymlinks(p)
+		if err != nil {
+			return err
+		}
+		if err = syscall.Mount(real, real, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			return fmt.Errorf("can't mount %s: %s", real, err)
+		}
+		keep = append(keep, real)
+	}
+
+	mounts, err := readMounts()
+	if err != nil {
+		return err
+	}
+	for _, m := range mounts {
+		if isUnder(m.point, keep) {
+			continue
+		}
+		flags := uintptr(syscall.MS_REMOUNT|syscall.MS_BIND|syscall.MS_RDONLY) | m.flags
+		if err := syscall.Mount("", m.point, "", flags, ""); err != nil {
+			return fmt.Errorf("can't make %s read-only: %s", m.point, err)
+		}
+	}
+
+	if err := loopbackUp(); err != nil {
+		return fmt.Errorf("can't bring up the loopback interface: %s", err)
+	}
+	// The working directory is entered again, to be into its new mount.
+	return os.Chdir(wd)
+}
+
+// mountPoint is a mount of /proc/self/mountinfo.
+type mountPoint struct {
+	point string
+	flags uintptr // Flags of the mount, which have to be kept on a remount.
+}
+
+// mountFlags are the options of the mounts which are kept on a remount, since
+// they can be locked into a user namespace.
+var mountFlags = map[string]uintptr{
+	"nosuid":     syscall.MS_NOSUID,
+	"nodev":      syscall.MS_NODEV,
+	"noexec":     syscall.MS_NOEXEC,
+	"noatime":    syscall.MS_NOATIME,
+	"nodiratime": syscall.MS_NODIRATIME,
+	"relatime":   syscall.MS_RELATIME,
+}
+
+// readMounts returns the mounts of the process.
+func readMounts() ([]mountPoint, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var mounts []mountPoint
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		// id parent major:minor root point options ...
+		fields := strings.Fields(s.Text())
+		if len(fields) < 6 {
+			continue
+		}
+		m := mountPoint{point: unescapeMount(fields[4])}
+		for _, opt := range strings.Split(fields[5], ",") {
+			m.flags |= mountFlags[opt]
+		}
+		mounts = append(mounts, m)
+	}
+	return mounts, s.Err()
+}
+
+// unescapeMount decodes the octal escapes, like "\040", of a path of
+// /proc/self/mountinfo.
+func unescapeMount(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// isUnder reports whether the path is one of the dirs or is into them.
+func isUnder(path string, dirs []string) bool {
+	for _, d := range dirs {
+		if path == d || strings.HasPrefix(path, strings.TrimSuffix(d, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// loopbackUp brings up the interface "lo" of the network namespace.
+func loopbackUp() error {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+
+	var ifr struct {
+		name  [syscall.IFNAMSIZ]byte
+		flags uint16
+		_     [22]byte
+	}
+	copy(ifr.name[:], "lo")
+	ifr.flags = syscall.IFF_UP | syscall.IFF_RUNNING
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.SIOCSIFFLAGS,
+		uintptr(unsafe.Pointer(&ifr))); errno != 0 {
+		return errno
+	}
+	return nil
+}
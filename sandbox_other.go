@@ -0,0 +1,21 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// sandboxCommand fails since the sandbox is only supported on Linux.
+func sandboxCommand(cmd *exec.Cmd) error {
+	return errors.New("-sandbox is only supported on Linux")
+}
+
+func sandboxInit() {}
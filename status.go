@@ -0,0 +1,135 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// Exit status of gake, so that CI can retry the failures of gake itself but not
+// the ones of the tasks. The ones of the task binary, from the package tasking,
+// are the same.
+const (
+	EXIT_TASK        = 1 // Some task failed.
+	EXIT_INFRA       = 2 // Failure of gake itself: parse, build, configuration or cache.
+	EXIT_TIMEOUT     = 3 // The run exceeded -timeout.
+	EXIT_INTERRUPTED = 4 // The run was aborted by an interrupt or SIGTERM.
+	EXIT_NO_TASKS    = 5 // No task matched -run or -names.
+)
+
+// exitCodes is the table of the exit statuses, printed by -print-exit-codes.
+var exitCodes = []struct {
+	code   int
+	status string // Of the JSON object of -json.
+	doc    string
+}{
+	{0, "pass", "all the tasks run have passed"},
+	{EXIT_TASK, "fail", "some task failed"},
+	{EXIT_INFRA, "infra-fail", "gake failed to resolve, parse, configure or build the tasks, or the task binary rejected its flags"},
+	{EXIT_TIMEOUT, "timeout", "the run exceeded -timeout"},
+	{EXIT_INTERRUPTED, "interrupted", "the run was aborted by an interrupt or SIGTERM"},
+	{EXIT_NO_TASKS, "no-tasks", "no task matched -run or -names, unless -allow-no-tasks is set"},
+}
+
+// printExitCodes prints the table of the exit statuses.
+func printExitCodes() {
+	for _, c := range exitCodes {
+		fmt.Printf("%d\t%-13s%s\n", c.code, c.status, c.doc)
+	}
+}
+
+// Kinds of infrastructure failures.
+const (
+	INFRA_CACHE     = "cache"     // Directory of kept binaries.
+	INFRA_CONFIG    = "config"    // Configuration file.
+	INFRA_BUILD     = "build"     // Build of the task binary.
+	INFRA_PARSE     = "parse"     // Parse of the task files.
+	INFRA_RESOLVE   = "resolve"   // Resolution of the packages to run.
+	INFRA_TOOLCHAIN = "toolchain" // Go toolchain.
+	INFRA_USAGE     = "usage"     // Flags or declarations rejected by the task binary.
+	INFRA_INTERNAL  = "internal"  // Any other failure.
+)
+
+// InfraError represents a failure of gake itself rather than of the tasks.
+type InfraError struct {
+	Kind string
+	Err  error
+}
+
+func (e InfraError) Error() string { return e.Err.Error() }
+
+// infraError returns err as an InfraError of the given kind, if it is not nil.
+func infraError(kind string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(InfraError); ok {
+		return err
+	}
+	return InfraError{kind, err}
+}
+
+// runStatus represents the result of a run, printed as the last JSON object of
+// the output when the -json flag is set.
+type runStatus struct {
+	Action string // Always "status".
+	Status string // pass, fail, timeout, interrupted, no-tasks or infra-fail.
+	Kind   string `json:",omitempty"` // Kind of infrastructure failure.
+	Error  string `json:",omitempty"`
+}
+
+// exitStatus returns the exit status of gake for the error of a run, and its
+// status to report.
+func exitStatus(err error) (int, runStatus) {
+	switch e := err.(type) {
+	case nil:
+		return 0, runStatus{Action: "status", Status: "pass"}
+	case *exec.ExitError:
+		switch code := e.ExitCode(); code {
+		case EXIT_TIMEOUT, EXIT_INTERRUPTED, EXIT_NO_TASKS:
+			for _, c := range exitCodes {
+				if c.code == code {
+					return code, runStatus{Action: "status", Status: c.status}
+				}
+			}
+		case EXIT_INFRA:
+			return EXIT_INFRA, runStatus{"status", "infra-fail", INFRA_USAGE, "the task binary exited with status 2: invalid flags or tasks, or a panic out of the tasks"}
+		case -1: // Killed by a signal.
+			return EXIT_INTERRUPTED, runStatus{Action: "status", Status: "interrupted"}
+		}
+		return EXIT_TASK, runStatus{Action: "status", Status: "fail"}
+	case InfraError:
+		return EXIT_INFRA, runStatus{"status", "infra-fail", e.Kind, e.Error()}
+	default:
+		return EXIT_INFRA, runStatus{"status", "infra-fail", INFRA_INTERNAL, e.Error()}
+	}
+}
+
+// isNoTasks reports whether the error is the exit of the task binary when no
+// task matched -run or -names.
+func isNoTasks(err error) bool {
+	e, ok := err.(*exec.ExitError)
+	return ok && e.ExitCode() == EXIT_NO_TASKS
+}
+
+// exit terminates gake with the exit status for the error of the run, which is
+// printed unless it comes from the task binary. With the flag -json, the status
+// is also printed to standard output as a JSON object.
+func exit(err error) {
+	code, status := exitStatus(err)
+	if _, ok := err.(*exec.ExitError); err != nil && !ok {
+		fmt.Fprintf(os.Stderr, "%s\n", errorText(err))
+	}
+	if taskValues.bool("json") {
+		json.NewEncoder(os.Stdout).Encode(status)
+	}
+	printTimings()
+	os.Exit(code)
+}
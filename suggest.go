@@ -0,0 +1,127 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"go/parser"
+	"go/token"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// A suggester is an error of the task files which can show the code at fault,
+// marked by a caret, and the code which fixes it.
+type suggester interface {
+	Suggestion() string
+}
+
+// errorText returns the message of the error followed by its suggestion, if it
+// has one.
+func errorText(err error) string {
+	msg := err.Error()
+	if e, ok := err.(InfraError); ok {
+		err = e.Err
+	}
+	if s, ok := err.(suggester); ok {
+		if sug := s.Suggestion(); sug != "" {
+			msg += "\n" + strings.TrimSuffix(sug, "\n")
+		}
+	}
+	return msg
+}
+
+func (e BuildConsError) Suggestion() string {
+	pos, ok := packagePos(e.filename)
+	if !ok {
+		return ""
+	}
+	return markLine(e.filename, pos.Line, pos.Column, "the build constraint goes before this line") +
+		"  fix: add these lines before \"package\", followed by a blank line:\n\n" +
+		"\t" + GO_BUILD_LINE + "\n\t" + PLUS_BUILD_LINE + "\n"
+}
+
+func (e BuildConsPosError) Suggestion() string {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, e.filename, nil, parser.ParseComments|parser.PackageClauseOnly)
+	if err != nil {
+		return ""
+	}
+	c := gakeConstraint(file)
+	if c == nil {
+		return ""
+	}
+	pos := fset.Position(c.Pos())
+	return markLine(e.filename, pos.Line, pos.Column, "build constraint after \"package\"") +
+		fmt.Sprintf("  fix: move it before \"package\", at line %d, followed by a blank line:\n\n",
+			fset.Position(file.Package).Line) +
+		"\t" + GO_BUILD_LINE + "\n\t" + PLUS_BUILD_LINE + "\n"
+}
+
+func (e ImportPathError) Suggestion() string {
+	pos, ok := packagePos(e.filename)
+	if !ok {
+		return ""
+	}
+	return markLine(e.filename, pos.Line, pos.Column, "the package tasking is not imported") +
+		"  fix: add the import after \"package\":\n\n" +
+		"\timport " + IMPORT_PATH + "\n"
+}
+
+func (e FuncSignError) Suggestion() string {
+	pos := e.fileSet.Position(e.taskFunc.Pos())
+	importName := e.importName
+	if importName == "" {
+		importName = "tasking"
+	}
+	param := strings.ToLower(e.typeName) + " *" + importName + "." + e.typeName
+	if importName == "." {
+		param = strings.ToLower(e.typeName) + " *" + e.typeName
+	}
+	return markLine(pos.Filename, pos.Line, pos.Column, "wrong signature") +
+		"  fix: declare it as:\n\n" +
+		"\tfunc " + e.taskFunc.Name.Name + "(" + param + ") {\n"
+}
+
+// packagePos returns the position of the "package" clause of the file.
+func packagePos(filename string) (token.Position, bool) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filename, nil, parser.PackageClauseOnly)
+	if err != nil {
+		return token.Position{}, false
+	}
+	return fset.Position(file.Package), true
+}
+
+// markLine returns the line of the file, from 1, with a caret and the note under
+// the column, from 1. It returns an empty string if the line can not be read.
+func markLine(filename string, line, col int, note string) string {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return ""
+	}
+	lines := strings.Split(string(data), "\n")
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+	text := strings.TrimRight(lines[line-1], "\r")
+
+	// The tabs are kept so that the caret is aligned.
+	pad := make([]rune, 0, col)
+	for i, r := range text {
+		if i >= col-1 {
+			break
+		}
+		if r != '\t' {
+			r = ' '
+		}
+		pad = append(pad, r)
+	}
+	num := strconv.Itoa(line)
+	return fmt.Sprintf("  %s | %s\n  %s | %s^ %s\n", num, text, strings.Repeat(" ", len(num)), string(pad), note)
+}
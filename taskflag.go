@@ -0,0 +1,253 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kinds of the values of the task flags.
+const (
+	KIND_BOOL     = "bool"
+	KIND_DURATION = "duration"
+	KIND_INT      = "int"
+	KIND_LIST     = "list" // String which can be repeated.
+	KIND_STRING   = "string"
+)
+
+// taskFlag is a flag of the package tasking, which gake passes to the task
+// binary as "-task.name" when it is given at the command line, with or without
+// the prefix "task.". The flags not given are not passed, so that their default
+// values are only the ones of tasking; Default just shows it into the usage.
+type taskFlag struct {
+	Name    string // Without the prefix "task.".
+	Kind    string
+	Default string // Value used by tasking when it is not given; the form of a list.
+	Usage   string // Added to "passes -task.name" into the usage.
+}
+
+// taskFlags are the flags passed to the task binary, sorted by name.
+var taskFlags = []taskFlag{
+	{"advise", KIND_BOOL, "false", "after the run, suggest the sequential tasks which could call t.Parallel, with the time saved, from the history of the last runs"},
+	{"allow-no-tasks", KIND_BOOL, "false", "pass the run when -run matches no task, instead of exiting with status 5"},
+	{"badge", KIND_STRING, `""`, "write an SVG badge with the result of the run, the tasks passed and the duration, like for a wiki"},
+	{"cpu", KIND_STRING, `""`, "with several values, every task is run once per value, named like TaskX[cpu=4], and their durations are compared"},
+	{"dashboard", KIND_STRING, `""`, `serve a live web page of the run at the address, like ":8080", on localhost if it has not host`},
+	{"dependents", KIND_BOOL, "false", "run also the tasks which depend on the selected ones, instead of their dependencies"},
+	{"dry-run", KIND_BOOL, "false", "the commands and the file transfers run by the tasks through tasking are logged as \"would run\" instead of done"},
+	{"enforce-budgets", KIND_BOOL, "false", "fail the run when a group of tasks exceeds its time budget, set into gake.toml"},
+	{"failfast", KIND_BOOL, "false", "do not start new tasks after a task fails, reporting them as NOT RUN"},
+	{"html", KIND_STRING, `""`, "write an HTML report of the run, with the result and output of every task"},
+	{"json", KIND_BOOL, "false", ""},
+	{"junit", KIND_STRING, `""`, ""},
+	{"kill-grace", KIND_DURATION, "5s", ""},
+	{"list", KIND_STRING, `""`, ""},
+	{"max-extend", KIND_DURATION, "0", "total time which the tasks can add to -timeout by T.Extend"},
+	{"max-output", KIND_STRING, "10M", ""},
+	{"names", KIND_STRING, `""`, "the tasks to run, in that order"},
+	{"needs-skip", KIND_BOOL, "false", "skip the tasks whose endpoints of \"gake:needs\" are not available, instead of failing them"},
+	{"needs-timeout", KIND_DURATION, "30s", "time given to the endpoints of \"gake:needs\" to be available"},
+	{"only", KIND_BOOL, "false", "run the selected tasks without their dependencies"},
+	{"ordered-output", KIND_BOOL, "false", ""},
+	{"output-spool", KIND_STRING, "1M", "size of the output kept in memory per task, beyond which it is moved to a temporary file"},
+	{"outputdir", KIND_STRING, `""`, "also used by -buildlog"},
+	{"parallel", KIND_INT, "GOMAXPROCS", "capacity shared by the task weights"},
+	{"param", KIND_LIST, "name=value", "it can be repeated"},
+	{"pty", KIND_BOOL, "false", "the tasks, and the commands which they run, get a pseudo-terminal as output, so that they write like in a terminal"},
+	{"resume", KIND_BOOL, "false", "after a failure, run only the tasks which did not pass and the ones which depend on them"},
+	{"run", KIND_STRING, `""`, `split by "/" into regular expressions which match every level of the names, like "Build/linux" for the instance TaskBuild/linux-amd64 of a matrix, or the branch TaskPush/2 of a group`},
+	{"sarif", KIND_STRING, `""`, "write the findings reported by the tasks by T.ReportFinding, in SARIF, like for code scanning"},
+	{"short", KIND_BOOL, "false", ""},
+	{"stall-timeout", KIND_DURATION, "0", ""},
+	{"tee", KIND_STRING, `""`, "comma-separated list of sinks: console, file"},
+	{"timeout", KIND_DURATION, "0", ""},
+	{"update", KIND_BOOL, "false", "write the golden files compared by T.MatchSnapshot, instead of comparing them"},
+	{"v", KIND_BOOL, "false", ""},
+	{"yes", KIND_BOOL, "false", ""},
+}
+
+// usageText returns the lines of the flag into the usage of gake, wrapped at 80
+// columns.
+func (f taskFlag) usageText() string {
+	text := fmt.Sprintf("-%s=%s: passes -task.%s", f.Name, f.Default, f.Name)
+	if f.Kind == KIND_LIST {
+		text = fmt.Sprintf("-%s %s: passes -task.%s", f.Name, f.Default, f.Name)
+	}
+	if f.Usage != "" {
+		text += "; " + f.Usage
+	}
+
+	var buf strings.Builder
+	line := "  "
+	for _, word := range strings.Fields(text) {
+		if len(line)+1+len(word) > 80 && strings.TrimSpace(line) != "" {
+			buf.WriteString(line + "\n")
+			line = "     "
+		}
+		if strings.TrimSpace(line) != "" {
+			line += " "
+		}
+		line += word
+	}
+	buf.WriteString(line + "\n")
+	return buf.String()
+}
+
+// taskFlagValue is the value of a task flag, kept as it is given at the command
+// line once checked.
+type taskFlagValue struct {
+	kind   string
+	values []string // Values given, in order; the last one counts, but for a list.
+}
+
+func (v *taskFlagValue) String() string {
+	if v == nil || len(v.values) == 0 {
+		return ""
+	}
+	if v.kind == KIND_LIST {
+		return strings.Join(v.values, ",")
+	}
+	return v.values[len(v.values)-1]
+}
+
+func (v *taskFlagValue) Set(s string) error {
+	var err error
+	switch v.kind {
+	case KIND_BOOL:
+		var b bool
+		if b, err = strconv.ParseBool(s); err == nil {
+			s = strconv.FormatBool(b)
+		}
+	case KIND_DURATION:
+		_, err = time.ParseDuration(s)
+	case KIND_INT:
+		_, err = strconv.Atoi(s)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %s value %q", v.kind, s)
+	}
+
+	if v.kind == KIND_LIST {
+		v.values = append(v.values, s)
+	} else {
+		v.values = []string{s}
+	}
+	return nil
+}
+
+func (v *taskFlagValue) IsBoolFlag() bool { return v.kind == KIND_BOOL }
+
+// taskFlagValues are the values of the task flags, by name.
+type taskFlagValues map[string]*taskFlagValue
+
+// taskValues are the values of the task flags of the command line.
+var taskValues taskFlagValues
+
+// registerTaskFlags defines the task flags into fs, with and without the prefix
+// "task.", and returns their values.
+func registerTaskFlags(fs *flag.FlagSet) taskFlagValues {
+	values := make(taskFlagValues, len(taskFlags))
+	for _, f := range taskFlags {
+		v := &taskFlagValue{kind: f.Kind}
+		values[f.Name] = v
+		fs.Var(v, f.Name, "passes -task."+f.Name)
+		fs.Var(v, "task."+f.Name, "")
+	}
+	return values
+}
+
+// args returns the arguments which pass the given flags to the task binary,
+// in the order of taskFlags.
+func (tv taskFlagValues) args() []string {
+	args := make([]string, 0)
+	for _, f := range taskFlags {
+		v := tv[f.Name]
+		if v == nil || len(v.values) == 0 {
+			continue
+		}
+		for _, s := range v.values {
+			switch {
+			case f.Kind != KIND_BOOL:
+				args = append(args, "-task."+f.Name, s)
+			case s == "true":
+				args = append(args, "-task."+f.Name)
+			default:
+				args = append(args, "-task."+f.Name+"="+s)
+			}
+		}
+	}
+	return args
+}
+
+// with returns a copy of the values where the flag has only the given value.
+func (tv taskFlagValues) with(name, value string) taskFlagValues {
+	values := make(taskFlagValues, len(tv))
+	for k, v := range tv {
+		values[k] = v
+	}
+	v := &taskFlagValue{kind: KIND_STRING}
+	if old := tv[name]; old != nil {
+		v.kind = old.kind
+	}
+	v.values = []string{value}
+	values[name] = v
+	return values
+}
+
+// bool returns the value of the boolean flag, or false if it is not given.
+func (tv taskFlagValues) bool(name string) bool {
+	return tv[name].String() == "true"
+}
+
+// string returns the value of the flag, or "" if it is not given.
+func (tv taskFlagValues) string(name string) string {
+	return tv[name].String()
+}
+
+// taskUnknown are the flags of the command line with the prefix "task." which
+// gake does not know, to be passed as they are to the task binary.
+var taskUnknown []string
+
+// splitTaskFlags returns the arguments to be parsed by fs, without the flags,
+// before the first argument which is not a flag, with the prefix "task." which
+// are not defined into fs; they are returned apart. Their value has to be given
+// like "-task.name=value", since it is not known whether they are boolean.
+func splitTaskFlags(fs *flag.FlagSet, args []string) (known, unknown []string) {
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if a == "--" || a == "-" || !strings.HasPrefix(a, "-") {
+			return append(known, args[i:]...), unknown
+		}
+
+		name := strings.TrimPrefix(strings.TrimPrefix(a, "-"), "-")
+		hasValue := false
+		if j := strings.IndexByte(name, '='); j != -1 {
+			name, hasValue = name[:j], true
+		}
+		f := fs.Lookup(name)
+		if f == nil && strings.HasPrefix(name, "task.") {
+			unknown = append(unknown, a)
+			continue
+		}
+
+		known = append(known, a)
+		if f != nil && !hasValue && !isBoolFlag(f) && i+1 < len(args) {
+			i++
+			known = append(known, args[i])
+		}
+	}
+	return known, unknown
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
@@ -0,0 +1,106 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+var failFast = flag.Bool("task.failfast", false, "do not start new tasks after a task fails")
+
+// The abort of the run, by an interrupt or by a failure with -task.failfast.
+// The tasks which have not started, or which wait into Parallel, are not run
+// and they are reported as NOT RUN; the running ones are let finish.
+var (
+	abortMu     sync.Mutex
+	abortCh     = make(chan struct{})
+	abortReason string
+	interrupted bool // Aborted by a signal.
+)
+
+// resetAbort prepares a new run, which has not been aborted.
+func resetAbort() {
+	abortMu.Lock()
+	defer abortMu.Unlock()
+	abortCh = make(chan struct{})
+	abortReason = ""
+	interrupted = false
+}
+
+// abortRun aborts the run, for the given reason, if it has not been aborted.
+func abortRun(reason string) {
+	abortMu.Lock()
+	defer abortMu.Unlock()
+	if abortReason != "" {
+		return
+	}
+	abortReason = reason
+	close(abortCh)
+	fmt.Fprintf(os.Stderr, "tasking: %s; the tasks not started are not run\n", reason)
+}
+
+// aborted returns a channel which is closed when the run is aborted.
+func aborted() <-chan struct{} {
+	abortMu.Lock()
+	defer abortMu.Unlock()
+	return abortCh
+}
+
+// isAborted reports whether the run has been aborted.
+func isAborted() bool {
+	select {
+	case <-aborted():
+		return true
+	default:
+		return false
+	}
+}
+
+// isInterrupted reports whether the run has been aborted by a signal.
+func isInterrupted() bool {
+	abortMu.Lock()
+	defer abortMu.Unlock()
+	return interrupted
+}
+
+// handleInterrupt aborts the run at the first interrupt, stopping the processes
+// launched by the tasks so that they finish; a second interrupt kills the
+// program. The returned function stops the handling.
+func handleInterrupt() (stop func()) {
+	sig := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case s := <-sig:
+			signal.Stop(sig)
+			abortMu.Lock()
+			interrupted = true
+			abortMu.Unlock()
+			abortRun("got signal " + s.String())
+			stopAllProcesses()
+		case <-done:
+		}
+	}()
+	return func() {
+		signal.Stop(sig)
+		close(done)
+	}
+}
+
+// notRun marks the task as not run, since the run was aborted before it started.
+func (t *T) notRun() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.aborted = true
+}
@@ -0,0 +1,195 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Environment variables, set by gake from the table "approval" of "gake.toml":
+// the URL of the webhook notified of the approvals waited for, and the token
+// required to give an approval through the dashboard.
+const (
+	ENV_APPROVAL_WEBHOOK = "GAKE_APPROVAL_WEBHOOK"
+	ENV_APPROVAL_TOKEN   = "GAKE_APPROVAL_TOKEN"
+)
+
+// WEBHOOK_TIMEOUT is the time limit to notify the webhook of an approval.
+const WEBHOOK_TIMEOUT = 10 * time.Second
+
+// WaitForApproval pauses the task until a person approves to continue, like
+// before a deployment to production, with msg as the question. If it is
+// rejected, the task fails, so that the tasks which depend on it are skipped.
+// The directive "gake:approve [message]" waits for the approval before the
+// task is started, once its dependencies have finished.
+//
+// With the flag -task.dashboard, the approval is given at the dashboard, by its
+// buttons or by a POST to its path "/approve" with the form values "task" and
+// "decision", "approve" or "reject", like from a chat bot; else, it is asked at
+// the terminal. The webhook set into gake.toml is notified of every approval
+// waited for. The flag -task.yes approves without asking, and -task.dry-run
+// continues without asking.
+func (t *T) WaitForApproval(msg string) {
+	t.waitForApproval(msg)
+}
+
+func (t *T) waitForApproval(msg string) {
+	t.write("\twaiting for approval: "+msg+"\n", nil)
+	switch {
+	case *dryRun:
+		t.write("\twould wait for approval\n", nil)
+		return
+	case *assumeYes:
+		t.write("\tapproved (-task.yes)\n", nil)
+		return
+	case board == nil && !isTerminal(os.Stdin):
+		t.write("\ttasking: no terminal to ask for approval; use -task.dashboard, or -task.yes to approve\n", nil)
+		t.FailNow()
+	}
+	approvalEvent(t, "approval", msg)
+	notifyApproval(t, msg)
+
+	approved, by := false, "at the terminal"
+	if board != nil {
+		fmt.Fprintf(os.Stderr, "tasking: %s waits for approval at %s\n", t.name, board.url)
+		t.Progress()
+		approved, by = board.waitApproval(t.name), "at the dashboard"
+		t.Progress()
+	} else {
+		answer, err := t.readAnswer(msg+" Approve? [y/N] ", false)
+		if err != nil {
+			t.write("\ttasking: "+err.Error()+"\n", nil)
+			t.FailNow()
+		}
+		switch strings.ToLower(answer) {
+		case "y", "yes":
+			approved = true
+		}
+	}
+
+	if approved {
+		approvalEvent(t, "approved", msg)
+		t.write("\tapproved "+by+"\n", nil)
+		return
+	}
+	approvalEvent(t, "rejected", msg)
+	t.write("\trejected "+by+"\n", nil)
+	t.FailNow()
+}
+
+// approvalEvent sends the event of an approval of the task to the dashboard and,
+// with -task.json, to standard output.
+func approvalEvent(t *T, action, msg string) {
+	e := Event{Action: action, Task: t.name, Output: msg}
+	publish(e)
+	if *jsonOutput {
+		emit(e)
+	}
+}
+
+// approvalNotice is the body, in JSON, of the notification of the webhook.
+type approvalNotice struct {
+	Task      string
+	Message   string
+	Dashboard string `json:",omitempty"` // URL of the dashboard to approve.
+}
+
+// notifyApproval posts the approval waited for by the task to the webhook set
+// by gake, if any. A failure is only reported, since the approval can still be
+// given.
+func notifyApproval(t *T, msg string) {
+	url := os.Getenv(ENV_APPROVAL_WEBHOOK)
+	if url == "" {
+		return
+	}
+	notice := approvalNotice{Task: t.name, Message: msg}
+	if board != nil {
+		notice.Dashboard = board.url
+	}
+	body, _ := json.Marshal(notice)
+
+	client := &http.Client{Timeout: WEBHOOK_TIMEOUT}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			err = fmt.Errorf("%s", resp.Status)
+		}
+	}
+	if err != nil {
+		t.write("\ttasking: can't notify the approval webhook: "+redactURL(err.Error())+"\n", nil)
+	}
+}
+
+// waitApproval waits until the approval of the task is given at the dashboard,
+// and reports whether it is approved. It is rejected if the run is aborted.
+func (d *dashboard) waitApproval(task string) bool {
+	ch := make(chan bool, 1)
+	d.mu.Lock()
+	d.approvals[task] = ch
+	d.mu.Unlock()
+
+	select {
+	case ok := <-ch:
+		return ok
+	case <-aborted():
+		d.mu.Lock()
+		delete(d.approvals, task)
+		d.mu.Unlock()
+		return false
+	}
+}
+
+// serveApprove gives the decision, in the form values "task" and "decision",
+// on an approval waited for. If gake sets a token, it is required in the form
+// value "token" or as a bearer token.
+func (d *dashboard) serveApprove(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if token := os.Getenv(ENV_APPROVAL_TOKEN); token != "" {
+		given := r.FormValue("token")
+		if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			given = strings.TrimPrefix(auth, "Bearer ")
+		}
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			http.Error(w, "invalid token", http.StatusForbidden)
+			return
+		}
+	}
+
+	var approved bool
+	switch r.FormValue("decision") {
+	case "approve":
+		approved = true
+	case "reject":
+	default:
+		http.Error(w, `decision must be "approve" or "reject"`, http.StatusBadRequest)
+		return
+	}
+	task := r.FormValue("task")
+
+	d.mu.Lock()
+	ch := d.approvals[task]
+	delete(d.approvals, task)
+	d.mu.Unlock()
+	if ch == nil {
+		http.Error(w, fmt.Sprintf("task %q does not wait for approval", task), http.StatusNotFound)
+		return
+	}
+	ch <- approved
+	fmt.Fprintln(w, "ok")
+}
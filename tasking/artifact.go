@@ -0,0 +1,187 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// SUMS_FILE is the name of the file, into the directory of the artifacts, where
+// their SHA-256 sums are written in the format of sha256sum.
+const SUMS_FILE = "SHA256SUMS"
+
+// Artifact registers the file as produced by the task, like a binary or an
+// archive to be released. When the run finishes, the SHA-256 sums of the
+// artifacts of the tasks which have not failed are written to the file
+// SHA256SUMS into their directory, replacing the lines of the same files.
+func (t *T) Artifact(path string) {
+	abs, err := filepath.Abs(path)
+	if err == nil {
+		_, err = os.Stat(abs)
+	}
+	if err != nil {
+		t.log("tasking: invalid artifact: "+err.Error(), nil)
+		t.FailNow()
+	}
+
+	t.mu.Lock()
+	t.artifacts = append(t.artifacts, abs)
+	t.mu.Unlock()
+}
+
+// Checksum returns the checksum of the file, in hexadecimal, with the algorithm
+// md5, sha1, sha256 or sha512. The task fails if it can not be computed.
+func (t *T) Checksum(path, algo string) string {
+	sum, err := checksum(path, algo)
+	if err != nil {
+		t.log("tasking: can't compute the checksum: "+err.Error(), nil)
+		t.FailNow()
+	}
+	return sum
+}
+
+func checksum(path, algo string) (string, error) {
+	var h hash.Hash
+	switch algo {
+	case "md5":
+		h = md5.New()
+	case "sha1":
+		h = sha1.New()
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return "", fmt.Errorf("unknown algorithm %q: want md5, sha1, sha256 or sha512", algo)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// SignArtifact writes a detached signature of the file, and returns the name of
+// the signature file. The key is referenced with the form "tool:key":
+//
+//	gpg:ID      signed by gpg with the key ID, to the file path.asc
+//	cosign:KEY  signed by cosign sign-blob with the key (a file or a KMS URI),
+//	            to the file path.sig
+//	ssh:FILE    signed by ssh-keygen with the private key file, to the file
+//	            path.sig
+//
+// A reference without "tool:" is a gpg key. The signing command is logged like
+// Exec, and the task fails if it does not succeed.
+func (t *T) SignArtifact(path, keyRef string) string {
+	tool, key := "gpg", keyRef
+	if i := strings.IndexByte(keyRef, ':'); i != -1 {
+		tool, key = keyRef[:i], keyRef[i+1:]
+	}
+
+	var cmd *exec.Cmd
+	sig := path + ".sig"
+	switch tool {
+	case "gpg":
+		sig = path + ".asc"
+		cmd = exec.Command("gpg", "--batch", "--yes", "--armor", "--detach-sign",
+			"--local-user", key, "--output", sig, path)
+	case "cosign":
+		cmd = exec.Command("cosign", "sign-blob", "--yes", "--key", key, "--output-signature", sig, path)
+	case "ssh":
+		// ssh-keygen writes the signature to path.sig.
+		cmd = exec.Command("ssh-keygen", "-Y", "sign", "-n", "file", "-f", key, path)
+	default:
+		t.log(fmt.Sprintf("tasking: unknown signing tool %q: want gpg, cosign or ssh", tool), nil)
+		t.FailNow()
+	}
+
+	t.log(runPrefix()+commandLine(cmd), nil)
+	if err := t.exec(cmd); err != nil {
+		t.log("tasking: can't sign "+path+": "+err.Error(), nil)
+		t.FailNow()
+	}
+	return sig
+}
+
+// writeSums writes the SHA-256 sums of the artifacts registered by the tasks
+// which have not failed.
+func writeSums() {
+	resultsMu.Lock()
+	dirs := make(map[string][]string) // Artifacts by directory.
+	for _, t := range results {
+		t.mu.RLock()
+		if !t.failed {
+			for _, a := range t.artifacts {
+				dirs[filepath.Dir(a)] = append(dirs[filepath.Dir(a)], a)
+			}
+		}
+		t.mu.RUnlock()
+	}
+	resultsMu.Unlock()
+
+	for dir, artifacts := range dirs {
+		if err := updateSums(dir, artifacts); err != nil {
+			fmt.Fprintf(os.Stderr, "tasking: can't write %s: %s\n", SUMS_FILE, err)
+		}
+	}
+}
+
+// updateSums updates the file SHA256SUMS into the directory with the sums of
+// the artifacts, keeping the lines of other files.
+func updateSums(dir string, artifacts []string) error {
+	name := filepath.Join(dir, SUMS_FILE)
+	sums := make(map[string]string) // Sum by file name.
+
+	if data, err := os.ReadFile(name); err == nil {
+		for _, l := range strings.Split(string(data), "\n") {
+			// The sum is followed by a space and ' ' or '*', for the text or
+			// binary mode.
+			if i := strings.IndexByte(l, ' '); i > 0 && len(l) > i+2 {
+				sums[l[i+2:]] = l[:i]
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	for _, a := range artifacts {
+		sum, err := checksum(a, "sha256")
+		if err != nil {
+			return err
+		}
+		sums[filepath.Base(a)] = sum
+	}
+
+	files := make([]string, 0, len(sums))
+	for f := range sums {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+
+	var b strings.Builder
+	for _, f := range files {
+		fmt.Fprintf(&b, "%s  %s\n", sums[f], f)
+	}
+	return os.WriteFile(name, []byte(b.String()), 0644)
+}
@@ -0,0 +1,154 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CommandRecord is the record of a command run by Exec, ExecCmd or Shell, for
+// the audit of what the tasks have executed.
+type CommandRecord struct {
+	Task     string
+	Command  string // Command line, with the secrets redacted.
+	Dir      string // Working directory.
+	User     string `json:",omitempty"` // User whom it was run as; see T.RunAs.
+	Start    time.Time
+	Duration time.Duration
+	ExitCode int    // -1 if it was not started or it was killed by a signal.
+	Error    string `json:",omitempty"` // Error of the start or the wait.
+}
+
+func (r CommandRecord) String() string {
+	as := ""
+	if r.User != "" {
+		as = "as " + r.User + ", "
+	}
+	s := fmt.Sprintf("%s: $ %s (%sdir %s, %s, exit %d)", r.Task, r.Command, as, r.Dir,
+		r.Duration.Round(time.Millisecond), r.ExitCode)
+	if r.Error != "" && r.ExitCode == -1 {
+		s += ": " + r.Error
+	}
+	return s
+}
+
+// recordCommand adds the record of the command, run since start with the
+// result err, to the audit of the task.
+func (t *T) recordCommand(cmd *exec.Cmd, start time.Time, err error) {
+	r := CommandRecord{
+		Task:     t.name,
+		Command:  strings.Join(quoteArgs(redactArgs(cmd.Args)), " "),
+		Dir:      cmd.Dir,
+		User:     t.runUser(),
+		Start:    start,
+		Duration: time.Since(start),
+		ExitCode: -1,
+	}
+	if r.Dir == "" {
+		r.Dir, _ = os.Getwd()
+	}
+	if cmd.ProcessState != nil {
+		r.ExitCode = cmd.ProcessState.ExitCode()
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+
+	t.mu.Lock()
+	t.commands = append(t.commands, r)
+	t.mu.Unlock()
+}
+
+// reportAudit prints the commands run by the finished tasks, in the order in
+// which they were started, after the results of the tasks: as a section with
+// -task.v, or as an event "audit" with -task.json.
+func reportAudit() {
+	if !*chatty && !*jsonOutput {
+		return
+	}
+	var records []CommandRecord
+	resultsMu.Lock()
+	for _, t := range results {
+		t.mu.RLock()
+		records = append(records, t.commands...)
+		t.mu.RUnlock()
+	}
+	resultsMu.Unlock()
+	if len(records) == 0 {
+		return
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Start.Before(records[j].Start) })
+
+	if *jsonOutput {
+		emit(Event{Action: "audit", Audit: records})
+		return
+	}
+	fmt.Println("=== AUDIT")
+	for _, r := range records {
+		fmt.Printf("\t%s\n", r)
+	}
+}
+
+// redactArgs returns the arguments of a command with the values which could be
+// secrets replaced by "REDACTED": the ones of the flags and of the assignments
+// whose name has a word like in secretParams, like "--password=x", "--token x"
+// or "API_KEY=x"; the passwords and secret query parameters of the URLs; and
+// the values of the environment variables with such names.
+func redactArgs(args []string) []string {
+	secrets := secretEnvValues()
+	redacted := make([]string, len(args))
+
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if strings.Contains(a, "://") {
+			a = redactURL(a)
+		} else if j := strings.IndexByte(a, '='); j > 0 && isSecretName(a[:j]) {
+			a = a[:j+1] + "REDACTED"
+		}
+		for _, s := range secrets {
+			a = strings.Replace(a, s, "REDACTED", -1)
+		}
+		redacted[i] = a
+
+		// The value of a flag like "--token x" is the next argument.
+		if strings.HasPrefix(a, "-") && !strings.Contains(a, "=") && isSecretName(a) &&
+			i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") {
+			i++
+			redacted[i] = "REDACTED"
+		}
+	}
+	return redacted
+}
+
+// isSecretName reports whether the name of a flag or variable has a word which
+// names a secret.
+func isSecretName(name string) bool {
+	name = strings.ToLower(strings.TrimLeft(name, "-"))
+	for _, p := range secretParams {
+		if strings.Contains(name, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// secretEnvValues returns the values, of 4 characters at least, of the
+// environment variables whose name has a word which names a secret.
+func secretEnvValues() []string {
+	var values []string
+	for _, kv := range os.Environ() {
+		if j := strings.IndexByte(kv, '='); j > 0 && len(kv)-j-1 >= 4 && isSecretName(kv[:j]) {
+			values = append(values, kv[j+1:])
+		}
+	}
+	return values
+}
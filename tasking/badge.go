@@ -0,0 +1,186 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"flag"
+	"fmt"
+	"html/template"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	badgeFile = flag.String("task.badge", "", "write an SVG badge with the result of the run to the named file")
+	htmlFile  = flag.String("task.html", "", "write an HTML report of the run to the named file")
+)
+
+// Colors of the badge, like the ones of shields.io.
+const (
+	BADGE_PASS  = "#4c1"
+	BADGE_FAIL  = "#e05d44"
+	BADGE_LABEL = "#555"
+)
+
+// badgeSummary is the result of the run shown by the badge and the report.
+type badgeSummary struct {
+	OK      bool
+	Total   int
+	Passed  int // Tasks which have passed or failed as expected.
+	Elapsed time.Duration
+}
+
+// summarize returns the result of the run from the finished tasks.
+func summarize(ok bool, elapsed time.Duration) badgeSummary {
+	s := badgeSummary{OK: ok, Elapsed: elapsed.Round(100 * time.Millisecond)}
+
+	resultsMu.Lock()
+	defer resultsMu.Unlock()
+	s.Total = len(results)
+	for _, t := range results {
+		if status := t.status(); status == "pass" || status == "xfail" {
+			s.Passed++
+		}
+	}
+	return s
+}
+
+// Message returns the text of the result, like "passing 12/12 in 3.2s".
+func (s badgeSummary) Message() string {
+	result := "passing"
+	if !s.OK {
+		result = "failing"
+	}
+	return fmt.Sprintf("%s %d/%d in %v", result, s.Passed, s.Total, s.Elapsed)
+}
+
+// badgeTemplate is a flat badge in the manner of shields.io; the width of the
+// text is estimated, since there is not a font to measure it.
+var badgeTemplate = template.Must(template.New("badge").Parse(`<svg xmlns="http://www.w3.org/2000/svg" width="{{.Width}}" height="20" role="img" aria-label="{{.Label}}: {{.Message}}">
+<title>{{.Label}}: {{.Message}}</title>
+<linearGradient id="s" x2="0" y2="100%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>
+<clipPath id="r"><rect width="{{.Width}}" height="20" rx="3" fill="#fff"/></clipPath>
+<g clip-path="url(#r)">
+<rect width="{{.LabelWidth}}" height="20" fill="{{.LabelColor}}"/>
+<rect x="{{.LabelWidth}}" width="{{.MessageWidth}}" height="20" fill="{{.Color}}"/>
+<rect width="{{.Width}}" height="20" fill="url(#s)"/>
+</g>
+<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+<text x="{{.LabelX}}" y="14">{{.Label}}</text>
+<text x="{{.MessageX}}" y="14">{{.Message}}</text>
+</g>
+</svg>
+`))
+
+// writeBadge writes to the named file an SVG badge with the result of the run,
+// like "gake | passing 12/12 in 3.2s", green if the run has passed, else red.
+func writeBadge(name string, s badgeSummary) error {
+	textWidth := func(text string) int { return 7*len(text) + 10 }
+	data := struct {
+		Label, Message                  string
+		LabelColor, Color               string
+		LabelWidth, MessageWidth, Width int
+		LabelX, MessageX                float64
+	}{
+		Label:      "gake",
+		Message:    s.Message(),
+		LabelColor: BADGE_LABEL,
+		Color:      BADGE_PASS,
+	}
+	if !s.OK {
+		data.Color = BADGE_FAIL
+	}
+	data.LabelWidth = textWidth(data.Label)
+	data.MessageWidth = textWidth(data.Message)
+	data.Width = data.LabelWidth + data.MessageWidth
+	data.LabelX = float64(data.LabelWidth) / 2
+	data.MessageX = float64(data.LabelWidth) + float64(data.MessageWidth)/2
+
+	return writeTemplate(name, badgeTemplate, data)
+}
+
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"seconds": func(d time.Duration) string { return fmt.Sprintf("%.2fs", d.Seconds()) },
+	"upper":   strings.ToUpper,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>gake: {{.Summary.Message}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { text-align: left; padding: 0.3em 1em; border-bottom: 1px solid #ddd; vertical-align: top; }
+.pass, .xfail { color: #2a2; } .fail, .xpass { color: #c33; } .skip, .notrun { color: #888; }
+pre { margin: 0; white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<h1 class="{{if .Summary.OK}}pass{{else}}fail{{end}}">{{.Summary.Message}}</h1>
+<p>{{.Time.Format "2006-01-02 15:04:05 MST"}}{{if .Info.Command}} &middot; <code>{{.Info.Command}}</code>{{end}}</p>
+<table>
+<tr><th>Task</th><th>Status</th><th>Duration</th><th>Output</th></tr>
+{{- range .Tasks}}
+<tr><td>{{.Name}}</td><td class="{{.Status}}">{{upper .Status}}</td><td>{{seconds .Duration}}</td><td>{{if .Output}}<details><summary>output</summary><pre>{{.Output}}</pre></details>{{end}}</td></tr>
+{{- end}}
+</table>
+<h2>Run</h2>
+<table>
+{{- range .Info.Properties}}{{if index . 1}}
+<tr><th>{{index . 0}}</th><td>{{index . 1}}</td></tr>
+{{- end}}{{end}}
+</table>
+</body>
+</html>
+`))
+
+// writeHTML writes to the named file an HTML report of the run, with the
+// result and output of every task, and the information of the run.
+func writeHTML(name string, s badgeSummary, info RunInfo) error {
+	type task struct {
+		Name     string
+		Status   string
+		Duration time.Duration
+		Output   string
+	}
+	data := struct {
+		Summary badgeSummary
+		Time    time.Time
+		Info    struct {
+			Command    string
+			Properties [][2]string
+		}
+		Tasks []task
+	}{Summary: s, Time: time.Now()}
+	data.Info.Command = info.Command
+	data.Info.Properties = info.properties()
+
+	resultsMu.Lock()
+	for _, t := range results {
+		status := t.status()
+		t.mu.RLock()
+		data.Tasks = append(data.Tasks, task{t.name, status, t.duration, string(t.output.Bytes())})
+		t.mu.RUnlock()
+	}
+	resultsMu.Unlock()
+
+	return writeTemplate(name, htmlTemplate, data)
+}
+
+// writeTemplate writes to the named file the template executed with data.
+func writeTemplate(name string, tmpl *template.Template, data interface{}) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	err = tmpl.Execute(f, data)
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
@@ -0,0 +1,94 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// ENV_BUDGETS is the environment variable, set by gake from the table "budgets"
+// of "gake.toml", with the time budgets of the groups of tasks, like
+// "lint=2m,e2e=20m".
+const ENV_BUDGETS = "GAKE_BUDGETS"
+
+var enforceBudgets = flag.Bool("task.enforce-budgets", false, "fail the run when a group of tasks exceeds its time budget")
+
+// budget is the time budget of a group of tasks.
+type budget struct {
+	group string
+	limit time.Duration
+}
+
+// parseBudgets parses the budgets with the form "group=duration,...".
+func parseBudgets(s string) ([]budget, error) {
+	budgets := make([]budget, 0)
+	for _, b := range strings.Split(s, ",") {
+		if b = strings.TrimSpace(b); b == "" {
+			continue
+		}
+		i := strings.IndexByte(b, '=')
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid budget %q: want group=duration", b)
+		}
+		d, err := time.ParseDuration(b[i+1:])
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid budget %q: want a positive duration", b)
+		}
+		budgets = append(budgets, budget{b[:i], d})
+	}
+	return budgets, nil
+}
+
+// reportBudgets prints the time spent by the finished tasks of every group with
+// a budget, against it. It returns false if some group exceeds its budget.
+//
+// The time of a group is the sum of the durations of its tasks, so the tasks
+// run in parallel count as many times as they are.
+func reportBudgets() bool {
+	budgets, err := parseBudgets(os.Getenv(ENV_BUDGETS))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tasking: %s\n", err)
+		return true
+	}
+	if len(budgets) == 0 {
+		return true
+	}
+
+	spent := make(map[string]time.Duration)
+	resultsMu.Lock()
+	for _, t := range results {
+		t.mu.RLock()
+		for _, g := range t.groups {
+			spent[g] += t.duration
+		}
+		t.mu.RUnlock()
+	}
+	resultsMu.Unlock()
+
+	ok := true
+	for _, b := range budgets {
+		d := spent[b.group]
+		over := d > b.limit
+		ok = ok && !over
+
+		if *jsonOutput {
+			emit(Event{Action: "budget", Group: b.group, Elapsed: d.Seconds(), Budget: b.limit.Seconds()})
+			continue
+		}
+		status := "BUDGET"
+		if over {
+			status = "BUDGET EXCEEDED"
+		}
+		fmt.Printf("%s: %s %s of %s (%.0f%%)\n", status, b.group,
+			d.Round(time.Millisecond), b.limit, 100*d.Seconds()/b.limit.Seconds())
+	}
+	return ok
+}
@@ -0,0 +1,45 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"strings"
+)
+
+var printBuildInfo = flag.Bool("task.buildinfo", false, "print how the binary was built and exit")
+
+// gakeBuildInfo is the information embedded by gake into the binary.
+var gakeBuildInfo string
+
+// An internal function but exported because it is cross-package;
+// part of the implementation of the "gake" command.
+func SetBuildInfo(info string) { gakeBuildInfo = info }
+
+// showBuildInfo prints the information about how the binary was built, with
+// the versions of gake and Go, and the modules built into it.
+func showBuildInfo() {
+	info := strings.Trim(gakeBuildInfo, "\xff")
+	if i := strings.IndexByte(info, ':'); i != -1 {
+		info = strings.TrimSpace(info[i+1:])
+	}
+	fmt.Printf("built by: %s\n", info)
+
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		fmt.Printf("path: %s\n", bi.Path)
+		for _, m := range bi.Deps {
+			if m.Replace != nil {
+				m = m.Replace
+			}
+			fmt.Printf("dep: %s %s %s\n", m.Path, m.Version, m.Sum)
+		}
+	}
+	os.Exit(0)
+}
@@ -0,0 +1,170 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+var resume = flag.Bool("task.resume", false, "skip the tasks which passed in the last run, unless a task which they depend on is run again")
+
+// checkpoint records the tasks which have passed, during the run, so that a
+// run with -task.resume after a failure runs only the failed tasks and the ones
+// which depend on them, instead of the expensive steps already done.
+//
+// The checkpoint is kept into the directory given by the environment variable
+// GAKECACHE, like the fingerprints, scoped to the directory of the tasks; it is
+// removed when the run passes, so that there is nothing to resume.
+type checkpoint struct {
+	mu     sync.Mutex
+	path   string
+	passed map[string]bool
+}
+
+// checkpointData is the content of the file of a checkpoint, in JSON.
+type checkpointData struct {
+	Passed []string // Names of the tasks passed, sorted.
+}
+
+var lastCheckpoint *checkpoint
+
+// loadCheckpoint prepares the checkpoint of a new run. With -task.resume, it
+// starts with the tasks passed by the last run; else, it starts empty.
+func loadCheckpoint() {
+	lastCheckpoint = nil
+	path, err := cachePath("checkpoints")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tasking: can't keep a checkpoint: %s\n", err)
+		return
+	}
+	cp := &checkpoint{path: path, passed: make(map[string]bool)}
+	lastCheckpoint = cp
+	if !*resume {
+		clearCheckpoint()
+		return
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			fmt.Fprintln(os.Stderr, "tasking: no checkpoint of a failed run to resume; running all the tasks")
+		} else {
+			fmt.Fprintf(os.Stderr, "tasking: can't read checkpoint: %s\n", err)
+		}
+		return
+	}
+	var data checkpointData
+	if err = json.Unmarshal(b, &data); err != nil {
+		fmt.Fprintf(os.Stderr, "tasking: invalid checkpoint %s: %s\n", path, err)
+		return
+	}
+	for _, name := range data.Passed {
+		cp.passed[name] = true
+	}
+}
+
+// cachePath returns the file of the kind of data, like "checkpoints", kept into
+// the cache for the tasks of the working directory.
+func cachePath(kind string) (string, error) {
+	dir := os.Getenv(ENV_CACHE)
+	if dir == "" {
+		cache, err := os.UserCacheDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(cache, "gake")
+	}
+	scope := os.Getenv(ENV_TASKDIR)
+	if scope == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return "", err
+		}
+		scope = wd
+	}
+
+	h := sha256.Sum256([]byte(scope))
+	return filepath.Join(dir, kind, hex.EncodeToString(h[:])), nil
+}
+
+// canResume reports whether the task can be skipped with -task.resume: it has
+// passed in the last run, and the tasks which it depends on have been skipped
+// the same.
+func canResume(t *T) bool {
+	cp := lastCheckpoint
+	if !*resume || cp == nil {
+		return false
+	}
+	for _, d := range t.deps {
+		if !d.resumed {
+			return false
+		}
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return cp.passed[t.name]
+}
+
+// recordCheckpoint records the result of the finished task into the
+// checkpoint, which is written at once so that it is kept even if the run is
+// killed.
+func recordCheckpoint(t *T) {
+	cp := lastCheckpoint
+	if cp == nil || t.resumed {
+		return
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	passed := !t.Failed() && !t.Skipped() && !t.aborted
+	if cp.passed[t.name] == passed {
+		return
+	}
+	if passed {
+		cp.passed[t.name] = true
+	} else {
+		delete(cp.passed, t.name)
+	}
+
+	data := checkpointData{Passed: make([]string, 0, len(cp.passed))}
+	for name := range cp.passed {
+		data.Passed = append(data.Passed, name)
+	}
+	sort.Strings(data.Passed)
+	b, _ := json.Marshal(data)
+
+	err := os.MkdirAll(filepath.Dir(cp.path), 0750)
+	if err == nil {
+		tmp := cp.path + ".tmp"
+		if err = os.WriteFile(tmp, b, 0640); err == nil {
+			err = os.Rename(tmp, cp.path)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tasking: can't write checkpoint: %s\n", err)
+	}
+}
+
+// clearCheckpoint removes the checkpoint, once the run has passed or before a
+// run which does not resume.
+func clearCheckpoint() {
+	cp := lastCheckpoint
+	if cp == nil {
+		return
+	}
+	if err := os.Remove(cp.path); err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "tasking: can't remove checkpoint: %s\n", err)
+	}
+}
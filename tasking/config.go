@@ -0,0 +1,162 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Validator is implemented by the configurations which check their values once
+// loaded.
+type Validator interface {
+	Validate() error
+}
+
+// LoadJSON reads the JSON file at path and decodes it into the value pointed to
+// by v. The fields of the file which are not in v are an error, so that a typo
+// is not ignored. If v implements Validator, its method Validate is called.
+//
+// The task fails if the file can not be read, decoded or validated, with an
+// error which has the line and column of the problem.
+func (t *T) LoadJSON(path string, v interface{}) {
+	if err := loadConfig(path, v, decodeJSONFile); err != nil {
+		t.log("tasking: can't load config: "+err.Error(), nil)
+		t.FailNow()
+	}
+}
+
+// LoadYAML is like LoadJSON for a YAML file, decoded by unmarshal. Since the
+// package tasking depends only on the standard library, it is the function of
+// a package imported by the task files:
+//
+//	t.LoadYAML("deploy.yaml", &cfg, yaml.Unmarshal) // gopkg.in/yaml.v3
+//
+// The unknown fields are an error only if unmarshal rejects them. The line and
+// column of an error are the ones given by unmarshal, if any.
+func (t *T) LoadYAML(path string, v interface{}, unmarshal func(data []byte, v interface{}) error) {
+	if err := loadConfig(path, v, unmarshalWith("YAML", unmarshal)); err != nil {
+		t.log("tasking: can't load config: "+err.Error(), nil)
+		t.FailNow()
+	}
+}
+
+// LoadTOML is like LoadYAML for a TOML file:
+//
+//	t.LoadTOML("deploy.toml", &cfg, toml.Unmarshal) // github.com/pelletier/go-toml/v2
+func (t *T) LoadTOML(path string, v interface{}, unmarshal func(data []byte, v interface{}) error) {
+	if err := loadConfig(path, v, unmarshalWith("TOML", unmarshal)); err != nil {
+		t.log("tasking: can't load config: "+err.Error(), nil)
+		t.FailNow()
+	}
+}
+
+func loadConfig(path string, v interface{}, decode func(string, []byte, interface{}) error) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err = decode(path, data, v); err != nil {
+		return err
+	}
+	if val, ok := v.(Validator); ok {
+		if err = val.Validate(); err != nil {
+			return fmt.Errorf("%s: invalid: %s", path, err)
+		}
+	}
+	return nil
+}
+
+// unmarshalWith returns the decoder of a format by the function given by the
+// user. Its errors are returned as "path:line:column: message" when they have
+// the position of the problem: by a method Position, like the ones of
+// go-toml, or by the text "line N", like the ones of yaml.
+func unmarshalWith(format string, unmarshal func([]byte, interface{}) error) func(string, []byte, interface{}) error {
+	return func(path string, data []byte, v interface{}) error {
+		if unmarshal == nil {
+			return errors.New("no function to decode " + format)
+		}
+		err := unmarshal(data, v)
+		if err == nil {
+			return nil
+		}
+
+		if p, ok := err.(interface{ Position() (row, column int) }); ok {
+			if row, col := p.Position(); row > 0 {
+				return fmt.Errorf("%s:%d:%d: %s", path, row, col, err)
+			}
+		}
+		msg := err.Error()
+		if m := errorLine.FindStringSubmatchIndex(msg); m != nil {
+			return fmt.Errorf("%s:%s: %s", path, msg[m[2]:m[3]], msg[:m[0]]+msg[m[1]:])
+		}
+		return fmt.Errorf("%s: %s", path, err)
+	}
+}
+
+// errorLine matches the line into the errors of decoders like yaml, as in
+// "yaml: line 3: did not find expected key".
+var errorLine = regexp.MustCompile(`\bline (\d+): `)
+
+// decodeJSONFile decodes the JSON document, returning the errors with the
+// position of the problem, as "path:line:column: message".
+func decodeJSONFile(path string, data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(v)
+	if err == nil {
+		end := dec.InputOffset()
+		if _, err = dec.Token(); err == io.EOF {
+			return nil
+		}
+		rest := bytes.TrimLeft(data[end:], " \t\r\n")
+		return fmt.Errorf("%s: extra data after the document", position(path, data, int64(len(data)-len(rest))))
+	}
+
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		// The offset is after the invalid character.
+		return fmt.Errorf("%s: %s", position(path, data, e.Offset-1), e)
+	case *json.UnmarshalTypeError:
+		field := ""
+		if e.Field != "" {
+			field = " of field " + e.Field
+		}
+		return fmt.Errorf("%s: can't use %s as value%s of type %s", position(path, data, e.Offset), e.Value, field, e.Type)
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("%s: unexpected end of document", position(path, data, int64(len(data))))
+	}
+	// The error of an unknown field has not its offset, so it is searched.
+	if name, e := strconv.Unquote(strings.TrimPrefix(err.Error(), "json: unknown field ")); e == nil {
+		re := regexp.MustCompile(regexp.QuoteMeta(strconv.Quote(name)) + `\s*:`)
+		if loc := re.FindIndex(data); loc != nil {
+			return fmt.Errorf("%s: unknown field %q", position(path, data, int64(loc[0])), name)
+		}
+	}
+	return fmt.Errorf("%s: %s", position(path, data, dec.InputOffset()), err)
+}
+
+// position returns the path with the line and column, from 1, of the byte
+// offset in data.
+func position(path string, data []byte, offset int64) string {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	col := len(before) - bytes.LastIndexByte(before, '\n')
+	return fmt.Sprintf("%s:%d:%d", path, line, col)
+}
@@ -0,0 +1,84 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// cpuName returns the name of the run of the task with GOMAXPROCS set to procs,
+// like "TaskBuild[cpu=4]", used when the flag -task.cpu has several values.
+func cpuName(name string, procs int) string {
+	return fmt.Sprintf("%s[cpu=%d]", name, procs)
+}
+
+// now returns the current time, from the clock of the run.
+func (t *T) now() time.Time {
+	if t.clock == nil {
+		return time.Now()
+	}
+	return t.clock()
+}
+
+// cpuTask returns the name of the task of the run of t, without the value of
+// -task.cpu.
+func (t *T) cpuTask() string {
+	if t.cpu == 0 {
+		return t.name
+	}
+	return strings.TrimSuffix(t.name, fmt.Sprintf("[cpu=%d]", t.cpu))
+}
+
+// reportCPU prints, when the flag -task.cpu has several values, the durations
+// of the runs of every task which has passed with more than one of them,
+// compared with the duration of the first one:
+//
+//	CPU: TaskBuild cpu=1 1.2s, cpu=4 400ms (3.00x)
+//
+// With -task.json, the runs are grouped by the field CPU of the events.
+func reportCPU() {
+	if len(cpuList) < 2 || *jsonOutput {
+		return
+	}
+	type cpuRun struct {
+		cpu      int
+		duration time.Duration
+	}
+	var names []string
+	runs := make(map[string][]cpuRun)
+
+	resultsMu.Lock()
+	for _, t := range results {
+		if t.status() != "pass" {
+			continue
+		}
+		name := t.cpuTask()
+		if _, ok := runs[name]; !ok {
+			names = append(names, name)
+		}
+		runs[name] = append(runs[name], cpuRun{t.cpu, t.duration})
+	}
+	resultsMu.Unlock()
+
+	for _, name := range names {
+		r := runs[name]
+		if len(r) < 2 {
+			continue
+		}
+		parts := make([]string, len(r))
+		for i, run := range r {
+			parts[i] = fmt.Sprintf("cpu=%d %s", run.cpu, run.duration.Round(time.Millisecond))
+			// The ratio of the durations shorter than the rounding is noise.
+			if i != 0 && run.duration >= time.Millisecond && r[0].duration >= time.Millisecond {
+				parts[i] += fmt.Sprintf(" (%.2fx)", r[0].duration.Seconds()/run.duration.Seconds())
+			}
+		}
+		fmt.Printf("CPU: %s %s\n", name, strings.Join(parts, ", "))
+	}
+}
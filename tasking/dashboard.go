@@ -0,0 +1,359 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var dashboardAddr = flag.String("task.dashboard", "", "serve a live web page of the run at the address, like :8080")
+
+// dashboard serves a web page with the state of the run, updated through the
+// events of the run, the same as the ones of -task.json, sent as server-sent
+// events. The events are kept so that a page opened during the run gets the
+// whole run.
+type dashboard struct {
+	mu      sync.Mutex
+	plan    []dashboardTask
+	history []Event
+	subs    map[chan Event]bool
+	url     string
+
+	approvals map[string]chan bool // Approvals waited for, by task.
+}
+
+// dashboardTask is a task of the plan of the run, as shown by the dashboard.
+type dashboardTask struct {
+	Name string
+	Deps []string // Names of the task functions which it depends on.
+	File string
+	Line int
+}
+
+var board *dashboard
+
+// startDashboard starts the server of the dashboard, given by -task.dashboard,
+// for the run of the tasks. An address without host is served on localhost, so
+// that the output of the tasks is not published.
+func startDashboard(tasks []InternalTask) {
+	if *dashboardAddr == "" {
+		return
+	}
+	plan := make([]dashboardTask, len(tasks))
+	for i, task := range tasks {
+		plan[i] = dashboardTask{task.Name, task.Deps, task.File, task.Line}
+	}
+	if board != nil {
+		// A new run, from M.Rerun.
+		board.mu.Lock()
+		board.plan, board.history = plan, nil
+		board.mu.Unlock()
+		return
+	}
+
+	addr := *dashboardAddr
+	if strings.HasPrefix(addr, ":") {
+		addr = "localhost" + addr
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tasking: can't start dashboard: %s\n", err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "tasking: dashboard at http://%s/\n", ln.Addr())
+
+	board = &dashboard{
+		plan:      plan,
+		subs:      make(map[chan Event]bool),
+		url:       fmt.Sprintf("http://%s/", ln.Addr()),
+		approvals: make(map[string]chan bool),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", board.serveIndex)
+	mux.HandleFunc("/plan", board.servePlan)
+	mux.HandleFunc("/events", board.serveEvents)
+	mux.HandleFunc("/approve", board.serveApprove)
+	go http.Serve(ln, mux)
+}
+
+// publish sends the event to the pages of the dashboard, if it is served.
+func publish(e Event) {
+	if board == nil {
+		return
+	}
+	e.Time = time.Now()
+
+	board.mu.Lock()
+	defer board.mu.Unlock()
+	board.history = append(board.history, e)
+	for ch := range board.subs {
+		select {
+		case ch <- e:
+		default:
+			// A slow page reconnects, getting the whole run again.
+			delete(board.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// flushDashboard waits, for a second at most, until the events are sent to the
+// pages of the dashboard, since the program exits after the run.
+func flushDashboard() {
+	if board == nil {
+		return
+	}
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); {
+		pending := 0
+		board.mu.Lock()
+		for ch := range board.subs {
+			pending += len(ch)
+		}
+		board.mu.Unlock()
+		if pending == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (d *dashboard) serveIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, dashboardPage)
+}
+
+func (d *dashboard) servePlan(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	plan := d.plan
+	d.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(plan)
+}
+
+func (d *dashboard) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	ch := make(chan Event, 1024)
+	d.mu.Lock()
+	history := append([]Event(nil), d.history...)
+	d.subs[ch] = true
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		if d.subs[ch] {
+			delete(d.subs, ch)
+			close(ch)
+		}
+		d.mu.Unlock()
+	}()
+
+	send := func(e Event) error {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "data: %s\n\n", data)
+		return err
+	}
+	for _, e := range history {
+		if send(e) != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case e, ok := <-ch:
+			if !ok || send(e) != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// dashboardPage shows the tasks of the plan as a graph, by levels of
+// dependencies, colored by their status, with their duration and output.
+const dashboardPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>gake</title>
+<style>
+body { font: 14px sans-serif; margin: 1em; color: #222; }
+#status { font-weight: bold; margin-bottom: 1em; }
+#graph { position: relative; display: flex; gap: 3em; align-items: flex-start; }
+#edges { position: absolute; top: 0; left: 0; pointer-events: none; }
+.level { display: flex; flex-direction: column; gap: .6em; }
+.task { border: 2px solid #999; border-radius: 4px; padding: .3em .6em; cursor: pointer; background: #eee; }
+.task .time { color: #555; font-size: 12px; }
+.run { background: #ddeeff; border-color: #37c; }
+.pass { background: #dfd; border-color: #3a3; }
+.fail { background: #fdd; border-color: #c33; }
+.skip, .xfail { background: #ffd; border-color: #aa3; }
+.notrun { background: #eee; border-color: #999; border-style: dashed; color: #777; }
+.approval { background: #fec; border-color: #e80; }
+#approval button { margin-left: .6em; }
+.selected { outline: 2px solid #000; }
+pre { background: #f6f6f6; padding: .6em; white-space: pre-wrap; max-height: 40em; overflow: auto; }
+</style>
+</head>
+<body>
+<div id="status">connecting</div>
+<div id="graph"><svg id="edges"></svg></div>
+<h3 id="name"></h3>
+<div id="approval"></div>
+<pre id="output"></pre>
+<script>
+var plan = [], tasks = {}, selected = "", token = "";
+
+function base(name) { var i = name.indexOf("/"); return i < 0 ? name : name.slice(0, i); }
+
+function draw() {
+	var graph = document.getElementById("graph"), svg = document.getElementById("edges");
+	graph.querySelectorAll(".level").forEach(function(e) { e.remove(); });
+	var level = {}, byBase = {};
+	plan.forEach(function(t) { (byBase[base(t.Name)] = byBase[base(t.Name)] || []).push(t.Name); });
+	plan.forEach(function(t) {
+		var l = 0;
+		(t.Deps || []).forEach(function(d) {
+			(byBase[d] || []).forEach(function(n) { if (level[n] !== undefined) l = Math.max(l, level[n] + 1); });
+		});
+		level[t.Name] = l;
+	});
+	var columns = [];
+	plan.forEach(function(t) {
+		var l = level[t.Name];
+		if (!columns[l]) {
+			columns[l] = document.createElement("div");
+			columns[l].className = "level";
+			graph.appendChild(columns[l]);
+		}
+		var e = document.createElement("div");
+		e.id = "task-" + t.Name;
+		e.onclick = function() { selected = t.Name; update(); };
+		columns[l].appendChild(e);
+	});
+	update();
+
+	var box = graph.getBoundingClientRect(), lines = "";
+	svg.setAttribute("width", graph.scrollWidth);
+	svg.setAttribute("height", graph.scrollHeight);
+	plan.forEach(function(t) {
+		var to = document.getElementById("task-" + t.Name).getBoundingClientRect();
+		(t.Deps || []).forEach(function(d) {
+			(byBase[d] || []).forEach(function(n) {
+				var from = document.getElementById("task-" + n).getBoundingClientRect();
+				lines += '<line x1="' + (from.right - box.left) + '" y1="' + (from.top + from.height / 2 - box.top) +
+					'" x2="' + (to.left - box.left) + '" y2="' + (to.top + to.height / 2 - box.top) + '" stroke="#888"/>';
+			});
+		});
+	});
+	svg.innerHTML = lines;
+}
+
+function update() {
+	plan.forEach(function(t) {
+		var s = tasks[t.Name] || {}, e = document.getElementById("task-" + t.Name);
+		e.className = "task " + (s.status || "") + (t.Name == selected ? " selected" : "");
+		e.innerHTML = "";
+		e.appendChild(document.createTextNode(t.Name));
+		var time = document.createElement("div");
+		time.className = "time";
+		time.textContent = s.elapsed !== undefined ? s.elapsed.toFixed(2) + "s" :
+			s.start ? ((Date.now() - s.start) / 1000).toFixed(0) + "s" : "";
+		e.appendChild(time);
+	});
+	var s = tasks[selected] || {}, a = document.getElementById("approval");
+	document.getElementById("name").textContent = selected;
+	document.getElementById("output").textContent = s.output || "";
+	if (a.dataset.task != selected || a.dataset.message != (s.approval || "")) {
+		a.dataset.task = selected;
+		a.dataset.message = s.approval || "";
+		a.innerHTML = "";
+		if (s.approval) {
+			a.appendChild(document.createTextNode(s.approval));
+			["approve", "reject"].forEach(function(d) {
+				var b = document.createElement("button");
+				b.textContent = d;
+				b.onclick = function() { decide(selected, d); };
+				a.appendChild(b);
+			});
+		}
+	}
+}
+
+function decide(task, decision) {
+	var body = new URLSearchParams({task: task, decision: decision, token: token});
+	fetch("approve", {method: "POST", body: body}).then(function(r) {
+		if (r.status == 403) {
+			token = prompt("Approval token") || "";
+			if (token) decide(task, decision);
+		} else if (!r.ok) {
+			r.text().then(alert);
+		}
+	});
+}
+
+function connect() {
+	fetch("plan").then(function(r) { return r.json(); }).then(function(p) {
+		plan = p || [];
+		tasks = {};
+		draw();
+		var es = new EventSource("events");
+		es.onmessage = function(m) {
+			var e = JSON.parse(m.data), st = document.getElementById("status");
+			if (!e.Task) {
+				if (e.Action == "start") st.textContent = "running";
+				if (e.Action == "pass" || e.Action == "fail") st.textContent = "finished: " + e.Action.toUpperCase();
+				return;
+			}
+			var t = tasks[e.Task] = tasks[e.Task] || {output: ""};
+			switch (e.Action) {
+			case "run": t.status = "run"; t.start = Date.parse(e.Time); break;
+			case "output": t.output += e.Output; break;
+			case "pass": case "fail": case "skip": case "xfail": case "notrun": t.status = e.Action; t.elapsed = e.Elapsed; break;
+			case "approval": t.status = "approval"; t.approval = e.Output; selected = selected || e.Task; break;
+			case "approved": case "rejected": t.status = "run"; t.approval = ""; break;
+			}
+			update();
+		};
+		es.onerror = function() {
+			es.close();
+			document.getElementById("status").textContent += " (disconnected)";
+		};
+	});
+}
+
+setInterval(update, 1000);
+window.onresize = draw;
+connect();
+</script>
+</body>
+</html>
+`
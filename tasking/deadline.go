@@ -0,0 +1,182 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var maxExtend = flag.Duration("task.max-extend", 0, "maximum time which the tasks can add to -task.timeout by T.Extend, in total")
+
+// The alarm of -task.timeout, which is put off by Extend.
+var (
+	alarmMu       sync.Mutex
+	alarmDeadline time.Time
+	alarmExtended time.Duration // Time added to -task.timeout by Extend.
+)
+
+// deadline is the share of -task.timeout given to a task, so that a task which
+// takes too long fails alone, instead of the whole run timing out.
+type deadline struct {
+	mu      sync.Mutex
+	timer   *time.Timer
+	at      time.Time
+	share   time.Duration
+	stopped bool // Task finished.
+	expired bool // Task has exceeded its deadline.
+}
+
+// errExpired is returned by the commands run after the task has exceeded its
+// deadline.
+var errExpired = errors.New("tasking: the task has exceeded its share of -task.timeout")
+
+// Extend asks for d more time to run the task, like before a download which is
+// slower than expected. It puts off both the deadline of the task and the time
+// limit of the run, given by the flag -task.timeout, and reports whether the
+// time is given: the time added by all the tasks can not exceed the flag
+// -task.max-extend, which is 0 by default.
+//
+// With -task.timeout, every task is given a share of the time left when it is
+// started, in proportion to its weight among the tasks not finished yet and
+// the capacity given by -task.parallel. A task which exceeds its share fails,
+// and the processes which it has launched are stopped, but the rest of the run
+// goes on.
+func (t *T) Extend(d time.Duration) bool {
+	if *timeout <= 0 || d <= 0 {
+		return true
+	}
+	root := t
+	for root.parent != nil {
+		root = root.parent
+	}
+	if dl := root.deadline; dl != nil {
+		dl.mu.Lock()
+		expired := dl.expired
+		dl.mu.Unlock()
+		if expired {
+			t.log("tasking: can't extend the time: the task has exceeded its deadline", nil)
+			return false
+		}
+	}
+
+	alarmMu.Lock()
+	if left := *maxExtend - alarmExtended; d > left {
+		alarmMu.Unlock()
+		t.log(fmt.Sprintf("tasking: can't extend the time by %v: -task.max-extend=%v, %v left", d, *maxExtend, left), nil)
+		return false
+	}
+	if timer != nil && !timer.Stop() {
+		alarmMu.Unlock()
+		return false // The run has timed out.
+	}
+	alarmExtended += d
+	alarmDeadline = alarmDeadline.Add(d)
+	if timer != nil {
+		timer.Reset(time.Until(alarmDeadline))
+	}
+	alarmMu.Unlock()
+
+	if dl := root.deadline; dl != nil {
+		dl.mu.Lock()
+		if !dl.stopped && !dl.expired && dl.timer.Stop() {
+			dl.at = dl.at.Add(d)
+			dl.share += d
+			dl.timer.Reset(time.Until(dl.at))
+		}
+		dl.mu.Unlock()
+	}
+	t.log(fmt.Sprintf("time extended by %v", d), nil)
+	return true
+}
+
+// taskShare returns the time given to a task of the given weight which is
+// started now, or 0 if it is not limited but by the time limit of the run. The
+// time left is shared among the tasks of the scheduler which have not finished.
+func (s *scheduler) taskShare(weight int) time.Duration {
+	if *timeout <= 0 {
+		return 0
+	}
+	s.mu.Lock()
+	pending := s.pending
+	s.mu.Unlock()
+
+	capacity := s.opts.Parallel
+	if capacity < 1 {
+		capacity = 1
+	}
+	if pending <= capacity {
+		return 0
+	}
+	alarmMu.Lock()
+	left := time.Until(alarmDeadline)
+	alarmMu.Unlock()
+	share := time.Duration(int64(left) * int64(capacity) / int64(pending) * int64(weight))
+	if share >= left {
+		return 0
+	}
+	return share
+}
+
+// startDeadline starts the deadline of the task, if it is given a share of
+// -task.timeout.
+func (t *T) startDeadline() {
+	share := t.sched.taskShare(t.weight)
+	if share <= 0 {
+		return
+	}
+	dl := &deadline{at: time.Now().Add(share), share: share}
+	t.deadline = dl
+	dl.mu.Lock()
+	dl.timer = time.AfterFunc(share, t.expire)
+	dl.mu.Unlock()
+}
+
+// stopDeadline stops the deadline of the task, once it has finished.
+func (t *T) stopDeadline() {
+	if dl := t.deadline; dl != nil {
+		dl.mu.Lock()
+		dl.stopped = true
+		dl.timer.Stop()
+		dl.mu.Unlock()
+	}
+}
+
+// expire fails the task which has exceeded its deadline, and stops its
+// processes.
+func (t *T) expire() {
+	dl := t.deadline
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	if dl.stopped {
+		return
+	}
+	dl.expired = true
+	t.write(fmt.Sprintf("\ttasking: the task has exceeded its share of -task.timeout, %v; its processes are stopped\n",
+		dl.share.Round(time.Millisecond)), nil)
+	t.Fail()
+	t.stopProcesses()
+}
+
+// checkDeadline returns errExpired if the task, or the task of the branch, has
+// exceeded its deadline.
+func (t *T) checkDeadline() error {
+	for t.parent != nil {
+		t = t.parent
+	}
+	if dl := t.deadline; dl != nil {
+		dl.mu.Lock()
+		defer dl.mu.Unlock()
+		if dl.expired {
+			return errExpired
+		}
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+)
+
+var (
+	only       = flag.Bool("task.only", false, "run only the selected tasks, without their dependencies")
+	dependents = flag.Bool("task.dependents", false, "run also the tasks which depend on the selected ones")
+)
+
+// planTasks returns the tasks to run for the selected ones, ordered so that
+// every task is after its dependencies and else in the order of selection.
+//
+// The dependencies of a task are declared by "gake:deps" directives into its
+// documentation. By default, the selected tasks are run with all the tasks they
+// depend on; with -task.only, without them; and with -task.dependents, with all
+// the tasks which depend on them instead. The tasks whose conditions, declared
+// by "gake:when" directives, do not hold are left out of the plan; the ones which
+// depend on them are skipped when they are run.
+func planTasks(tasks, selected []InternalTask) ([]InternalTask, error) {
+	if *only && *dependents {
+		return nil, errors.New("-task.only and -task.dependents can not be used together")
+	}
+
+	index := make(map[string]int)       // Index of every task, by its name.
+	instances := make(map[string][]int) // Indexes of the instances of every task function.
+	enabled := make([]bool, len(tasks)) // Tasks whose conditions hold.
+	for i, task := range tasks {
+		index[task.Name] = i
+		enabled[i] = conditionsHold(task.When)
+		base := baseName(task.Name)
+		instances[base] = append(instances[base], i)
+	}
+	for _, task := range tasks {
+		for _, dep := range task.Deps {
+			if _, ok := instances[dep]; !ok {
+				return nil, fmt.Errorf("%s: unknown dependency %q", task.Name, dep)
+			}
+		}
+	}
+
+	in := make(map[int]bool)           // Tasks to run.
+	disabled := make(map[int][]string) // Dependencies of the tasks to run which are not enabled.
+	roots := make([]int, 0, len(selected))
+	for _, task := range selected {
+		if i := index[task.Name]; enabled[i] {
+			in[i] = true
+			roots = append(roots, i)
+		}
+	}
+
+	switch {
+	case *only:
+	case *dependents:
+		for added := true; added; {
+			added = false
+			for i, task := range tasks {
+				if !in[i] && enabled[i] && dependsOn(task, instances, in) {
+					in[i] = true
+					roots = append(roots, i)
+					added = true
+				}
+			}
+		}
+	default:
+		for stack := append([]int(nil), roots...); len(stack) != 0; {
+			i := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			for _, dep := range tasks[i].Deps {
+				isEnabled := false
+				for _, j := range instances[dep] {
+					if !enabled[j] {
+						continue
+					}
+					isEnabled = true
+					if !in[j] {
+						in[j] = true
+						stack = append(stack, j)
+					}
+				}
+				if !isEnabled {
+					disabled[i] = append(disabled[i], dep)
+				}
+			}
+		}
+	}
+
+	plan := make([]InternalTask, 0, len(in))
+	state := make(map[int]int) // 1 while its dependencies are visited, 2 once planned.
+	path := make([]string, 0)
+
+	var visit func(i int) error
+	visit = func(i int) error {
+		switch state[i] {
+		case 2:
+			return nil
+		case 1:
+			for j, name := range path {
+				if name == tasks[i].Name {
+					path = append(path[j:], name)
+					break
+				}
+			}
+			return fmt.Errorf("dependency cycle: %s", strings.Join(path, " -> "))
+		}
+		state[i] = 1
+		path = append(path, tasks[i].Name)
+		for _, dep := range tasks[i].Deps {
+			for _, j := range instances[dep] {
+				if !in[j] {
+					continue
+				}
+				if err := visit(j); err != nil {
+					return err
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[i] = 2
+		task := tasks[i]
+		task.disabledDeps = disabled[i]
+		plan = append(plan, task)
+		return nil
+	}
+
+	for _, i := range roots {
+		if err := visit(i); err != nil {
+			return nil, err
+		}
+	}
+	return plan, nil
+}
+
+// dependsOn reports whether the task depends on some of the tasks in the set.
+func dependsOn(task InternalTask, instances map[string][]int, set map[int]bool) bool {
+	for _, dep := range task.Deps {
+		for _, j := range instances[dep] {
+			if set[j] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchAny returns a function which matches the name of any of the tasks,
+// whatever the pattern, to run the tasks already planned.
+func matchAny(tasks []InternalTask) func(pat, str string) (bool, error) {
+	names := make(map[string]bool, len(tasks))
+	for _, task := range tasks {
+		names[task.Name] = true
+	}
+	return func(_, name string) (bool, error) { return names[name], nil }
+}
+
+// checkDeps skips the task when some of its dependencies has failed, or has
+// been skipped for that same reason, or it is disabled by its "gake:when"
+// conditions.
+func (t *T) checkDeps() {
+	if len(t.disabledDeps) != 0 {
+		t.blocked = true
+		t.write("\tskipped: dependency "+strings.Join(t.disabledDeps, ", ")+" is disabled by gake:when\n", nil)
+		t.skipNow(SKIP_DEPENDENCY)
+	}
+	for _, d := range t.deps {
+		reason := ""
+		switch {
+		case d.Failed():
+			reason = "failed"
+		case d.blocked:
+			reason = "was skipped"
+		default:
+			continue
+		}
+		t.blocked = true
+		t.write("\tskipped: dependency "+d.name+" "+reason+"\n", nil)
+		t.skipNow(SKIP_DEPENDENCY)
+	}
+}
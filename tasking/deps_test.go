@@ -0,0 +1,103 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestPlanTasks(t *testing.T) {
+	const disabledVar = "GAKE_TEST_DISABLED"
+	os.Unsetenv(disabledVar)
+
+	task := func(name string, deps ...string) InternalTask {
+		return InternalTask{Name: name, Deps: deps}
+	}
+	when := func(task InternalTask, conds ...string) InternalTask {
+		task.When = conds
+		return task
+	}
+	tasks := []InternalTask{
+		task("TaskA"),
+		task("TaskB", "TaskA"),
+		task("TaskC", "TaskB"),
+		task("TaskD", "TaskA"),
+		when(task("TaskE"), "env:"+disabledVar),
+		task("TaskF", "TaskE"),
+		task("TaskG", "TaskF"),
+	}
+	cycle := []InternalTask{
+		task("TaskX", "TaskY"),
+		task("TaskY", "TaskZ"),
+		task("TaskZ", "TaskX"),
+	}
+
+	tests := []struct {
+		tasks      []InternalTask
+		selected   []string
+		only       bool
+		dependents bool
+		want       string // Names of the plan, with the disabled dependencies into brackets.
+		err        string
+	}{
+		// The dependencies are run before, else in the order of selection.
+		{tasks, []string{"TaskC"}, false, false, "TaskA TaskB TaskC", ""},
+		{tasks, []string{"TaskD", "TaskC"}, false, false, "TaskA TaskD TaskB TaskC", ""},
+		{tasks, []string{"TaskC", "TaskA"}, false, false, "TaskA TaskB TaskC", ""},
+
+		{tasks, []string{"TaskC"}, true, false, "TaskC", ""},
+		{tasks, []string{"TaskC", "TaskB"}, true, false, "TaskB TaskC", ""},
+		{tasks, []string{"TaskA"}, false, true, "TaskA TaskB TaskC TaskD", ""},
+		{tasks, []string{"TaskB"}, false, true, "TaskB TaskC", ""},
+
+		// The tasks which depend on a disabled one are planned to be skipped.
+		{tasks, []string{"TaskE"}, false, false, "", ""},
+		{tasks, []string{"TaskF"}, false, false, "TaskF[TaskE]", ""},
+		{tasks, []string{"TaskG"}, false, false, "TaskF[TaskE] TaskG", ""},
+		{tasks, []string{"TaskF"}, true, false, "TaskF", ""},
+
+		{cycle, []string{"TaskX"}, false, false, "", "dependency cycle: TaskX -> TaskY -> TaskZ -> TaskX"},
+		{cycle, []string{"TaskY"}, true, false, "TaskY", ""},
+		{[]InternalTask{task("TaskA", "TaskNope")}, []string{"TaskA"}, false, false, "",
+			`TaskA: unknown dependency "TaskNope"`},
+		{tasks, []string{"TaskA"}, true, true, "", "-task.only and -task.dependents can not be used together"},
+	}
+
+	defer func(o, d bool) { *only, *dependents = o, d }(*only, *dependents)
+
+	for _, tt := range tests {
+		*only, *dependents = tt.only, tt.dependents
+
+		selected := make([]InternalTask, 0, len(tt.selected))
+		for _, name := range tt.selected {
+			for _, task := range tt.tasks {
+				if task.Name == name {
+					selected = append(selected, task)
+				}
+			}
+		}
+		plan, err := planTasks(tt.tasks, selected)
+
+		names := make([]string, len(plan))
+		for i, task := range plan {
+			names[i] = task.Name
+			if len(task.disabledDeps) != 0 {
+				names[i] += "[" + strings.Join(task.disabledDeps, ",") + "]"
+			}
+		}
+		errText := ""
+		if err != nil {
+			errText = err.Error()
+		}
+		if got := strings.Join(names, " "); got != tt.want || errText != tt.err {
+			t.Errorf("planTasks(%v, only=%v, dependents=%v) = %q, %q; want %q, %q",
+				tt.selected, tt.only, tt.dependents, got, errText, tt.want, tt.err)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Diff returns the differences between the values a and b, like the expected
+// and the actual configuration of a system, with a line per difference:
+//
+//	only in a:  - path: value
+//	only in b:  + path: value
+//	changed:    ~ path: value -> value
+//
+// where the path is like `spec.ports[0].port`; the strings of several lines
+// which differ are shown as the DiffText of their lines. It returns an empty
+// string if there are no differences.
+//
+// The values can be structs, maps, slices and scalars, compared by their
+// content: the numbers are equal whatever their type, and the fields of the
+// structs are named by their "json" tag, if any, so that a struct can be
+// compared with a JSON document. A []byte or json.RawMessage, and a string
+// which starts with '{' or '[', are decoded as JSON. A YAML document can be
+// compared once it is decoded into a map, with any YAML package.
+func Diff(a, b interface{}) string {
+	var buf bytes.Buffer
+	diff(&buf, "", plain(decodeJSON(a)), plain(decodeJSON(b)))
+	return buf.String()
+}
+
+// number is a number with its shortest representation, so that the numbers are
+// compared whatever their type.
+type number string
+
+func (n number) MarshalJSON() ([]byte, error) { return []byte(n), nil }
+
+func diff(buf *bytes.Buffer, path string, a, b interface{}) {
+	switch a := a.(type) {
+	case map[string]interface{}:
+		if b, ok := b.(map[string]interface{}); ok {
+			keys := make([]string, 0, len(a)+len(b))
+			for k := range a {
+				keys = append(keys, k)
+			}
+			for k := range b {
+				if _, ok := a[k]; !ok {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+
+			for _, k := range keys {
+				va, inA := a[k]
+				vb, inB := b[k]
+				switch {
+				case !inB:
+					fmt.Fprintf(buf, "- %s: %s\n", keyPath(path, k), format(va))
+				case !inA:
+					fmt.Fprintf(buf, "+ %s: %s\n", keyPath(path, k), format(vb))
+				default:
+					diff(buf, keyPath(path, k), va, vb)
+				}
+			}
+			return
+		}
+	case []interface{}:
+		if b, ok := b.([]interface{}); ok {
+			for i := 0; i < len(a) || i < len(b); i++ {
+				p := fmt.Sprintf("%s[%d]", path, i)
+				switch {
+				case i >= len(b):
+					fmt.Fprintf(buf, "- %s: %s\n", p, format(a[i]))
+				case i >= len(a):
+					fmt.Fprintf(buf, "+ %s: %s\n", p, format(b[i]))
+				default:
+					diff(buf, p, a[i], b[i])
+				}
+			}
+			return
+		}
+	}
+
+	if reflect.DeepEqual(a, b) {
+		return
+	}
+	if path == "" {
+		path = "."
+	}
+	// The texts of several lines are compared by lines.
+	if sa, ok := a.(string); ok {
+		if sb, ok := b.(string); ok && (strings.Contains(sa, "\n") || strings.Contains(sb, "\n")) {
+			fmt.Fprintf(buf, "~ %s:\n", path)
+			for _, l := range splitLines(DiffText(sa, sb)) {
+				fmt.Fprintf(buf, "\t%s\n", l)
+			}
+			return
+		}
+	}
+	fmt.Fprintf(buf, "~ %s: %s -> %s\n", path, format(a), format(b))
+}
+
+// keyPath returns the path of the key into the map at path.
+func keyPath(path, key string) string {
+	if key == "" || strings.ContainsAny(key, ".[]\"' \t\n") {
+		return path + "[" + strconv.Quote(key) + "]"
+	}
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// format returns the value in JSON.
+func format(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
+
+// decodeJSON returns the JSON document decoded, if v is one.
+func decodeJSON(v interface{}) interface{} {
+	var data []byte
+	switch v := v.(type) {
+	case []byte:
+		data = v
+	case json.RawMessage:
+		data = v
+	case string:
+		if s := strings.TrimSpace(v); strings.HasPrefix(s, "{") || strings.HasPrefix(s, "[") {
+			data = []byte(s)
+		}
+	}
+	if data == nil {
+		return v
+	}
+
+	var doc interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if dec.Decode(&doc) != nil {
+		return v
+	}
+	return doc
+}
+
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+// plain returns the value with only maps with string keys, slices of
+// interface{}, strings, numbers, booleans and nil.
+func plain(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	if n, ok := v.(json.Number); ok {
+		if f, err := n.Float64(); err == nil {
+			return number(strconv.FormatFloat(f, 'g', -1, 64))
+		}
+		return number(n)
+	}
+	return plainValue(reflect.ValueOf(v))
+}
+
+func plainValue(v reflect.Value) interface{} {
+	if v.Type().Implements(textMarshalerType) && (v.Kind() != reflect.Ptr || !v.IsNil()) {
+		if text, err := v.Interface().(encoding.TextMarshaler).MarshalText(); err == nil {
+			return string(text)
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return plain(v.Elem().Interface())
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.String:
+		return v.String()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return number(strconv.FormatInt(v.Int(), 10))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return number(strconv.FormatUint(v.Uint(), 10))
+	case reflect.Float32, reflect.Float64:
+		return number(strconv.FormatFloat(v.Float(), 'g', -1, 64))
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 && v.Kind() == reflect.Slice {
+			return string(v.Bytes())
+		}
+		s := make([]interface{}, v.Len())
+		for i := range s {
+			s[i] = plainValue(v.Index(i))
+		}
+		return s
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		m := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			m[fmt.Sprint(iter.Key().Interface())] = plainValue(iter.Value())
+		}
+		return m
+	case reflect.Struct:
+		m := make(map[string]interface{})
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Type().Field(i)
+			if f.PkgPath != "" { // Unexported.
+				continue
+			}
+			name := f.Name
+			if tag := f.Tag.Get("json"); tag != "" {
+				if tag = strings.Split(tag, ",")[0]; tag == "-" {
+					continue
+				} else if tag != "" {
+					name = tag
+				}
+			}
+			m[name] = plainValue(v.Field(i))
+		}
+		return m
+	}
+	return fmt.Sprint(v.Interface())
+}
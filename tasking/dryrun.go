@@ -0,0 +1,35 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import "flag"
+
+var dryRun = flag.Bool("task.dry-run", false, "log the commands and the file changes instead of doing them")
+
+// DryRun reports whether the -task.dry-run flag is set.
+//
+// In a dry run, the helpers which act, like Exec, Shell, StartService, the
+// transfers of SSH, SignArtifact and the update of the snapshots, log what they
+// would do, with the prefix "would run:", and return as if it had succeeded;
+// the tasks check DryRun to preview the rest of their actions.
+func DryRun() bool {
+	return *dryRun
+}
+
+// DryRun reports whether the -task.dry-run flag is set; see DryRun.
+func (t *T) DryRun() bool {
+	return *dryRun
+}
+
+// runPrefix returns the prefix of the commands logged by the helpers: "$ ",
+// or "would run: " in a dry run.
+func runPrefix() string {
+	if *dryRun {
+		return "would run: "
+	}
+	return "$ "
+}
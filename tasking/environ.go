@@ -0,0 +1,84 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"os"
+	"strings"
+	"sync"
+)
+
+// Environment variables which identify a CI provider.
+var ciProviders = []struct{ env, name string }{
+	{"GITHUB_ACTIONS", "github"},
+	{"GITLAB_CI", "gitlab"},
+	{"CIRCLECI", "circleci"},
+	{"TRAVIS", "travis"},
+	{"JENKINS_URL", "jenkins"},
+	{"BUILDKITE", "buildkite"},
+	{"TF_BUILD", "azure"},
+	{"TEAMCITY_VERSION", "teamcity"},
+	{"BITBUCKET_BUILD_NUMBER", "bitbucket"},
+	{"DRONE", "drone"},
+	{"CODEBUILD_BUILD_ID", "codebuild"},
+	{"APPVEYOR", "appveyor"},
+}
+
+// CIProvider returns the name of the continuous integration service where the
+// tasks are run, such as "github", "gitlab", "circleci", "travis", "jenkins",
+// "buildkite", "azure", "teamcity", "bitbucket", "drone", "codebuild" or
+// "appveyor". It returns "unknown" for another service which
+// sets the environment variable CI, and "" out of CI.
+func CIProvider() string {
+	for _, p := range ciProviders {
+		if os.Getenv(p.env) != "" {
+			return p.name
+		}
+	}
+	switch strings.ToLower(os.Getenv("CI")) {
+	case "", "0", "false":
+		return ""
+	}
+	return "unknown"
+}
+
+// InCI reports whether the tasks are run by a continuous integration service.
+func InCI() bool { return CIProvider() != "" }
+
+var (
+	containerOnce sync.Once
+	inContainer   bool
+)
+
+// InContainer reports whether the tasks are run into a container, such as
+// Docker, Podman, LXC or a Kubernetes pod.
+func InContainer() bool {
+	containerOnce.Do(func() { inContainer = detectContainer() })
+	return inContainer
+}
+
+func detectContainer() bool {
+	if os.Getenv("KUBERNETES_SERVICE_HOST") != "" || os.Getenv("container") != "" {
+		return true
+	}
+	for _, f := range []string{"/.dockerenv", "/run/.containerenv"} {
+		if _, err := os.Stat(f); err == nil {
+			return true
+		}
+	}
+
+	cgroup, err := os.ReadFile("/proc/1/cgroup")
+	if err != nil {
+		return false
+	}
+	for _, s := range []string{"docker", "kubepods", "containerd", "lxc", "libpod"} {
+		if strings.Contains(string(cgroup), s) {
+			return true
+		}
+	}
+	return false
+}
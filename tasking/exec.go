@@ -0,0 +1,323 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tredoe/gake/internal/pty"
+)
+
+var (
+	killGrace = flag.Duration("task.kill-grace", 5*time.Second, "time given to the processes left by a task to exit before they are killed")
+	ptyOutput = flag.Bool("task.pty", false, "give a pseudo-terminal as output to the commands run by Exec, so that they write like in a terminal")
+)
+
+// Exec runs the named program with the given arguments, in the manner of
+// exec.Command, and waits for it to exit. The command line, its standard output
+// and its standard error are written to the output of the task, and the limits
+// of the task are applied to the process; see Limits.
+//
+// The process is started into a new process group, unless its standard input
+// is a terminal, so that the processes it launches can be tracked. When the
+// task finishes, or the run times out, the processes still alive are
+// terminated; after the period given by the flag -task.kill-grace, they are
+// killed.
+//
+// With the flag -task.pty, the output is read through a pseudo-terminal, so
+// that the program writes like in a terminal, with colors and progress bars.
+//
+// Every command run is recorded, with its directory, duration and exit code,
+// into the audit of the run, printed after the results with -task.v and
+// -task.json; the values of its arguments which look like secrets are redacted.
+func (t *T) Exec(name string, arg ...string) error {
+	cmd := exec.Command(name, arg...)
+	t.log(runPrefix()+commandLine(cmd), nil)
+	return t.exec(cmd)
+}
+
+// ExecCmd runs the command like Exec, so that its directory, environment or
+// input can be set. Its standard output and standard error are written to the
+// output of the task only if they are not set.
+func (t *T) ExecCmd(cmd *exec.Cmd) error {
+	t.log(runPrefix()+commandLine(cmd), nil)
+	return t.exec(cmd)
+}
+
+func (t *T) exec(cmd *exec.Cmd) error {
+	if *dryRun {
+		return nil
+	}
+	if err := t.checkDeadline(); err != nil {
+		return err
+	}
+	if cmd.Dir == "" {
+		cmd.Dir = t.workDir()
+	}
+	if err := t.setUser(cmd); err != nil {
+		return err
+	}
+
+	// The output is read through a pipe, instead of letting the command copy
+	// it, so that it does not wait for the processes left in background which
+	// hold the pipe.
+	var pr, pw *os.File
+	tty := false
+	if cmd.Stdout == nil || cmd.Stderr == nil {
+		var err error
+		if pr, pw, tty, err = t.outputPipe(); err != nil {
+			return err
+		}
+		defer pr.Close()
+		if cmd.Stdout == nil {
+			cmd.Stdout = pw
+		}
+		if cmd.Stderr == nil {
+			cmd.Stderr = pw
+		}
+	}
+
+	setProcGroup(cmd)
+	start := time.Now()
+	err := cmd.Start()
+	if pw != nil {
+		pw.Close()
+	}
+	if err != nil {
+		t.recordCommand(cmd, start, err)
+		return err
+	}
+
+	copied := make(chan bool)
+	if pr != nil {
+		go func() {
+			out := &lineWriter{c: &t.common, crlf: tty}
+			io.Copy(out, pr)
+			out.Flush()
+			close(copied)
+		}()
+		defer drainPipe(pr, copied)
+	}
+	g, err := newProcGroup(cmd.Process)
+	if err != nil {
+		t.write("\ttasking: processes not tracked: "+err.Error()+"\n", nil)
+	} else {
+		t.addProcGroup(g)
+	}
+
+	t.mu.RLock()
+	limits := t.limits
+	t.mu.RUnlock()
+	if limits != (Limits{}) {
+		release, err := applyLimits(t.name, g, limits)
+		if err != nil {
+			t.write("\ttasking: limits not applied: "+err.Error()+"\n", nil)
+		}
+		if release != nil {
+			defer release()
+		}
+	}
+
+	err = cmd.Wait()
+	t.recordCommand(cmd, start, err)
+	return err
+}
+
+// procSet is the set of process groups launched by the tasks of a scheduler,
+// and not stopped yet.
+type procSet struct {
+	mu     sync.Mutex
+	groups map[*procGroup]bool
+}
+
+func newProcSet() *procSet {
+	return &procSet{groups: make(map[*procGroup]bool)}
+}
+
+// add tracks the process group, until it is stopped.
+func (ps *procSet) add(g *procGroup) {
+	ps.mu.Lock()
+	ps.groups[g] = true
+	ps.mu.Unlock()
+}
+
+// stopAll stops the processes left by all the tasks.
+func (ps *procSet) stopAll() {
+	ps.mu.Lock()
+	groups := make([]*procGroup, 0, len(ps.groups))
+	for g := range ps.groups {
+		groups = append(groups, g)
+	}
+	ps.mu.Unlock()
+
+	ps.stop(groups)
+}
+
+// stop terminates the process groups still alive, and kills them if they have
+// not exited after the grace period. The groups already stopped, which are not
+// into the set, are skipped, since a task and the abort of the run could stop
+// the same ones; so a group is closed once.
+func (ps *procSet) stop(groups []*procGroup) {
+	ps.mu.Lock()
+	n := 0
+	for _, g := range groups {
+		if ps.groups[g] {
+			delete(ps.groups, g)
+			groups[n] = g
+			n++
+		}
+	}
+	groups = groups[:n]
+	ps.mu.Unlock()
+
+	alive := make([]*procGroup, 0, len(groups))
+	for _, g := range groups {
+		if g.alive() {
+			g.terminate()
+			alive = append(alive, g)
+		}
+	}
+
+	deadline := time.Now().Add(*killGrace)
+	for len(alive) != 0 && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+
+		n := 0
+		for _, g := range alive {
+			if g.alive() {
+				alive[n] = g
+				n++
+			}
+		}
+		alive = alive[:n]
+	}
+	for _, g := range alive {
+		g.kill()
+	}
+	for _, g := range groups {
+		g.close()
+	}
+}
+
+// addProcGroup tracks the process group, to be stopped when the task finishes.
+func (t *T) addProcGroup(g *procGroup) {
+	t.mu.Lock()
+	t.procGroups = append(t.procGroups, g)
+	t.mu.Unlock()
+
+	t.sched.groups.add(g)
+}
+
+// stopProcesses stops the processes left by the task.
+func (t *T) stopProcesses() {
+	t.mu.Lock()
+	groups := t.procGroups
+	t.procGroups = nil
+	t.mu.Unlock()
+
+	t.sched.groups.stop(groups)
+}
+
+// drainPipe waits, once the process has exited, for what is left into the pipe
+// of its output to be copied, which is closed by copied. The descendants of the
+// process which keep the pipe open are not waited for.
+func drainPipe(pr *os.File, copied <-chan bool) {
+	if pr.SetReadDeadline(time.Now().Add(100*time.Millisecond)) != nil {
+		pr.Close()
+	}
+	<-copied
+}
+
+// commandLine returns the arguments of the command, quoted when required.
+func commandLine(cmd *exec.Cmd) string {
+	args := make([]string, len(cmd.Args))
+	for i, a := range cmd.Args {
+		if a == "" || strings.ContainsAny(a, " \t\n\"'\\$") {
+			a = strconv.Quote(a)
+		}
+		args[i] = a
+	}
+	return strings.Join(args, " ")
+}
+
+// lineWriter writes whole lines to the output of a task, indented under the
+// command line.
+type lineWriter struct {
+	c      *common
+	prefix string            // Prefix of every line, after the indentation.
+	line   func(line string) // Called with every line, if set.
+	crlf   bool              // Output of a terminal, whose lines end in "\r\n".
+	buf    []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+
+	if i := bytes.LastIndexByte(w.buf, '\n'); i != -1 {
+		w.writeLines(w.buf[:i+1])
+		w.buf = append(w.buf[:0], w.buf[i+1:]...)
+	}
+	return len(p), nil
+}
+
+// Flush writes the last line, if it has not a newline.
+func (w *lineWriter) Flush() {
+	if len(w.buf) != 0 {
+		w.writeLines(append(w.buf, '\n'))
+		w.buf = nil
+	}
+}
+
+func (w *lineWriter) writeLines(b []byte) {
+	if w.crlf {
+		b = bytes.Replace(b, []byte("\r\n"), []byte("\n"), -1)
+	}
+	w.c.write(indent(b, w.prefix), nil)
+	if w.line != nil {
+		for _, l := range strings.SplitAfter(string(b), "\n") {
+			if l != "" {
+				w.line(strings.TrimSuffix(l, "\n"))
+			}
+		}
+	}
+}
+
+// outputPipe returns the pipe where the output of a command is read, which is
+// a pseudo-terminal with the flag -task.pty, and whether it is so.
+func (t *T) outputPipe() (r, w *os.File, tty bool, err error) {
+	if *ptyOutput {
+		if r, w, err = pty.Open(); err == nil {
+			// The size of the terminal where gake is run, if any.
+			if pty.InheritSize(os.Stdout, w) != nil {
+				pty.SetSize(w, 24, 80)
+			}
+			return r, w, true, nil
+		}
+		t.write("\ttasking: no pseudo-terminal: "+err.Error()+"\n", nil)
+	}
+	r, w, err = os.Pipe()
+	return r, w, false, err
+}
+
+// indent prefixes every line with two tabs and the given prefix.
+func indent(b []byte, prefix string) string {
+	lines := strings.SplitAfter(string(b), "\n")
+	for i, l := range lines {
+		if l != "" {
+			lines[i] = "\t\t" + prefix + l
+		}
+	}
+	return strings.Join(lines, "")
+}
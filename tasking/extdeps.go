@@ -0,0 +1,214 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ENV_EXECUTABLE is the environment variable, set by gake, with the path
+	// of its executable, which runs the tasks of other packages.
+	ENV_EXECUTABLE = "GAKE_EXECUTABLE"
+
+	// ENV_DEPS_CHAIN is the environment variable with the tasks of other
+	// packages being run as dependencies, like
+	// "/src/app:TaskBuild,/src/proto:TaskGenerate", to find the cycles.
+	ENV_DEPS_CHAIN = "GAKE_DEPS_CHAIN"
+)
+
+// extRun is the run of a task of another package, which is shared by all the
+// tasks which depend on it.
+type extRun struct {
+	done      chan struct{}
+	status    string // Of the task: pass, fail, skip, or "" if it was not run.
+	output    string
+	artifacts []string
+	err       error
+}
+
+var (
+	extRunsMu sync.Mutex
+	extRuns   = make(map[string]*extRun) // By "dir:Task", with the absolute directory.
+)
+
+// runExtDeps runs, in order, the tasks of other packages declared by the
+// directive "gake:deps" like "./proto:TaskGenerate", before the task is
+// started. Every one is run once per run, by gake, with the directory relative
+// to the one of the task files. When one of them is skipped, the task is
+// skipped; when it fails, the task fails with its output, since it is not
+// reported into this run.
+func (t *T) runExtDeps(deps []string) {
+	if *dryRun {
+		t.write("\twould run "+strings.Join(deps, ", ")+"\n", nil)
+		return
+	}
+	taskDir := os.Getenv(ENV_TASKDIR)
+	self := taskDir + ":" + baseName(t.name)
+	chain := os.Getenv(ENV_DEPS_CHAIN)
+
+	for _, dep := range deps {
+		dir, name := extDep(taskDir, dep)
+		key := dir + ":" + name
+		for _, k := range strings.Split(chain, ",") {
+			if k == key {
+				t.write("\ttasking: dependency cycle: "+strings.Replace(chain, ",", " -> ", -1)+" -> "+key+"\n", nil)
+				t.FailNow()
+			}
+		}
+		next := self + "," + key
+		if chain != "" {
+			next = chain + "," + next
+		}
+
+		extRunsMu.Lock()
+		r, ok := extRuns[key]
+		if !ok {
+			r = &extRun{done: make(chan struct{})}
+			extRuns[key] = r
+			go r.run(dir, name, next)
+		}
+		extRunsMu.Unlock()
+		t.waitExtRun(r)
+
+		switch {
+		case r.err != nil:
+			t.write("\ttasking: dependency "+dep+": "+r.err.Error()+"\n", nil)
+			t.FailNow()
+		case r.status == "skip":
+			t.blocked = true
+			t.write("\tskipped: dependency "+dep+" was skipped\n", nil)
+			t.skipNow(SKIP_DEPENDENCY)
+		case r.status != "pass":
+			t.write("\tdependency "+dep+" failed:\n"+indent([]byte(r.output), ""), nil)
+			t.FailNow()
+		}
+		t.mu.Lock()
+		if t.depArtifacts == nil {
+			t.depArtifacts = make(map[string][]string)
+		}
+		t.depArtifacts[dep] = r.artifacts
+		t.mu.Unlock()
+	}
+}
+
+// waitExtRun waits for the run of the task of another package, keeping the
+// task from being seen as stalled.
+func (t *T) waitExtRun(r *extRun) {
+	if *stallTimeout <= 0 {
+		<-r.done
+		return
+	}
+	tick := time.NewTicker(stallTick())
+	defer tick.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-tick.C:
+			t.Progress()
+		}
+	}
+}
+
+// extDep returns the directory of the dependency "dir:Task", made absolute
+// from the one of the task files, and the name of the task.
+func extDep(taskDir, dep string) (dir, name string) {
+	i := strings.LastIndexByte(dep, ':')
+	return filepath.Join(taskDir, filepath.FromSlash(dep[:i])), dep[i+1:]
+}
+
+// run runs the task of the directory by gake, and records its result from the
+// JSON events.
+func (r *extRun) run(dir, name, chain string) {
+	defer close(r.done)
+
+	gake := os.Getenv(ENV_EXECUTABLE)
+	if gake == "" {
+		gake = "gake"
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command(gake, "-json", "-no-stdin", "-names", name, dir)
+	cmd.Env = append(os.Environ(), ENV_DEPS_CHAIN+"="+chain)
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		r.err = err
+		return
+	}
+	if err = cmd.Start(); err != nil {
+		r.err = err
+		return
+	}
+
+	var (
+		output bytes.Buffer
+		status string // Of the run.
+		reason string
+	)
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var e struct {
+			Event
+			Status string
+			Error  string
+		}
+		if json.Unmarshal(scanner.Bytes(), &e) != nil {
+			continue
+		}
+		switch {
+		case e.Action == "status":
+			status, reason = e.Status, e.Error
+		case baseName(e.Task) != name:
+		case e.Action == "output":
+			output.WriteString(e.Output)
+		case e.Action == "pass", e.Action == "fail", e.Action == "skip", e.Action == "xfail":
+			if r.status == "" || r.status == "pass" {
+				r.status = e.Action
+			}
+			r.artifacts = append(r.artifacts, e.Artifacts...)
+		}
+	}
+	err = cmd.Wait()
+	r.output = output.String()
+
+	switch status {
+	case "no-tasks":
+		r.err = fmt.Errorf("no task %s into %s", name, dir)
+	case "infra-fail":
+		r.err = fmt.Errorf("gake: %s", reason)
+	case "timeout", "interrupted":
+		r.err = fmt.Errorf("gake: %s", status)
+	case "":
+		if err == nil {
+			err = fmt.Errorf("gake: no status")
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%s: %s", err, msg)
+		}
+		r.err = err
+	}
+}
+
+// DepArtifacts returns the artifacts registered by the task of another package
+// which the task depends on, given like into the directive "gake:deps", such as
+// "./proto:TaskGenerate". They are absolute paths.
+func (t *T) DepArtifacts(dep string) []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return append([]string(nil), t.depArtifacts[dep]...)
+}
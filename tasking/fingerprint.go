@@ -0,0 +1,399 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ENV_CACHE is the environment variable, set by gake, with the directory where
+// the data kept between runs is stored.
+const ENV_CACHE = "GAKECACHE"
+
+// fingerprint is the hash of the inputs of a task, recorded under a key.
+type fingerprint struct {
+	key    string
+	path   string // File where it is recorded.
+	remote string // URL where it is recorded into the remote cache, if any.
+	sum    string
+}
+
+// fingerprintRecord is a fingerprint as recorded into the cache: the hash of the
+// inputs and the outputs of the task which produced them, the files registered
+// by Artifact, relative to the working directory unless they are out of it.
+type fingerprintRecord struct {
+	sum     string
+	outputs []string
+}
+
+// parseRecord returns the record stored into the cache; the hash is on the
+// first line, and every output on a line after it.
+func parseRecord(data []byte) fingerprintRecord {
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	return fingerprintRecord{sum: lines[0], outputs: lines[1:]}
+}
+
+func (r fingerprintRecord) bytes() []byte {
+	return []byte(strings.Join(append([]string{r.sum}, r.outputs...), "\n") + "\n")
+}
+
+// missingOutputs returns the outputs which are not into the local file system.
+func (r fingerprintRecord) missingOutputs() []string {
+	missing := make([]string, 0)
+	for _, out := range r.outputs {
+		if _, err := os.Stat(out); err != nil {
+			missing = append(missing, out)
+		}
+	}
+	return missing
+}
+
+// SkipIfUnchanged skips the task when the files and directories given as
+// inputs have not changed since the last successful run of a task which called
+// it with the same key, and the outputs of that run, the files registered by
+// Artifact, are into the file system. Otherwise, the task is run and the
+// fingerprint of the inputs is recorded at its end, with its outputs, if it has
+// not failed nor been skipped. When the task is skipped, its outputs are
+// registered as its artifacts.
+//
+// The fingerprints are stored into the directory given by the environment
+// variable GAKECACHE, set by gake, or else into the user's cache directory;
+// they are scoped to the working directory. When a remote cache is set into the
+// table "cache" of "gake.toml", they are also shared through it, with an archive
+// of the outputs under the working directory; so the outputs missing on another
+// machine are restored from it before skipping the task. The task is run when
+// they can not be restored.
+func (t *T) SkipIfUnchanged(key string, inputs ...string) {
+	// The messages are logged from here to be reported at the call site.
+	fp, err := newFingerprint(key, inputs)
+	if err != nil {
+		t.log(fmt.Sprintf("tasking: can't fingerprint the inputs: %s", err), nil)
+		t.FailNow()
+	}
+	if data, err := os.ReadFile(fp.path); err == nil {
+		if r := parseRecord(data); r.sum == fp.sum && len(r.missingOutputs()) == 0 {
+			t.addOutputs(r.outputs)
+			t.log(fmt.Sprintf("inputs unchanged for %q", key), nil)
+			t.skipNow(SKIP_UNCHANGED)
+		}
+	}
+	if fp.remote != "" {
+		data, err := remoteGet(fp.remote)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "tasking: remote cache: %s\n", err)
+		} else if data != nil {
+			r := parseRecord(data)
+			if r.sum == fp.sum {
+				if err = fp.restoreOutputs(r); err == nil {
+					fp.saveLocal(r)
+					t.addOutputs(r.outputs)
+					t.log(fmt.Sprintf("inputs unchanged for %q, by the remote cache", key), nil)
+					t.skipNow(SKIP_UNCHANGED)
+				}
+				fmt.Fprintf(os.Stderr, "tasking: remote cache: %q: outputs not restored: %s\n", key, err)
+			}
+		}
+	}
+
+	t.mu.Lock()
+	t.fingerprints = append(t.fingerprints, fp)
+	t.mu.Unlock()
+}
+
+// addOutputs registers the outputs of a run of the task, recorded with the
+// fingerprint, as its artifacts.
+func (t *T) addOutputs(outputs []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, out := range outputs {
+		if abs, err := filepath.Abs(out); err == nil {
+			t.artifacts = append(t.artifacts, abs)
+		}
+	}
+}
+
+// saveFingerprints records the fingerprints of a task run successfully, with
+// its outputs. The archive of the outputs is stored into the remote cache before
+// the fingerprint, so that the fingerprint does not refer to a missing one.
+func (t *T) saveFingerprints() {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.failed || t.skipped {
+		return
+	}
+
+	r := fingerprintRecord{outputs: relOutputs(t.artifacts)}
+	for _, fp := range t.fingerprints {
+		r.sum = fp.sum
+		fp.saveLocal(r)
+		if fp.remote == "" {
+			continue
+		}
+		err := fp.storeOutputs(r)
+		if err == nil {
+			err = remotePut(fp.remote, r.bytes())
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "tasking: remote cache: %s\n", err)
+		}
+	}
+}
+
+// saveLocal records the fingerprint into the local cache.
+func (fp fingerprint) saveLocal(r fingerprintRecord) {
+	err := os.MkdirAll(filepath.Dir(fp.path), 0750)
+	if err == nil {
+		err = os.WriteFile(fp.path, r.bytes(), 0640)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tasking: can't record fingerprint: %s\n", err)
+	}
+}
+
+// relOutputs returns the artifacts relative to the working directory, unless
+// they are out of it.
+func relOutputs(artifacts []string) []string {
+	wd, err := os.Getwd()
+	outputs := make([]string, 0, len(artifacts))
+	for _, a := range artifacts {
+		if err == nil {
+			if rel, err := filepath.Rel(wd, a); err == nil && !strings.HasPrefix(rel, "..") {
+				a = filepath.ToSlash(rel)
+			}
+		}
+		outputs = append(outputs, a)
+	}
+	return outputs
+}
+
+// outputsURL returns the URL, into the remote cache, of the archive of the
+// outputs of the run which recorded the fingerprint.
+func (fp fingerprint) outputsURL() string {
+	return remoteName("outputs", fp.key+"\x00"+fp.sum)
+}
+
+// storeOutputs stores into the remote cache an archive of the outputs which are
+// under the working directory.
+func (fp fingerprint) storeOutputs(r fingerprintRecord) error {
+	files := make([]string, 0, len(r.outputs))
+	for _, out := range r.outputs {
+		if !filepath.IsAbs(out) {
+			files = append(files, out)
+		}
+	}
+	if len(files) == 0 {
+		return nil
+	}
+	data, err := archiveFiles(files)
+	if err != nil {
+		return err
+	}
+	return remotePut(fp.outputsURL(), data)
+}
+
+// restoreOutputs restores the outputs of the record which are missing, from the
+// archive into the remote cache. It fails if some of them are not into it.
+func (fp fingerprint) restoreOutputs(r fingerprintRecord) error {
+	missing := r.missingOutputs()
+	if len(missing) == 0 {
+		return nil
+	}
+	for _, out := range missing {
+		if filepath.IsAbs(out) {
+			return fmt.Errorf("%s is out of the working directory", out)
+		}
+	}
+	data, err := remoteGet(fp.outputsURL())
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		return fmt.Errorf("no archive of the outputs")
+	}
+	if err = extractFiles(data); err != nil {
+		return err
+	}
+	if missing = r.missingOutputs(); len(missing) != 0 {
+		return fmt.Errorf("%s not into the archive", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// archiveFiles returns a tar archive, compressed by gzip, of the files and the
+// files into the directories, with their relative paths.
+func archiveFiles(files []string) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+
+	for _, name := range files {
+		err := filepath.Walk(name, func(path string, info os.FileInfo, err error) error {
+			if err != nil || !info.Mode().IsRegular() {
+				return err
+			}
+			hdr, err := tar.FileInfoHeader(info, "")
+			if err != nil {
+				return err
+			}
+			hdr.Name = filepath.ToSlash(path)
+			if err = tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(tw, f)
+			f.Close()
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// extractFiles extracts the archive made by archiveFiles into the working
+// directory. The files out of it are an error.
+func extractFiles(data []byte) error {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := filepath.FromSlash(hdr.Name)
+		if filepath.IsAbs(name) || name != filepath.Clean(name) || strings.HasPrefix(name, "..") {
+			return fmt.Errorf("invalid file into the archive: %s", hdr.Name)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err = os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.FileMode(hdr.Mode)&os.ModePerm)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, tr)
+		if err2 := f.Close(); err == nil {
+			err = err2
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// newFingerprint returns the fingerprint of the inputs, to be recorded under
+// the key.
+func newFingerprint(key string, inputs []string) (fp fingerprint, err error) {
+	if fp.sum, err = hashInputs(inputs); err != nil {
+		return fp, err
+	}
+
+	dir := os.Getenv(ENV_CACHE)
+	if dir == "" {
+		cache, err := os.UserCacheDir()
+		if err != nil {
+			return fp, err
+		}
+		dir = filepath.Join(cache, "gake")
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return fp, err
+	}
+
+	fp.key = key
+	h := sha256.Sum256([]byte(wd + "\x00" + key))
+	fp.path = filepath.Join(dir, "fingerprints", hex.EncodeToString(h[:]))
+	fp.remote = remoteName("fingerprints", key)
+	return fp, nil
+}
+
+// hashInputs returns the hash of the names, types and contents of the files,
+// and of the files into the directories, and of whether they are executable.
+// The rest of the permissions are left out, since they differ by the umask and
+// the system of the checkout, so that the fingerprints are shared among
+// machines by the remote cache.
+func hashInputs(inputs []string) (string, error) {
+	h := sha256.New()
+
+	for _, in := range inputs {
+		files := make([]string, 0, 1)
+		err := filepath.Walk(in, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+		sort.Strings(files)
+
+		for _, name := range files {
+			info, err := os.Lstat(name)
+			if err != nil {
+				return "", err
+			}
+			mode := info.Mode() & os.ModeType
+			if info.Mode()&0111 != 0 {
+				mode |= 0111
+			}
+			fmt.Fprintf(h, "%s\x00%s\x00", filepath.ToSlash(name), mode)
+
+			if info.Mode()&os.ModeSymlink != 0 {
+				target, err := os.Readlink(name)
+				if err != nil {
+					return "", err
+				}
+				io.WriteString(h, target)
+				continue
+			}
+			f, err := os.Open(name)
+			if err != nil {
+				return "", err
+			}
+			_, err = io.Copy(h, f)
+			f.Close()
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,52 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestHashInputsMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the permissions can not be set on Windows")
+	}
+	dir := t.TempDir()
+	file := filepath.Join(dir, "input")
+	if err := os.WriteFile(file, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	hash := func(mode os.FileMode) string {
+		t.Helper()
+		if err := os.Chmod(file, mode); err != nil {
+			t.Fatal(err)
+		}
+		h, err := hashInputs([]string{dir})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	base := hash(0644)
+
+	// Only the executable bit of the permissions changes the hash.
+	if h := hash(0600); h != base {
+		t.Error("the hash changes with the permissions of read and write")
+	}
+	if h := hash(0664); h != base {
+		t.Error("the hash changes with the permission of write of the group")
+	}
+	if h := hash(0755); h == base {
+		t.Error("the hash does not change when the file is made executable")
+	}
+	if h := hash(0700); h != hash(0755) {
+		t.Error("the hash changes with the permissions of an executable")
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Group runs concurrent branches of a task, like pushing several images at
+// once. Every branch gets its own T, named after the task with the number of
+// the branch, like "TaskPush/2", so that it can log, fail and run commands
+// without interfering with the others.
+type Group struct {
+	t     *T
+	limit int
+	sem   chan bool
+	wg    sync.WaitGroup
+
+	mu       sync.Mutex
+	branches []*T
+	n        int // Number of the last branch, run or skipped by -task.run.
+}
+
+// Group returns a group to run branches of the task concurrently, at most as
+// many at once as the flag -task.parallel.
+func (t *T) Group() *Group {
+	return &Group{t: t, limit: *parallel}
+}
+
+// SetLimit sets the maximum number of branches running at once; n <= 0 means
+// no limit. It must be called before Go.
+func (g *Group) SetLimit(n int) *Group {
+	g.limit = n
+	return g
+}
+
+// Go runs the function in a new branch, waiting first for a running branch to
+// finish if the limit is reached. The branch finishes when the function
+// returns, or calls FailNow or SkipNow; a panic fails the branch. The branch is
+// not run if its name does not match the flag -task.run, like "TaskPush/2".
+func (g *Group) Go(f func(t *T)) {
+	g.mu.Lock()
+	g.n++
+	n := strconv.Itoa(g.n)
+	if !matchBranch(g.t.cpuTask() + "/" + n) {
+		g.mu.Unlock()
+		return
+	}
+	if g.sem == nil && g.limit > 0 {
+		g.sem = make(chan bool, g.limit)
+	}
+	b := &T{
+		name:   g.t.name + "/" + n,
+		parent: g.t,
+		params: g.t.params,
+		limits: g.t.limits,
+		matrix: g.t.matrix,
+		sched:  g.t.sched,
+
+		isolation: g.t.isolation,
+		user:      g.t.runUser(),
+	}
+	b.self = b
+	b.output.task = b.name
+	b.w = &b.output
+	g.branches = append(g.branches, b)
+	g.mu.Unlock()
+
+	if g.sem != nil {
+		g.sem <- true
+	}
+	g.wg.Add(1)
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				b.write(panicText(err), nil)
+				b.Fail()
+			} else if !b.finished {
+				b.write("\tbranch executed panic(nil) or runtime.Goexit\n", nil)
+				b.Fail()
+			}
+			b.stopServices()
+			b.stopProcesses()
+			b.duration = time.Since(b.start)
+			if g.sem != nil {
+				<-g.sem
+			}
+			g.wg.Done()
+		}()
+
+		b.start = time.Now()
+		f(b)
+		b.finished = true
+	}()
+}
+
+// Wait waits for all the branches to finish, and adds their output to the
+// output of the task. If any branch has failed, the task fails with the list of
+// failed branches.
+//
+// Wait must be called from the goroutine running the task.
+func (g *Group) Wait() {
+	g.wg.Wait()
+
+	g.mu.Lock()
+	branches := g.branches
+	g.branches = nil
+	g.mu.Unlock()
+
+	var failed []string
+	for _, b := range branches {
+		b.output.Close()
+		status := "PASS"
+		switch {
+		case b.Failed():
+			status = "FAIL"
+			failed = append(failed, b.name)
+		case b.Skipped():
+			status = "SKIP"
+		}
+
+		// The output of the branch is indented under its header.
+		out := strings.TrimSuffix(string(b.output.Bytes()), "\n")
+		if out != "" {
+			out = "\t" + strings.Replace(out, "\n", "\n\t", -1) + "\n"
+		}
+		g.t.write(fmt.Sprintf("\t--- %s: %s (%.2f seconds)\n%s", status, b.name, b.duration.Seconds(), out), nil)
+
+		b.mu.RLock()
+		g.t.mu.Lock()
+		g.t.warnings += b.warnings
+		g.t.artifacts = append(g.t.artifacts, b.artifacts...)
+		g.t.findings = append(g.t.findings, b.findings...)
+		for k, v := range b.meta {
+			if g.t.meta == nil {
+				g.t.meta = make(map[string]string)
+			}
+			g.t.meta[k] = v
+		}
+		g.t.mu.Unlock()
+		b.mu.RUnlock()
+	}
+
+	if len(failed) != 0 {
+		g.t.log(fmt.Sprintf("tasking: %d of %d branches failed: %s",
+			len(failed), len(branches), strings.Join(failed, ", ")), nil)
+		g.t.FailNow()
+	}
+}
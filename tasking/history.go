@@ -0,0 +1,240 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// HISTORY_RUNS is the number of runs kept into the history of the tasks.
+const HISTORY_RUNS = 10
+
+var advise = flag.Bool("task.advise", false, "after the run, suggest the sequential tasks which could call Parallel, with the time saved, from the history of the last runs")
+
+// historyRun is a run into the history of the tasks of a directory, which is
+// kept into the cache like the checkpoint, in JSON.
+type historyRun struct {
+	Time     time.Time
+	Parallel int // Capacity given by -task.parallel.
+	Tasks    []historyTask
+}
+
+// historyTask is a task into a run of the history.
+type historyTask struct {
+	Name     string
+	Status   string
+	Duration time.Duration
+	Parallel bool     `json:",omitempty"` // It called Parallel.
+	Weight   int      `json:",omitempty"`
+	Mutexes  []string `json:",omitempty"`
+	Deps     []string `json:",omitempty"` // Tasks which it depends on.
+}
+
+// recordHistory adds the run to the history, dropping the runs beyond
+// HISTORY_RUNS, and returns the history. A dry run is not recorded.
+func recordHistory() []historyRun {
+	if *dryRun {
+		return nil
+	}
+	path, err := cachePath("history")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tasking: can't keep the history: %s\n", err)
+		return nil
+	}
+	var history []historyRun
+	if b, err := os.ReadFile(path); err == nil {
+		if err = json.Unmarshal(b, &history); err != nil {
+			fmt.Fprintf(os.Stderr, "tasking: invalid history %s: %s\n", path, err)
+			history = nil
+		}
+	}
+
+	run := historyRun{Time: time.Now(), Parallel: *parallel}
+	resultsMu.Lock()
+	for _, t := range results {
+		task := historyTask{
+			Name:     t.name,
+			Status:   t.status(),
+			Duration: t.duration,
+			Parallel: t.isParallel,
+			Weight:   t.weight,
+			Mutexes:  t.mutexes,
+		}
+		for _, d := range t.deps {
+			task.Deps = append(task.Deps, d.name)
+		}
+		run.Tasks = append(run.Tasks, task)
+	}
+	resultsMu.Unlock()
+	if len(run.Tasks) == 0 {
+		return history
+	}
+
+	history = append(history, run)
+	if len(history) > HISTORY_RUNS {
+		history = history[len(history)-HISTORY_RUNS:]
+	}
+	b, _ := json.Marshal(history)
+	err = os.MkdirAll(filepath.Dir(path), 0750)
+	if err == nil {
+		tmp := path + ".tmp"
+		if err = os.WriteFile(tmp, b, 0640); err == nil {
+			err = os.Rename(tmp, path)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tasking: can't write the history: %s\n", err)
+	}
+	return history
+}
+
+// reportAdvice prints, with the flag -task.advise, the sequential tasks of the
+// last run of the history which could call Parallel: the ones which have passed,
+// do not use a resource of "gake:mutex" and have not dependencies, nor tasks
+// which depend on them. Every one is given with the wall time which would be
+// saved, projected from the median of its durations into the history:
+//
+//	ADVICE: 2 sequential tasks could call t.Parallel(), from 5 runs:
+//		TaskLint: 1.2s, saves ~1.1s
+//		TaskDocs: 800ms, saves ~650ms
+//		all of them save ~1.75s of ~4.3s
+//
+// The sequential tasks are run one after another, and the parallel ones at the
+// end, sharing the capacity of -task.parallel by their weights.
+func reportAdvice(history []historyRun) {
+	if !*advise || *jsonOutput || len(history) == 0 {
+		return
+	}
+	last := history[len(history)-1]
+
+	// The duration of a task is the median of the ones of the runs where it
+	// has passed.
+	durations := make(map[string][]time.Duration)
+	for _, run := range history {
+		for _, t := range run.Tasks {
+			if t.Status == "pass" {
+				durations[t.Name] = append(durations[t.Name], t.Duration)
+			}
+		}
+	}
+	median := func(t historyTask) time.Duration {
+		d := durations[t.Name]
+		if len(d) == 0 {
+			return t.Duration
+		}
+		sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+		return d[len(d)/2]
+	}
+
+	dependents := make(map[string]bool)
+	for _, t := range last.Tasks {
+		for _, d := range t.Deps {
+			dependents[d] = true
+		}
+	}
+
+	var seq, par, candidates []historyTask
+	for _, t := range last.Tasks {
+		if t.Status == "notrun" {
+			continue
+		}
+		t.Duration = median(t)
+		if t.Parallel {
+			par = append(par, t)
+			continue
+		}
+		seq = append(seq, t)
+		if t.Status == "pass" && len(t.Mutexes) == 0 && len(t.Deps) == 0 && !dependents[t.Name] {
+			candidates = append(candidates, t)
+		}
+	}
+	if len(candidates) == 0 {
+		fmt.Println("ADVICE: no sequential task could safely call t.Parallel()")
+		return
+	}
+
+	capacity := last.Parallel
+	if capacity < 1 {
+		capacity = 1
+	}
+	wall := projectWall(seq, par, capacity)
+
+	type advice struct {
+		task  historyTask
+		saved time.Duration
+	}
+	advices := make([]advice, 0, len(candidates))
+	for _, c := range candidates {
+		saved := wall - projectWall(without(seq, c.Name), append(par[:len(par):len(par)], c), capacity)
+		if saved > 0 {
+			advices = append(advices, advice{c, saved})
+		}
+	}
+	if len(advices) == 0 {
+		fmt.Println("ADVICE: no sequential task would save time calling t.Parallel()")
+		return
+	}
+	sort.SliceStable(advices, func(i, j int) bool { return advices[i].saved > advices[j].saved })
+
+	rest := seq
+	moved := par[:len(par):len(par)]
+	for _, a := range advices {
+		rest = without(rest, a.task.Name)
+		moved = append(moved, a.task)
+	}
+	tasks := "tasks"
+	if len(advices) == 1 {
+		tasks = "task"
+	}
+	fmt.Printf("ADVICE: %d sequential %s could call t.Parallel(), from %d runs:\n", len(advices), tasks, len(history))
+	for _, a := range advices {
+		fmt.Printf("\t%s: %v, saves ~%v\n", a.task.Name, a.task.Duration.Round(time.Millisecond), a.saved.Round(time.Millisecond))
+	}
+	fmt.Printf("\tall of them save ~%v of ~%v\n", (wall - projectWall(rest, moved, capacity)).Round(time.Millisecond),
+		wall.Round(time.Millisecond))
+}
+
+// projectWall returns the projected wall time of a run where the sequential
+// tasks are run one after another, and then the parallel ones, which take the
+// longest of their durations, or their work shared by the capacity.
+func projectWall(seq, par []historyTask, capacity int) time.Duration {
+	var wall, longest, work time.Duration
+	for _, t := range seq {
+		wall += t.Duration
+	}
+	for _, t := range par {
+		if t.Duration > longest {
+			longest = t.Duration
+		}
+		w := t.Weight
+		if w < 1 {
+			w = 1
+		}
+		work += time.Duration(w) * t.Duration
+	}
+	if shared := work / time.Duration(capacity); shared > longest {
+		longest = shared
+	}
+	return wall + longest
+}
+
+// without returns the tasks but the named one, into a new slice.
+func without(tasks []historyTask, name string) []historyTask {
+	rest := make([]historyTask, 0, len(tasks))
+	for _, t := range tasks {
+		if t.Name != name {
+			rest = append(rest, t)
+		}
+	}
+	return rest
+}
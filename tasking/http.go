@@ -0,0 +1,252 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HTTP_TIMEOUT is the time limit of a request made by an HTTPClient, and
+// HTTP_ATTEMPTS the number of attempts of a request which fails temporarily.
+const (
+	HTTP_TIMEOUT  = 30 * time.Second
+	HTTP_ATTEMPTS = 3
+)
+
+// Names of the query parameters which are redacted from the URLs logged, like
+// "access_token" or "api_key".
+var secretParams = []string{"token", "key", "secret", "password", "passwd", "signature", "sig", "auth"}
+
+// HTTPClient makes HTTP requests from a task, logging every request with its
+// status and duration into the output of the task. The secrets of the URLs,
+// like the password or the query parameters named like "token" or "key", are
+// redacted from the log; the headers are never logged.
+//
+// A request which fails temporarily is retried up to HTTP_ATTEMPTS times, with
+// an exponential backoff or the delay asked by the server: on a network error
+// or a status 500, 502, 503 or 504, when its method is idempotent; and on a
+// status 429 for any method.
+type HTTPClient struct {
+	t      *T
+	Client *http.Client
+	Header http.Header // Headers added to every request, like Authorization.
+}
+
+// HTTP returns a client to make HTTP requests, with a timeout of HTTP_TIMEOUT.
+func (t *T) HTTP() *HTTPClient {
+	return &HTTPClient{
+		t:      t,
+		Client: &http.Client{Timeout: HTTP_TIMEOUT},
+		Header: make(http.Header),
+	}
+}
+
+// HTTPError is the error of a request whose response has not a status 2xx.
+type HTTPError struct {
+	Method     string
+	URL        string // Redacted.
+	StatusCode int
+	Body       string // Start of the body of the response.
+}
+
+func (e *HTTPError) Error() string {
+	s := fmt.Sprintf("%s %s: %d %s", e.Method, e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Body != "" {
+		s += ": " + e.Body
+	}
+	return s
+}
+
+// Do sends the request, retrying it if it fails temporarily, and returns the
+// response. As with http.Client, the body of the response has to be closed.
+func (c *HTTPClient) Do(req *http.Request) (*http.Response, error) {
+	resp, trace, err := c.do(req)
+	c.t.log(trace, nil)
+	return resp, err
+}
+
+// Get sends a GET request to the URL.
+func (c *HTTPClient) Get(url string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, trace, err := c.do(req)
+	c.t.log(trace, nil)
+	return resp, err
+}
+
+// GetJSON sends a GET request to the URL, and decodes the JSON body of the
+// response into out. A response without a status 2xx returns an *HTTPError.
+func (c *HTTPClient) GetJSON(url string, out interface{}) error {
+	trace, err := c.doJSON("GET", url, nil, out)
+	c.t.log(trace, nil)
+	return err
+}
+
+// PostJSON sends a POST request to the URL with the value in encoded as JSON,
+// and decodes the JSON body of the response into out, if it is not nil. A
+// response without a status 2xx returns an *HTTPError.
+func (c *HTTPClient) PostJSON(url string, in, out interface{}) error {
+	trace, err := c.doJSON("POST", url, in, out)
+	c.t.log(trace, nil)
+	return err
+}
+
+// DoJSON is like PostJSON with the given method; in can be nil for a request
+// without body.
+func (c *HTTPClient) DoJSON(method, url string, in, out interface{}) error {
+	trace, err := c.doJSON(method, url, in, out)
+	c.t.log(trace, nil)
+	return err
+}
+
+// doJSON makes the request of a JSON method, returning the trace to log.
+func (c *HTTPClient) doJSON(method, url string, in, out interface{}) (string, error) {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return method + " " + redactURL(url), err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return method + " " + redactURL(url), err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, trace, err := c.do(req)
+	if err != nil {
+		return trace, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return trace, &HTTPError{method, redactURL(url), resp.StatusCode, strings.TrimSpace(string(data))}
+	}
+	if out != nil {
+		if err = json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return trace, fmt.Errorf("%s %s: invalid JSON response: %s", method, redactURL(url), err)
+		}
+	}
+	return trace, nil
+}
+
+// do sends the request with the retries, and returns the trace of the attempts
+// to log.
+func (c *HTTPClient) do(req *http.Request) (*http.Response, string, error) {
+	for k, v := range c.Header {
+		if _, ok := req.Header[k]; !ok {
+			req.Header[k] = v
+		}
+	}
+	replayable := req.Body == nil || req.GetBody != nil
+	name := req.Method + " " + redactURL(req.URL.String())
+	trace := make([]string, 0, 1)
+
+	for i := 1; ; i++ {
+		if i > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, strings.Join(trace, "\n"), err
+			}
+			req.Body = body
+		}
+
+		start := time.Now()
+		resp, err := c.Client.Do(req)
+		elapsed := time.Since(start).Round(time.Millisecond)
+
+		if err != nil {
+			// The error of net/http has the URL, which could have secrets.
+			if uerr, ok := err.(*url.Error); ok {
+				err = uerr.Err
+			}
+			trace = append(trace, fmt.Sprintf("%s: %s (%v)", name, err, elapsed))
+			err = fmt.Errorf("%s: %s", name, err)
+		} else {
+			trace = append(trace, fmt.Sprintf("%s: %s (%v)", name, resp.Status, elapsed))
+		}
+
+		if i == HTTP_ATTEMPTS || !replayable || !retryable(req.Method, resp, err) {
+			return resp, strings.Join(trace, "\n"), err
+		}
+
+		delay := DefaultBackoff.Delay(i)
+		if resp != nil {
+			if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && s >= 0 && s <= 30 {
+				delay = time.Duration(s) * time.Second
+			}
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+		}
+		time.Sleep(delay)
+	}
+}
+
+// retryable reports whether the request can be retried after the response or
+// the error.
+func retryable(method string, resp *http.Response, err error) bool {
+	idempotent := false
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "PUT", "DELETE":
+		idempotent = true
+	}
+
+	if err != nil {
+		return idempotent
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return idempotent
+	}
+	return false
+}
+
+// redactURL returns the URL without the password nor the values of the query
+// parameters which could be secrets.
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return s
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "REDACTED")
+	}
+
+	if u.RawQuery != "" {
+		q := u.Query()
+		for name := range q {
+			lower := strings.ToLower(name)
+			for _, p := range secretParams {
+				if strings.Contains(lower, p) {
+					q.Set(name, "REDACTED")
+					break
+				}
+			}
+		}
+		u.RawQuery = q.Encode()
+	}
+	return u.String()
+}
@@ -0,0 +1,200 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// isolation is the private copy of the working directory of a task.
+type isolation struct {
+	src, dir string
+	copied   map[string]fileStamp // Files copied, by path relative to dir.
+}
+
+// fileStamp identifies the version of a file, to find the changed ones.
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+// The copies back into the working directory are serialized, so that the
+// files of a task are not mixed with the ones of another.
+var isolateMu sync.Mutex
+
+// Isolate gives the task a private copy of the working directory, so that the
+// tasks run in parallel which write into the tree, like generators of code
+// into the same directories, do not corrupt each other. It returns the
+// directory of the copy, where the commands run by Exec, ExecCmd, Shell and
+// StartService are run if their directory is not set; the task has to write
+// its own files with paths relative to it. The directory ".git" is not copied.
+//
+// When the task finishes, the files which it has created or changed into the
+// copy are copied back into the working directory, unless it has failed or
+// been skipped; the files removed are not. The directive "gake:isolate" calls
+// Isolate before the task is started.
+func (t *T) Isolate() string {
+	if t.parent != nil {
+		t.log("tasking: Isolate called from a branch of a Group; call it from the task", nil)
+		t.FailNow()
+	}
+	if t.isolation != nil {
+		return t.isolation.dir
+	}
+	if err := t.isolate(); err != nil {
+		t.log("tasking: can't isolate the task: "+err.Error(), nil)
+		t.FailNow()
+	}
+	return t.isolation.dir
+}
+
+func (t *T) isolate() error {
+	src, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	dir, err := os.MkdirTemp("", "gake-isolate-")
+	if err != nil {
+		return err
+	}
+	iso := &isolation{src: src, dir: dir, copied: make(map[string]fileStamp)}
+	if err = iso.copyTree(); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+
+	t.mu.Lock()
+	t.isolation = iso
+	t.mu.Unlock()
+	return nil
+}
+
+// workDir returns the directory where the commands of the task are run by
+// default: the one of its isolation, or the working directory.
+func (t *T) workDir() string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.isolation == nil {
+		return ""
+	}
+	return t.isolation.dir
+}
+
+// endIsolation copies back the files created or changed by the task, if it has
+// passed, and removes its copy of the working directory.
+func (t *T) endIsolation() {
+	iso := t.isolation
+	if iso == nil {
+		return
+	}
+	defer os.RemoveAll(iso.dir)
+	if t.Failed() || t.Skipped() {
+		return
+	}
+
+	isolateMu.Lock()
+	n, err := iso.copyBack()
+	isolateMu.Unlock()
+	if err != nil {
+		t.write("\ttasking: can't copy back the isolated files: "+err.Error()+"\n", nil)
+		t.Fail()
+		return
+	}
+	if n != 0 {
+		t.write(fmt.Sprintf("\tisolation: %d files copied back\n", n), nil)
+	}
+}
+
+// copyTree copies the working directory, but ".git", into the directory of the
+// isolation, which could be into it.
+func (iso *isolation) copyTree() error {
+	return filepath.Walk(iso.src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(iso.src, path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && (info.Name() == ".git" || path == iso.dir) {
+			return filepath.SkipDir
+		}
+		dst := filepath.Join(iso.dir, rel)
+
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(dst, info.Mode().Perm()|0700)
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(target, dst)
+		case info.Mode().IsRegular():
+			if err := copyFile(path, dst, info); err != nil {
+				return err
+			}
+			iso.copied[rel] = fileStamp{info.Size(), info.ModTime()}
+		}
+		return nil
+	})
+}
+
+// copyBack copies the files created or changed into the isolation to the
+// working directory, and returns their number.
+func (iso *isolation) copyBack() (n int, err error) {
+	err = filepath.Walk(iso.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(iso.dir, path)
+		if err != nil {
+			return err
+		}
+		if s, ok := iso.copied[rel]; ok && s.size == info.Size() && s.modTime.Equal(info.ModTime()) {
+			return nil
+		}
+		dst := filepath.Join(iso.src, rel)
+		if err = os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		if err = copyFile(path, dst, info); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	return n, err
+}
+
+// copyFile copies the regular file src to dst, with the mode and time of
+// modification given by info.
+func copyFile(src, dst string, info os.FileInfo) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(dst, info.ModTime(), info.ModTime())
+}
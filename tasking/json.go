@@ -0,0 +1,88 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+var jsonOutput = flag.Bool("task.json", false, "print the run as a stream of JSON events")
+
+// Event is a JSON event printed to standard output when the -task.json flag is
+// set, one per line, in the manner of "go test -json".
+//
+// The Action field is one of:
+//
+//	start    - the run has started; Run describes it
+//	list     - the task matches the -task.list flag; Output is its documentation
+//	run      - the task has started running
+//	output   - the task has logged some text
+//	approval - the task waits for approval; Output is the question. See
+//	           T.WaitForApproval
+//	approved - the approval has been given
+//	rejected - the approval has been rejected
+//	pass     - the task passed
+//	fail     - the task failed, or passed being expected to fail
+//	skip     - the task was skipped; SkipReason is the code of the reason,
+//	           if any. See T.SkipReason
+//	xfail    - the task expected to fail has failed; see T.ExpectFail
+//	notrun   - the task was not run since the run was aborted
+//	budget   - the time spent by the tasks of Group, in Elapsed, against its
+//	           Budget; after the tasks
+//	audit    - the commands run by the tasks, in Audit; after the tasks
+//
+// The events without the Task field refer to the whole run. With a run of
+// several packages by gake, the Package field is the one of the event.
+type Event struct {
+	Time       time.Time         // Time when the event was generated.
+	Action     string            // Kind of event.
+	Package    string            `json:",omitempty"` // Package run by gake with others.
+	Task       string            `json:",omitempty"`
+	File       string            `json:",omitempty"` // Source file of the task.
+	Line       int               `json:",omitempty"` // Line of the task into File.
+	Elapsed    float64           `json:",omitempty"` // Seconds spent by the task.
+	Output     string            `json:",omitempty"` // Text logged by the task.
+	Fields     map[string]string `json:",omitempty"` // Structured data logged by LogKV.
+	Meta       map[string]string `json:",omitempty"` // Metadata set by SetMeta, in the task result.
+	Usage      *Usage            `json:",omitempty"` // Resources used by the task, in the task result.
+	Warnings   int               `json:",omitempty"` // Warnings recorded by Warn, in the task result.
+	SkipReason string            `json:",omitempty"` // Code of the reason of a skip, in the task result.
+	Artifacts  []string          `json:",omitempty"` // Files registered by Artifact, in the task result.
+	Findings   []Finding         `json:",omitempty"` // Problems reported by ReportFinding, in the task result.
+	CPU        int               `json:",omitempty"` // GOMAXPROCS of the task, with several values of -task.cpu.
+	Run        *RunInfo          `json:",omitempty"` // Information of the run, in the start event.
+	Group      string            `json:",omitempty"` // Group of tasks, in the budget event.
+	Budget     float64           `json:",omitempty"` // Seconds of the budget of Group.
+	Audit      []CommandRecord   `json:",omitempty"` // Commands run by the tasks, in the audit event.
+}
+
+// ENV_PACKAGE is the environment variable, set by gake when it runs several
+// packages, with the package of the task binary.
+const ENV_PACKAGE = "GAKE_PACKAGE"
+
+var (
+	emitMu       sync.Mutex
+	encoder      = json.NewEncoder(os.Stdout)
+	eventPackage = os.Getenv(ENV_PACKAGE)
+)
+
+// emit prints the event to standard output.
+func emit(e Event) {
+	e.Time = time.Now()
+	e.Package = eventPackage
+
+	emitMu.Lock()
+	defer emitMu.Unlock()
+	if err := encoder.Encode(e); err != nil {
+		fmt.Fprintf(os.Stderr, "tasking: can't write event: %s\n", err)
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+var junitFile = flag.String("task.junit", "", "write a JUnit XML report of the run to the named file")
+
+// Tasks finished, in the order of their report.
+var (
+	resultsMu sync.Mutex
+	results   []*T
+)
+
+// recordResult adds the finished task to the results of the run.
+func recordResult(t *T) {
+	resultsMu.Lock()
+	defer resultsMu.Unlock()
+	results = append(results, t)
+}
+
+type junitSuite struct {
+	XMLName    xml.Name        `xml:"testsuite"`
+	Name       string          `xml:"name,attr"`
+	Tests      int             `xml:"tests,attr"`
+	Failures   int             `xml:"failures,attr"`
+	Skipped    int             `xml:"skipped,attr"`
+	Time       string          `xml:"time,attr"`
+	Properties []junitProperty `xml:"properties>property,omitempty"`
+	Cases      []junitCase     `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name       string          `xml:"name,attr"`
+	Classname  string          `xml:"classname,attr"`
+	Time       string          `xml:"time,attr"`
+	Properties []junitProperty `xml:"properties>property,omitempty"`
+	Failure    *junitMessage   `xml:"failure,omitempty"`
+	Skipped    *junitMessage   `xml:"skipped,omitempty"`
+	SystemOut  string          `xml:"system-out,omitempty"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+}
+
+// writeJUnit writes the results of the run to the named file as a JUnit report.
+func writeJUnit(name string, info RunInfo) error {
+	resultsMu.Lock()
+	defer resultsMu.Unlock()
+
+	suite := junitSuite{Name: "gake", Tests: len(results)}
+	for _, p := range info.properties() {
+		suite.Properties = append(suite.Properties, junitProperty{p[0], p[1]})
+	}
+	total := 0.0
+
+	for _, t := range results {
+		t.mu.RLock()
+		tc := junitCase{
+			Name:      t.name,
+			Classname: "gake",
+			Time:      fmt.Sprintf("%.3f", t.duration.Seconds()),
+			SystemOut: string(t.output.Bytes()),
+		}
+		keys := make([]string, 0, len(t.meta))
+		for k := range t.meta {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			tc.Properties = append(tc.Properties, junitProperty{k, t.meta[k]})
+		}
+		if t.cpu != 0 {
+			tc.Properties = append(tc.Properties, junitProperty{"cpu", strconv.Itoa(t.cpu)})
+		}
+		if t.warnings != 0 {
+			tc.Properties = append(tc.Properties, junitProperty{"warnings", strconv.Itoa(t.warnings)})
+		}
+		if t.aborted {
+			tc.Skipped = &junitMessage{"Not run: the run was aborted"}
+			suite.Skipped++
+		} else if t.xpassed {
+			tc.Failure = &junitMessage{"Unexpected pass: " + t.xfail}
+			suite.Failures++
+		} else if t.failed {
+			tc.Failure = &junitMessage{"Failed"}
+			suite.Failures++
+		} else if t.xfailed {
+			tc.Skipped = &junitMessage{"Expected failure: " + t.xfail}
+			suite.Skipped++
+		} else if t.skipped {
+			tc.Skipped = &junitMessage{"Skipped"}
+			if t.skipReason != "" {
+				tc.Skipped.Message += ": " + t.skipReason
+				tc.Properties = append(tc.Properties, junitProperty{"skip-reason", t.skipReason})
+			}
+			suite.Skipped++
+		}
+		t.mu.RUnlock()
+
+		total += t.duration.Seconds()
+		suite.Cases = append(suite.Cases, tc)
+	}
+	suite.Time = fmt.Sprintf("%.3f", total)
+
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err = f.WriteString(xml.Header); err == nil {
+		enc := xml.NewEncoder(f)
+		enc.Indent("", "\t")
+		err = enc.Encode(suite)
+	}
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
@@ -0,0 +1,61 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import "fmt"
+
+// Limits are the resources which can be used by the processes launched by a
+// task through Exec, so that a runaway process can not starve the machine.
+// They are set by the directive "gake:limit" into the task documentation, or
+// by the methods of T.
+//
+// On Linux, the CPU and memory are limited through a cgroup v2, which has to be
+// delegated to the user; on Windows, through a job object. When they can not
+// be applied, the process is run anyway and a note is written to the output of
+// the task.
+type Limits struct {
+	CPU    float64 // Number of CPUs; 0 means no limit.
+	Memory int64   // Bytes of memory; 0 means no limit.
+	Nice   int     // Adjustment of the scheduling priority, from -20 to 19.
+}
+
+// LimitCPU limits the processes launched by Exec to use n CPUs, such as 0.5
+// or 2. It is equivalent to "gake:limit cpu=n".
+func (t *T) LimitCPU(n float64) {
+	if n < 0 {
+		t.log(fmt.Sprintf("tasking: invalid CPU limit %v", n), nil)
+		t.FailNow()
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.limits.CPU = n
+}
+
+// LimitMemory limits the processes launched by Exec to use n bytes of memory.
+// It is equivalent to "gake:limit mem=n".
+func (t *T) LimitMemory(n int64) {
+	if n < 0 {
+		t.log(fmt.Sprintf("tasking: invalid memory limit %d", n), nil)
+		t.FailNow()
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.limits.Memory = n
+}
+
+// SetNice sets the adjustment of the scheduling priority of the processes
+// launched by Exec, from -20 (highest) to 19 (lowest). It is equivalent to
+// "gake:limit nice=n".
+func (t *T) SetNice(n int) {
+	if n < -20 || n > 19 {
+		t.log(fmt.Sprintf("tasking: invalid nice %d", n), nil)
+		t.FailNow()
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.limits.Nice = n
+}
@@ -0,0 +1,95 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+)
+
+// CGROUP_ROOT is where the cgroup v2 hierarchy is mounted.
+const CGROUP_ROOT = "/sys/fs/cgroup"
+
+var numCgroups uint32 // To name the cgroups created.
+
+// applyLimits applies the limits to the process started; the CPU and memory
+// through a new cgroup, which is removed by the returned function once the
+// process has exited.
+func applyLimits(task string, g *procGroup, l Limits) (release func(), err error) {
+	if l.Nice != 0 {
+		if err = syscall.Setpriority(syscall.PRIO_PROCESS, g.p.Pid, l.Nice); err != nil {
+			return nil, fmt.Errorf("can't set nice: %s", err)
+		}
+	}
+	if l.CPU == 0 && l.Memory == 0 {
+		return nil, nil
+	}
+
+	parent, err := ownCgroup()
+	if err != nil {
+		return nil, err
+	}
+	// The controllers could be already enabled.
+	os.WriteFile(filepath.Join(parent, "cgroup.subtree_control"), []byte("+cpu +memory"), 0)
+
+	dir := filepath.Join(parent, fmt.Sprintf("gake-%d-%s-%d",
+		os.Getpid(), outputFileName(task), atomic.AddUint32(&numCgroups, 1)))
+	if err = os.Mkdir(dir, 0755); err != nil {
+		return nil, fmt.Errorf("can't create cgroup: %s", err)
+	}
+	release = func() { os.Remove(dir) }
+
+	if l.CPU != 0 {
+		const period = 100000 // Microseconds.
+		quota := fmt.Sprintf("%d %d", int64(l.CPU*period), period)
+		if err = os.WriteFile(filepath.Join(dir, "cpu.max"), []byte(quota), 0); err != nil {
+			return release, fmt.Errorf("can't limit CPU: %s", err)
+		}
+	}
+	if l.Memory != 0 {
+		mem := strconv.FormatInt(l.Memory, 10)
+		if err = os.WriteFile(filepath.Join(dir, "memory.max"), []byte(mem), 0); err != nil {
+			return release, fmt.Errorf("can't limit memory: %s", err)
+		}
+	}
+	if err = os.WriteFile(filepath.Join(dir, "cgroup.procs"), []byte(strconv.Itoa(g.p.Pid)), 0); err != nil {
+		return release, fmt.Errorf("can't move process to cgroup: %s", err)
+	}
+	return release, nil
+}
+
+// ownCgroup returns the directory of the cgroup v2 of the actual process.
+func ownCgroup() (string, error) {
+	f, err := os.Open("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if path := strings.TrimPrefix(s.Text(), "0::"); path != s.Text() {
+			dir := filepath.Join(CGROUP_ROOT, path)
+			// The hierarchy could be of cgroup v1, or a hybrid one.
+			if _, err = os.Stat(filepath.Join(dir, "cgroup.controllers")); err != nil {
+				break
+			}
+			return dir, nil
+		}
+	}
+	if err = s.Err(); err != nil {
+		return "", err
+	}
+	return "", errors.New("cgroup v2 not found")
+}
@@ -0,0 +1,29 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// +build !linux,!windows
+
+package tasking
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+)
+
+// applyLimits applies the scheduling priority to the process started; the
+// limits of CPU and memory are not supported.
+func applyLimits(task string, g *procGroup, l Limits) (release func(), err error) {
+	if l.Nice != 0 {
+		if err = syscall.Setpriority(syscall.PRIO_PROCESS, g.p.Pid, l.Nice); err != nil {
+			return nil, fmt.Errorf("can't set nice: %s", err)
+		}
+	}
+	if l.CPU != 0 || l.Memory != 0 {
+		return nil, errors.New("CPU and memory limits not supported on this system")
+	}
+	return nil, nil
+}
@@ -0,0 +1,120 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"unsafe"
+)
+
+var procSetInformationJobObject = kernel32.NewProc("SetInformationJobObject")
+
+// Classes of information of a job object.
+const (
+	infoExtendedLimit  = 9
+	infoCpuRateControl = 15
+)
+
+const (
+	jobObjectLimitPriorityClass  = 0x20
+	jobObjectLimitJobMemory      = 0x200
+	jobObjectLimitKillOnJobClose = 0x2000
+
+	jobObjectCpuRateControlEnable  = 0x1
+	jobObjectCpuRateControlHardCap = 0x4
+
+	idlePriorityClass        = 0x40
+	belowNormalPriorityClass = 0x4000
+	aboveNormalPriorityClass = 0x8000
+)
+
+type jobObjectBasicLimitInformation struct {
+	PerProcessUserTimeLimit int64
+	PerJobUserTimeLimit     int64
+	LimitFlags              uint32
+	MinimumWorkingSetSize   uintptr
+	MaximumWorkingSetSize   uintptr
+	ActiveProcessLimit      uint32
+	Affinity                uintptr
+	PriorityClass           uint32
+	SchedulingClass         uint32
+}
+
+type ioCounters struct {
+	ReadOperationCount  uint64
+	WriteOperationCount uint64
+	OtherOperationCount uint64
+	ReadTransferCount   uint64
+	WriteTransferCount  uint64
+	OtherTransferCount  uint64
+}
+
+type jobObjectExtendedLimitInformation struct {
+	BasicLimitInformation jobObjectBasicLimitInformation
+	IoInfo                ioCounters
+	ProcessMemoryLimit    uintptr
+	JobMemoryLimit        uintptr
+	PeakProcessMemoryUsed uintptr
+	PeakJobMemoryUsed     uintptr
+}
+
+type jobObjectCpuRateControlInformation struct {
+	ControlFlags uint32
+	CpuRate      uint32
+}
+
+// applyLimits applies the limits to the job object of the process group.
+func applyLimits(task string, g *procGroup, l Limits) (release func(), err error) {
+	if g == nil {
+		return nil, errors.New("no job object")
+	}
+	job := uintptr(g.job)
+
+	var info jobObjectExtendedLimitInformation
+	info.BasicLimitInformation.LimitFlags = jobObjectLimitKillOnJobClose
+	if l.Memory != 0 {
+		info.BasicLimitInformation.LimitFlags |= jobObjectLimitJobMemory
+		info.JobMemoryLimit = uintptr(l.Memory)
+	}
+	if l.Nice != 0 {
+		info.BasicLimitInformation.LimitFlags |= jobObjectLimitPriorityClass
+		switch {
+		case l.Nice < 0:
+			info.BasicLimitInformation.PriorityClass = aboveNormalPriorityClass
+		case l.Nice < 10:
+			info.BasicLimitInformation.PriorityClass = belowNormalPriorityClass
+		default:
+			info.BasicLimitInformation.PriorityClass = idlePriorityClass
+		}
+	}
+	r, _, err := procSetInformationJobObject.Call(job, infoExtendedLimit,
+		uintptr(unsafe.Pointer(&info)), unsafe.Sizeof(info))
+	if r == 0 {
+		return nil, fmt.Errorf("can't limit memory or priority: %s", err)
+	}
+
+	if l.CPU != 0 {
+		// The rate is the percentage of the CPU cycles of all processors,
+		// multiplied by 100.
+		rate := l.CPU / float64(runtime.NumCPU()) * 10000
+		if rate > 10000 {
+			rate = 10000
+		}
+		cpu := jobObjectCpuRateControlInformation{
+			ControlFlags: jobObjectCpuRateControlEnable | jobObjectCpuRateControlHardCap,
+			CpuRate:      uint32(rate),
+		}
+		r, _, err := procSetInformationJobObject.Call(job, infoCpuRateControl,
+			uintptr(unsafe.Pointer(&cpu)), unsafe.Sizeof(cpu))
+		if r == 0 {
+			return nil, fmt.Errorf("can't limit CPU: %s", err)
+		}
+	}
+	return nil, nil
+}
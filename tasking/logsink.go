@@ -0,0 +1,95 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Environment variables, set by gake from the table "log" of "gake.toml", with
+// the system logs where the summary of the run is written: the address of the
+// syslog, "local" or like "udp://host:514", its tag, and the source of the
+// Windows Event Log.
+const (
+	ENV_SYSLOG     = "GAKE_SYSLOG"
+	ENV_SYSLOG_TAG = "GAKE_SYSLOG_TAG"
+	ENV_EVENTLOG   = "GAKE_EVENTLOG"
+)
+
+// logRunSummary writes the summary of the run to the system logs given by gake,
+// so that the runs of scheduled jobs get into the logging of the system. The
+// syslog is only written on Unix, and the Event Log on Windows.
+func logRunSummary(ok bool, info RunInfo, elapsed time.Duration) {
+	syslogAddr := os.Getenv(ENV_SYSLOG)
+	eventSource := os.Getenv(ENV_EVENTLOG)
+	if syslogAddr == "" && eventSource == "" {
+		return
+	}
+	summary := runSummary(ok, info, elapsed)
+
+	if syslogAddr != "" {
+		tag := os.Getenv(ENV_SYSLOG_TAG)
+		if tag == "" {
+			tag = "gake"
+		}
+		if err := writeSyslog(syslogAddr, tag, ok, summary); err != nil {
+			fmt.Fprintf(os.Stderr, "tasking: can't write to syslog: %s\n", err)
+		}
+	}
+	if eventSource != "" {
+		if err := writeEventLog(eventSource, ok, summary); err != nil {
+			fmt.Fprintf(os.Stderr, "tasking: can't write to the Event Log: %s\n", err)
+		}
+	}
+}
+
+// runSummary returns a line with the result of the run, like:
+//
+//	gake FAIL in /src/app (12.3s): 10 tasks: 8 pass, 1 fail, 1 skip; failed: TaskLint
+func runSummary(ok bool, info RunInfo, elapsed time.Duration) string {
+	counts := make(map[string]int)
+	var failed []string
+
+	resultsMu.Lock()
+	total := len(results)
+	for _, t := range results {
+		status := t.status()
+		counts[status]++
+		if status == "fail" || status == "xpass" {
+			failed = append(failed, t.name)
+		}
+	}
+	resultsMu.Unlock()
+
+	statuses := make([]string, 0, len(counts))
+	for s := range counts {
+		statuses = append(statuses, s)
+	}
+	sort.Strings(statuses)
+	for i, s := range statuses {
+		statuses[i] = fmt.Sprintf("%d %s", counts[s], s)
+	}
+
+	result := "PASS"
+	if !ok {
+		result = "FAIL"
+	}
+	dir := os.Getenv(ENV_TASKDIR)
+	if dir == "" {
+		dir = info.Dir
+	}
+	summary := fmt.Sprintf("gake %s in %s (%s): %d tasks: %s", result, dir,
+		elapsed.Round(100*time.Millisecond), total, strings.Join(statuses, ", "))
+	if len(failed) != 0 {
+		summary += "; failed: " + strings.Join(failed, ", ")
+	}
+	return summary
+}
@@ -0,0 +1,40 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// +build !windows
+
+package tasking
+
+import (
+	"log/syslog"
+	"net/url"
+)
+
+// writeSyslog writes the message to the syslog at addr, with the priority of
+// an error if the run failed.
+func writeSyslog(addr, tag string, ok bool, msg string) error {
+	network, raddr := "", ""
+	if addr != "local" {
+		u, err := url.Parse(addr)
+		if err != nil {
+			return err
+		}
+		network, raddr = u.Scheme, u.Host
+	}
+
+	w, err := syslog.Dial(network, raddr, syslog.LOG_INFO|syslog.LOG_USER, tag)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	if !ok {
+		return w.Err(msg)
+	}
+	return w.Info(msg)
+}
+
+// writeEventLog does nothing, since the Event Log is only of Windows.
+func writeEventLog(source string, ok bool, msg string) error { return nil }
@@ -0,0 +1,59 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var (
+	advapi32                  = syscall.NewLazyDLL("advapi32.dll")
+	procRegisterEventSourceW  = advapi32.NewProc("RegisterEventSourceW")
+	procDeregisterEventSource = advapi32.NewProc("DeregisterEventSource")
+	procReportEventW          = advapi32.NewProc("ReportEventW")
+)
+
+// Types of the events of the Event Log.
+const (
+	EVENTLOG_ERROR_TYPE       = 0x0001
+	EVENTLOG_INFORMATION_TYPE = 0x0004
+)
+
+// writeSyslog does nothing, since the syslog is only of Unix.
+func writeSyslog(addr, tag string, ok bool, msg string) error { return nil }
+
+// writeEventLog writes the message to the Application log, from the source, as
+// an error if the run failed. The source should be registered, like with the
+// PowerShell command "New-EventLog -LogName Application -Source gake", so that
+// the Event Viewer shows the message without a warning.
+func writeEventLog(source string, ok bool, msg string) error {
+	src, err := syscall.UTF16PtrFromString(source)
+	if err != nil {
+		return err
+	}
+	h, _, err := procRegisterEventSourceW.Call(0, uintptr(unsafe.Pointer(src)))
+	if h == 0 {
+		return err
+	}
+	defer procDeregisterEventSource.Call(h)
+
+	text, err := syscall.UTF16PtrFromString(msg)
+	if err != nil {
+		return err
+	}
+	etype := uintptr(EVENTLOG_INFORMATION_TYPE)
+	if !ok {
+		etype = EVENTLOG_ERROR_TYPE
+	}
+	strs := [1]*uint16{text}
+	r, _, err := procReportEventW.Call(h, etype, 0, 1, 0, 1, 0, uintptr(unsafe.Pointer(&strs[0])), 0)
+	if r == 0 {
+		return err
+	}
+	return nil
+}
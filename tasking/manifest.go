@@ -0,0 +1,140 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// An internal type but exported because it is cross-package; part of the
+// implementation of the "gake" command.
+type InternalManifest struct {
+	Path  string // Relative to the directory where the tasks are run.
+	Field string // Field of the entries with the name of the instances.
+}
+
+func (m InternalManifest) String() string {
+	return fmt.Sprintf("%s name=%s", m.Path, m.Field)
+}
+
+// expandManifests returns the tasks with every task which has a manifest
+// replaced by one instance per entry of the manifest, named like
+// "TaskDeploy/api".
+//
+// A manifest is a JSON or YAML file, by its extension, with a list of objects;
+// the name of an instance is the value of the field of the manifest, which has
+// to be a string unique into the list. The YAML files are decoded by
+// yamlUnmarshal, set by M.SetYAMLUnmarshal.
+func expandManifests(tasks []InternalTask, yamlUnmarshal func([]byte, interface{}) error) ([]InternalTask, error) {
+	expanded := make([]InternalTask, 0, len(tasks))
+
+	for _, task := range tasks {
+		if task.Manifest == nil {
+			expanded = append(expanded, task)
+			continue
+		}
+
+		entries, err := loadManifest(task.Manifest.Path, yamlUnmarshal)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", task.Name, err)
+		}
+		seen := make(map[string]bool, len(entries))
+		for i, entry := range entries {
+			name, ok := entry[task.Manifest.Field].(string)
+			switch {
+			case !ok || name == "":
+				return nil, fmt.Errorf("%s: %s: entry %d: missing string field %q",
+					task.Name, task.Manifest.Path, i, task.Manifest.Field)
+			case strings.ContainsAny(name, " \t\n,/"):
+				return nil, fmt.Errorf("%s: %s: entry %d: invalid name %q",
+					task.Name, task.Manifest.Path, i, name)
+			case seen[name]:
+				return nil, fmt.Errorf("%s: %s: entry %d: name %q repeated",
+					task.Name, task.Manifest.Path, i, name)
+			}
+			seen[name] = true
+
+			data, err := json.Marshal(entry)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s: entry %d: %s", task.Name, task.Manifest.Path, i, err)
+			}
+			instance := task
+			instance.Name = task.Name + "/" + name
+			instance.entry = data
+			expanded = append(expanded, instance)
+		}
+	}
+	return expanded, nil
+}
+
+// loadManifest returns the entries of the manifest at path.
+func loadManifest(path string, yamlUnmarshal func([]byte, interface{}) error) ([]map[string]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc interface{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		if err = dec.Decode(&doc); err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+	case ".yaml", ".yml":
+		if yamlUnmarshal == nil {
+			return nil, fmt.Errorf("%s: no function to decode YAML: call M.SetYAMLUnmarshal into TaskMain", path)
+		}
+		if err = unmarshalWith("YAML", yamlUnmarshal)(path, data, &doc); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%s: unknown format %q: want .json, .yaml or .yml", path, ext)
+	}
+
+	// The maps of YAML can have keys of any type.
+	list, ok := plain(doc).([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: want a list of objects", path)
+	}
+	entries := make([]map[string]interface{}, len(list))
+	for i, v := range list {
+		if entries[i], ok = v.(map[string]interface{}); !ok {
+			return nil, fmt.Errorf("%s: entry %d: want an object", path, i)
+		}
+	}
+	return entries, nil
+}
+
+// Entry decodes the entry of the manifest for this instance of the task, declared
+// by the directive "gake:manifest" into the task documentation, into the value
+// pointed to by v, like by json.Unmarshal:
+//
+//	// gake:manifest services.json
+//	func TaskDeploy(t *tasking.T) {
+//		var svc struct{ Name, Image string }
+//		t.Entry(&svc)
+//		...
+//	}
+//
+// The task fails if it has no manifest or the entry can not be decoded.
+func (t *T) Entry(v interface{}) {
+	if t.entry == nil {
+		t.log("tasking: Entry called from a task without manifest", nil)
+		t.FailNow()
+	}
+	if err := json.Unmarshal(t.entry, v); err != nil {
+		t.log("tasking: can't decode the manifest entry: "+err.Error(), nil)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"strings"
+	"sync"
+)
+
+// runMatch is the function which matches -task.run with the names of the
+// branches of a Group, set by MainStart.
+var runMatch func(pat, str string) (bool, error)
+
+// matchLevels returns a function like matchString which splits the pattern by
+// the slashes, like "go test -run", and matches every element with the level of
+// the name at its position: the task, the instance of a matrix or manifest, and
+// the branch of a Group. So "Build/linux" matches the instance of the matrix
+// "TaskBuild/linux-amd64", and "/linux" matches the one of any task.
+//
+// A name with more levels than the pattern matches if its first ones do. A name
+// with fewer levels matches if all of them do, since its branches are only known
+// once it is run, when Group.Go skips the ones which do not match.
+//
+// The calls to matchString are serialized, since the branches of the groups are
+// run concurrently.
+func matchLevels(matchString func(pat, str string) (bool, error)) func(pat, str string) (bool, error) {
+	var mu sync.Mutex
+
+	return func(pat, str string) (bool, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if pat == "" {
+			return matchString(pat, str)
+		}
+		elems := splitPattern(pat)
+		levels := strings.Split(str, "/")
+		for i, e := range elems {
+			if i == len(levels) {
+				// Check the syntax of the rest of the pattern.
+				for _, e := range elems[i:] {
+					if _, err := matchString(e, ""); err != nil {
+						return false, err
+					}
+				}
+				break
+			}
+			ok, err := matchString(e, levels[i])
+			if err != nil || !ok {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+}
+
+// splitPattern splits the pattern by the slashes which are not into brackets or
+// parentheses, nor escaped, like "go test -run".
+func splitPattern(s string) []string {
+	elems := make([]string, 0, strings.Count(s, "/")+1)
+	cs := 0 // Depth of brackets.
+	cp := 0 // Depth of parentheses.
+	for i := 0; i < len(s); {
+		switch s[i] {
+		case '[':
+			cs++
+		case ']':
+			if cs--; cs < 0 { // An unmatched ']' is legal.
+				cs = 0
+			}
+		case '(':
+			if cs == 0 {
+				cp++
+			}
+		case ')':
+			if cs == 0 {
+				cp--
+			}
+		case '\\':
+			i++
+		case '/':
+			if cs == 0 && cp == 0 {
+				elems = append(elems, s[:i])
+				s = s[i+1:]
+				i = 0
+				continue
+			}
+		}
+		i++
+	}
+	return append(elems, s)
+}
+
+// matchBranch reports whether the branch of a Group, named after the task with
+// its number, matches -task.run.
+func matchBranch(name string) bool {
+	if *match == "" || runMatch == nil {
+		return true
+	}
+	ok, _ := runMatch(*match, name)
+	return ok
+}
@@ -0,0 +1,82 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestSplitPattern(t *testing.T) {
+	tests := []struct {
+		pat  string
+		want []string
+	}{
+		{"", []string{""}},
+		{"A", []string{"A"}},
+		{"A/B", []string{"A", "B"}},
+		{"A/B/C", []string{"A", "B", "C"}},
+		{"/x", []string{"", "x"}},
+		{"A/", []string{"A", ""}},
+
+		// The slashes into brackets or parentheses, or escaped, are not split.
+		{"[/]", []string{"[/]"}},
+		{"(a/b)", []string{"(a/b)"}},
+		{`a\/b`, []string{`a\/b`}},
+		{"(a/b)/c", []string{"(a/b)", "c"}},
+		{"[(]/b", []string{"[(]", "b"}},
+		{"]/x", []string{"]", "x"}},
+	}
+	for _, tt := range tests {
+		if got := splitPattern(tt.pat); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitPattern(%q) = %q; want %q", tt.pat, got, tt.want)
+		}
+	}
+}
+
+func TestMatchLevels(t *testing.T) {
+	match := matchLevels(regexp.MatchString)
+
+	tests := []struct {
+		pat, name string
+		want      bool
+		err       bool
+	}{
+		{"", "TaskBuild/linux", true, false},
+		{"Build", "TaskBuild", true, false},
+		{"Build", "TaskBuild/linux", true, false},
+		{"Build/linux", "TaskBuild/linux-amd64", true, false},
+		{"Build/linux", "TaskBuild/darwin-amd64", false, false},
+		{"Test/linux", "TaskBuild/linux-amd64", false, false},
+		{"/linux", "TaskTest/linux", true, false},
+		{"/x", "TaskA/y", false, false},
+
+		// A name with fewer levels matches if all of them do.
+		{"/x", "TaskA", true, false},
+		{"Build/linux", "TaskBuild", true, false},
+		{"Build/linux/2", "TaskBuild/linux", true, false},
+		{"Test/linux", "TaskBuild", false, false},
+
+		// The slashes not split are matched into a single level.
+		{"[/]", "TaskA/b", false, false},
+		{"(a/b)", "a/b", false, false},
+		{`a\/b`, "a/b", false, false},
+		{"(A|B/C)", "TaskA/x", true, false},
+
+		// The syntax of the levels beyond the name is checked too.
+		{"[", "TaskA", false, true},
+		{"Build/[", "TaskBuild", false, true},
+		{"Build/(", "TaskBuild/linux", false, true},
+	}
+	for _, tt := range tests {
+		got, err := match(tt.pat, tt.name)
+		if got != tt.want || (err != nil) != tt.err {
+			t.Errorf("match(%q, %q) = %v, %v; want %v, error %v", tt.pat, tt.name, got, err, tt.want, tt.err)
+		}
+	}
+}
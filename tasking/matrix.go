@@ -0,0 +1,87 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"fmt"
+	"strings"
+)
+
+// An internal type but exported because it is cross-package; part of the
+// implementation of the "gake" command.
+type InternalAxis struct {
+	Name   string
+	Values []string
+}
+
+func (a InternalAxis) String() string {
+	return fmt.Sprintf("%s=%s", a.Name, strings.Join(a.Values, ","))
+}
+
+// expandMatrix returns the tasks with every task which has a matrix replaced by
+// one instance per combination of the values of its axes, named like
+// "TaskBuild/linux-amd64".
+func expandMatrix(tasks []InternalTask) []InternalTask {
+	expanded := make([]InternalTask, 0, len(tasks))
+
+	for _, task := range tasks {
+		if len(task.Matrix) == 0 {
+			expanded = append(expanded, task)
+			continue
+		}
+
+		combs := []map[string]string{{}}
+		for _, axis := range task.Matrix {
+			next := make([]map[string]string, 0, len(combs)*len(axis.Values))
+			for _, c := range combs {
+				for _, v := range axis.Values {
+					m := make(map[string]string, len(c)+1)
+					for k, v := range c {
+						m[k] = v
+					}
+					m[axis.Name] = v
+					next = append(next, m)
+				}
+			}
+			combs = next
+		}
+
+		for _, c := range combs {
+			values := make([]string, len(task.Matrix))
+			for i, axis := range task.Matrix {
+				values[i] = c[axis.Name]
+			}
+			instance := task
+			instance.Name = task.Name + "/" + strings.Join(values, "-")
+			instance.matrix = c
+			expanded = append(expanded, instance)
+		}
+	}
+	return expanded
+}
+
+// baseName returns the name of the task function of a matrix instance.
+func baseName(name string) string {
+	if i := strings.IndexByte(name, '/'); i != -1 {
+		return name[:i]
+	}
+	return name
+}
+
+// Matrix returns the values of the matrix axes for this instance of the task,
+// declared by the directive "gake:matrix" into the task documentation; nil if
+// the task has no matrix.
+func (t *T) Matrix() map[string]string {
+	if t.matrix == nil {
+		return nil
+	}
+	m := make(map[string]string, len(t.matrix))
+	for k, v := range t.matrix {
+		m[k] = v
+	}
+	return m
+}
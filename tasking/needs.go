@@ -0,0 +1,176 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ENV_NEEDS is the environment variable, set by gake from the table "needs" of
+// "gake.toml", with the endpoints which can be needed by name, like
+// "postgres=tcp://localhost:5432,api=http://localhost:8080/health".
+const ENV_NEEDS = "GAKE_NEEDS"
+
+// PROBE_TIMEOUT is the time limit of every attempt to reach an endpoint.
+const PROBE_TIMEOUT = 5 * time.Second
+
+var (
+	needsTimeout = flag.Duration("task.needs-timeout", 30*time.Second, "time given to the endpoints needed by a task to be available")
+	needsSkip    = flag.Bool("task.needs-skip", false, "skip the tasks whose needed endpoints are not available, instead of failing them")
+)
+
+// probeBackoff gives the delays between the attempts to reach an endpoint.
+var probeBackoff = Backoff{
+	Initial: 250 * time.Millisecond,
+	Max:     5 * time.Second,
+	Factor:  2,
+	Jitter:  0.2,
+}
+
+// Needs waits until the external endpoints needed by the task are available,
+// like a database or a service of the development environment, retrying for
+// the time given by the flag -task.needs-timeout. If any of them is not, the
+// task fails with the reason, or it is skipped with -task.needs-skip.
+//
+// An endpoint is "tcp://host:port", which is available when a connection is
+// accepted; an HTTP URL, which is available when it answers without a status
+// 5xx; or the name of an endpoint into the table "needs" of gake.toml. The
+// directive "gake:needs endpoint..." calls Needs before the task is started.
+func (t *T) Needs(endpoint ...string) {
+	t.needs(endpoint)
+}
+
+func (t *T) needs(endpoints []string) {
+	named, err := parseNeeds(os.Getenv(ENV_NEEDS))
+	if err != nil {
+		t.write("\ttasking: "+err.Error()+"\n", nil)
+		t.FailNow()
+	}
+	if *dryRun {
+		t.write("\twould wait for "+strings.Join(endpoints, ", ")+"\n", nil)
+		return
+	}
+
+	var (
+		mu       sync.Mutex
+		failures []string
+		wg       sync.WaitGroup
+	)
+	for _, e := range endpoints {
+		target := e
+		if v, ok := named[e]; ok {
+			target = v
+		} else if !strings.Contains(e, "://") {
+			failures = append(failures, fmt.Sprintf("needs %s: unknown endpoint; declare it into the table \"needs\" of gake.toml", e))
+			continue
+		}
+
+		wg.Add(1)
+		go func(name, target string) {
+			defer wg.Done()
+			if err := t.waitEndpoint(target); err != nil {
+				if name != target {
+					name += " (" + redactURL(target) + ")"
+				} else {
+					name = redactURL(name)
+				}
+				mu.Lock()
+				failures = append(failures, fmt.Sprintf("needs %s: not available after %v: %s",
+					name, *needsTimeout, err))
+				mu.Unlock()
+			}
+		}(e, target)
+	}
+	wg.Wait()
+
+	if len(failures) == 0 {
+		return
+	}
+	for _, f := range failures {
+		t.write("\ttasking: "+f+"\n", nil)
+	}
+	if *needsSkip {
+		t.write("\tskipped by -task.needs-skip\n", nil)
+		t.skipNow(SKIP_UNAVAILABLE)
+	}
+	t.FailNow()
+}
+
+// waitEndpoint probes the endpoint until it is available, the time given by
+// -task.needs-timeout has passed, or the run is aborted.
+func (t *T) waitEndpoint(endpoint string) error {
+	deadline := time.Now().Add(*needsTimeout)
+	for n := 1; ; n++ {
+		err := probeEndpoint(endpoint)
+		if err == nil {
+			return nil
+		}
+		delay := probeBackoff.Delay(n)
+		if time.Now().Add(delay).After(deadline) {
+			return err
+		}
+		t.Progress()
+
+		select {
+		case <-time.After(delay):
+		case <-aborted():
+			return err
+		}
+	}
+}
+
+// probeEndpoint reports whether the endpoint is available.
+func probeEndpoint(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "tcp":
+		conn, err := net.DialTimeout("tcp", u.Host, PROBE_TIMEOUT)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case "http", "https":
+		client := &http.Client{Timeout: PROBE_TIMEOUT}
+		resp, err := client.Get(endpoint)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 == 5 {
+			return fmt.Errorf("%s", resp.Status)
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported endpoint %q: want tcp://host:port or an HTTP URL", endpoint)
+}
+
+// parseNeeds parses the endpoints with the form "name=endpoint,...".
+func parseNeeds(s string) (map[string]string, error) {
+	named := make(map[string]string)
+	for _, n := range strings.Split(s, ",") {
+		if n = strings.TrimSpace(n); n == "" {
+			continue
+		}
+		i := strings.IndexByte(n, '=')
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid endpoint %q into %s: want name=endpoint", n, ENV_NEEDS)
+		}
+		named[n[:i]] = n[i+1:]
+	}
+	return named, nil
+}
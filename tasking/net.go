@@ -0,0 +1,69 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Ports returned by FreePort, which are not returned again.
+var (
+	portsMu sync.Mutex
+	ports   = make(map[int]bool)
+)
+
+// FreePort returns a TCP port available on the loopback interface, so that a
+// server can be started on it. The port is not returned again to other tasks.
+func (t *T) FreePort() int {
+	portsMu.Lock()
+	defer portsMu.Unlock()
+
+	// The system could return again a port released.
+	for i := 0; i < 100; i++ {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.log("tasking: can't get a free port: "+err.Error(), nil)
+			t.FailNow()
+		}
+		port := ln.Addr().(*net.TCPAddr).Port
+		ln.Close()
+
+		if !ports[port] {
+			ports[port] = true
+			return port
+		}
+	}
+	t.log("tasking: can't get a free port", nil)
+	t.FailNow()
+	return 0
+}
+
+// WaitForPort waits until a TCP connection can be made to addr, such as a
+// server started by the task. The task fails if it is not achieved within the
+// timeout.
+func (t *T) WaitForPort(addr string, timeout time.Duration) {
+	if *dryRun {
+		return
+	}
+	deadline := time.Now().Add(timeout)
+
+	for {
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err == nil {
+			conn.Close()
+			return
+		}
+		if time.Now().After(deadline) {
+			t.log(fmt.Sprintf("tasking: %s not available after %v: %s", addr, timeout, err), nil)
+			t.FailNow()
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,479 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// sizeFlag is a flag for a size in bytes, which can have the suffix K, M or G
+// for the powers of 1024.
+type sizeFlag int64
+
+func (s *sizeFlag) String() string { return strconv.FormatInt(int64(*s), 10) }
+
+func (s *sizeFlag) Set(v string) error {
+	v = strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(v), "B"), "I")
+	mul := int64(1)
+
+	if n := len(v); n != 0 {
+		switch v[n-1] {
+		case 'K':
+			mul = 1 << 10
+		case 'M':
+			mul = 1 << 20
+		case 'G':
+			mul = 1 << 30
+		}
+		if mul != 1 {
+			v = v[:n-1]
+		}
+	}
+
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid size %q", v)
+	}
+	*s = sizeFlag(n * mul)
+	return nil
+}
+
+var (
+	maxOutput  = sizeFlag(10 << 20)
+	teeOutput  = flag.String("task.tee", "", "stream the output of the tasks as it is logged to a comma-separated list of sinks: console, file")
+	teeConsole bool
+	teeFile    bool
+)
+
+func init() {
+	flag.Var(&maxOutput, "task.max-output", "maximum size of the output kept in memory per task, like 10M; 0 for no limit")
+}
+
+var (
+	sinksMu sync.Mutex
+	sinks   []io.Writer
+)
+
+// AddOutputSink adds a writer which receives the output of all tasks as it is
+// logged, with every line prefixed by the name of the task. The writes to the
+// sinks are serialized. It has to be called before the tasks are run, such as
+// from an init function.
+func AddOutputSink(w io.Writer) {
+	sinksMu.Lock()
+	defer sinksMu.Unlock()
+	sinks = append(sinks, w)
+}
+
+// parseTee sets the sinks given by the flag -task.tee.
+func parseTee() {
+	if *teeOutput == "" {
+		return
+	}
+	for _, v := range strings.Split(*teeOutput, ",") {
+		switch strings.TrimSpace(v) {
+		case "console":
+			teeConsole = true
+		case "file":
+			teeFile = true
+		default:
+			fmt.Fprintf(os.Stderr, "tasking: invalid sink %q for -task.tee: want console or file\n", v)
+			os.Exit(EXIT_USAGE)
+		}
+	}
+}
+
+// newOutput returns the pipeline where the output of the task is written: its
+// buffer, and the sinks given by -task.tee and AddOutputSink.
+func (c *common) newOutput(name string) io.Writer {
+	c.output.task = name
+	ws := []io.Writer{&c.output}
+
+	if teeFile {
+		f, err := os.Create(toOutputDir(outputFileName(name) + ".log"))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "tasking: can't tee the output of %s: %s\n", name, err)
+		} else {
+			c.teeFile = f
+			ws = append(ws, f)
+		}
+	}
+	if teeConsole {
+		ws = append(ws, &sinkWriter{prefix: name + ": ", w: os.Stderr})
+	}
+
+	sinksMu.Lock()
+	for _, w := range sinks {
+		ws = append(ws, &sinkWriter{prefix: name + ": ", w: w})
+	}
+	sinksMu.Unlock()
+
+	if len(ws) == 1 {
+		return &c.output
+	}
+	return teeWriter(ws)
+}
+
+// closeOutput closes the files of the output pipeline.
+func (c *common) closeOutput() {
+	c.output.Close()
+	if c.teeFile != nil {
+		c.teeFile.Close()
+	}
+}
+
+// teeWriter writes to all its writers. Unlike io.MultiWriter, a failed sink does
+// not stop the writes to the rest.
+type teeWriter []io.Writer
+
+func (t teeWriter) Write(p []byte) (int, error) {
+	for _, w := range t {
+		w.Write(p)
+	}
+	return len(p), nil
+}
+
+// sinkWriter writes to a sink shared by all tasks, prefixing every line.
+type sinkWriter struct {
+	prefix string
+	w      io.Writer
+}
+
+func (s *sinkWriter) Write(p []byte) (int, error) {
+	var buf bytes.Buffer
+	for _, line := range bytes.SplitAfter(p, []byte("\n")) {
+		if len(line) != 0 {
+			buf.WriteString(s.prefix)
+			buf.Write(line)
+		}
+	}
+
+	sinksMu.Lock()
+	defer sinksMu.Unlock()
+	return s.w.Write(buf.Bytes())
+}
+
+// outputFileName returns the task name usable as file name.
+func outputFileName(task string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ':' || r == ' ' {
+			return '_'
+		}
+		return r
+	}, task)
+}
+
+// outputBuffer holds the output of a task. Beyond the size given by the flag
+// -task.max-output, only its head and its tail are kept, and the whole output is
+// spilled to a file into the output directory.
+//
+// The head is kept at the start of its store, and the tail goes round the rest
+// of it, so that the bytes kept are written once, without copies as the output
+// grows.
+type outputBuffer struct {
+	task string // Name of the task, used to name the spill file.
+
+	store spoolBuffer
+	n     int64 // Bytes written.
+	spill *os.File
+}
+
+// Write appends the bytes to the output.
+func (b *outputBuffer) Write(p []byte) (int, error) {
+	b.write(p)
+	return len(p), nil
+}
+
+func (b *outputBuffer) write(p []byte) {
+	max := int64(maxOutput)
+	if max <= 0 || b.n+int64(len(p)) <= max {
+		b.store.WriteAt(p, b.n)
+		b.n += int64(len(p))
+		return
+	}
+	if b.n <= max {
+		b.startSpill()
+	}
+	if b.spill != nil {
+		b.spill.Write(p)
+	}
+
+	half := max / 2
+	size := max - half // Of the tail.
+	if b.n >= half {
+		// The bytes which would be overwritten in this write are dropped.
+		if extra := int64(len(p)) - size; extra > 0 {
+			b.n += extra
+			p = p[extra:]
+		}
+	}
+	for len(p) != 0 {
+		off, n := b.n, int64(len(p))
+		if off < half {
+			if n > half-off {
+				n = half - off
+			}
+		} else {
+			pos := (off - half) % size
+			off = half + pos
+			if n > size-pos {
+				n = size - pos
+			}
+		}
+		b.store.WriteAt(p[:n], off)
+		b.n += n
+		p = p[n:]
+	}
+}
+
+// startSpill creates the spill file, writing the output got until now.
+func (b *outputBuffer) startSpill() {
+	f, err := os.Create(toOutputDir(outputFileName(b.task) + ".output"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tasking: can't spill the output of %s: %s\n", b.task, err)
+		return
+	}
+	b.store.copyTo(f, 0, b.n)
+	b.spill = f
+}
+
+// Bytes returns the output, with a note about the bytes dropped if it was
+// truncated.
+func (b *outputBuffer) Bytes() []byte {
+	max := int64(maxOutput)
+	if max <= 0 || b.n <= max {
+		return b.store.appendTo(nil, 0, b.n)
+	}
+
+	note := fmt.Sprintf("\n\t... [%d bytes dropped", b.n-max)
+	if b.spill != nil {
+		note += "; full output in " + b.spill.Name()
+	}
+	note += "] ...\n"
+
+	half := max / 2
+	pos := (b.n - half) % (max - half)
+	out := make([]byte, 0, max+int64(len(note)))
+	out = b.store.appendTo(out, 0, half)
+	out = append(out, note...)
+	out = b.store.appendTo(out, half+pos, max-half-pos)
+	return b.store.appendTo(out, half, pos)
+}
+
+// Close closes the spill file, if any.
+func (b *outputBuffer) Close() {
+	if b.spill != nil {
+		b.spill.Close()
+	}
+}
+
+// OUTPUT_CHUNK is the size of the chunks of memory which hold the output.
+const OUTPUT_CHUNK = 32 << 10
+
+var outputSpool = sizeFlag(1 << 20)
+
+func init() {
+	flag.Var(&outputSpool, "task.output-spool", "size of the output kept in memory per task, like 1M, beyond which it is moved to a temporary file; 0 to keep it in memory")
+}
+
+var (
+	spoolsMu sync.Mutex
+	spools   []*os.File // Spool files which could not be removed once created.
+)
+
+// spoolBuffer holds bytes into chunks of OUTPUT_CHUNK bytes, so that they are
+// not copied as it grows. Beyond the size given by the flag -task.output-spool,
+// they are moved to a temporary file, removed once created, so that the output
+// of verbose tools does not stay in memory until the end of the run.
+type spoolBuffer struct {
+	chunks [][]byte
+	size   int64 // Offset after the last byte written.
+	failed bool  // The spool file could not be used.
+
+	file       *os.File
+	pending    []byte // Consecutive bytes not written yet to the file.
+	pendingOff int64
+}
+
+// WriteAt writes the bytes at the offset, which must not be beyond its size.
+func (s *spoolBuffer) WriteAt(p []byte, off int64) {
+	size := s.size
+	if end := off + int64(len(p)); end > s.size {
+		s.size = end
+	}
+	if s.file == nil && !s.failed && outputSpool > 0 && s.size > int64(outputSpool) {
+		s.spool(size)
+	}
+	if s.file != nil {
+		// The writes of lines are gathered, to write the file by chunks.
+		if off == s.pendingOff+int64(len(s.pending)) && len(s.pending)+len(p) <= cap(s.pending) {
+			s.pending = append(s.pending, p...)
+			return
+		}
+		err := s.flush()
+		if err == nil {
+			if len(p) >= OUTPUT_CHUNK {
+				_, err = s.file.WriteAt(p, off)
+			} else {
+				s.pending = append(s.pending, p...)
+				s.pendingOff = off
+			}
+		}
+		if err == nil {
+			return
+		}
+		s.unspool(size, err)
+	}
+
+	for len(p) != 0 {
+		i, j := int(off/OUTPUT_CHUNK), int(off%OUTPUT_CHUNK)
+		if i == len(s.chunks) {
+			s.chunks = append(s.chunks, nil)
+		}
+		c := s.chunks[i]
+		n := len(p)
+		if n > OUTPUT_CHUNK-j {
+			n = OUTPUT_CHUNK - j
+		}
+		if need := j + n; need > len(c) {
+			if need > cap(c) {
+				// Only the first chunk grows up to its size, so that a short
+				// output does not take a whole chunk.
+				size := OUTPUT_CHUNK
+				if i == 0 {
+					if size = 2 * cap(c); size < need {
+						size = need
+					}
+					if size > OUTPUT_CHUNK {
+						size = OUTPUT_CHUNK
+					}
+				}
+				c = append(make([]byte, 0, size), c...)
+			}
+			c = c[:need]
+			s.chunks[i] = c
+		}
+		copy(c[j:], p[:n])
+		p = p[n:]
+		off += int64(n)
+	}
+}
+
+// spool moves the first bytes, up to size, to a temporary file.
+func (s *spoolBuffer) spool(size int64) {
+	f, err := os.CreateTemp("", "gake-output-")
+	if err == nil {
+		err = s.copyTo(f, 0, size)
+	}
+	if err != nil {
+		s.failed = true
+		fmt.Fprintf(os.Stderr, "tasking: can't spool the output: %s\n", err)
+		if f != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+		return
+	}
+	// An open file can not be removed on Windows.
+	if os.Remove(f.Name()) != nil {
+		spoolsMu.Lock()
+		spools = append(spools, f)
+		spoolsMu.Unlock()
+	}
+	s.file = f
+	s.pending = make([]byte, 0, OUTPUT_CHUNK)
+	s.chunks = nil
+}
+
+// flush writes the pending bytes to the spool file.
+func (s *spoolBuffer) flush() error {
+	if len(s.pending) == 0 {
+		return nil
+	}
+	_, err := s.file.WriteAt(s.pending, s.pendingOff)
+	s.pending = s.pending[:0]
+	return err
+}
+
+// unspool moves back to memory the first bytes, up to size, once the spool file
+// has failed.
+func (s *spoolBuffer) unspool(size int64, err error) {
+	fmt.Fprintf(os.Stderr, "tasking: can't spool the output: %s\n", err)
+	f := s.file
+	b := s.appendTo(nil, 0, size)
+	s.file = nil
+	s.pending = nil
+	s.failed = true
+	f.Close()
+	os.Remove(f.Name())
+	s.WriteAt(b, 0)
+}
+
+// appendTo appends n bytes from the offset to out.
+func (s *spoolBuffer) appendTo(out []byte, off, n int64) []byte {
+	start := len(out)
+	if int64(cap(out)-start) < n {
+		out = append(make([]byte, 0, int64(start)+n), out...)
+	}
+	if s.file == nil {
+		buf := bytes.NewBuffer(out)
+		s.copyTo(buf, off, n)
+		return buf.Bytes()
+	}
+
+	out = out[:start+int(n)]
+	err := s.flush()
+	if err == nil {
+		_, err = s.file.ReadAt(out[start:], off)
+	}
+	if err != nil {
+		return append(out[:start], fmt.Sprintf("\n\t... [can't read the spooled output: %s] ...\n", err)...)
+	}
+	return out
+}
+
+// copyTo writes n bytes from the offset to w.
+func (s *spoolBuffer) copyTo(w io.Writer, off, n int64) error {
+	if s.file != nil {
+		if err := s.flush(); err != nil {
+			return err
+		}
+		_, err := io.Copy(w, io.NewSectionReader(s.file, off, n))
+		return err
+	}
+
+	for n != 0 {
+		i, j := int(off/OUTPUT_CHUNK), int(off%OUTPUT_CHUNK)
+		c := s.chunks[i][j:]
+		if int64(len(c)) > n {
+			c = c[:n]
+		}
+		if _, err := w.Write(c); err != nil {
+			return err
+		}
+		off += int64(len(c))
+		n -= int64(len(c))
+	}
+	return nil
+}
+
+// removeSpools removes the spool files which could not be removed once created.
+func removeSpools() {
+	spoolsMu.Lock()
+	defer spoolsMu.Unlock()
+	for _, f := range spools {
+		f.Close()
+		os.Remove(f.Name())
+	}
+	spools = nil
+}
@@ -0,0 +1,204 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// setOutputFlags sets -task.max-output, -task.output-spool and -task.outputdir
+// until the end of the test.
+func setOutputFlags(tb testing.TB, max, spool int64) {
+	oldMax, oldSpool, oldDir := maxOutput, outputSpool, *outputDir
+	tb.Cleanup(func() { maxOutput, outputSpool, *outputDir = oldMax, oldSpool, oldDir })
+	maxOutput, outputSpool, *outputDir = sizeFlag(max), sizeFlag(spool), tb.TempDir()
+}
+
+// outputText returns n bytes of numbered lines.
+func outputText(n int) []byte {
+	var buf bytes.Buffer
+	for i := 0; buf.Len() < n; i++ {
+		fmt.Fprintf(&buf, "line %d of the output\n", i)
+	}
+	return buf.Bytes()[:n]
+}
+
+func TestOutputBuffer(t *testing.T) {
+	tests := []struct {
+		max, spool int64 // Flags -task.max-output and -task.output-spool.
+		size       int   // Bytes written.
+		write      int   // Bytes by write.
+	}{
+		{0, 0, 100 << 10, 50},
+		{0, 1 << 10, 100 << 10, 50},
+		{10 << 10, 0, 9 << 10, 50},
+		{10 << 10, 0, 10 << 10, 50},
+		{10 << 10, 4 << 10, 10 << 10, 50},
+
+		// Truncated, into memory.
+		{10 << 10, 0, 10<<10 + 1, 50},
+		{10 << 10, 0, 50 << 10, 50},
+		{10001, 0, 50 << 10, 77},
+		{100 << 10, 0, 300 << 10, 40 << 10},
+
+		// Truncated, with the store spooled before and after the limit.
+		{10 << 10, 4 << 10, 50 << 10, 50},
+		{10 << 10, 1 << 10, 30 << 10, 30 << 10},
+		{100 << 10, 64 << 10, 300 << 10, 40 << 10},
+		{200 << 10, 150 << 10, 1 << 20, 3000},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("max=%d,spool=%d,size=%d,write=%d", tt.max, tt.spool, tt.size, tt.write), func(t *testing.T) {
+			setOutputFlags(t, tt.max, tt.spool)
+			text := outputText(tt.size)
+
+			b := &outputBuffer{task: "TaskOut"}
+			for p := text; len(p) != 0; {
+				n := tt.write
+				if n > len(p) {
+					n = len(p)
+				}
+				b.Write(p[:n])
+				p = p[n:]
+			}
+			got := b.Bytes()
+			b.Close()
+			if b.store.file != nil {
+				b.store.file.Close()
+			}
+			// The store holds up to -task.max-output bytes.
+			stored := int64(tt.size)
+			if tt.max > 0 && stored > tt.max {
+				stored = tt.max
+			}
+			if spooled := b.store.file != nil; spooled != (tt.spool > 0 && stored > tt.spool) {
+				t.Errorf("spooled = %v", spooled)
+			}
+
+			want := text
+			if tt.max > 0 && int64(tt.size) > tt.max {
+				spill := filepath.Join(*outputDir, "TaskOut.output")
+				half := tt.max / 2
+				want = append([]byte(nil), text[:half]...)
+				want = append(want, fmt.Sprintf("\n\t... [%d bytes dropped; full output in %s] ...\n",
+					int64(tt.size)-tt.max, spill)...)
+				want = append(want, text[int64(tt.size)-(tt.max-half):]...)
+
+				if full, err := os.ReadFile(spill); err != nil {
+					t.Error(err)
+				} else if !bytes.Equal(full, text) {
+					t.Errorf("spill file has %d bytes; want the %d of the output", len(full), len(text))
+				}
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("Bytes() has %d bytes, differing at %d; want %d bytes",
+					len(got), firstDiff(got, want), len(want))
+			}
+		})
+	}
+}
+
+func TestSpoolBuffer(t *testing.T) {
+	const SIZE = 200 << 10
+
+	// The writes at offsets before the end, like the ones of the tail of
+	// outputBuffer, overwrite the bytes of the memory and of the spool file.
+	for _, spool := range []int64{0, 1 << 10, 100 << 10, SIZE} {
+		setOutputFlags(t, 0, spool)
+
+		var s spoolBuffer
+		want := make([]byte, 0, SIZE+OUTPUT_CHUNK)
+		fill := byte('a')
+		for i, off := 0, int64(0); off < SIZE; i++ {
+			// Writes smaller and bigger than a chunk, and rewrites.
+			n := 1000
+			if i%5 == 0 {
+				n = OUTPUT_CHUNK + 100
+			}
+			p := bytes.Repeat([]byte{fill}, n)
+			fill = 'a' + (fill-'a'+1)%26
+
+			s.WriteAt(p, off)
+			if end := off + int64(n); end > int64(len(want)) {
+				want = want[:end]
+			}
+			copy(want[off:], p)
+
+			if off > 5000 && i%2 == 0 {
+				back := off - 3000
+				q := bytes.Repeat([]byte{'#'}, 2000)
+				s.WriteAt(q, back)
+				copy(want[back:], q)
+			}
+			off += int64(n)
+		}
+
+		if got := s.appendTo(nil, 0, s.size); !bytes.Equal(got, want) {
+			t.Errorf("spool=%d: appendTo has %d bytes, differing at %d; want %d bytes",
+				spool, len(got), firstDiff(got, want), len(want))
+		}
+		if got := s.appendTo([]byte("x"), 70000, 5000); !bytes.Equal(got, append([]byte("x"), want[70000:75000]...)) {
+			t.Errorf("spool=%d: appendTo at an offset differs", spool)
+		}
+		if spooled := s.file != nil; spooled != (spool > 0 && s.size > spool) {
+			t.Errorf("spool=%d: spooled = %v", spool, spooled)
+		}
+		if s.file != nil {
+			s.file.Close()
+		}
+	}
+}
+
+// firstDiff returns the index of the first byte which differs, or -1.
+func firstDiff(a, b []byte) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	switch {
+	case len(a) < len(b):
+		return len(a)
+	case len(a) > len(b):
+		return len(b)
+	}
+	return -1
+}
+
+func BenchmarkOutputWrite(b *testing.B) {
+	line := outputText(80)
+	for _, bb := range []struct {
+		name       string
+		max, spool int64
+	}{
+		{"memory", 0, 0},
+		{"spooled", 0, 1 << 20},
+		{"truncated", 1 << 20, 0},
+		{"truncated-spooled", 4 << 20, 1 << 20},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			setOutputFlags(b, bb.max, bb.spool)
+			buf := &outputBuffer{task: "TaskBench"}
+			b.SetBytes(int64(len(line)))
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				buf.Write(line)
+			}
+			b.StopTimer()
+			buf.Close()
+			if buf.store.file != nil {
+				buf.store.file.Close()
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// paramFlag holds the values of the parameters given by -task.param flags.
+type paramFlag map[string]string
+
+func (p paramFlag) String() string {
+	s := make([]string, 0, len(p))
+	for k, v := range p {
+		s = append(s, k+"="+v)
+	}
+	return strings.Join(s, ",")
+}
+
+func (p paramFlag) Set(s string) error {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return fmt.Errorf("want name=value")
+	}
+	p[kv[0]] = kv[1]
+	return nil
+}
+
+var params = make(paramFlag)
+
+func init() {
+	flag.Var(params, "task.param", "set the parameter of tasks with the form name=value; it can be repeated")
+}
+
+// An internal type but exported because it is cross-package; part of the
+// implementation of the "gake" command. It represents a parameter declared by a
+// "gake:param" directive.
+type InternalParam struct {
+	Name     string
+	Type     string // string, int, bool or duration.
+	Required bool
+	Default  string
+}
+
+// String returns the declaration of the parameter.
+func (p InternalParam) String() string {
+	s := p.Name + " " + p.Type
+	if p.Required {
+		s += " required"
+	}
+	if p.Default != "" {
+		s += " default=" + p.Default
+	}
+	return s
+}
+
+// check checks whether the value given to the parameter is valid for its type.
+func (p InternalParam) check(value string) error {
+	var err error
+
+	switch p.Type {
+	case "int":
+		_, err = strconv.Atoi(value)
+	case "bool":
+		_, err = strconv.ParseBool(value)
+	case "duration":
+		_, err = time.ParseDuration(value)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid value %q for parameter %s of type %s", value, p.Name, p.Type)
+	}
+	return nil
+}
+
+// checkParams checks, before any task is run, that the parameters given are
+// declared by some task, that their values are valid, and that the required
+// parameters of the tasks to run are given. It exits on failure.
+func checkParams(matchString func(pat, str string) (bool, error), tasks []InternalTask) {
+	declared := make(map[string]bool)
+	ok := true
+
+	for _, task := range tasks {
+		matched, err := matchString(*match, task.Name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "tasking: invalid regexp for -task.run: %s\n", err)
+			os.Exit(EXIT_USAGE)
+		}
+
+		for _, p := range task.Params {
+			declared[p.Name] = true
+			value, given := params[p.Name]
+
+			if given {
+				if err = p.check(value); err != nil {
+					fmt.Fprintf(os.Stderr, "tasking: %s: %s\n", task.Name, err)
+					ok = false
+				}
+			} else if matched && p.Required {
+				fmt.Fprintf(os.Stderr, "tasking: %s: missing required parameter %s; set it with -param %s=value\n",
+					task.Name, p.Name, p.Name)
+				ok = false
+			}
+		}
+	}
+
+	for name := range params {
+		if !declared[name] {
+			fmt.Fprintf(os.Stderr, "tasking: unknown parameter %s\n", name)
+			ok = false
+		}
+	}
+	if !ok {
+		os.Exit(EXIT_USAGE)
+	}
+}
+
+// Param returns the value of the named parameter, declared into the task
+// documentation by a directive with the form:
+//
+//	gake:param name type [required] [default=value]
+//
+// where type is string, int, bool or duration. The value is given to the run
+// with the flag -task.param name=value, and it has been validated against the
+// type before running any task. If the parameter is not given, it returns the
+// default value, if any.
+//
+// Param fails the task if it does not declare the parameter.
+func (t *T) Param(name string) string {
+	for _, p := range t.params {
+		if p.Name == name {
+			if v, ok := params[name]; ok {
+				return v
+			}
+			return p.Default
+		}
+	}
+	t.log("tasking: undeclared parameter "+name, nil)
+	t.FailNow()
+	return ""
+}
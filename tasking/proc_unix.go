@@ -0,0 +1,61 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// +build !windows
+
+package tasking
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// procGroup is a process launched by Exec, with its descendants.
+type procGroup struct {
+	p    *os.Process
+	pgid int // Negative to signal the whole group; 0 if it has not its own group.
+}
+
+// setProcGroup sets the command to be started into a new process group; unless
+// its standard input is a terminal, since it could not read from it.
+func setProcGroup(cmd *exec.Cmd) {
+	if f, ok := cmd.Stdin.(*os.File); ok && isTerminal(f) {
+		return
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = new(syscall.SysProcAttr)
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// newProcGroup returns the group of the process. When the process is not the
+// leader of its own group, like when its standard input is a terminal, it is
+// not signaled: its PID could be reused by another process once it is reaped.
+func newProcGroup(p *os.Process) (*procGroup, error) {
+	pgid, err := syscall.Getpgid(p.Pid)
+	if err != nil || pgid != p.Pid {
+		return &procGroup{p: p}, nil
+	}
+	return &procGroup{p: p, pgid: -p.Pid}, nil
+}
+
+// alive reports whether any process of the group is running.
+func (g *procGroup) alive() bool { return g.pgid != 0 && syscall.Kill(g.pgid, 0) == nil }
+
+func (g *procGroup) terminate() {
+	if g.pgid != 0 {
+		syscall.Kill(g.pgid, syscall.SIGTERM)
+	}
+}
+
+func (g *procGroup) kill() {
+	if g.pgid != 0 {
+		syscall.Kill(g.pgid, syscall.SIGKILL)
+	}
+}
+
+func (g *procGroup) close() {}
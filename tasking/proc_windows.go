@@ -0,0 +1,88 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	procCreateJobObjectW          = kernel32.NewProc("CreateJobObjectW")
+	procAssignProcessToJobObject  = kernel32.NewProc("AssignProcessToJobObject")
+	procTerminateJobObject        = kernel32.NewProc("TerminateJobObject")
+	procQueryInformationJobObject = kernel32.NewProc("QueryInformationJobObject")
+)
+
+const (
+	infoBasicAccounting = 1
+
+	processSetQuota  = 0x100
+	processTerminate = 0x1
+)
+
+type jobObjectBasicAccountingInformation struct {
+	TotalUserTime             int64
+	TotalKernelTime           int64
+	ThisPeriodTotalUserTime   int64
+	ThisPeriodTotalKernelTime int64
+	TotalPageFaultCount       uint32
+	TotalProcesses            uint32
+	ActiveProcesses           uint32
+	TotalTerminatedProcesses  uint32
+}
+
+// procGroup is a process launched by Exec, with its descendants, into a job
+// object.
+type procGroup struct {
+	p   *os.Process
+	job syscall.Handle
+}
+
+// setProcGroup does nothing since the process is added to a job object once it
+// has been started.
+func setProcGroup(cmd *exec.Cmd) {}
+
+func newProcGroup(p *os.Process) (*procGroup, error) {
+	job, _, err := procCreateJobObjectW.Call(0, 0)
+	if job == 0 {
+		return nil, fmt.Errorf("can't create job object: %s", err)
+	}
+	g := &procGroup{p: p, job: syscall.Handle(job)}
+
+	h, err := syscall.OpenProcess(processSetQuota|processTerminate, false, uint32(p.Pid))
+	if err != nil {
+		g.close()
+		return nil, fmt.Errorf("can't open process: %s", err)
+	}
+	defer syscall.CloseHandle(h)
+
+	if r, _, err := procAssignProcessToJobObject.Call(job, uintptr(h)); r == 0 {
+		g.close()
+		return nil, fmt.Errorf("can't assign process to job object: %s", err)
+	}
+	return g, nil
+}
+
+// alive reports whether any process of the job object is running.
+func (g *procGroup) alive() bool {
+	var info jobObjectBasicAccountingInformation
+	r, _, _ := procQueryInformationJobObject.Call(uintptr(g.job), infoBasicAccounting,
+		uintptr(unsafe.Pointer(&info)), unsafe.Sizeof(info), 0)
+	return r != 0 && info.ActiveProcesses != 0
+}
+
+// terminate kills the processes, since Windows has not a signal to ask them to
+// exit.
+func (g *procGroup) terminate() { g.kill() }
+
+func (g *procGroup) kill() { procTerminateJobObject.Call(uintptr(g.job), 1) }
+
+func (g *procGroup) close() { syscall.CloseHandle(g.job) }
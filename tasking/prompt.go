@@ -0,0 +1,103 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+var assumeYes = flag.Bool("task.yes", false, "answer yes to the confirmations of tasks, for non-interactive runs")
+
+// The prompts of tasks run in parallel are serialized.
+var (
+	promptMu sync.Mutex
+	stdin    = bufio.NewReader(os.Stdin)
+)
+
+// Confirm asks the question at the terminal and reports whether the answer is
+// yes. It returns true without asking when the -task.yes flag is set, so that a
+// run in CI can confirm the operations beforehand. The task fails if there is no
+// terminal to ask.
+//
+//	if !t.Confirm("Really drop the production DB?") {
+//		t.Skip("not confirmed")
+//	}
+func (t *T) Confirm(question string) bool {
+	if *assumeYes {
+		t.log(fmt.Sprintf("%s yes (-task.yes)", question), nil)
+		return true
+	}
+	answer, err := t.readAnswer(question+" [y/N] ", false)
+	if err != nil {
+		t.log("tasking: "+err.Error(), nil)
+		t.FailNow()
+	}
+
+	switch strings.ToLower(answer) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
+// Prompt asks the question at the terminal and returns the answer, without
+// surrounding spaces. The task fails if there is no terminal to ask.
+func (t *T) Prompt(question string) string {
+	answer, err := t.readAnswer(question+" ", false)
+	if err != nil {
+		t.log("tasking: "+err.Error(), nil)
+		t.FailNow()
+	}
+	return answer
+}
+
+// PromptSecret is like Prompt but the answer, like a password, is not echoed.
+func (t *T) PromptSecret(question string) string {
+	answer, err := t.readAnswer(question+" ", true)
+	if err != nil {
+		t.log("tasking: "+err.Error(), nil)
+		t.FailNow()
+	}
+	return answer
+}
+
+// readAnswer prints the question to standard error and reads a line from the
+// terminal, hiding it if secret is set.
+func (t *T) readAnswer(question string, secret bool) (string, error) {
+	if !isTerminal(os.Stdin) {
+		return "", fmt.Errorf("no terminal to ask %q; run interactively or use -task.yes for confirmations",
+			strings.TrimSpace(question))
+	}
+	t.Progress()
+
+	promptMu.Lock()
+	defer promptMu.Unlock()
+
+	fmt.Fprintf(os.Stderr, "%s: %s", t.name, question)
+	if secret {
+		restore, err := disableEcho(os.Stdin)
+		if err != nil {
+			return "", fmt.Errorf("can't hide the input: %s", err)
+		}
+		defer func() {
+			restore()
+			fmt.Fprintln(os.Stderr)
+		}()
+	}
+
+	answer, err := stdin.ReadString('\n')
+	if err != nil && answer == "" {
+		return "", fmt.Errorf("can't read the answer: %s", err)
+	}
+	t.Progress()
+	return strings.TrimSpace(answer), nil
+}
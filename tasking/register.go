@@ -0,0 +1,87 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+var (
+	registeredMu sync.Mutex
+	registered   []InternalTask // Tasks added by Register.
+)
+
+// Register adds a task named name which runs f, in addition to the TaskXxx
+// functions of the task files. It is intended to be called from an init function
+// of the task files, so that tasks can be generated programmatically:
+//
+//	func init() {
+//		for _, svc := range []string{"api", "web"} {
+//			svc := svc
+//			tasking.Register("TaskDeploy_"+svc, func(t *tasking.T) {
+//				t.Exec("./deploy.sh", svc)
+//			})
+//		}
+//	}
+//
+// The registered tasks are run after the task functions, in the order of
+// registration, and they are selected by -task.run and -task.names and listed
+// by -task.list like them. A task function can depend on them through the
+// directive "gake:deps".
+//
+// Register panics if the name is empty, has spaces, commas or slashes, or it is
+// already registered; it exits if the name is of a task function.
+func Register(name string, f func(*T)) {
+	if name == "" || strings.ContainsAny(name, " \t\n,/") {
+		panic(fmt.Sprintf("tasking: invalid task name %q", name))
+	}
+	if f == nil {
+		panic("tasking: Register of " + name + " with a nil function")
+	}
+
+	task := InternalTask{Name: name, F: f}
+	// The task files are built into a temporary directory.
+	if _, file, line, ok := runtime.Caller(1); ok {
+		task.File, task.Line = filepath.Base(file), line
+	}
+
+	registeredMu.Lock()
+	defer registeredMu.Unlock()
+	for _, t := range registered {
+		if t.Name == name {
+			panic("tasking: task " + name + " registered twice")
+		}
+	}
+	registered = append(registered, task)
+}
+
+// withRegistered returns the tasks followed by the registered ones.
+func withRegistered(tasks []InternalTask) []InternalTask {
+	registeredMu.Lock()
+	defer registeredMu.Unlock()
+	if len(registered) == 0 {
+		return tasks
+	}
+
+	names := make(map[string]bool, len(tasks))
+	for _, task := range tasks {
+		names[task.Name] = true
+	}
+	for _, task := range registered {
+		if names[task.Name] {
+			fmt.Fprintf(os.Stderr, "tasking: %s:%d: task %s registered, but it is a task function\n",
+				task.File, task.Line, task.Name)
+			os.Exit(EXIT_USAGE)
+		}
+	}
+	return append(append(make([]InternalTask, 0, len(tasks)+len(registered)), tasks...), registered...)
+}
@@ -0,0 +1,94 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Environment variables, set by gake from the table "cache" of "gake.toml",
+// which configure the remote cache.
+const (
+	ENV_REMOTE          = "GAKECACHE_REMOTE"   // URL of the store.
+	ENV_REMOTE_TOKEN    = "GAKECACHE_TOKEN"    // Bearer token.
+	ENV_REMOTE_READONLY = "GAKECACHE_READONLY" // Set to not upload.
+	ENV_REMOTE_SCOPE    = "GAKECACHE_SCOPE"    // Directory of the tasks into the project.
+)
+
+var remoteClient = &http.Client{Timeout: 30 * time.Second}
+
+// remoteName returns the name, into the remote cache, of the data of kind
+// stored under the key; or "" if there is no remote cache.
+//
+// The remote cache is a HTTP server where the data is got by GET and stored by
+// PUT at "URL/kind/name", such as a WebDAV server or a bucket behind a gateway.
+func remoteName(kind, key string) string {
+	remote := os.Getenv(ENV_REMOTE)
+	if remote == "" {
+		return ""
+	}
+	h := sha256.Sum256([]byte(os.Getenv(ENV_REMOTE_SCOPE) + "\x00" + key))
+	return strings.TrimSuffix(remote, "/") + "/" + kind + "/" + hex.EncodeToString(h[:])
+}
+
+// remoteGet returns the data at url into the remote cache; nil if it is not
+// found.
+func remoteGet(url string) ([]byte, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := remoteDo(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return io.ReadAll(resp.Body)
+	case http.StatusNotFound:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+}
+
+// remotePut stores the data at url into the remote cache, unless it is read-only.
+func remotePut(url string, data []byte) error {
+	if os.Getenv(ENV_REMOTE_READONLY) != "" {
+		return nil
+	}
+	req, err := http.NewRequest("PUT", url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp, err := remoteDo(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("PUT %s: %s", url, resp.Status)
+	}
+	return nil
+}
+
+func remoteDo(req *http.Request) (*http.Response, error) {
+	if token := os.Getenv(ENV_REMOTE_TOKEN); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return remoteClient.Do(req)
+}
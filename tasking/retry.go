@@ -0,0 +1,190 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Backoff gives the delays between the attempts of Retry: the delay after the
+// attempt n is Initial * Factor^(n-1), up to Max, randomized by Jitter so that
+// the clients failing at once do not retry at once.
+type Backoff struct {
+	Initial time.Duration // Delay after the first attempt.
+	Max     time.Duration // Maximum delay; 0 means no maximum.
+	Factor  float64       // Growth of the delay per attempt; 0 means 2.
+	Jitter  float64       // Fraction of the delay which is random, from 0 to 1.
+}
+
+// DefaultBackoff is a backoff suited to the APIs of external services.
+var DefaultBackoff = Backoff{
+	Initial: 500 * time.Millisecond,
+	Max:     30 * time.Second,
+	Factor:  2,
+	Jitter:  0.2,
+}
+
+var (
+	randMu sync.Mutex
+	random = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// Delay returns the delay after the attempt n, from 1.
+func (b Backoff) Delay(n int) time.Duration {
+	factor := b.Factor
+	if factor == 0 {
+		factor = 2
+	}
+	d := float64(b.Initial)
+	for i := 1; i < n && (b.Max <= 0 || d < float64(b.Max)); i++ {
+		d *= factor
+	}
+	if b.Max > 0 && d > float64(b.Max) {
+		d = float64(b.Max)
+	}
+
+	if b.Jitter > 0 {
+		randMu.Lock()
+		r := random.Float64()
+		randMu.Unlock()
+		// The delay is reduced by up to the fraction Jitter.
+		d -= d * b.Jitter * r
+	}
+	return time.Duration(d)
+}
+
+// permanentError is an error which must not be retried.
+type permanentError struct{ err error }
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps the error so that Retry returns it without more attempts,
+// like a response "404 Not Found" or invalid credentials.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err}
+}
+
+// Retry calls fn until it returns nil, up to the given number of attempts,
+// waiting between them the delays given by the backoff. The failed attempts
+// are logged into the output of the task. It returns the error of the last
+// attempt, or the error wrapped by Permanent which stopped the retries.
+func Retry(t *T, attempts int, backoff Backoff, fn func() error) error {
+	return retry(context.Background(), t, attempts, backoff, func(context.Context) error { return fn() })
+}
+
+// RetryContext is like Retry, but it stops waiting and returns the error of the
+// context when the context is done. The context is passed to fn.
+func RetryContext(ctx context.Context, t *T, attempts int, backoff Backoff, fn func(ctx context.Context) error) error {
+	return retry(ctx, t, attempts, backoff, fn)
+}
+
+func retry(ctx context.Context, t *T, attempts int, backoff Backoff, fn func(context.Context) error) error {
+	for n := 1; ; n++ {
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
+		var perm *permanentError
+		if errors.As(err, &perm) {
+			return perm.err
+		}
+		if n >= attempts {
+			if attempts > 1 {
+				return fmt.Errorf("after %d attempts: %w", attempts, err)
+			}
+			return err
+		}
+
+		delay := backoff.Delay(n)
+		t.write(fmt.Sprintf("\tattempt %d of %d failed: %s; retrying in %v\n",
+			n, attempts, err, delay.Round(time.Millisecond)), nil)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// RateLimit limits the rate of the operations, such as the requests to an API,
+// shared by the tasks and the branches which use it: at most n operations per
+// period, in bursts of up to n.
+type RateLimit struct {
+	mu       sync.Mutex
+	n        float64       // Capacity of the bucket.
+	interval time.Duration // Time to get a token.
+	tokens   float64
+	last     time.Time // Time when the tokens were updated.
+}
+
+// NewRateLimit returns a rate limit of n operations per period.
+func NewRateLimit(n int, period time.Duration) *RateLimit {
+	if n <= 0 || period <= 0 {
+		panic("tasking: invalid rate limit")
+	}
+	return &RateLimit{
+		n:        float64(n),
+		interval: period / time.Duration(n),
+		tokens:   float64(n),
+		last:     time.Now(),
+	}
+}
+
+// Wait waits until an operation is allowed.
+func (r *RateLimit) Wait() {
+	r.WaitContext(context.Background())
+}
+
+// WaitContext waits until an operation is allowed or the context is done, and
+// returns the error of the context in the latter case.
+func (r *RateLimit) WaitContext(ctx context.Context) error {
+	for {
+		delay := r.reserve()
+		if delay == 0 {
+			return nil
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// reserve takes a token if there is one, or returns the time to wait for it.
+func (r *RateLimit) reserve() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	r.tokens += float64(now.Sub(r.last)) / float64(r.interval)
+	if r.tokens > r.n {
+		r.tokens = r.n
+	}
+	r.last = now
+
+	if r.tokens >= 1 {
+		r.tokens--
+		return 0
+	}
+	return time.Duration((1 - r.tokens) * float64(r.interval))
+}
@@ -0,0 +1,129 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Environment variables set by gake to describe its run.
+const (
+	ENV_COMMAND  = "GAKE_COMMAND"  // Command line of gake.
+	ENV_INJECTED = "GAKE_INJECTED" // Comma-separated names of the variables set by gake.
+)
+
+// RunInfo describes how the tasks were run, so that a run can be reproduced
+// from its report: it is printed at the start of the run with the -task.v flag,
+// set in the "start" event with -task.json, and in the properties of the JUnit
+// report.
+type RunInfo struct {
+	Command string            `json:",omitempty"` // Command line of gake.
+	Args    []string          // Arguments of the task binary.
+	Flags   map[string]string // Value of every flag of the task binary.
+	Env     []string          `json:",omitempty"` // Names of the variables set by gake.
+	Gake    string            `json:",omitempty"` // Version of gake.
+	Go      string            // Version of Go which built the task binary.
+	OS      string
+	Arch    string
+	Host    string
+	CPUs    int
+	CI      string // CI provider, as returned by CIProvider.
+	Dir     string // Working directory.
+}
+
+// runInfo returns the information of the actual run.
+func runInfo() RunInfo {
+	info := RunInfo{
+		Command: os.Getenv(ENV_COMMAND),
+		Args:    os.Args[1:],
+		Flags:   make(map[string]string),
+		Go:      runtime.Version(),
+		OS:      runtime.GOOS,
+		Arch:    runtime.GOARCH,
+		CPUs:    runtime.NumCPU(),
+		CI:      CIProvider(),
+	}
+	if v := os.Getenv(ENV_INJECTED); v != "" {
+		info.Env = strings.Split(v, ",")
+	}
+	bi := gakeBuildInfo
+	if i := strings.IndexByte(bi, ':'); i != -1 {
+		bi = bi[i+1:]
+	}
+	for _, field := range strings.Fields(strings.Trim(bi, "\xff")) {
+		if strings.HasPrefix(field, "gake=") {
+			info.Gake = strings.TrimPrefix(field, "gake=")
+		}
+	}
+	info.Host, _ = os.Hostname()
+	info.Dir, _ = os.Getwd()
+
+	flag.VisitAll(func(f *flag.Flag) {
+		if strings.HasPrefix(f.Name, "task.") {
+			info.Flags[f.Name] = f.Value.String()
+		}
+	})
+	return info
+}
+
+// properties returns the information as pairs of name and value, in order.
+func (info RunInfo) properties() [][2]string {
+	props := [][2]string{
+		{"command", info.Command},
+		{"args", strings.Join(quoteArgs(info.Args), " ")},
+		{"env", strings.Join(info.Env, ",")},
+		{"gake", info.Gake},
+		{"go", info.Go},
+		{"os", info.OS + "/" + info.Arch},
+		{"host", info.Host},
+		{"cpus", strconv.Itoa(info.CPUs)},
+		{"ci", info.CI},
+		{"dir", info.Dir},
+	}
+
+	names := make([]string, 0, len(info.Flags))
+	for name := range info.Flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		props = append(props, [2]string{"flag." + strings.TrimPrefix(name, "task."), info.Flags[name]})
+	}
+	return props
+}
+
+// printRunInfo prints the header of the run, without the flags, which are
+// already in the arguments.
+func printRunInfo(info RunInfo) {
+	fmt.Println("=== RUN INFO")
+	for _, p := range info.properties() {
+		if strings.HasPrefix(p[0], "flag.") {
+			break
+		}
+		if p[1] != "" {
+			fmt.Printf("\t%s: %s\n", p[0], p[1])
+		}
+	}
+}
+
+// quoteArgs quotes the arguments which are not plain words.
+func quoteArgs(args []string) []string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		if a == "" || strings.ContainsAny(a, " \t\n\"'\\$`;&|<>*?()[]{}#~") {
+			a = strconv.Quote(a)
+		}
+		quoted[i] = a
+	}
+	return quoted
+}
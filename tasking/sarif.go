@@ -0,0 +1,162 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var sarifFile = flag.String("task.sarif", "", "write the findings reported by the tasks to the named file, in SARIF")
+
+// Severities of a finding, which are the levels of SARIF.
+const (
+	SEVERITY_ERROR   = "error"
+	SEVERITY_WARNING = "warning"
+	SEVERITY_NOTE    = "note"
+)
+
+// SARIF_SCHEMA is the JSON schema of the SARIF files written by -task.sarif.
+const SARIF_SCHEMA = "https://json.schemastore.org/sarif-2.1.0.json"
+
+// Finding is a problem in a source file reported by a task, like the ones of a
+// linter or a scanner which it runs.
+type Finding struct {
+	File     string // Path relative to the working directory, with slashes.
+	Line     int    `json:",omitempty"` // 0 if it is not known.
+	Severity string // SEVERITY_ERROR, SEVERITY_WARNING or SEVERITY_NOTE.
+	Message  string
+}
+
+// ReportFinding records a problem found into the line of the file, logging it
+// like "file:line: severity: msg". The severity is SEVERITY_ERROR,
+// SEVERITY_WARNING or SEVERITY_NOTE; line is 0 if it is not known. A finding
+// does not fail the task.
+//
+// The findings of all the tasks are written to the file given by the flag
+// -task.sarif, in SARIF 2.1.0, whose results are named by the task, so that
+// they can be uploaded to code scanning services.
+func (t *T) ReportFinding(file string, line int, severity, msg string) {
+	switch severity {
+	case SEVERITY_ERROR, SEVERITY_WARNING, SEVERITY_NOTE:
+	default:
+		t.log(fmt.Sprintf("tasking: invalid severity %q of finding; want error, warning or note", severity), nil)
+		t.FailNow()
+	}
+	if filepath.IsAbs(file) {
+		if wd, err := os.Getwd(); err == nil {
+			if rel, err := filepath.Rel(wd, file); err == nil && !strings.HasPrefix(rel, "..") {
+				file = rel
+			}
+		}
+	}
+	f := Finding{File: filepath.ToSlash(file), Line: line, Severity: severity, Message: msg}
+
+	loc := f.File
+	if line > 0 {
+		loc = fmt.Sprintf("%s:%d", loc, line)
+	}
+	t.write(fmt.Sprintf("\t%s: %s: %s\n", loc, severity, msg), nil)
+
+	t.mu.Lock()
+	t.findings = append(t.findings, f)
+	t.mu.Unlock()
+}
+
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver struct {
+		Name    string      `json:"name"`
+		Version string      `json:"version,omitempty"`
+		Rules   []sarifRule `json:"rules"`
+	} `json:"driver"`
+}
+
+type sarifRule struct {
+	ID               string     `json:"id"`
+	ShortDescription *sarifText `json:"shortDescription,omitempty"`
+}
+
+type sarifText struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifText       `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation struct {
+		ArtifactLocation struct {
+			URI string `json:"uri"`
+		} `json:"artifactLocation"`
+		Region *sarifRegion `json:"region,omitempty"`
+	} `json:"physicalLocation"`
+}
+
+type sarifRegion struct {
+	StartLine int `json:"startLine"`
+}
+
+// writeSARIF writes the findings of the tasks to the named file, as a run of
+// SARIF where every task with findings is a rule.
+func writeSARIF(name string, info RunInfo) error {
+	var run sarifRun
+	run.Tool.Driver.Name = "gake"
+	if info.Gake != "(devel)" {
+		run.Tool.Driver.Version = info.Gake
+	}
+	run.Tool.Driver.Rules = make([]sarifRule, 0)
+	run.Results = make([]sarifResult, 0)
+
+	rules := make(map[string]bool)
+	resultsMu.Lock()
+	for _, t := range results {
+		t.mu.RLock()
+		rule := baseName(t.cpuTask())
+		for _, f := range t.findings {
+			if !rules[rule] {
+				rules[rule] = true
+				run.Tool.Driver.Rules = append(run.Tool.Driver.Rules,
+					sarifRule{ID: rule, ShortDescription: &sarifText{"Findings of the task " + rule}})
+			}
+			r := sarifResult{RuleID: rule, Level: f.Severity, Message: sarifText{f.Message}}
+			var loc sarifLocation
+			loc.PhysicalLocation.ArtifactLocation.URI = f.File
+			if f.Line > 0 {
+				loc.PhysicalLocation.Region = &sarifRegion{f.Line}
+			}
+			r.Locations = []sarifLocation{loc}
+			run.Results = append(run.Results, r)
+		}
+		t.mu.RUnlock()
+	}
+	resultsMu.Unlock()
+
+	b, err := json.MarshalIndent(sarifLog{"2.1.0", SARIF_SCHEMA, []sarifRun{run}}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(name, append(b, '\n'), 0644)
+}
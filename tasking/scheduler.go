@@ -0,0 +1,363 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Options sets how RunTasksWithOptions runs the tasks. Its zero value runs them
+// like RunTasks, as set by the flags.
+type Options struct {
+	Match    string // Pattern given to matchString; "" means the flag -task.run.
+	CPU      []int  // Values of GOMAXPROCS to run the tasks with; nil means the flag -task.cpu.
+	Parallel int    // Capacity shared by the weights of the tasks; 0 means the flag -task.parallel.
+
+	// OrderedOutput reports the parallel tasks in the order of declaration;
+	// false means the flag -task.ordered-output.
+	OrderedOutput bool
+
+	// Clock returns the current time, to measure the tasks; nil means
+	// time.Now.
+	Clock func() time.Time
+
+	// Runner runs the function of the task, from the goroutine of the task;
+	// nil means calling task.F(t). It can replace or wrap the tasks, like to
+	// test the order in which they are run.
+	Runner func(t *T, task *InternalTask)
+}
+
+// RunTasks runs the tasks which match the flag -task.run, and reports whether
+// all of them have passed. An invalid pattern exits with the status of a usage
+// error.
+func RunTasks(matchString func(pat, str string) (bool, error), tasks []InternalTask) (ok bool) {
+	ok, err := RunTasksWithOptions(matchString, tasks, Options{})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tasking: invalid regexp for -task.run: %s\n", err)
+		os.Exit(EXIT_USAGE)
+	}
+	return ok
+}
+
+// RunTasksWithOptions is like RunTasks, with the options which replace the
+// flags and the functions used to run the tasks. It returns the error of
+// matchString, before any task is run.
+func RunTasksWithOptions(matchString func(pat, str string) (bool, error), tasks []InternalTask, opts Options) (ok bool, err error) {
+	ok = true
+	if len(tasks) == 0 /*&& !haveExamples*/ {
+		fmt.Fprintln(os.Stderr, "tasking: warning: no tasks to run")
+		return
+	}
+	if opts.Match == "" {
+		opts.Match = *match
+	}
+	if opts.CPU == nil {
+		opts.CPU = cpuList
+	}
+	if opts.Parallel <= 0 {
+		opts.Parallel = *parallel
+	}
+	if !opts.OrderedOutput {
+		opts.OrderedOutput = *orderedOutput
+	}
+	if opts.Clock == nil {
+		opts.Clock = time.Now
+	}
+
+	for _, procs := range opts.CPU {
+		runtime.GOMAXPROCS(procs)
+		s := newScheduler(matchString, opts, procs)
+		passed, err := s.run(tasks)
+		if err != nil {
+			return false, err
+		}
+		if !passed {
+			ok = false
+		}
+	}
+	return
+}
+
+// scheduler runs the tasks with a value of GOMAXPROCS: it starts the ones which
+// match in order, once their dependencies are done, and the parallel ones as
+// the capacity and the resources held by the running ones allow.
+type scheduler struct {
+	matchString func(pat, str string) (bool, error)
+	opts        Options
+	procs       int // GOMAXPROCS of the run; 0 with a single value of Options.CPU.
+	ok          bool
+
+	// collector merges in one channel all the upstream signals from parallel
+	// tasks. If all tasks pump to the same channel, a bug can occur where a
+	// task kicks off a goroutine that Fails, yet the task still delivers a
+	// completion signal, which skews the counting.
+	collector chan interface{}
+	abort     <-chan struct{}
+
+	waiting []*T            // Parallel tasks waiting to start.
+	held    map[string]bool // Resources used by running tasks.
+	running int             // Number of running tasks.
+	load    int             // Sum of weights of running tasks.
+
+	// With -task.ordered-output, the parallel tasks are reported in the
+	// order of declaration, once the previous ones are done.
+	order []*T
+	done  map[*T]bool
+
+	started map[string][]*T // Tasks started, by their task function.
+	groups  *procSet        // Processes launched by the tasks.
+
+	mu      sync.Mutex
+	pending int // Sum of weights of the tasks not finished, to share -task.timeout.
+}
+
+// Schedulers running, whose processes are stopped when the run is interrupted
+// or times out.
+var (
+	runningMu sync.Mutex
+	running   = make(map[*scheduler]bool)
+)
+
+// stopAllProcesses stops the processes left by the tasks of all the running
+// schedulers.
+func stopAllProcesses() {
+	runningMu.Lock()
+	scheds := make([]*scheduler, 0, len(running))
+	for s := range running {
+		scheds = append(scheds, s)
+	}
+	runningMu.Unlock()
+
+	for _, s := range scheds {
+		s.groups.stopAll()
+	}
+}
+
+// newScheduler returns a scheduler of a run with GOMAXPROCS set to procs.
+func newScheduler(matchString func(pat, str string) (bool, error), opts Options, procs int) *scheduler {
+	s := &scheduler{
+		matchString: matchString,
+		opts:        opts,
+		ok:          true,
+		collector:   make(chan interface{}),
+		abort:       aborted(),
+		waiting:     make([]*T, 0),
+		held:        make(map[string]bool),
+		order:       make([]*T, 0),
+		done:        make(map[*T]bool),
+		started:     make(map[string][]*T),
+		groups:      newProcSet(),
+	}
+	if len(opts.CPU) > 1 {
+		s.procs = procs
+	}
+	return s
+}
+
+// run runs the tasks, and reports whether all of them have passed. It returns
+// the error of matchString, before any task is run.
+func (s *scheduler) run(tasks []InternalTask) (bool, error) {
+	matched := make([]*InternalTask, 0, len(tasks))
+	for i := range tasks {
+		ok, err := s.matchString(s.opts.Match, tasks[i].Name)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			continue
+		}
+		matched = append(matched, &tasks[i])
+		if tasks[i].Weight > 0 {
+			s.pending += tasks[i].Weight
+		} else {
+			s.pending++
+		}
+	}
+
+	runningMu.Lock()
+	running[s] = true
+	runningMu.Unlock()
+	defer func() {
+		runningMu.Lock()
+		delete(running, s)
+		runningMu.Unlock()
+	}()
+
+	for _, task := range matched {
+		s.start(task)
+	}
+	s.runParallel(func() bool { return false })
+	return s.ok, nil
+}
+
+// newTask returns the task to run the internal one.
+func (s *scheduler) newTask(task *InternalTask) *T {
+	t := &T{
+		common: common{
+			signal: make(chan interface{}),
+		},
+		name:          task.Name,
+		startParallel: make(chan bool),
+		mutexes:       append([]string(nil), task.Mutexes...),
+		weight:        task.Weight,
+		params:        task.Params,
+		limits:        task.Limits,
+		matrix:        task.matrix,
+		entry:         task.entry,
+		groups:        task.Groups,
+		xfail:         task.XFail,
+		user:          task.User,
+		disabledDeps:  task.disabledDeps,
+		cpu:           s.procs,
+		clock:         s.opts.Clock,
+		runner:        s.opts.Runner,
+		sched:         s,
+	}
+	if t.cpu != 0 {
+		t.name = cpuName(t.name, t.cpu)
+	}
+	if t.weight <= 0 {
+		t.weight = 1
+	}
+	for _, dep := range task.Deps {
+		t.deps = append(t.deps, s.started[dep]...)
+	}
+	t.resumed = canResume(t)
+	base := baseName(task.Name)
+	s.started[base] = append(s.started[base], t)
+	return t
+}
+
+// start runs the task, once its dependencies are done. It returns once the task
+// is done, or it has called Parallel.
+func (s *scheduler) start(task *InternalTask) {
+	t := s.newTask(task)
+
+	// The dependencies are finished before the task is started.
+	s.runParallel(func() bool {
+		for _, d := range t.deps {
+			if !s.done[d] {
+				return false
+			}
+		}
+		return true
+	})
+
+	t.self = t
+	if isAborted() {
+		t.notRun()
+		s.finish(t)
+		t.report()
+		return
+	}
+	t.w = t.newOutput(t.name)
+	publish(Event{Action: "run", Task: t.name, File: task.File, Line: task.Line, CPU: t.cpu})
+	if *jsonOutput {
+		emit(Event{Action: "run", Task: t.name, File: task.File, Line: task.Line, CPU: t.cpu})
+	} else if *chatty {
+		fmt.Printf("=== RUN %s\n", t.name)
+	}
+	go tRunner(t, task)
+	out := (<-t.signal).(*T)
+	if out == nil { // Parallel run.
+		go func() {
+			s.collector <- <-t.signal
+		}()
+		s.waiting = append(s.waiting, t)
+		s.order = append(s.order, t)
+		return
+	}
+	s.finish(t)
+	t.report()
+}
+
+// finish records the result of a task which is done.
+func (s *scheduler) finish(t *T) {
+	s.done[t] = true
+	s.mu.Lock()
+	s.pending -= t.weight
+	s.mu.Unlock()
+	recordCheckpoint(t)
+	if t.Failed() {
+		s.ok = false
+		if *failFast {
+			abortRun("task " + t.name + " failed with -task.failfast")
+		}
+	}
+}
+
+// runParallel runs the waiting tasks until stop returns true, or until all of
+// them are done. When the run is aborted, the waiting tasks are released
+// without being run, and the running ones are waited for.
+func (s *scheduler) runParallel(stop func() bool) {
+	for len(s.waiting)+s.running > 0 && !stop() {
+		if isAborted() {
+			for _, t := range s.waiting {
+				close(t.startParallel)
+				s.running++
+			}
+			s.waiting = s.waiting[:0]
+		} else if i := nextParallel(s.waiting, s.held, s.opts.Parallel-s.load, s.running == 0); i != -1 {
+			t := s.waiting[i]
+			s.waiting = append(s.waiting[:i], s.waiting[i+1:]...)
+			for _, r := range t.mutexes {
+				s.held[r] = true
+			}
+			t.startParallel <- true
+			s.running++
+			s.load += t.weight
+			continue
+		}
+		var t *T
+		select {
+		case v := <-s.collector:
+			t = v.(*T)
+		case <-s.abort:
+			s.abort = nil // Handled once.
+			continue
+		}
+		if !t.aborted {
+			for _, r := range t.mutexes {
+				delete(s.held, r)
+			}
+			s.load -= t.weight
+		}
+		s.finish(t)
+		if s.opts.OrderedOutput {
+			for ; len(s.order) != 0 && s.done[s.order[0]]; s.order = s.order[1:] {
+				s.order[0].report()
+			}
+		} else {
+			t.report()
+		}
+		s.running--
+	}
+}
+
+// nextParallel returns the index of the first task in waiting which fits in the
+// free capacity and does not use any of the held resources, or -1 if there is
+// none. When idle is set, a task heavier than the capacity is also accepted since
+// it could not be run otherwise.
+func nextParallel(waiting []*T, held map[string]bool, free int, idle bool) int {
+Tasks:
+	for i, t := range waiting {
+		if t.weight > free && !idle {
+			continue
+		}
+		for _, r := range t.mutexes {
+			if held[r] {
+				continue Tasks
+			}
+		}
+		return i
+	}
+	return -1
+}
@@ -0,0 +1,177 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRun records the tasks run by a scheduler, with the options to run them.
+type fakeRun struct {
+	mu      sync.Mutex
+	events  []string // "start Name" and "end Name", in order.
+	load    int      // Sum of weights of the tasks running.
+	maxLoad int
+
+	parallel map[string]bool // Tasks which call Parallel.
+	panics   map[string]bool // Tasks which panic.
+}
+
+func (r *fakeRun) record(event string) {
+	r.mu.Lock()
+	r.events = append(r.events, event)
+	r.mu.Unlock()
+}
+
+// runner is the function given to Options.Runner.
+func (r *fakeRun) runner(t *T, task *InternalTask) {
+	if r.parallel[task.Name] {
+		t.Parallel()
+	}
+	r.mu.Lock()
+	r.events = append(r.events, "start "+task.Name)
+	r.load += t.weight
+	if r.load > r.maxLoad {
+		r.maxLoad = r.load
+	}
+	r.mu.Unlock()
+
+	defer func() {
+		r.mu.Lock()
+		r.load -= t.weight
+		r.mu.Unlock()
+		r.record("end " + task.Name)
+	}()
+	if r.parallel[task.Name] {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if r.panics[task.Name] {
+		panic("boom")
+	}
+}
+
+// run runs the tasks by a scheduler with the given capacity, and returns
+// whether they have passed.
+func (r *fakeRun) run(tasks []InternalTask, parallel int) (bool, error) {
+	return RunTasksWithOptions(matchAny(tasks), tasks, Options{
+		CPU:      []int{1},
+		Parallel: parallel,
+		Runner:   r.runner,
+	})
+}
+
+// index returns the position of the event, or -1.
+func (r *fakeRun) index(event string) int {
+	for i, e := range r.events {
+		if e == event {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestSchedulerOrder(t *testing.T) {
+	tasks := []InternalTask{{Name: "TaskA"}, {Name: "TaskB"}, {Name: "TaskC"}}
+	r := &fakeRun{}
+	if ok, err := r.run(tasks, 1); !ok || err != nil {
+		t.Fatalf("run = %v, %v; want true, nil", ok, err)
+	}
+	want := "start TaskA,end TaskA,start TaskB,end TaskB,start TaskC,end TaskC"
+	if got := strings.Join(r.events, ","); got != want {
+		t.Errorf("events = %s; want %s", got, want)
+	}
+}
+
+func TestSchedulerWeights(t *testing.T) {
+	tests := []struct {
+		weights  []int
+		parallel int
+		maxLoad  int
+	}{
+		{[]int{1, 1, 1, 1}, 2, 2},
+		{[]int{2, 1, 1}, 2, 2},
+		{[]int{1, 1, 1, 1}, 4, 4},
+		// A task heavier than the capacity is run alone.
+		{[]int{3, 1}, 2, 3},
+	}
+	for _, tt := range tests {
+		r := &fakeRun{parallel: make(map[string]bool)}
+		tasks := make([]InternalTask, len(tt.weights))
+		for i, w := range tt.weights {
+			tasks[i] = InternalTask{Name: "Task" + string(rune('A'+i)), Weight: w}
+			r.parallel[tasks[i].Name] = true
+		}
+		if ok, err := r.run(tasks, tt.parallel); !ok || err != nil {
+			t.Fatalf("weights %v: run = %v, %v; want true, nil", tt.weights, ok, err)
+		}
+		if len(r.events) != 2*len(tasks) {
+			t.Errorf("weights %v: events = %v; want all the tasks run", tt.weights, r.events)
+		}
+		if r.maxLoad != tt.maxLoad {
+			t.Errorf("weights %v, parallel %d: maximum load = %d; want %d",
+				tt.weights, tt.parallel, r.maxLoad, tt.maxLoad)
+		}
+	}
+}
+
+func TestSchedulerDeps(t *testing.T) {
+	tasks := []InternalTask{
+		{Name: "TaskA"},
+		{Name: "TaskB", Deps: []string{"TaskA"}},
+		{Name: "TaskC"},
+		{Name: "TaskD", Deps: []string{"TaskB", "TaskC"}},
+	}
+	r := &fakeRun{parallel: map[string]bool{"TaskA": true, "TaskB": true, "TaskC": true}}
+	if ok, err := r.run(tasks, 4); !ok || err != nil {
+		t.Fatalf("run = %v, %v; want true, nil", ok, err)
+	}
+	for _, d := range [][2]string{{"TaskA", "TaskB"}, {"TaskB", "TaskD"}, {"TaskC", "TaskD"}} {
+		end, start := r.index("end "+d[0]), r.index("start "+d[1])
+		if end == -1 || start == -1 || start < end {
+			t.Errorf("%s started before the end of its dependency %s: %v", d[1], d[0], r.events)
+		}
+	}
+}
+
+func TestSchedulerPanic(t *testing.T) {
+	tasks := []InternalTask{{Name: "TaskA"}, {Name: "TaskB"}, {Name: "TaskC"}, {Name: "TaskD"}}
+	r := &fakeRun{
+		parallel: map[string]bool{"TaskC": true, "TaskD": true},
+		panics:   map[string]bool{"TaskA": true, "TaskC": true},
+	}
+	ok, err := r.run(tasks, 2)
+	if ok || err != nil {
+		t.Fatalf("run = %v, %v; want false, nil", ok, err)
+	}
+	// A panic fails the task alone.
+	for _, name := range []string{"TaskA", "TaskB", "TaskC", "TaskD"} {
+		if r.index("end "+name) == -1 {
+			t.Errorf("%s not run: %v", name, r.events)
+		}
+	}
+}
+
+func TestSchedulerMatchError(t *testing.T) {
+	errPattern := errors.New("bad pattern")
+	matchString := func(pat, name string) (bool, error) { return false, errPattern }
+
+	r := &fakeRun{}
+	ok, err := RunTasksWithOptions(matchString, []InternalTask{{Name: "TaskA"}}, Options{
+		CPU:    []int{1},
+		Runner: r.runner,
+	})
+	if ok || err != errPattern {
+		t.Errorf("run = %v, %v; want false, %v", ok, err, errPattern)
+	}
+	if len(r.events) != 0 {
+		t.Errorf("events = %v; want no task run", r.events)
+	}
+}
@@ -0,0 +1,252 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// MAX_SERVICE_LINES is the number of lines of output kept by a service to be
+// matched by WaitReady.
+const MAX_SERVICE_LINES = 1000
+
+// Service is a long-lived process started by a task, like a database or a
+// server, which is stopped when the task finishes.
+type Service struct {
+	t    *T
+	name string
+	cmd  *exec.Cmd
+	g    *procGroup
+
+	mu       sync.Mutex
+	lines    []string // Last lines of output.
+	nlines   int      // Number of lines of output.
+	stopping bool
+	err      error // Result of the process, once it has exited.
+
+	newLine  chan bool // Signaled when a line is written.
+	exited   chan bool // Closed once the process has exited.
+	stopOnce sync.Once
+}
+
+// StartService starts the command in background, like a database or a server
+// needed by the task, and returns without waiting for it. Its standard output
+// and standard error, unless they are set, are written to the output of the
+// task, every line prefixed by the name of the program.
+//
+// The service is kept alive while the task runs, and it is stopped when the
+// task finishes like the processes left by Exec; the services are stopped in
+// the reverse order of their start.
+func (t *T) StartService(cmd *exec.Cmd) *Service {
+	t.log(runPrefix()+commandLine(cmd)+" &", nil)
+
+	s := &Service{
+		t:       t,
+		name:    filepath.Base(cmd.Path),
+		cmd:     cmd,
+		newLine: make(chan bool, 1),
+		exited:  make(chan bool),
+	}
+	if *dryRun {
+		return s
+	}
+	if err := s.start(); err != nil {
+		t.log("tasking: can't start service "+s.name+": "+err.Error(), nil)
+		t.FailNow()
+	}
+
+	t.mu.Lock()
+	t.services = append(t.services, s)
+	t.mu.Unlock()
+	return s
+}
+
+func (s *Service) start() error {
+	if s.cmd.Dir == "" {
+		s.cmd.Dir = s.t.workDir()
+	}
+	if err := s.t.setUser(s.cmd); err != nil {
+		return err
+	}
+	var pr, pw *os.File
+	tty := false
+	if s.cmd.Stdout == nil || s.cmd.Stderr == nil {
+		var err error
+		if pr, pw, tty, err = s.t.outputPipe(); err != nil {
+			return err
+		}
+		if s.cmd.Stdout == nil {
+			s.cmd.Stdout = pw
+		}
+		if s.cmd.Stderr == nil {
+			s.cmd.Stderr = pw
+		}
+	}
+
+	setProcGroup(s.cmd)
+	err := s.cmd.Start()
+	if pw != nil {
+		pw.Close()
+	}
+	if err != nil {
+		if pr != nil {
+			pr.Close()
+		}
+		return err
+	}
+
+	if s.g, err = newProcGroup(s.cmd.Process); err != nil {
+		s.t.write("\ttasking: processes not tracked: "+err.Error()+"\n", nil)
+	} else {
+		s.t.sched.groups.add(s.g)
+	}
+
+	copied := make(chan bool)
+	if pr != nil {
+		go func() {
+			out := &lineWriter{c: &s.t.common, prefix: s.name + ": ", line: s.addLine, crlf: tty}
+			io.Copy(out, pr)
+			out.Flush()
+			close(copied)
+		}()
+	} else {
+		close(copied)
+	}
+
+	go func() {
+		err := s.cmd.Wait()
+		if pr != nil {
+			drainPipe(pr, copied)
+			pr.Close()
+		}
+
+		s.mu.Lock()
+		s.err = err
+		stopping := s.stopping
+		s.mu.Unlock()
+		if !stopping {
+			msg := "exited"
+			if err != nil {
+				msg = err.Error()
+			}
+			s.t.write("\tservice "+s.name+": "+msg+"\n", nil)
+		}
+		close(s.exited)
+	}()
+	return nil
+}
+
+// addLine records a line of output, to be matched by WaitReady.
+func (s *Service) addLine(line string) {
+	s.mu.Lock()
+	if len(s.lines) == MAX_SERVICE_LINES {
+		s.lines = append(s.lines[:0], s.lines[MAX_SERVICE_LINES/2:]...)
+	}
+	s.lines = append(s.lines, line)
+	s.nlines++
+	s.mu.Unlock()
+
+	select {
+	case s.newLine <- true:
+	default:
+	}
+}
+
+// WaitReady waits until the service writes a line of output for which match
+// returns true, such as "ready to accept connections"; the lines written since
+// the service was started are matched too. The task fails if the service exits
+// or the timeout expires before.
+//
+// WaitReady must be called from the goroutine running the task. For a service
+// which does not report when it is ready, use WaitForPort.
+func (s *Service) WaitReady(match func(line string) bool, timeout time.Duration) {
+	if *dryRun {
+		return
+	}
+	deadline := time.After(timeout)
+	exited := false
+
+	for n := 0; ; {
+		s.mu.Lock()
+		first := s.nlines - len(s.lines) // Number of the first line kept.
+		if n < first {
+			n = first
+		}
+		lines := s.lines[n-first:]
+		n = s.nlines
+		s.mu.Unlock()
+
+		for _, l := range lines {
+			if match(l) {
+				return
+			}
+		}
+		if exited {
+			s.t.log("tasking: service "+s.name+" exited before being ready", nil)
+			s.t.FailNow()
+		}
+
+		select {
+		case <-s.newLine:
+		case <-s.exited:
+			exited = true
+		case <-deadline:
+			s.t.log("tasking: service "+s.name+" not ready after "+timeout.String(), nil)
+			s.t.FailNow()
+		}
+	}
+}
+
+// Stop stops the service, and waits for it to exit. The process is terminated
+// and, after the period given by the flag -task.kill-grace, killed.
+func (s *Service) Stop() {
+	if *dryRun {
+		return
+	}
+	s.stopOnce.Do(func() {
+		s.mu.Lock()
+		s.stopping = true
+		s.mu.Unlock()
+
+		if s.g != nil {
+			s.t.sched.groups.stop([]*procGroup{s.g})
+		} else {
+			s.cmd.Process.Kill()
+		}
+	})
+	<-s.exited
+}
+
+// Exited reports whether the process of the service has exited, and its error
+// if so.
+func (s *Service) Exited() (bool, error) {
+	select {
+	case <-s.exited:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return true, s.err
+	default:
+		return false, nil
+	}
+}
+
+// stopServices stops the services started by the task, in reverse order.
+func (t *T) stopServices() {
+	t.mu.Lock()
+	services := t.services
+	t.services = nil
+	t.mu.Unlock()
+
+	for i := len(services) - 1; i >= 0; i-- {
+		services[i].Stop()
+	}
+}
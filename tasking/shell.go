@@ -0,0 +1,78 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"os/exec"
+	"runtime"
+	"unicode/utf16"
+)
+
+// Shell runs the script with the shell of the system, like Exec, and waits for
+// it to exit. The script is logged as it is.
+//
+// On Unix, the shell is bash, or sh if bash is not found, and the script stops
+// at the first command which fails, also into a pipeline with bash. On Windows,
+// the shell is PowerShell, "pwsh" or else "powershell", with
+// $ErrorActionPreference set to "Stop"; the script is passed encoded, so that
+// it is not changed by the quoting of the command line of Windows.
+//
+// A task which runs on both systems can give a script for each one with
+// ShellUnix and ShellWindows.
+func (t *T) Shell(script string) error {
+	t.log(runPrefix()+script, nil)
+	return t.exec(shellCommand(script))
+}
+
+// ShellUnix runs the script like Shell on Unix; it does nothing on Windows.
+func (t *T) ShellUnix(script string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	t.log(runPrefix()+script, nil)
+	return t.exec(shellCommand(script))
+}
+
+// ShellWindows runs the script like Shell on Windows; it does nothing on Unix.
+func (t *T) ShellWindows(script string) error {
+	if runtime.GOOS != "windows" {
+		return nil
+	}
+	t.log(runPrefix()+script, nil)
+	return t.exec(shellCommand(script))
+}
+
+// shellCommand returns the command which runs the script with the shell of the
+// system.
+func shellCommand(script string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		name := "pwsh"
+		if _, err := exec.LookPath(name); err != nil {
+			name = "powershell"
+		}
+		return exec.Command(name, "-NoProfile", "-NonInteractive",
+			"-EncodedCommand", encodePowerShell("$ErrorActionPreference = 'Stop'\n"+script))
+	}
+
+	if _, err := exec.LookPath("bash"); err == nil {
+		return exec.Command("bash", "-e", "-o", "pipefail", "-c", script)
+	}
+	return exec.Command("sh", "-e", "-c", script)
+}
+
+// encodePowerShell returns the script encoded for the flag -EncodedCommand of
+// PowerShell: in base64, from UTF-16LE.
+func encodePowerShell(script string) string {
+	units := utf16.Encode([]rune(script))
+	b := make([]byte, 2*len(units))
+	for i, u := range units {
+		binary.LittleEndian.PutUint16(b[2*i:], u)
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
@@ -0,0 +1,42 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Codes of the reasons of the tasks skipped by tasking itself.
+const (
+	SKIP_DEPENDENCY  = "dependency"  // A task which it depends on failed or was skipped.
+	SKIP_UNCHANGED   = "unchanged"   // Its inputs are unchanged; see T.SkipIfUnchanged.
+	SKIP_RESUMED     = "resumed"     // It passed in the last run; see -task.resume.
+	SKIP_UNAVAILABLE = "unavailable" // An endpoint is not available; see T.Needs.
+)
+
+// SkipReason is equivalent to Skip, with the code of the reason, like
+// "missing-docker" or "not-applicable". The code is reported into the events
+// of -task.json, the results and the JUnit report, so that the dashboards can
+// tell the tasks which do not apply from the ones skipped since something is
+// missing from the environment, and alert on the latter. A code has no spaces.
+func (c *common) SkipReason(code string, args ...interface{}) {
+	if code == "" || strings.ContainsAny(code, " \t\r\n") {
+		c.log(fmt.Sprintf("tasking: invalid skip reason %q: want a code without spaces", code), nil)
+		c.FailNow()
+	}
+	c.log(fmt.Sprintln(args...), nil)
+	c.skipNow(code)
+}
+
+// skipNow is like SkipNow, with the code of the reason.
+func (c *common) skipNow(code string) {
+	c.mu.Lock()
+	c.skipReason = code
+	c.mu.Unlock()
+	c.SkipNow()
+}
@@ -0,0 +1,111 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// ENV_TASKDIR is the environment variable, set by gake, with the absolute
+// directory of the task files.
+const ENV_TASKDIR = "GAKE_TASKDIR"
+
+// SNAPSHOT_DIR is the directory, into the one of the task files, where the
+// golden files of MatchSnapshot are kept.
+const SNAPSHOT_DIR = "testdata"
+
+var updateSnapshots = flag.Bool("task.update", false, "write the golden files of MatchSnapshot instead of comparing them")
+
+// MatchSnapshot compares data, like the output of a generator, with the golden
+// file "testdata/name" into the directory of the task files, so that a task can
+// check that the generated files have not drifted:
+//
+//	func TaskCheckGen(t *tasking.T) {
+//		out, err := exec.Command("stringer", "-type=Kind", "-output=-").Output()
+//		if err != nil {
+//			t.Fatal(err)
+//		}
+//		t.MatchSnapshot("kind_string.go", out)
+//	}
+//
+// The task fails, but continues, if the data differs, logging the differences
+// of the lines, or if the golden file does not exist. With -task.update, the
+// golden file is written with data instead, creating its directory; but not
+// with -task.dry-run.
+//
+// The name is a slash-separated path relative to the testdata directory.
+func (t *T) MatchSnapshot(name string, data []byte) {
+	path, err := snapshotPath(name)
+	if err != nil {
+		t.log("tasking: invalid snapshot: "+err.Error(), nil)
+		t.FailNow()
+	}
+
+	if *updateSnapshots {
+		if *dryRun {
+			t.log(fmt.Sprintf("would run: update snapshot %s", name), nil)
+			return
+		}
+		if err = os.MkdirAll(filepath.Dir(path), 0755); err == nil {
+			err = os.WriteFile(path, data, 0644)
+		}
+		if err != nil {
+			t.log("tasking: can't update snapshot: "+err.Error(), nil)
+			t.FailNow()
+		}
+		t.log(fmt.Sprintf("tasking: snapshot %s updated", name), nil)
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			t.log(fmt.Sprintf("tasking: snapshot %s not found; run with -update to write it", name), nil)
+			t.Fail()
+			return
+		}
+		t.log("tasking: can't read snapshot: "+err.Error(), nil)
+		t.FailNow()
+	}
+	if bytes.Equal(want, data) {
+		return
+	}
+
+	msg := fmt.Sprintf("tasking: snapshot %s mismatch (-want +got); run with -update to accept it:\n", name)
+	if isText(want) && isText(data) {
+		msg += DiffText(string(want), string(data))
+	} else {
+		msg += fmt.Sprintf("want %d bytes, sha256 %x\ngot  %d bytes, sha256 %x\n",
+			len(want), sha256.Sum256(want), len(data), sha256.Sum256(data))
+	}
+	t.log(msg, nil)
+	t.Fail()
+}
+
+// snapshotPath returns the path of the golden file of the snapshot name, into
+// the directory of the task files given by gake, or the working directory.
+func snapshotPath(name string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(name))
+	if name == "" || filepath.IsAbs(clean) || clean == ".." ||
+		strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("name %q: want a relative path into %s", name, SNAPSHOT_DIR)
+	}
+	return filepath.Join(os.Getenv(ENV_TASKDIR), SNAPSHOT_DIR, clean), nil
+}
+
+// isText reports whether data is UTF-8 text without NUL bytes, to be compared
+// by lines.
+func isText(data []byte) bool {
+	return utf8.Valid(data) && bytes.IndexByte(data, 0) == -1
+}
@@ -0,0 +1,120 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"bytes"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SSH_CONNECT_TIMEOUT is the time to connect to a remote host, and
+// SSH_ALIVE_INTERVAL the interval to check that the connection is alive; the
+// connection is closed after 3 checks without answer.
+const (
+	SSH_CONNECT_TIMEOUT = 15 * time.Second
+	SSH_ALIVE_INTERVAL  = 15 * time.Second
+)
+
+// SSH runs commands on a remote host through the ssh client of the system, so
+// that the configuration of the user (~/.ssh/config, known_hosts) is honored.
+// The authentication is done by the ssh agent or the keys of the user, or the
+// key set by Key; it never prompts for a password, so that a task can not hang.
+//
+// The client of the system is used instead of the package
+// golang.org/x/crypto/ssh, which the module does not depend on: that package
+// does not read the configuration of the user, so the aliases, jump hosts and
+// known hosts would have to be given by every task, and the programs tasking
+// builds into would depend on it. The requirement is an ssh client into the
+// PATH, which is available wherever the tasks provision hosts.
+type SSH struct {
+	t    *T
+	host string   // [user@]host
+	opts []string // Options of the ssh client.
+}
+
+// SSH returns a session to run commands on the remote host, which has the form
+// "[user@]host[:port]"; the extra options are passed to the ssh client, like
+// "-o", "StrictHostKeyChecking=accept-new".
+//
+// The options BatchMode=yes, ConnectTimeout, ServerAliveInterval and
+// ServerAliveCountMax=3 are set by default, after the extra ones; since ssh
+// uses the first value given for an option, the extra ones override them, like
+// "-o", "ConnectTimeout=60".
+func (t *T) SSH(host string, opts ...string) *SSH {
+	s := &SSH{t: t, host: host}
+
+	user := ""
+	if i := strings.LastIndexByte(host, '@'); i != -1 {
+		user, host = host[:i+1], host[i+1:]
+	}
+	if h, port, err := net.SplitHostPort(host); err == nil {
+		s.host = user + h
+		s.opts = append(s.opts, "-p", port)
+	}
+
+	s.opts = append(s.opts, opts...)
+	return s
+}
+
+// sshDefaults are the options of the ssh client which the ones of a session
+// override.
+var sshDefaults = []string{
+	"-o", "BatchMode=yes",
+	"-o", "ConnectTimeout=" + secs(SSH_CONNECT_TIMEOUT),
+	"-o", "ServerAliveInterval=" + secs(SSH_ALIVE_INTERVAL),
+	"-o", "ServerAliveCountMax=3",
+}
+
+// Key sets the private key used to authenticate, instead of the ones of the
+// ssh agent and the user.
+func (s *SSH) Key(path string) *SSH {
+	s.opts = append(s.opts, "-i", path, "-o", "IdentitiesOnly=yes")
+	return s
+}
+
+// Run runs the command on the remote host, through its shell, and waits for it
+// to exit. Its output is written to the output of the task, like Exec.
+func (s *SSH) Run(command string) error {
+	s.t.log(runPrefix()+s.commandLine(command), nil)
+	return s.t.exec(s.command(command))
+}
+
+// Output runs the command on the remote host like Run, and returns its standard
+// output.
+func (s *SSH) Output(command string) (string, error) {
+	var out bytes.Buffer
+	cmd := s.command(command)
+	cmd.Stdout = &out
+	s.t.log(runPrefix()+s.commandLine(command), nil)
+	err := s.t.exec(cmd)
+	return out.String(), err
+}
+
+func (s *SSH) command(command string) *exec.Cmd {
+	args := make([]string, 0, len(s.opts)+len(sshDefaults)+2)
+	args = append(append(append(args, s.opts...), sshDefaults...), s.host, command)
+	return exec.Command("ssh", args...)
+}
+
+// commandLine returns the command line to log, without the options.
+func (s *SSH) commandLine(command string) string {
+	return commandLine(&exec.Cmd{Args: []string{"ssh", s.host, command}})
+}
+
+// secs returns the duration in whole seconds, as wanted by the ssh options.
+func secs(d time.Duration) string {
+	return strconv.Itoa(int(d / time.Second))
+}
+
+// shellQuote quotes the string for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,43 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSSHOptions(t *testing.T) {
+	tests := []struct {
+		host string
+		opts []string
+		key  string
+		want string // Arguments of ssh.
+	}{
+		{"web1", nil, "",
+			"-o BatchMode=yes -o ConnectTimeout=15 -o ServerAliveInterval=15 -o ServerAliveCountMax=3 web1 uptime"},
+		{"deploy@web1:2222", nil, "",
+			"-p 2222 -o BatchMode=yes -o ConnectTimeout=15 -o ServerAliveInterval=15 -o ServerAliveCountMax=3 deploy@web1 uptime"},
+
+		// ssh uses the first value of an option, so the ones of the session
+		// go before the defaults.
+		{"web1", []string{"-o", "BatchMode=no", "-o", "ConnectTimeout=60"}, "",
+			"-o BatchMode=no -o ConnectTimeout=60 -o BatchMode=yes -o ConnectTimeout=15 -o ServerAliveInterval=15 -o ServerAliveCountMax=3 web1 uptime"},
+		{"web1", []string{"-o", "ServerAliveCountMax=10"}, "id_deploy",
+			"-o ServerAliveCountMax=10 -i id_deploy -o IdentitiesOnly=yes -o BatchMode=yes -o ConnectTimeout=15 -o ServerAliveInterval=15 -o ServerAliveCountMax=3 web1 uptime"},
+	}
+	for _, tt := range tests {
+		s := (&T{}).SSH(tt.host, tt.opts...)
+		if tt.key != "" {
+			s.Key(tt.key)
+		}
+		args := s.command("uptime").Args
+		if got := strings.Join(args[1:], " "); got != tt.want {
+			t.Errorf("SSH(%q, %q): ssh %s; want ssh %s", tt.host, tt.opts, got, tt.want)
+		}
+	}
+}
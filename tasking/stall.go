@@ -0,0 +1,128 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var stallTimeout = flag.Duration("task.stall-timeout", 0, "if positive, warns about tasks which produce no output for the given duration")
+
+// MIN_STALL_TICK is the minimum period to check whether the tasks are stalled,
+// so that a tiny -task.stall-timeout does not give a period of zero.
+const MIN_STALL_TICK = 10 * time.Millisecond
+
+// Tasks being run, which are watched to detect whether they are stalled.
+var (
+	watchMu sync.Mutex
+	watched = make(map[*T]bool)
+)
+
+// Progress reports that the task is still making progress, without generating
+// any output. It avoids the warning given by the -task.stall-timeout flag in
+// tasks which are quiet for long periods.
+func (c *common) Progress() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastActivity = time.Now()
+}
+
+// watch adds the task to the set of stall-watched tasks.
+func (t *T) watch() {
+	if *stallTimeout <= 0 {
+		return
+	}
+	t.Progress()
+	t.goid = goroutineID()
+
+	watchMu.Lock()
+	defer watchMu.Unlock()
+	watched[t] = true
+}
+
+// unwatch removes the task from the set of stall-watched tasks.
+func (t *T) unwatch() {
+	if *stallTimeout <= 0 {
+		return
+	}
+	watchMu.Lock()
+	defer watchMu.Unlock()
+	delete(watched, t)
+}
+
+// startStallWatcher starts checking periodically the running tasks, if requested.
+func startStallWatcher() {
+	if *stallTimeout <= 0 {
+		return
+	}
+	go func() {
+		for now := range time.Tick(stallTick()) {
+			watchMu.Lock()
+			for t := range watched {
+				t.mu.Lock()
+				idle := now.Sub(t.lastActivity)
+				if idle >= *stallTimeout {
+					// Warn once per period of inactivity.
+					t.lastActivity = now
+				}
+				t.mu.Unlock()
+
+				if idle >= *stallTimeout {
+					fmt.Fprintf(os.Stderr, "tasking: warning: %s produced no output for %v\n%s\n",
+						t.name, idle.Round(time.Millisecond), goroutineStack(t.goid))
+				}
+			}
+			watchMu.Unlock()
+		}
+	}()
+}
+
+// stallTick returns the period to check whether the tasks are stalled, a
+// quarter of -task.stall-timeout but not less than MIN_STALL_TICK.
+func stallTick() time.Duration {
+	if d := *stallTimeout / 4; d > MIN_STALL_TICK {
+		return d
+	}
+	return MIN_STALL_TICK
+}
+
+// goroutineID returns the identifier of the current goroutine.
+func goroutineID() string {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	// The stack starts with "goroutine N [running]:".
+	buf = bytes.TrimPrefix(buf, []byte("goroutine "))
+	if i := bytes.IndexByte(buf, ' '); i != -1 {
+		buf = buf[:i]
+	}
+	if _, err := strconv.ParseUint(string(buf), 10, 64); err != nil {
+		return ""
+	}
+	return string(buf)
+}
+
+// goroutineStack returns the stack trace of the goroutine with the given
+// identifier.
+func goroutineStack(id string) []byte {
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+	header := []byte("goroutine " + id + " ")
+
+	for _, g := range bytes.Split(buf, []byte("\n\n")) {
+		if bytes.HasPrefix(g, header) {
+			return g
+		}
+	}
+	return []byte("goroutine " + id + ": stack not found")
+}
@@ -28,6 +28,12 @@
 //         ...
 //     }
 //
+// A function TaskMain(m *tasking.M) into the task files controls the run of the
+// tasks, in the manner of TestMain in package "testing"; see M.
+//
+// Tasks can also be generated programmatically, registering them from an init
+// function; see Register.
+//
 // For detail about flags, run "gake -help".
 package tasking
 
@@ -35,8 +41,10 @@ import (
 	"bytes"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"runtime"
+	"runtime/debug"
 	//"runtime/pprof"
 	"strconv"
 	"strings"
@@ -55,12 +63,18 @@ var (
 	// "gake", the binary always runs in the source directory for the package;
 	// this flag lets "gake" tell the binary to write the files in the directory where
 	// the "gake" command is run.
-	//outputDir = flag.String("task.outputdir", "", "directory in which to write profiles")
+	outputDir = flag.String("task.outputdir", "", "directory in which to write profiles")
 
 	// Report as tasks are run; default is silent for success.
 	chatty = flag.Bool("task.v", false, "verbose: print additional output")
+
+	// Report the parallel tasks in the order of declaration as they are done.
+	orderedOutput = flag.Bool("task.ordered-output", false, "report the parallel tasks in the order of declaration, for deterministic logs")
+
 	//coverProfile     = flag.String("task.coverprofile", "", "write a coverage profile to the named file after execution")
-	match = flag.String("task.run", "", "regular expression to select tasks to run")
+	match     = flag.String("task.run", "", "regular expression to select tasks to run, split by \"/\" to match every level of their names")
+	names     = flag.String("task.names", "", "comma-separated list of tasks to run, in that order")
+	matchList = flag.String("task.list", "", "list tasks matching the regular expression, with their source location, and exit")
 	//memProfile       = flag.String("task.memprofile", "", "write a memory profile to the named file after execution")
 	//memProfileRate   = flag.Int("task.memprofilerate", 0, "if >=0, sets runtime.MemProfileRate")
 	//cpuProfile       = flag.String("task.cpuprofile", "", "write a cpu profile to the named file during execution")
@@ -68,13 +82,27 @@ var (
 	//blockProfileRate = flag.Int("task.blockprofilerate", 1, "if >= 0, calls runtime.SetBlockProfileRate()")
 	timeout    = flag.Duration("task.timeout", 0, "if positive, sets an aggregate time limit for all tasks")
 	cpuListStr = flag.String("task.cpu", "", "comma-separated list of number of CPUs to use for each task")
-	parallel   = flag.Int("task.parallel", runtime.GOMAXPROCS(0), "maximum task parallelism")
+	parallel   = flag.Int("task.parallel", runtime.GOMAXPROCS(0), "maximum task parallelism, as the sum of weights of the running tasks")
+
+	// A run where -task.run matches no task fails, since it is most likely a
+	// mistyped name which would pass silently.
+	allowNoTasks = flag.Bool("task.allow-no-tasks", false, "do not fail when -task.run matches no task")
 
 	//haveExamples bool // are there examples?
 
 	cpuList []int
 )
 
+// Exit status of the program, which gake passes on as its own one, so that the
+// failures of the tasks are distinct from the ones of the run.
+const (
+	EXIT_FAIL        = 1 // Some task failed.
+	EXIT_USAGE       = 2 // Invalid flags, parameters or declarations of the tasks.
+	EXIT_TIMEOUT     = 3 // The run exceeded -task.timeout.
+	EXIT_INTERRUPTED = 4 // The run was aborted by an interrupt or SIGTERM.
+	EXIT_NO_TASKS    = 5 // No task is selected by -task.run or -task.names.
+)
+
 var eargs = flag.String("task.args", "", "comma-separated list of extra arguments to be used by some task")
 
 // Args returns the extra arguments, if any.
@@ -83,16 +111,22 @@ func Args() []string { return strings.Split(*eargs, ",") }
 // common holds the elements common for M and captures common methods
 // such as Errorf.
 type common struct {
-	mu       sync.RWMutex // guards output and failed
-	output   []byte       // Output generated by task.
-	failed   bool         // Task has failed.
-	skipped  bool         // Task has been skipped.
-	finished bool
+	mu         sync.RWMutex // guards output and failed
+	output     outputBuffer // Output generated by task.
+	w          io.Writer    // Pipeline where the output is written.
+	teeFile    *os.File     // File where the output is streamed by -task.tee.
+	failed     bool         // Task has failed.
+	warnings   int          // Warnings recorded by Warn.
+	skipped    bool         // Task has been skipped.
+	skipReason string       // Code of the reason given by SkipReason.
+	finished   bool
 
-	start    time.Time // Time task started
-	duration time.Duration
-	self     interface{}      // To be sent on signal channel when done.
-	signal   chan interface{} // Output for serial tasks.
+	start        time.Time // Time task started
+	duration     time.Duration
+	lastActivity time.Time         // Time of the last output or progress of the task.
+	meta         map[string]string // Metadata to be shown in reports.
+	self         interface{}       // To be sent on signal channel when done.
+	signal       chan interface{}  // Output for serial tasks.
 }
 
 // Short reports whether the -task.short flag is set.
@@ -169,6 +203,39 @@ type T struct {
 	common
 	name          string    // Name of task.
 	startParallel chan bool // Parallel tasks will wait on this.
+	isParallel    bool      // Parallel has been called.
+	mutexes       []string  // Resources which can not be shared with other tasks.
+	weight        int       // Capacity used when it is run in parallel.
+	goid          string    // Identifier of the goroutine running the task.
+	params        []InternalParam
+	limits        Limits              // Resources of the processes launched by Exec.
+	procGroups    []*procGroup        // Processes launched by Exec.
+	services      []*Service          // Services started by StartService.
+	artifacts     []string            // Files registered by Artifact.
+	depArtifacts  map[string][]string // Artifacts of the tasks of other packages, by dependency.
+	findings      []Finding           // Problems reported by ReportFinding.
+	commands      []CommandRecord     // Commands run by Exec, for the audit.
+	matrix        map[string]string
+	entry         []byte                  // Entry of the manifest, in JSON.
+	groups        []string                // Groups with a time budget.
+	fingerprints  []fingerprint           // Recorded when the task succeeds.
+	xfail         string                  // Reason why the task is expected to fail.
+	xfailed       bool                    // Task has failed as expected.
+	xpassed       bool                    // Task expected to fail has passed.
+	deps          []*T                    // Tasks which have to finish before this one.
+	disabledDeps  []string                // Dependencies left out by their "gake:when" conditions.
+	blocked       bool                    // Task skipped since a dependency failed.
+	aborted       bool                    // Task not run since the run was aborted.
+	resumed       bool                    // Task skipped since it passed in the last run.
+	deadline      *deadline               // Share of -task.timeout given to the task.
+	cpu           int                     // GOMAXPROCS of the run, with several values of -task.cpu.
+	clock         func() time.Time        // Source of the time; see Options.Clock.
+	runner        func(*T, *InternalTask) // Runner of the task function; see Options.Runner.
+	sched         *scheduler              // Scheduler which runs the task.
+	isolation     *isolation              // Private copy of the working directory.
+	user          string                  // User whom the processes launched by Exec are run as.
+	parent        *T                      // Task of a branch run by a Group.
+	usage         Usage                   // Resources used by the task.
 }
 
 func (c *common) private() {}
@@ -218,56 +285,137 @@ func (c *common) FailNow() {
 	runtime.Goexit()
 }
 
-// log generates the output. It's always at the same stack depth.
-func (c *common) log(s string) {
+// log generates the output, with the structured fields if any.
+// It's always at the same stack depth.
+func (c *common) log(s string, fields map[string]string) {
+	c.write(decorate(s), fields)
+}
+
+// write writes the text to the output of the task.
+func (c *common) write(s string, fields map[string]string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	c.output = append(c.output, decorate(s)...)
+	c.w.Write([]byte(s))
+	c.lastActivity = time.Now()
+
+	// The output of a branch is emitted by its task, once the branch is done.
+	if c.self.(*T).parent == nil {
+		e := Event{Action: "output", Task: c.self.(*T).name, Output: s, Fields: fields}
+		publish(e)
+		if *jsonOutput {
+			emit(e)
+		}
+	}
 }
 
 // Log formats its arguments using default formatting, analogous to Println,
 // and records the text in the error log. The text will be printed only if
 // the task fails or the -task.v flag is set.
-func (c *common) Log(args ...interface{}) { c.log(fmt.Sprintln(args...)) }
+func (c *common) Log(args ...interface{}) { c.log(fmt.Sprintln(args...), nil) }
 
 // Logf formats its arguments according to the format, analogous to Printf,
 // and records the text in the error log. The text will be printed only if
 // the task fails or the -task.v flag is set.
-func (c *common) Logf(format string, args ...interface{}) { c.log(fmt.Sprintf(format, args...)) }
+func (c *common) Logf(format string, args ...interface{}) { c.log(fmt.Sprintf(format, args...), nil) }
+
+// LogKV records the key/value pairs in the error log, like Log, with the form
+// "key=value". The keys are expected to be strings, and the values are formatted
+// using default formatting. With the -task.json flag, the pairs are also set in
+// the field "Fields" of the output event, so that they can be indexed by other
+// tools.
+func (c *common) LogKV(keyvals ...interface{}) {
+	fields := make(map[string]string, (len(keyvals)+1)/2)
+	text := make([]string, 0, len(fields))
+
+	for i := 0; i < len(keyvals); i += 2 {
+		key := fmt.Sprint(keyvals[i])
+		value := "(MISSING)"
+		if i+1 < len(keyvals) {
+			value = fmt.Sprint(keyvals[i+1])
+		}
+		fields[key] = value
+
+		if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+			value = strconv.Quote(value)
+		}
+		text = append(text, key+"="+value)
+	}
+	c.log(strings.Join(text, " "), fields)
+}
+
+// SetMeta records the metadata key with the given value, such as the deployed
+// version, the target cluster or an artifact URL. The metadata are shown in the
+// JSON event of the task result and in the JUnit report, as an audit record of
+// what the task did. A later call with the same key replaces the value.
+func (c *common) SetMeta(key, value string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.meta == nil {
+		c.meta = make(map[string]string)
+	}
+	c.meta[key] = value
+}
+
+// Warn is equivalent to Log, prefixing the text with "warning: ", but it also
+// records a warning. The task does not fail, but it is reported as passed with
+// warnings, and its output is printed even without the -task.v flag.
+func (c *common) Warn(args ...interface{}) {
+	c.log("warning: "+fmt.Sprintln(args...), nil)
+	c.warn()
+}
+
+// Warnf is like Warn, formatting its arguments analogous to Printf.
+func (c *common) Warnf(format string, args ...interface{}) {
+	c.log("warning: "+fmt.Sprintf(format, args...), nil)
+	c.warn()
+}
+
+func (c *common) warn() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.warnings++
+}
+
+// Warned reports whether the task has recorded warnings.
+func (c *common) Warned() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.warnings != 0
+}
 
 // Error is equivalent to Log followed by Fail.
 func (c *common) Error(args ...interface{}) {
-	c.log(fmt.Sprintln(args...))
+	c.log(fmt.Sprintln(args...), nil)
 	c.Fail()
 }
 
 // Errorf is equivalent to Logf followed by Fail.
 func (c *common) Errorf(format string, args ...interface{}) {
-	c.log(fmt.Sprintf(format, args...))
+	c.log(fmt.Sprintf(format, args...), nil)
 	c.Fail()
 }
 
 // Fatal is equivalent to Log followed by FailNow.
 func (c *common) Fatal(args ...interface{}) {
-	c.log(fmt.Sprintln(args...))
+	c.log(fmt.Sprintln(args...), nil)
 	c.FailNow()
 }
 
 // Fatalf is equivalent to Logf followed by FailNow.
 func (c *common) Fatalf(format string, args ...interface{}) {
-	c.log(fmt.Sprintf(format, args...))
+	c.log(fmt.Sprintf(format, args...), nil)
 	c.FailNow()
 }
 
 // Skip is equivalent to Log followed by SkipNow.
 func (c *common) Skip(args ...interface{}) {
-	c.log(fmt.Sprintln(args...))
+	c.log(fmt.Sprintln(args...), nil)
 	c.SkipNow()
 }
 
 // Skipf is equivalent to Logf followed by SkipNow.
 func (c *common) Skipf(format string, args ...interface{}) {
-	c.log(fmt.Sprintf(format, args...))
+	c.log(fmt.Sprintf(format, args...), nil)
 	c.SkipNow()
 }
 
@@ -298,151 +446,620 @@ func (c *common) Skipped() bool {
 // Parallel signals that this task is to be run in parallel with (and only with)
 // other parallel tasks.
 func (t *T) Parallel() {
+	if t.parent != nil {
+		t.log("tasking: Parallel called from a branch of a Group", nil)
+		t.FailNow()
+	}
+	t.isParallel = true
+	t.unwatch()
 	t.signal <- (*T)(nil) // Release main run tasks loop
-	<-t.startParallel     // Wait for serial tasks to finish
+
+	// Wait for serial tasks to finish. The gate is closed, instead, when the
+	// run is aborted.
+	if !<-t.startParallel {
+		t.notRun()
+		runtime.Goexit()
+	}
+	t.watch()
 	// Assuming Parallel is the first thing a task does, which is reasonable,
 	// reinitialize the task's start time because it's actually starting now.
-	t.start = time.Now()
+	t.start = t.now()
+}
+
+// Serialize declares that the task uses the named external resources (a
+// database, a port, the docker daemon), so it is never run in parallel with
+// other tasks which declare any of them, even when Parallel is used.
+// It is equivalent to the directive "gake:mutex" into the task documentation.
+//
+// Serialize must be called before Parallel.
+func (t *T) Serialize(resources ...string) {
+	if t.isParallel {
+		t.log("tasking: Serialize called after Parallel", nil)
+		t.FailNow()
+	}
+	t.mutexes = append(t.mutexes, resources...)
+}
+
+// SetWeight declares the cost of the task when it is run in parallel, so that
+// the flag -task.parallel is the capacity shared by the running tasks rather
+// than their number. A heavy task can so avoid running alongside others while
+// many light tasks can still run together. The default weight is 1.
+// It is equivalent to the directive "gake:weight" into the task documentation.
+//
+// SetWeight must be called before Parallel.
+func (t *T) SetWeight(n int) {
+	if t.isParallel {
+		t.log("tasking: SetWeight called after Parallel", nil)
+		t.FailNow()
+	}
+	if n <= 0 {
+		t.log(fmt.Sprintf("tasking: invalid weight %d", n), nil)
+		t.FailNow()
+	}
+	t.weight = n
 }
 
 // An internal type but exported because it is cross-package; part of the
 // implementation of the "gake" command.
 type InternalTask struct {
-	Name string
-	F    func(*T)
+	Name    string
+	F       func(*T)
+	File    string          // Source file of the function.
+	Line    int             // Line of the function into the source file.
+	Doc     string          // Documentation of the function.
+	Mutexes []string        // Resources declared by "gake:mutex" directives.
+	Weight  int             // Cost declared by "gake:weight" directive; 0 means 1.
+	Params  []InternalParam // Parameters declared by "gake:param" directives.
+	Limits  Limits          // Resources declared by "gake:limit" directives.
+	Matrix  []InternalAxis  // Axes declared by "gake:matrix" directives.
+	XFail   string          // Reason declared by "gake:xfail" directive.
+	Deps    []string        // Tasks declared by "gake:deps" directives.
+	ExtDeps []string        // Tasks of other packages declared by "gake:deps", like "./proto:TaskGenerate".
+	When    []string        // Conditions declared by "gake:when" directives.
+
+	Manifest *InternalManifest // Manifest declared by "gake:manifest" directive.
+	Groups   []string          // Groups declared by "gake:group" directives.
+	Approve  string            // Question declared by "gake:approve" directive.
+	Isolate  bool              // Declared by "gake:isolate" directive.
+	User     string            // User declared by "gake:user" directive.
+	Needs    []string          // Endpoints declared by "gake:needs" directives.
+
+	matrix       map[string]string // Values of the axes for an instance of the task.
+	entry        []byte            // Entry of the manifest for an instance of the task.
+	disabledDeps []string          // Dependencies whose "gake:when" conditions do not hold.
 }
 
 func tRunner(t *T, task *InternalTask) {
+	var usage0 Usage
+
 	// When this goroutine is done, either because task.F(t)
 	// returned normally or because a task failure triggered
 	// a call to runtime.Goexit, record the duration and send
 	// a signal saying that the task is done.
 	defer func() {
-		t.duration = time.Now().Sub(t.start)
-		// If the task panicked, print any task output before dying.
+		// A panic fails the task, instead of the whole run, so that the
+		// scheduler still accounts for it, and the other tasks, the parallel
+		// ones too, are finished and reported.
 		err := recover()
-		if !t.finished && err == nil {
-			err = fmt.Errorf("task executed panic(nil) or runtime.Goexit")
-		}
 		if err != nil {
+			t.write(panicText(err), nil)
+			t.Fail()
+		} else if !t.finished && !t.aborted {
+			t.write("\ttask executed panic(nil) or runtime.Goexit\n", nil)
 			t.Fail()
-			t.report()
-			panic(err)
+		}
+
+		t.stopDeadline()
+		t.unwatch()
+		if t.finished || err != nil {
+			t.checkExpectedFailure()
+		}
+		t.stopServices()
+		t.stopProcesses()
+		t.endIsolation()
+		t.mu.Lock()
+		t.closeOutput()
+		t.usage = readUsage().sub(usage0)
+		t.mu.Unlock()
+		if !t.aborted {
+			t.duration = t.now().Sub(t.start)
 		}
 		t.signal <- t
 	}()
 
-	t.start = time.Now()
-	task.F(t)
+	usage0 = readUsage()
+	t.start = t.now()
+	t.watch()
+	t.checkDeps()
+	if t.resumed {
+		t.write("\tpassed in the last run; skipped by -task.resume\n", nil)
+		t.skipNow(SKIP_RESUMED)
+	}
+	if len(task.ExtDeps) != 0 {
+		t.runExtDeps(task.ExtDeps)
+	}
+	if len(task.Needs) != 0 {
+		t.needs(task.Needs)
+	}
+	if task.Approve != "" {
+		t.waitForApproval(task.Approve)
+	}
+	if task.Isolate {
+		if err := t.isolate(); err != nil {
+			t.write("\ttasking: can't isolate the task: "+err.Error()+"\n", nil)
+			t.FailNow()
+		}
+	}
+	t.startDeadline()
+	if t.runner != nil {
+		t.runner(t, task)
+	} else {
+		task.F(t)
+	}
 	t.finished = true
+	t.saveFingerprints()
+}
+
+// panicText returns the report of a recovered panic, with the stack of the
+// goroutine which has panicked.
+func panicText(err interface{}) string {
+	return fmt.Sprintf("\tpanic: %v\n\t\t%s\n", err,
+		strings.Replace(strings.TrimSpace(string(debug.Stack())), "\n", "\n\t\t", -1))
 }
 
 // An internal function but exported because it is cross-package;
 // part of the implementation of the "gake" command.
 func Main(matchString func(pat, str string) (bool, error), tasks []InternalTask) {
-	flag.Parse()
-	parseCpuList()
+	code := MainStart(matchString, tasks).Run()
+	removeSpools()
+	os.Exit(code)
+}
+
+// M is a type passed to a TaskMain function to run the actual tasks.
+//
+// If the task files contain a function
+//
+//	func TaskMain(m *tasking.M)
+//
+// then the generated program calls TaskMain(m) instead of running the tasks
+// directly. TaskMain runs in the main goroutine and can do whatever setup and
+// teardown is necessary around a call to m.Run, and then inspect the outcome
+// with m.Results. It should call os.Exit with the result of m.Run.
+type M struct {
+	matchString func(pat, str string) (bool, error)
+	tasks       []InternalTask
+	instances   []InternalTask // Tasks with the matrices expanded.
+	started     bool
+
+	yamlUnmarshal func([]byte, interface{}) error // Decoder of the YAML manifests.
+}
+
+// SetYAMLUnmarshal sets the function which decodes the manifests in YAML of
+// the directive "gake:manifest". It has to be called before Run:
+//
+//	func TaskMain(m *tasking.M) {
+//		m.SetYAMLUnmarshal(yaml.Unmarshal) // gopkg.in/yaml.v3
+//		os.Exit(m.Run())
+//	}
+func (m *M) SetYAMLUnmarshal(unmarshal func(data []byte, v interface{}) error) {
+	m.yamlUnmarshal = unmarshal
+}
+
+// Result is the outcome of a task run.
+type Result struct {
+	Name       string
+	Status     string // "pass", "fail", "skip", "xfail", "xpass" or "notrun".
+	CPU        int    // GOMAXPROCS of the run, with several values of -task.cpu.
+	SkipReason string // Code of the reason of a skip; see T.SkipReason.
+	Duration   time.Duration
+	Output     string
+	Meta       map[string]string // Metadata set by SetMeta.
+	Usage      Usage             // Resources used by the task.
+	Warnings   int               // Warnings recorded by Warn.
+	Artifacts  []string          // Files registered by Artifact.
+	Findings   []Finding         // Problems reported by ReportFinding.
+	Commands   []CommandRecord   // Commands run by Exec, ExecCmd and Shell.
+}
+
+// An internal function but exported because it is cross-package;
+// part of the implementation of the "gake" command.
+func MainStart(matchString func(pat, str string) (bool, error), tasks []InternalTask) *M {
+	runMatch = matchLevels(matchString)
+	return &M{matchString: runMatch, tasks: withRegistered(tasks)}
+}
+
+// Run runs the tasks. It returns an exit code to pass to os.Exit.
+func (m *M) Run() int {
+	if !flag.Parsed() {
+		flag.Parse()
+	}
+	if *printBuildInfo {
+		showBuildInfo()
+	}
+	if *matchList != "" {
+		// The instances of the manifests are only known once loaded.
+		tasks, err := expandManifests(m.tasks, m.yamlUnmarshal)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "tasking: %s\n", err)
+			os.Exit(EXIT_USAGE)
+		}
+		listTasks(m.matchString, tasks)
+		return 0
+	}
+	if !m.started {
+		tasks, err := expandManifests(m.tasks, m.yamlUnmarshal)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "tasking: %s\n", err)
+			os.Exit(EXIT_USAGE)
+		}
+		tasks = expandMatrix(tasks)
+		selected := tasks
+
+		if *names != "" {
+			if *match != "" {
+				fmt.Fprintf(os.Stderr, "tasking: -task.run and -task.names can not be used together\n")
+				os.Exit(EXIT_USAGE)
+			}
+			if selected, err = selectTasks(tasks, strings.Split(*names, ",")); err != nil {
+				exitNoTasks(err.Error(), tasks)
+			}
+		} else {
+			selected = matchTasks(m.matchString, tasks)
+			if len(selected) == 0 && len(tasks) != 0 && !*allowNoTasks {
+				exitNoTasks(fmt.Sprintf("no tasks match -task.run %q", *match), tasks)
+			}
+		}
+		if m.instances, err = planTasks(tasks, selected); err != nil {
+			fmt.Fprintf(os.Stderr, "tasking: %s\n", err)
+			os.Exit(EXIT_USAGE)
+		}
+		if *parallel < 1 {
+			fmt.Fprintf(os.Stderr, "tasking: -task.parallel can only be given a positive integer\n")
+			os.Exit(EXIT_USAGE)
+		}
+		parseCpuList()
+		checkParams(matchAny(m.instances), tasks)
+		parseTee()
+		startStallWatcher()
+		m.started = true
+	}
+	return m.run(m.instances)
+}
+
+// Rerun runs again the named tasks, among the ones planned by Run; such as the
+// failed ones of a previous run. Their dependencies are not run again. The name
+// of a task with a matrix selects all its instances. The results of the
+// previous run are replaced. It returns an exit code to pass to os.Exit.
+func (m *M) Rerun(names ...string) int {
+	if !m.started {
+		panic("tasking: M.Rerun called before M.Run")
+	}
+	tasks := make([]InternalTask, 0, len(names))
+	for _, task := range m.instances {
+		for _, name := range names {
+			if task.Name == name || baseName(task.Name) == name {
+				tasks = append(tasks, task)
+				break
+			}
+		}
+	}
+	return m.run(tasks)
+}
+
+// Results returns the results of the tasks finished in the last call to Run or
+// Rerun, in the order of their report.
+func (m *M) Results() []Result {
+	resultsMu.Lock()
+	defer resultsMu.Unlock()
+
+	res := make([]Result, len(results))
+	for i, t := range results {
+		status := t.status()
+		t.mu.RLock()
+		res[i] = Result{
+			Name:     t.name,
+			Status:   status,
+			Duration: t.duration,
+			Output:   string(t.output.Bytes()),
+			Usage:    t.usage,
+			Warnings: t.warnings,
+			CPU:      t.cpu,
+		}
+		if status == "skip" {
+			res[i].SkipReason = t.skipReason
+		}
+		res[i].Artifacts = append(res[i].Artifacts, t.artifacts...)
+		res[i].Findings = append(res[i].Findings, t.findings...)
+		res[i].Commands = append(res[i].Commands, t.commands...)
+		if len(t.meta) != 0 {
+			res[i].Meta = make(map[string]string, len(t.meta))
+			for k, v := range t.meta {
+				res[i].Meta[k] = v
+			}
+		}
+		t.mu.RUnlock()
+	}
+	return res
+}
+
+// run runs the tasks and reports the result of the whole run.
+func (m *M) run(tasks []InternalTask) int {
+	resultsMu.Lock()
+	results = nil
+	resultsMu.Unlock()
+	removeSpools()
+
+	resetAbort()
+	stopInterrupt := handleInterrupt()
+	defer stopInterrupt()
+
+	info := runInfo()
+	start := time.Now()
+	startDashboard(tasks)
+	publish(Event{Action: "start", Run: &info})
+	if *jsonOutput {
+		emit(Event{Action: "start", Run: &info})
+	} else if *chatty {
+		printRunInfo(info)
+	}
 
 	//before()
+	loadCheckpoint()
 	startAlarm()
 	//haveExamples = len(examples) > 0
-	taskOk := RunTasks(matchString, tasks)
+	taskOk := RunTasks(matchAny(tasks), tasks)
 	//exampleOk := RunExamples(matchString, examples)
 	stopAlarm()
+	if isAborted() {
+		taskOk = false
+	}
+	if !reportBudgets() && *enforceBudgets {
+		taskOk = false
+	}
+	reportCPU()
+	reportAdvice(recordHistory())
+	reportAudit()
+	writeSums()
+	if *junitFile != "" {
+		if err := writeJUnit(toOutputDir(*junitFile), info); err != nil {
+			fmt.Fprintf(os.Stderr, "tasking: can't write JUnit report: %s\n", err)
+		}
+	}
+	if *sarifFile != "" {
+		if err := writeSARIF(toOutputDir(*sarifFile), info); err != nil {
+			fmt.Fprintf(os.Stderr, "tasking: can't write SARIF report: %s\n", err)
+		}
+	}
+	if *badgeFile != "" || *htmlFile != "" {
+		summary := summarize(taskOk, time.Since(start))
+		if *badgeFile != "" {
+			if err := writeBadge(toOutputDir(*badgeFile), summary); err != nil {
+				fmt.Fprintf(os.Stderr, "tasking: can't write badge: %s\n", err)
+			}
+		}
+		if *htmlFile != "" {
+			if err := writeHTML(toOutputDir(*htmlFile), summary, info); err != nil {
+				fmt.Fprintf(os.Stderr, "tasking: can't write HTML report: %s\n", err)
+			}
+		}
+	}
+	logRunSummary(taskOk, info, time.Since(start))
 	if !taskOk /*|| !exampleOk*/ {
-		fmt.Println("FAIL")
+		publish(Event{Action: "fail"})
+		flushDashboard()
+		if *jsonOutput {
+			emit(Event{Action: "fail"})
+		} else {
+			fmt.Println("FAIL")
+		}
 		//after()
-		os.Exit(1)
+		if isInterrupted() {
+			return EXIT_INTERRUPTED
+		}
+		return EXIT_FAIL
+	}
+	clearCheckpoint()
+	publish(Event{Action: "pass"})
+	flushDashboard()
+	if *jsonOutput {
+		emit(Event{Action: "pass"})
+	} else {
+		fmt.Println("PASS")
 	}
-	fmt.Println("PASS")
 	//RunBenchmarks(matchString, benchmarks)
 	//after()
+	return 0
 }
 
 func (t *T) report() {
+	recordResult(t)
+
+	status := t.status()
+	action := status
+	if status == "xpass" {
+		action = "fail"
+	}
+	t.mu.RLock()
+	usage := t.usage
+	e := Event{Action: action, Task: t.name, Elapsed: t.duration.Seconds(), Meta: t.meta,
+		Usage: &usage, Warnings: t.warnings, CPU: t.cpu}
+	if status == "notrun" {
+		e.Usage = nil
+	}
+	if status == "skip" {
+		e.SkipReason = t.skipReason
+	}
+	e.Artifacts = append(e.Artifacts, t.artifacts...)
+	e.Findings = append(e.Findings, t.findings...)
+	t.mu.RUnlock()
+	publish(e)
+	if *jsonOutput {
+		emit(e)
+		return
+	}
+
 	tstr := fmt.Sprintf("(%.2f seconds)", t.duration.Seconds())
 	format := "--- %s: %s %s\n%s"
-	if t.Failed() {
-		fmt.Printf(format, "FAIL", t.name, tstr, t.output)
-	} else if *chatty {
-		if t.Skipped() {
-			fmt.Printf(format, "SKIP", t.name, tstr, t.output)
-		} else {
-			fmt.Printf(format, "PASS", t.name, tstr, t.output)
-		}
+	if *chatty {
+		format += "\tusage: " + t.usage.String() + "\n"
+	}
+	switch {
+	case status == "notrun":
+		fmt.Printf("--- NOT RUN: %s\n", t.name)
+	case status == "fail" || status == "xpass":
+		fmt.Printf(format, strings.ToUpper(status), t.name, tstr, t.output.Bytes())
+	case status == "pass" && t.Warned():
+		fmt.Printf(format, "PASS (with warnings)", t.name, tstr, t.output.Bytes())
+	case *chatty:
+		fmt.Printf(format, strings.ToUpper(status), t.name, tstr, t.output.Bytes())
 	}
 }
 
-func RunTasks(matchString func(pat, str string) (bool, error), tasks []InternalTask) (ok bool) {
-	ok = true
-	if len(tasks) == 0 /*&& !haveExamples*/ {
-		fmt.Fprintln(os.Stderr, "tasking: warning: no tasks to run")
-		return
-	}
-	for _, procs := range cpuList {
-		runtime.GOMAXPROCS(procs)
-		// We build a new channel tree for each run of the loop.
-		// collector merges in one channel all the upstream signals from parallel tasks.
-		// If all tasks pump to the same channel, a bug can occur where a task
-		// kicks off a goroutine that Fails, yet the task still delivers a completion signal,
-		// which skews the counting.
-		var collector = make(chan interface{})
-
-		numParallel := 0
-		startParallel := make(chan bool)
-
-		for i := 0; i < len(tasks); i++ {
-			matched, err := matchString(*match, tasks[i].Name)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "tasking: invalid regexp for -task.run: %s\n", err)
-				os.Exit(1)
-			}
-			if !matched {
-				continue
-			}
-			taskName := tasks[i].Name
-			if procs != 1 {
-				taskName = fmt.Sprintf("%s-%d", tasks[i].Name, procs)
-			}
-			t := &T{
-				common: common{
-					signal: make(chan interface{}),
-				},
-				name:          taskName,
-				startParallel: startParallel,
-			}
-			t.self = t
-			if *chatty {
-				fmt.Printf("=== RUN %s\n", t.name)
-			}
-			go tRunner(t, &tasks[i])
-			out := (<-t.signal).(*T)
-			if out == nil { // Parallel run.
-				go func() {
-					collector <- <-t.signal
-				}()
-				numParallel++
-				continue
+// selectTasks returns the named tasks, in the order given. The name of a task
+// with a matrix selects all its instances.
+//
+// A name which is not found as is matches the task whose name is equal when
+// the prefix "Task", the case, hyphens and underscores are ignored; so "build"
+// selects TaskBuild and "docker-push" selects TaskDockerPush.
+func selectTasks(tasks []InternalTask, names []string) ([]InternalTask, error) {
+	selected := make([]InternalTask, 0, len(names))
+	seen := make(map[string]bool)
+
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		matches := matchName(tasks, name, func(a, b string) bool { return a == b })
+		if len(matches) == 0 {
+			matches = matchName(tasks, name, func(a, b string) bool {
+				return normalizeName(a) == normalizeName(b)
+			})
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("unknown task %q", name)
+		}
+
+		// Different tasks could be normalized to the same name.
+		funcs := make([]string, 0, 1)
+		for _, task := range matches {
+			if base := baseName(task.Name); len(funcs) == 0 || funcs[len(funcs)-1] != base {
+				funcs = append(funcs, base)
 			}
-			t.report()
-			ok = ok && !out.Failed()
+		}
+		if len(funcs) > 1 {
+			return nil, fmt.Errorf("ambiguous task %q: it matches %s", name, strings.Join(funcs, ", "))
 		}
 
-		running := 0
-		for numParallel+running > 0 {
-			if running < *parallel && numParallel > 0 {
-				startParallel <- true
-				running++
-				numParallel--
-				continue
+		for _, task := range matches {
+			if !seen[task.Name] {
+				seen[task.Name] = true
+				selected = append(selected, task)
 			}
-			t := (<-collector).(*T)
-			t.report()
-			ok = ok && !t.Failed()
-			running--
 		}
 	}
-	return
+	return selected, nil
+}
+
+// exitNoTasks prints why no task is selected, followed by the names of the
+// tasks, and exits with EXIT_NO_TASKS.
+func exitNoTasks(reason string, tasks []InternalTask) {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "tasking: %s; the tasks are:\n", reason)
+	for i, task := range tasks {
+		// The instances of a matrix or manifest are listed after their task.
+		if base := baseName(task.Name); i == 0 || baseName(tasks[i-1].Name) != base {
+			fmt.Fprintf(&buf, "\t%s\n", base)
+		}
+		if task.Name != baseName(task.Name) {
+			fmt.Fprintf(&buf, "\t  %s\n", task.Name)
+		}
+	}
+	fmt.Fprint(os.Stderr, buf.String())
+	os.Exit(EXIT_NO_TASKS)
+}
+
+// matchTasks returns the tasks whose name matches the regular expression of
+// the flag -task.run.
+func matchTasks(matchString func(pat, str string) (bool, error), tasks []InternalTask) []InternalTask {
+	matches := make([]InternalTask, 0, len(tasks))
+	for _, task := range tasks {
+		matched, err := matchString(*match, task.Name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "tasking: invalid regexp for -task.run: %s\n", err)
+			os.Exit(EXIT_USAGE)
+		}
+		if matched {
+			matches = append(matches, task)
+		}
+	}
+	return matches
+}
+
+// matchName returns the tasks whose name, or the name of its task function,
+// is equal to name according to the function equal. The instance of a matrix,
+// after the slash, is compared as is.
+func matchName(tasks []InternalTask, name string, equal func(a, b string) bool) []InternalTask {
+	base, instance := name, ""
+	if i := strings.IndexByte(name, '/'); i != -1 {
+		base, instance = name[:i], name[i:]
+	}
+
+	matches := make([]InternalTask, 0, 1)
+	for _, task := range tasks {
+		taskBase := baseName(task.Name)
+		if !equal(taskBase, base) {
+			continue
+		}
+		if instance == "" || task.Name[len(taskBase):] == instance {
+			matches = append(matches, task)
+		}
+	}
+	return matches
+}
+
+// normalizeName returns the name of a task without the prefix "Task", hyphens
+// nor underscores, in lower case.
+func normalizeName(name string) string {
+	name = strings.ToLower(strings.NewReplacer("-", "", "_", "").Replace(name))
+	return strings.TrimPrefix(name, "task")
+}
+
+// listTasks prints the tasks matching the -task.list flag, with their source
+// location and the first sentence of their documentation.
+func listTasks(matchString func(pat, str string) (bool, error), tasks []InternalTask) {
+	for _, task := range tasks {
+		matched, err := matchString(*matchList, task.Name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "tasking: invalid regexp for -task.list: %s\n", err)
+			os.Exit(EXIT_USAGE)
+		}
+		if !matched {
+			continue
+		}
+
+		if *jsonOutput {
+			emit(Event{Action: "list", Task: task.Name, File: task.File, Line: task.Line, Output: task.Doc})
+			continue
+		}
+		synopsis := task.Doc
+		if i := strings.Index(synopsis, "\n"); i != -1 {
+			synopsis = synopsis[:i]
+		}
+		fmt.Printf("%s\t%s:%d\t%s\n", task.Name, task.File, task.Line, synopsis)
+		for _, p := range task.Params {
+			fmt.Printf("\tparam %s\n", p)
+		}
+		for _, a := range task.Matrix {
+			fmt.Printf("\tmatrix %s\n", a)
+		}
+		if deps := append(task.Deps[:len(task.Deps):len(task.Deps)], task.ExtDeps...); len(deps) != 0 {
+			fmt.Printf("\tdeps %s\n", strings.Join(deps, " "))
+		}
+		if len(task.When) != 0 {
+			fmt.Printf("\twhen %s\n", strings.Join(task.When, " "))
+		}
+		if len(task.Groups) != 0 {
+			fmt.Printf("\tgroups %s\n", strings.Join(task.Groups, " "))
+		}
+	}
 }
 
 // before runs before all run tasks.
@@ -508,7 +1125,7 @@ func RunTasks(matchString func(pat, str string) (bool, error), tasks []InternalT
 
 // toOutputDir returns the file name relocated, if required, to outputDir.
 // Simple implementation to avoid pulling in path/filepath.
-/*func toOutputDir(path string) string {
+func toOutputDir(path string) string {
 	if *outputDir == "" || path == "" {
 		return path
 	}
@@ -532,15 +1149,30 @@ func RunTasks(matchString func(pat, str string) (bool, error), tasks []InternalT
 		return path
 	}
 	return fmt.Sprintf("%s%c%s", *outputDir, os.PathSeparator, path)
-}*/
+}
 
 var timer *time.Timer
 
 // startAlarm starts an alarm if requested.
 func startAlarm() {
 	if *timeout > 0 {
+		alarmMu.Lock()
+		defer alarmMu.Unlock()
+		alarmDeadline = time.Now().Add(*timeout)
+		alarmExtended = 0
 		timer = time.AfterFunc(*timeout, func() {
-			panic(fmt.Sprintf("task timed out after %v", *timeout))
+			stopAllProcesses()
+			alarmMu.Lock()
+			limit := *timeout + alarmExtended
+			alarmMu.Unlock()
+
+			// Like a panic, with the stacks of all the goroutines to find the
+			// tasks which have not finished.
+			buf := make([]byte, 1<<20)
+			buf = buf[:runtime.Stack(buf, true)]
+			fmt.Fprintf(os.Stderr, "tasking: run timed out after %v\n\n%s\n", limit, buf)
+			removeSpools()
+			os.Exit(EXIT_TIMEOUT)
 		})
 	}
 }
@@ -561,7 +1193,7 @@ func parseCpuList() {
 		cpu, err := strconv.Atoi(val)
 		if err != nil || cpu <= 0 {
 			fmt.Fprintf(os.Stderr, "tasking: invalid value %q for -task.cpu\n", val)
-			os.Exit(1)
+			os.Exit(EXIT_USAGE)
 		}
 		cpuList = append(cpuList, cpu)
 	}
@@ -0,0 +1,42 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// +build !windows
+
+package tasking
+
+import (
+	"os"
+	"os/exec"
+)
+
+// isTerminal reports whether the file is a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	// Other character devices, like "/dev/null", have no terminal settings.
+	cmd := exec.Command("stty", "-g")
+	cmd.Stdin = f
+	return cmd.Run() == nil
+}
+
+// disableEcho turns off the echo of the terminal, returning a function which
+// turns it on again.
+func disableEcho(f *os.File) (restore func(), err error) {
+	cmd := exec.Command("stty", "-echo")
+	cmd.Stdin = f
+	if err = cmd.Run(); err != nil {
+		return nil, err
+	}
+
+	return func() {
+		cmd := exec.Command("stty", "echo")
+		cmd.Stdin = f
+		cmd.Run()
+	}, nil
+}
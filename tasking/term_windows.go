@@ -0,0 +1,44 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const enableEchoInput = 0x0004
+
+var (
+	kernel32           = syscall.NewLazyDLL("kernel32.dll")
+	procGetConsoleMode = kernel32.NewProc("GetConsoleMode")
+	procSetConsoleMode = kernel32.NewProc("SetConsoleMode")
+)
+
+// isTerminal reports whether the file is a console.
+func isTerminal(f *os.File) bool {
+	var mode uint32
+	r, _, _ := procGetConsoleMode.Call(f.Fd(), uintptr(unsafe.Pointer(&mode)))
+	return r != 0
+}
+
+// disableEcho turns off the echo of the console, returning a function which
+// turns it on again.
+func disableEcho(f *os.File) (restore func(), err error) {
+	var mode uint32
+	h := f.Fd()
+
+	if r, _, err := procGetConsoleMode.Call(h, uintptr(unsafe.Pointer(&mode))); r == 0 {
+		return nil, err
+	}
+	if r, _, err := procSetConsoleMode.Call(h, uintptr(mode&^enableEchoInput)); r == 0 {
+		return nil, err
+	}
+
+	return func() { procSetConsoleMode.Call(h, uintptr(mode)) }, nil
+}
@@ -0,0 +1,216 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// DIFF_CONTEXT is the number of unchanged lines shown around the changes by
+// DiffText, and MAX_DIFF_CELLS the size above which the changed lines are not
+// matched, to bound the time and memory used.
+const (
+	DIFF_CONTEXT   = 3
+	MAX_DIFF_CELLS = 4 << 20
+)
+
+// ANSI sequences to color the diffs.
+const (
+	colorRed   = "\x1b[31m"
+	colorGreen = "\x1b[32m"
+	colorCyan  = "\x1b[36m"
+	colorReset = "\x1b[0m"
+)
+
+var (
+	colorOnce sync.Once
+	colored   bool
+)
+
+// useColor reports whether the output of the run goes to a terminal which can
+// show colors, unless the variable NO_COLOR is set.
+func useColor() bool {
+	colorOnce.Do(func() {
+		colored = !*jsonOutput && os.Getenv("NO_COLOR") == "" && os.Getenv("TERM") != "dumb" &&
+			isTerminal(os.Stdout)
+	})
+	return colored
+}
+
+// DiffText returns the differences between the lines of the texts want and got,
+// like the expected and the actual output of a program, in the unified format
+// without file headers: the lines only in want are prefixed by '-', the ones
+// only in got by '+', and DIFF_CONTEXT unchanged lines around them by ' '. The
+// lines are colored when the run is printed to a terminal. It returns an empty
+// string if the texts are equal.
+func DiffText(want, got string) string {
+	if want == got {
+		return ""
+	}
+	a := splitLines(want)
+	b := splitLines(got)
+	ops := diffLines(a, b)
+	color := useColor()
+
+	var changes []int // Index of the changed lines.
+	for k, op := range ops {
+		if op.kind != ' ' {
+			changes = append(changes, k)
+		}
+	}
+
+	var buf strings.Builder
+	for c := 0; c < len(changes); {
+		// The changes are in the same hunk while their context overlaps.
+		first, last := changes[c], changes[c]
+		for c++; c < len(changes) && changes[c]-last-1 <= 2*DIFF_CONTEXT; c++ {
+			last = changes[c]
+		}
+		start, end := first-DIFF_CONTEXT, last+DIFF_CONTEXT+1
+		if start < 0 {
+			start = 0
+		}
+		if end > len(ops) {
+			end = len(ops)
+		}
+
+		hunk := ops[start:end]
+		header := fmt.Sprintf("@@ -%s +%s @@", hunkRange(hunk, '+'), hunkRange(hunk, '-'))
+		if color {
+			header = colorCyan + header + colorReset
+		}
+		buf.WriteString(header + "\n")
+		for _, op := range hunk {
+			line := string(op.kind) + op.line
+			if color && op.kind != ' ' {
+				col := colorRed
+				if op.kind == '+' {
+					col = colorGreen
+				}
+				line = col + line + colorReset
+			}
+			buf.WriteString(line + "\n")
+		}
+	}
+	return buf.String()
+}
+
+// lineOp is a line of a diff, with the kind ' ', '-' or '+'.
+type lineOp struct {
+	kind byte
+	line string
+	a, b int // Number of the line into each text, from 1.
+}
+
+// hunkRange returns the range of lines of the hunk into a text, without the
+// lines of the kind skip, as "start,count".
+func hunkRange(hunk []lineOp, skip byte) string {
+	start, count := 0, 0
+	for _, op := range hunk {
+		if op.kind == skip {
+			continue
+		}
+		n := op.a
+		if skip == '-' {
+			n = op.b
+		}
+		if count == 0 {
+			start = n
+		}
+		count++
+	}
+	if count == 0 {
+		// An empty range is given by the line before it.
+		for _, op := range hunk {
+			if skip == '+' {
+				start = op.a - 1
+			} else {
+				start = op.b - 1
+			}
+			break
+		}
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+// splitLines returns the lines of the text, without the final newline.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// diffLines returns the operations which turn the lines a into the lines b,
+// matching their longest common subsequence.
+func diffLines(a, b []string) []lineOp {
+	// The common prefix and suffix are kept out of the matching.
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	ma, mb := a[pre:len(a)-suf], b[pre:len(b)-suf]
+
+	ops := make([]lineOp, 0, len(a)+len(b))
+	for i := 0; i < pre; i++ {
+		ops = append(ops, lineOp{' ', a[i], i + 1, i + 1})
+	}
+
+	if (len(ma)+1)*(len(mb)+1) > MAX_DIFF_CELLS {
+		for i, l := range ma {
+			ops = append(ops, lineOp{'-', l, pre + i + 1, pre + 1})
+		}
+		for j, l := range mb {
+			ops = append(ops, lineOp{'+', l, pre + len(ma) + 1, pre + j + 1})
+		}
+	} else {
+		// lcs[i][j] is the length of the longest common subsequence of
+		// ma[i:] and mb[j:].
+		w := len(mb) + 1
+		lcs := make([]int32, (len(ma)+1)*w)
+		for i := len(ma) - 1; i >= 0; i-- {
+			for j := len(mb) - 1; j >= 0; j-- {
+				if ma[i] == mb[j] {
+					lcs[i*w+j] = lcs[(i+1)*w+j+1] + 1
+				} else if lcs[(i+1)*w+j] >= lcs[i*w+j+1] {
+					lcs[i*w+j] = lcs[(i+1)*w+j]
+				} else {
+					lcs[i*w+j] = lcs[i*w+j+1]
+				}
+			}
+		}
+
+		i, j := 0, 0
+		for i < len(ma) || j < len(mb) {
+			switch {
+			case i < len(ma) && j < len(mb) && ma[i] == mb[j]:
+				ops = append(ops, lineOp{' ', ma[i], pre + i + 1, pre + j + 1})
+				i++
+				j++
+			case j == len(mb) || (i < len(ma) && lcs[(i+1)*w+j] >= lcs[i*w+j+1]):
+				ops = append(ops, lineOp{'-', ma[i], pre + i + 1, pre + j + 1})
+				i++
+			default:
+				ops = append(ops, lineOp{'+', mb[j], pre + i + 1, pre + j + 1})
+				j++
+			}
+		}
+	}
+
+	for k := 0; k < suf; k++ {
+		i, j := len(a)-suf+k, len(b)-suf+k
+		ops = append(ops, lineOp{' ', a[i], i + 1, j + 1})
+	}
+	return ops
+}
@@ -0,0 +1,174 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TRANSFER_ATTEMPTS is the number of attempts of a file transfer, and
+// PROGRESS_INTERVAL the interval to log its progress.
+const (
+	TRANSFER_ATTEMPTS = 3
+	PROGRESS_INTERVAL = 5 * time.Second
+)
+
+// Upload copies the local file to the path on the remote host, keeping its
+// permissions. The file is written to a temporary file which replaces the
+// remote one once its SHA-256 sum is checked, so that a broken transfer never
+// leaves a partial file; it is retried up to TRANSFER_ATTEMPTS times. The
+// progress is logged for long transfers.
+//
+// The remote host needs a POSIX shell with sha256sum or shasum.
+func (s *SSH) Upload(local, remote string) error {
+	s.t.log(runPrefix()+"upload "+local+" "+s.host+":"+remote, nil)
+	if *dryRun {
+		return nil
+	}
+
+	sum, err := fileSum(local)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(local)
+	if err != nil {
+		return err
+	}
+
+	tmp := shellQuote(remote + ".gake-tmp")
+	command := fmt.Sprintf("cat > %[1]s && chmod %[2]o %[1]s && sum=$(%[3]s %[1]s) && set -- $sum && "+
+		`if [ "$1" = %[4]s ]; then mv -f %[1]s %[5]s; else rm -f %[1]s; echo "checksum mismatch" >&2; exit 1; fi`,
+		tmp, info.Mode().Perm(), remoteSum, sum, shellQuote(remote))
+
+	return s.retry("upload", func() error {
+		f, err := os.Open(local)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		cmd := s.command(command)
+		cmd.Stdin = io.TeeReader(f, s.t.newProgress("upload", info.Size()))
+		return s.t.exec(cmd)
+	})
+}
+
+// Download copies the file at the path on the remote host to the local one.
+// Like Upload, the file is written to a temporary file which replaces the local
+// one once its SHA-256 sum is checked, and it is retried up to
+// TRANSFER_ATTEMPTS times.
+func (s *SSH) Download(remote, local string) error {
+	s.t.log(runPrefix()+"download "+s.host+":"+remote+" "+local, nil)
+	if *dryRun {
+		return nil
+	}
+
+	var info bytes.Buffer
+	src := shellQuote(remote)
+	cmd := s.command(fmt.Sprintf("sum=$(%s %s) && set -- $sum && echo $1 $(wc -c < %s)", remoteSum, src, src))
+	cmd.Stdout = &info
+	if err := s.t.exec(cmd); err != nil {
+		return err
+	}
+	fields := strings.Fields(info.String())
+	if len(fields) != 2 {
+		return fmt.Errorf("download: unexpected answer %q", info.String())
+	}
+	sum := fields[0]
+	size, _ := strconv.ParseInt(fields[1], 10, 64)
+
+	tmp := filepath.Join(filepath.Dir(local), "."+filepath.Base(local)+".gake-tmp")
+	return s.retry("download", func() error {
+		f, err := os.Create(tmp)
+		if err != nil {
+			return err
+		}
+		h := sha256.New()
+		cmd := s.command("cat " + src)
+		cmd.Stdout = io.MultiWriter(f, h, s.t.newProgress("download", size))
+		err = s.t.exec(cmd)
+		if err2 := f.Close(); err == nil {
+			err = err2
+		}
+		if err == nil && hex.EncodeToString(h.Sum(nil)) != sum {
+			err = fmt.Errorf("checksum mismatch")
+		}
+		if err != nil {
+			os.Remove(tmp)
+			return err
+		}
+		return os.Rename(tmp, local)
+	})
+}
+
+// remoteSum is the shell command which prints the SHA-256 sum of a file.
+const remoteSum = "{ sha256sum 2>/dev/null || shasum -a 256; } <"
+
+var transferBackoff = Backoff{Initial: time.Second, Max: 10 * time.Second, Jitter: 0.2}
+
+// retry runs the transfer until it succeeds, up to TRANSFER_ATTEMPTS times.
+func (s *SSH) retry(op string, transfer func() error) (err error) {
+	for i := 1; ; i++ {
+		if err = transfer(); err == nil || i == TRANSFER_ATTEMPTS {
+			return err
+		}
+		s.t.write(fmt.Sprintf("\t\t%s: attempt %d failed: %s; retrying\n", op, i, err), nil)
+		time.Sleep(transferBackoff.Delay(i))
+	}
+}
+
+// fileSum returns the SHA-256 sum of the named file, in hexadecimal.
+func fileSum(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// progress is a writer which counts the bytes transferred and logs the progress
+// into the output of a task every PROGRESS_INTERVAL.
+type progress struct {
+	t     *T
+	op    string
+	total int64
+	n     int64
+	last  time.Time
+}
+
+func (t *T) newProgress(op string, total int64) *progress {
+	return &progress{t: t, op: op, total: total, last: time.Now()}
+}
+
+func (p *progress) Write(b []byte) (int, error) {
+	p.n += int64(len(b))
+
+	if time.Since(p.last) >= PROGRESS_INTERVAL {
+		p.last = time.Now()
+		msg := fmt.Sprintf("\t\t%s: %d bytes", p.op, p.n)
+		if p.total > 0 {
+			msg += fmt.Sprintf(" of %d (%d%%)", p.total, p.n*100/p.total)
+		}
+		p.t.write(msg+"\n", nil)
+	}
+	return len(b), nil
+}
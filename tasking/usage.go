@@ -0,0 +1,56 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// Usage is the use of resources by a task, measured around its run. The tasks
+// run into the same process, so the figures of the tasks run in parallel
+// overlap.
+type Usage struct {
+	UserTime    time.Duration // CPU time spent in user mode.
+	SystemTime  time.Duration // CPU time spent in kernel mode.
+	MaxRSSDelta int64         // Growth of the peak resident set size, in bytes.
+	Alloc       uint64        // Bytes allocated in the heap.
+	NumGC       uint32        // Garbage collections completed.
+	GCPause     time.Duration // Time stopped by the garbage collector.
+}
+
+// readUsage returns the use of resources of the process until now.
+func readUsage() Usage {
+	var u Usage
+	u.UserTime, u.SystemTime, u.MaxRSSDelta = processUsage()
+
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	u.Alloc = ms.TotalAlloc
+	u.NumGC = ms.NumGC
+	u.GCPause = time.Duration(ms.PauseTotalNs)
+	return u
+}
+
+// sub returns the use of resources since the measure in u0.
+func (u Usage) sub(u0 Usage) Usage {
+	return Usage{
+		UserTime:    u.UserTime - u0.UserTime,
+		SystemTime:  u.SystemTime - u0.SystemTime,
+		MaxRSSDelta: u.MaxRSSDelta - u0.MaxRSSDelta,
+		Alloc:       u.Alloc - u0.Alloc,
+		NumGC:       u.NumGC - u0.NumGC,
+		GCPause:     u.GCPause - u0.GCPause,
+	}
+}
+
+func (u Usage) String() string {
+	return fmt.Sprintf("user=%v sys=%v maxrss+=%dKB alloc=%dKB gc=%d gcpause=%v",
+		u.UserTime.Round(time.Millisecond), u.SystemTime.Round(time.Millisecond),
+		u.MaxRSSDelta>>10, u.Alloc>>10, u.NumGC, u.GCPause.Round(time.Microsecond))
+}
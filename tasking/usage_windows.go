@@ -0,0 +1,51 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+var procGetProcessMemoryInfo = kernel32.NewProc("K32GetProcessMemoryInfo")
+
+// processMemoryCounters is the structure PROCESS_MEMORY_COUNTERS.
+type processMemoryCounters struct {
+	cb                         uint32
+	pageFaultCount             uint32
+	peakWorkingSetSize         uintptr
+	workingSetSize             uintptr
+	quotaPeakPagedPoolUsage    uintptr
+	quotaPagedPoolUsage        uintptr
+	quotaPeakNonPagedPoolUsage uintptr
+	quotaNonPagedPoolUsage     uintptr
+	pagefileUsage              uintptr
+	peakPagefileUsage          uintptr
+}
+
+// processUsage returns the CPU time of the process in user and kernel modes,
+// and its peak working set size in bytes.
+func processUsage() (user, sys time.Duration, maxRSS int64) {
+	h, err := syscall.GetCurrentProcess()
+	if err != nil {
+		return 0, 0, 0
+	}
+	var creation, exit, kernel, usr syscall.Filetime
+	if err = syscall.GetProcessTimes(h, &creation, &exit, &kernel, &usr); err == nil {
+		// Filetime counts intervals of 100 nanoseconds.
+		user = time.Duration(uint64(usr.HighDateTime)<<32|uint64(usr.LowDateTime)) * 100
+		sys = time.Duration(uint64(kernel.HighDateTime)<<32|uint64(kernel.LowDateTime)) * 100
+	}
+
+	var mc processMemoryCounters
+	mc.cb = uint32(unsafe.Sizeof(mc))
+	if r, _, _ := procGetProcessMemoryInfo.Call(uintptr(h), uintptr(unsafe.Pointer(&mc)), uintptr(mc.cb)); r != 0 {
+		maxRSS = int64(mc.peakWorkingSetSize)
+	}
+	return user, sys, maxRSS
+}
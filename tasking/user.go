@@ -0,0 +1,49 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// RunAs runs the processes launched by Exec, ExecCmd, Shell and StartService
+// as the named user, like "root" to elevate the privileges of a step or
+// "nobody" to drop them; the empty name runs them as the actual user again.
+// It is equivalent to "gake:user name".
+//
+// On Unix, the privileges are dropped by gake itself when it is run as root,
+// with the groups and the home directory of the user; else, the command is run
+// through "sudo -n -u name", so the rules of sudo have to allow it without a
+// password, and the environment is the one kept by sudo. On Windows, the
+// command is run through "runas /savecred", in a new console: its output is not
+// captured and the password is asked the first time.
+func (t *T) RunAs(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.user = name
+}
+
+// runUser returns the user whom the processes of the task are run as, or "" for
+// the actual user.
+func (t *T) runUser() string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.user
+}
+
+// setUser prepares the command to be run as the user of the task, if any.
+func (t *T) setUser(cmd *exec.Cmd) error {
+	name := t.runUser()
+	if name == "" {
+		return nil
+	}
+	if err := runAs(cmd, name); err != nil {
+		return fmt.Errorf("can't run as user %s: %s", name, err)
+	}
+	return nil
+}
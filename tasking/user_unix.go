@@ -0,0 +1,83 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// +build !windows
+
+package tasking
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// runAs changes the command to be run as the named user: with its credentials
+// when the process is run as root, or else through sudo.
+func runAs(cmd *exec.Cmd, name string) error {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return err
+	}
+	if strconv.Itoa(os.Geteuid()) == u.Uid {
+		return nil
+	}
+
+	if os.Geteuid() != 0 {
+		sudo, err := exec.LookPath("sudo")
+		if err != nil {
+			return errors.New("not run as root, and sudo is not found")
+		}
+		args := []string{"sudo", "-n", "-u", name, "--", cmd.Path}
+		cmd.Args = append(args, cmd.Args[1:]...)
+		cmd.Path = sudo
+		return nil
+	}
+
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return err
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return err
+	}
+	cred := &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}
+	if ids, err := u.GroupIds(); err == nil {
+		for _, id := range ids {
+			if g, err := strconv.ParseUint(id, 10, 32); err == nil {
+				cred.Groups = append(cred.Groups, uint32(g))
+			}
+		}
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = new(syscall.SysProcAttr)
+	}
+	cmd.SysProcAttr.Credential = cred
+
+	// The variables of the identity of the user.
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	set := map[string]string{"HOME": u.HomeDir, "USER": u.Username, "LOGNAME": u.Username}
+	cmd.Env = make([]string, 0, len(env)+len(set))
+	for _, kv := range env {
+		if i := strings.IndexByte(kv, '='); i != -1 {
+			if _, ok := set[kv[:i]]; ok {
+				continue
+			}
+		}
+		cmd.Env = append(cmd.Env, kv)
+	}
+	for _, k := range []string{"HOME", "USER", "LOGNAME"} {
+		cmd.Env = append(cmd.Env, k+"="+set[k])
+	}
+	return nil
+}
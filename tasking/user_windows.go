@@ -0,0 +1,47 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"os/exec"
+	"os/user"
+	"strings"
+	"syscall"
+)
+
+// runAs changes the command to be run as the named user, through runas.
+func runAs(cmd *exec.Cmd, name string) error {
+	if cur, err := user.Current(); err == nil {
+		username := cur.Username
+		if i := strings.LastIndexByte(username, '\\'); i != -1 && !strings.Contains(name, `\`) {
+			username = username[i+1:]
+		}
+		if strings.EqualFold(username, name) {
+			return nil
+		}
+	}
+	runas, err := exec.LookPath("runas")
+	if err != nil {
+		return err
+	}
+
+	// runas takes the command line as a single argument.
+	args := make([]string, len(cmd.Args))
+	args[0] = syscall.EscapeArg(cmd.Path)
+	for i, a := range cmd.Args[1:] {
+		args[i+1] = syscall.EscapeArg(a)
+	}
+	line := strings.Replace(strings.Join(args, " "), `"`, `\"`, -1)
+
+	cmd.Path = runas
+	cmd.Args = []string{"runas", "/savecred", "/user:" + name, line}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = new(syscall.SysProcAttr)
+	}
+	cmd.SysProcAttr.CmdLine = `runas /savecred /user:` + syscall.EscapeArg(name) + ` "` + line + `"`
+	return nil
+}
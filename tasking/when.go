@@ -0,0 +1,63 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+import (
+	"os"
+	"runtime"
+	"strings"
+)
+
+// conditionsHold reports whether all the conditions, declared by "gake:when"
+// directives, hold. A condition has one of the forms:
+//
+//	GOOS=value,...     the operating system is one of the values
+//	GOARCH=value,...   the architecture is one of the values
+//	env:NAME           the environment variable is set and not empty
+//	env:NAME=value     the environment variable is set to the value
+//
+// and it is negated by a prefix '!'.
+func conditionsHold(conds []string) bool {
+	for _, c := range conds {
+		if !conditionHolds(c) {
+			return false
+		}
+	}
+	return true
+}
+
+func conditionHolds(c string) bool {
+	neg := strings.HasPrefix(c, "!")
+	c = strings.TrimPrefix(c, "!")
+	ok := false
+
+	switch {
+	case strings.HasPrefix(c, "env:"):
+		name := c[len("env:"):]
+		if i := strings.IndexByte(name, '='); i != -1 {
+			v, set := os.LookupEnv(name[:i])
+			ok = set && v == name[i+1:]
+		} else {
+			ok = os.Getenv(name) != ""
+		}
+	case strings.HasPrefix(c, "GOOS="):
+		ok = oneOf(runtime.GOOS, c[len("GOOS="):])
+	case strings.HasPrefix(c, "GOARCH="):
+		ok = oneOf(runtime.GOARCH, c[len("GOARCH="):])
+	}
+	return ok != neg
+}
+
+// oneOf reports whether s is one of the comma-separated values.
+func oneOf(s, values string) bool {
+	for _, v := range strings.Split(values, ",") {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tasking
+
+// ExpectFail marks the task as known to be broken, for the given reason. When
+// it fails, it is reported as XFAIL and the run does not fail; when it passes,
+// it is reported as XPASS and it fails, so that the mark is removed once the
+// task is fixed. It is equivalent to the directive "gake:xfail reason" into the
+// task documentation.
+func (t *T) ExpectFail(reason string) {
+	if reason == "" {
+		reason = "expected to fail"
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.xfail = reason
+}
+
+// checkExpectedFailure sets the result of a task expected to fail, once it is
+// finished.
+func (t *T) checkExpectedFailure() {
+	t.mu.Lock()
+	if t.xfail == "" || t.skipped {
+		t.mu.Unlock()
+		return
+	}
+	if t.failed {
+		t.failed = false
+		t.xfailed = true
+		t.mu.Unlock()
+		return
+	}
+	t.failed = true
+	t.xpassed = true
+	reason := t.xfail
+	t.mu.Unlock()
+
+	t.write("\tunexpected pass: "+reason+"\n", nil)
+}
+
+// status returns the result of the finished task: "pass", "fail", "skip",
+// "xfail", "xpass" or "notrun".
+func (t *T) status() string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	switch {
+	case t.aborted:
+		return "notrun"
+	case t.xpassed:
+		return "xpass"
+	case t.failed:
+		return "fail"
+	case t.xfailed:
+		return "xfail"
+	case t.skipped:
+		return "skip"
+	}
+	return "pass"
+}
@@ -0,0 +1,92 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/tredoe/gake/internal/pty"
+)
+
+// runTerminal runs the command with a pseudo-terminal as its standard input,
+// output and error, for the flag -pty. What is read from stdin, if it is not
+// nil, is typed into the terminal, and the output of the terminal is written
+// to stdout.
+func runTerminal(cmd *exec.Cmd, stdin io.Reader, stdout io.Writer) error {
+	master, slave, err := pty.Open()
+	if err != nil {
+		return infraError(INFRA_INTERNAL, fmt.Errorf("can't use -pty: %s", err))
+	}
+	defer master.Close()
+
+	if pty.InheritSize(os.Stdout, slave) != nil {
+		pty.SetSize(slave, 24, 80)
+	}
+	cmd.Stdin = slave
+	cmd.Stdout = slave
+	cmd.Stderr = slave
+	pty.SetControlling(cmd)
+
+	err = cmd.Start()
+	slave.Close()
+	if err != nil {
+		return err
+	}
+
+	if stdin != nil {
+		// The keys are passed as they are typed, since the terminal of the
+		// command handles the lines and the echo.
+		if f, ok := stdin.(*os.File); ok {
+			if restore, err := makeRaw(f); err == nil {
+				defer restore()
+			}
+		}
+		go io.Copy(master, stdin)
+	}
+
+	copied := make(chan bool)
+	go func() {
+		io.Copy(stdout, master)
+		close(copied)
+	}()
+	err = cmd.Wait()
+
+	// Read what is left once the process has exited.
+	if master.SetReadDeadline(time.Now().Add(100*time.Millisecond)) != nil {
+		master.Close()
+	}
+	<-copied
+	return err
+}
+
+// makeRaw sets the terminal in raw mode, returning a function which restores
+// its mode. It fails if f is not a terminal.
+func makeRaw(f *os.File) (restore func(), err error) {
+	cmd := exec.Command("stty", "-g")
+	cmd.Stdin = f
+	mode, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	cmd = exec.Command("stty", "raw", "-echo")
+	cmd.Stdin = f
+	if err = cmd.Run(); err != nil {
+		return nil, err
+	}
+
+	return func() {
+		cmd := exec.Command("stty", string(bytes.TrimSpace(mode)))
+		cmd.Stdin = f
+		cmd.Run()
+	}, nil
+}
@@ -0,0 +1,14 @@
+// +build gake
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/tredoe/gake/tasking"
+	"github.com/tredoe/gake/testdata/ext_pkg"
+)
+
+// The tag "gake" adds the task files of ext_pkg, of the package ext_tasks, to
+// the package ext imported.
+func TaskImport(t *tasking.T) { fmt.Println(ext.Greeting()) }
@@ -0,0 +1,4 @@
+package ext
+
+// Greeting returns the greeting used by the tasks.
+func Greeting() string { return "Hello from ext!" }
@@ -0,0 +1,12 @@
+// +build gake
+
+package ext_tasks
+
+import (
+	"fmt"
+
+	"github.com/tredoe/gake/tasking"
+	"github.com/tredoe/gake/testdata/ext_pkg"
+)
+
+func TaskGreeting(t *tasking.T) { fmt.Println(ext.Greeting()) }
@@ -0,0 +1,19 @@
+// +build gake
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/tredoe/gake/tasking"
+)
+
+// TaskZulu says its name.
+func TaskZulu(t *tasking.T) {
+	fmt.Println("Zulu")
+}
+
+// TaskAlpha says its name.
+func TaskAlpha(t *tasking.T) {
+	fmt.Println("Alpha")
+}
@@ -0,0 +1,14 @@
+// +build gake
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/tredoe/gake/tasking"
+)
+
+// TaskMike says its name.
+func TaskMike(t *tasking.T) {
+	fmt.Println("Mike")
+}
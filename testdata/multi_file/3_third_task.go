@@ -0,0 +1,19 @@
+// +build gake
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/tredoe/gake/tasking"
+)
+
+// TaskBravo says its name.
+func TaskBravo(t *tasking.T) {
+	fmt.Println("Bravo")
+}
+
+// TaskYankee says its name.
+func TaskYankee(t *tasking.T) {
+	fmt.Println("Yankee")
+}
@@ -0,0 +1,14 @@
+// +build gake
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/tredoe/gake/tasking"
+)
+
+// TaskPrint prints a line which is not an event.
+func TaskPrint(t *tasking.T) {
+	fmt.Println("Printed")
+}
@@ -0,0 +1,10 @@
+// +build gake
+
+package main
+
+import "github.com/tredoe/gake/tasking"
+
+// TaskLog logs a line.
+func TaskLog(t *tasking.T) {
+	t.Log("Logged")
+}
@@ -0,0 +1,11 @@
+// +build gake
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/tredoe/gake/tasking"
+)
+
+func TaskTest(t *tasking.T) { fmt.Println("Done") }
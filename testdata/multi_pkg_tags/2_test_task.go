@@ -0,0 +1,4 @@
+package lib
+
+// Task is not a task file, since it has not the build constraint.
+func Task() {}
@@ -0,0 +1,5 @@
+// +build !gake
+
+package lib2
+
+func Task() {}
@@ -0,0 +1,10 @@
+// +build gake
+
+package main
+
+import "github.com/tredoe/gake/tasking"
+
+// TaskFail fails.
+func TaskFail(t *tasking.T) {
+	t.Error("Failed")
+}
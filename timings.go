@@ -0,0 +1,63 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Phases of a run of gake, whose time is printed by the flag -debug-timings.
+const (
+	PHASE_DISCOVERY = "discovery" // Resolution of the path and search of the task directories.
+	PHASE_PARSING   = "parsing"   // Configuration, parse of the task files and hash of the inputs.
+	PHASE_BUILDING  = "building"  // Build of the task binaries.
+	PHASE_RUNNING   = "running"   // Run of the task binaries.
+)
+
+var phases = []string{PHASE_DISCOVERY, PHASE_PARSING, PHASE_BUILDING, PHASE_RUNNING}
+
+var (
+	timingsMu sync.Mutex
+	timings   = make(map[string]time.Duration)
+	startTime = time.Now()
+)
+
+// timePhase adds to the phase the time since start, with the flag
+// -debug-timings. It is used deferred, like:
+//
+//	defer timePhase(PHASE_PARSING, time.Now())
+func timePhase(phase string, start time.Time) {
+	if !*taskDebugTimings {
+		return
+	}
+	d := time.Since(start)
+	timingsMu.Lock()
+	timings[phase] += d
+	timingsMu.Unlock()
+}
+
+// printTimings prints to standard error the time spent in every phase, with the
+// flag -debug-timings. With several packages, the times of every phase are
+// added, so that they can be longer than the whole run.
+func printTimings() {
+	if !*taskDebugTimings {
+		return
+	}
+	timingsMu.Lock()
+	defer timingsMu.Unlock()
+
+	parts := make([]string, 0, len(phases))
+	for _, p := range phases {
+		parts = append(parts, fmt.Sprintf("%s %v", p, timings[p].Round(time.Millisecond)))
+	}
+	fmt.Fprintf(os.Stderr, "gake: timings: %s; total %v\n",
+		strings.Join(parts, ", "), time.Since(startTime).Round(time.Millisecond))
+}
@@ -0,0 +1,123 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"go/build"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// reToolchain matches the name of a Go release, like "go1.21.5" or "go1.22rc1".
+var reToolchain = regexp.MustCompile(`^go1(\.[0-9]+)*((rc|beta)[0-9]+)?$`)
+
+// Go commands found for every toolchain.
+var (
+	toolchainMu sync.Mutex
+	toolchains  = make(map[string]string)
+)
+
+// goTool returns the go command used to build the task files. It is "go" unless
+// the configuration pins a Go release with the key "toolchain"; then, the release
+// is located, or else downloaded, in the manner of golang.org/dl.
+func goTool(c *config) (string, error) {
+	version := c.Get("", "toolchain")
+	if version == "" {
+		return "go", nil
+	}
+	if !reToolchain.MatchString(version) {
+		return "", fmt.Errorf("%s: invalid toolchain %q: want a Go release like \"go1.21.5\"", c.path, version)
+	}
+
+	toolchainMu.Lock()
+	defer toolchainMu.Unlock()
+	if path, ok := toolchains[version]; ok {
+		return path, nil
+	}
+
+	path, err := findToolchain(version)
+	if err != nil {
+		return "", err
+	}
+	toolchains[version] = path
+	return path, nil
+}
+
+// findToolchain returns the go command of the Go release.
+func findToolchain(version string) (string, error) {
+	// The go command in the PATH could be of the wanted release.
+	if out, err := exec.Command("go", "env", "GOVERSION").Output(); err == nil {
+		if strings.TrimSpace(string(out)) == version {
+			return "go", nil
+		}
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", ToolchainError{version, err.Error()}
+	}
+	goBin := "go"
+	if runtime.GOOS == "windows" {
+		goBin += ".exe"
+	}
+	// Where the downloader of golang.org/dl unpacks the release.
+	sdkGo := filepath.Join(home, "sdk", version, "bin", goBin)
+
+	if _, err = os.Stat(sdkGo); err == nil {
+		return sdkGo, nil
+	}
+
+	// Download the release.
+	fmt.Fprintf(os.Stderr, "gake: downloading Go toolchain %s\n", version)
+
+	cmd := exec.Command("go", "install", "golang.org/dl/"+version+"@latest")
+	cmd.Stderr = os.Stderr
+	xtrace("%s", strings.Join(cmd.Args, " "))
+	if err = cmd.Run(); err != nil {
+		return "", ToolchainError{version, err.Error()}
+	}
+
+	downloader, err := exec.LookPath(version)
+	if err != nil {
+		gobin := os.Getenv("GOBIN")
+		if gobin == "" {
+			gobin = filepath.Join(build.Default.GOPATH, "bin")
+		}
+		downloader = filepath.Join(gobin, version)
+	}
+	cmd = exec.Command(downloader, "download")
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	xtrace("%s", strings.Join(cmd.Args, " "))
+	if err = cmd.Run(); err != nil {
+		return "", ToolchainError{version, err.Error()}
+	}
+
+	if _, err = os.Stat(sdkGo); err != nil {
+		return "", ToolchainError{version, err.Error()}
+	}
+	return sdkGo, nil
+}
+
+// ToolchainError reports that the Go release pinned in the configuration is
+// not available.
+type ToolchainError struct {
+	version string
+	msg     string
+}
+
+func (e ToolchainError) Error() string {
+	return fmt.Sprintf("Go toolchain %s is unavailable: %s\n"+
+		"\tinstall it running: go install golang.org/dl/%s@latest && %s download",
+		e.version, e.msg, e.version, e.version)
+}
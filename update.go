@@ -0,0 +1,261 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/tredoe/gake/internal/semver"
+)
+
+// MODULE_PATH is the module path of gake, used to look for its releases.
+const MODULE_PATH = "github.com/tredoe/gake"
+
+var cmdUpdate = &command{
+	Name:      "update",
+	UsageLine: "[-check] [-from url]",
+	Short:     "update gake to its latest release",
+	Long: `Update replaces the gake executable by its latest release.
+
+By default, the release is built with "go install", which verifies the
+module against the checksum database. With -from, the binary is downloaded
+instead from the URL, which is a template with the fields .Version, .GOOS
+and .GOARCH; the file at the URL plus ".sha256" must hold its SHA-256 sum.`,
+	Run: runUpdate,
+}
+
+func runUpdate(cmd *command, args []string) error {
+	fs := cmd.FlagSet()
+	check := fs.Bool("check", false, "only report whether there is a newer release")
+	from := fs.String("from", "", "URL template of the binary to download")
+	fs.Parse(args)
+
+	latest, err := latestVersion()
+	if err != nil {
+		return fmt.Errorf("can't get the latest release: %s", err)
+	}
+	current := gakeVersion()
+	newer, err := isNewerRelease(latest, current)
+	if err != nil {
+		return err
+	}
+	if !newer {
+		fmt.Printf("gake %s is up to date; the latest release, %s, is not newer\n", current, latest)
+		return nil
+	}
+	fmt.Printf("gake %s; the latest release is %s\n", current, latest)
+	if *check {
+		return nil
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+
+	tmpDir, err := os.MkdirTemp("", "gake-update-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	newExe := ""
+	if *from != "" {
+		newExe, err = downloadRelease(*from, latest, tmpDir)
+	} else {
+		newExe, err = installRelease(latest, tmpDir)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = replaceExecutable(exe, newExe); err != nil {
+		return err
+	}
+	fmt.Printf("gake updated to %s\n", latest)
+	return nil
+}
+
+// gakeVersion returns the version of the running gake, as recorded by the
+// go command into the executable.
+func gakeVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "(devel)"
+}
+
+// isNewerRelease reports whether the release latest is newer than the version
+// current, by the precedence of semantic versioning; so a build newer than the
+// latest release, or of a pseudo-version after it, is not replaced. A current
+// version which is not semantic, like "(devel)", is not replaced either.
+func isNewerRelease(latest, current string) (bool, error) {
+	last, err := semver.Parse(latest)
+	if err != nil {
+		return false, fmt.Errorf("invalid latest release %q: %s", latest, err)
+	}
+	cur, err := semver.Parse(current)
+	if err != nil {
+		return false, nil
+	}
+	return last.Compare(cur) > 0, nil
+}
+
+// latestVersion returns the latest release of gake, asking to the module proxy.
+func latestVersion() (string, error) {
+	out, err := exec.Command("go", "env", "GOPROXY").Output()
+	if err != nil {
+		return "", err
+	}
+	proxy := "https://proxy.golang.org"
+	for _, p := range strings.FieldsFunc(strings.TrimSpace(string(out)), func(r rune) bool {
+		return r == ',' || r == '|'
+	}) {
+		if p != "direct" && p != "off" {
+			proxy = strings.TrimSuffix(p, "/")
+			break
+		}
+	}
+
+	body, err := httpGet(proxy + "/" + MODULE_PATH + "/@latest")
+	if err != nil {
+		return "", err
+	}
+	var info struct{ Version string }
+	if err = json.Unmarshal(body, &info); err != nil {
+		return "", err
+	}
+	if info.Version == "" {
+		return "", fmt.Errorf("no version in the proxy response")
+	}
+	return info.Version, nil
+}
+
+// installRelease builds the given release into dir using "go install".
+func installRelease(version, dir string) (string, error) {
+	cmd := exec.Command("go", "install", MODULE_PATH+"@"+version)
+	cmd.Env = append(os.Environ(), "GOBIN="+dir)
+	cmd.Stderr = os.Stderr
+	xtrace("GOBIN=%s %s", dir, strings.Join(cmd.Args, " "))
+	if err := cmd.Run(); err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, gakeExeName()), nil
+}
+
+// downloadRelease downloads the given release into dir, from the URL given by
+// the template urlTmpl, and checks its SHA-256 sum.
+func downloadRelease(urlTmpl, version, dir string) (string, error) {
+	tmpl, err := template.New("url").Parse(urlTmpl)
+	if err != nil {
+		return "", err
+	}
+	buf := new(bytes.Buffer)
+	err = tmpl.Execute(buf, map[string]string{
+		"Version": version,
+		"GOOS":    runtime.GOOS,
+		"GOARCH":  runtime.GOARCH,
+	})
+	if err != nil {
+		return "", err
+	}
+	url := buf.String()
+
+	bin, err := httpGet(url)
+	if err != nil {
+		return "", err
+	}
+	sum, err := httpGet(url + ".sha256")
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(string(sum))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("%s.sha256: empty checksum", url)
+	}
+	hash := sha256.Sum256(bin)
+	if got := hex.EncodeToString(hash[:]); !strings.EqualFold(got, fields[0]) {
+		return "", fmt.Errorf("%s: checksum mismatch: got %s, want %s", url, got, fields[0])
+	}
+
+	exe := filepath.Join(dir, gakeExeName())
+	return exe, os.WriteFile(exe, bin, 0755)
+}
+
+// gakeExeName returns the name of the gake executable, with the suffix of the
+// executables on Windows. Unlike BIN_NAME, which is the name of the task binary.
+func gakeExeName() string {
+	if runtime.GOOS == "windows" {
+		return "gake.exe"
+	}
+	return "gake"
+}
+
+// replaceExecutable replaces the executable exe by newExe.
+func replaceExecutable(exe, newExe string) error {
+	bin, err := os.ReadFile(newExe)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(exe)
+	if err != nil {
+		return err
+	}
+
+	// Write the new executable beside the old one, so the rename is atomic.
+	tmp := exe + ".new"
+	if err = os.WriteFile(tmp, bin, info.Mode().Perm()); err != nil {
+		return err
+	}
+	// Windows does not allow to overwrite a running executable, but to rename it.
+	if runtime.GOOS == "windows" {
+		old := exe + ".old"
+		os.Remove(old)
+		if err = os.Rename(exe, old); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+	}
+	if err = os.Rename(tmp, exe); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// httpGet returns the body got from the URL.
+func httpGet(url string) ([]byte, error) {
+	client := &http.Client{Timeout: 5 * time.Minute}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
@@ -0,0 +1,115 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// taskingSrc holds the source of the package tasking, and of the packages which
+// it imports, so that the tasks can be built without fetching it.
+//
+//go:embed tasking/*.go internal/pty/*.go
+var taskingSrc embed.FS
+
+var moduleRe = regexp.MustCompile(`(?m)^module\s+"?([^\s"]+)"?`)
+
+// vendorTasking writes the package tasking of this gake into the work directory,
+// and a copy of the go.mod of the tasks which requires it from there, like with
+// a "replace" directive. It returns the arguments to add to "go build".
+//
+// The tasks into the module of gake already build its package tasking.
+func vendorTasking(pkg *taskPackage, workDir string) ([]string, error) {
+	if pkg.TaskingPath != MODULE_PATH+"/tasking" {
+		return nil, fmt.Errorf("can't vendor the package tasking imported as %q", pkg.TaskingPath)
+	}
+
+	gomod := ""
+	cmd := exec.Command(pkg.GoCmd, "env", "GOMOD")
+	cmd.Dir = pkg.Dir
+	if out, err := cmd.Output(); err == nil {
+		if gomod = strings.TrimSpace(string(out)); gomod == os.DevNull {
+			gomod = ""
+		}
+	}
+
+	var args []string
+	modFile := filepath.Join(workDir, "go.mod")
+	modData := []byte("module gake.task\n\ngo 1.16\n")
+
+	if gomod != "" {
+		data, err := os.ReadFile(gomod)
+		if err != nil {
+			return nil, err
+		}
+		if m := moduleRe.FindSubmatch(data); m != nil && string(m[1]) == MODULE_PATH {
+			return nil, nil
+		}
+		modFile, modData = filepath.Join(workDir, "gake.mod"), data
+
+		// The checksums of the other modules are kept.
+		sum, err := os.ReadFile(strings.TrimSuffix(gomod, ".mod") + ".sum")
+		if err == nil {
+			err = os.WriteFile(strings.TrimSuffix(modFile, ".mod")+".sum", sum, 0644)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		args = append(args, "-modfile="+modFile)
+
+		// The vendor directory has not the package tasking of this gake.
+		if _, err = os.Stat(filepath.Join(filepath.Dir(gomod), "vendor")); err == nil && *taskMod == "" {
+			args = append(args, "-mod=mod")
+		}
+	}
+	if err := os.WriteFile(modFile, modData, 0644); err != nil {
+		return nil, err
+	}
+
+	// The module of gake, with the embedded packages.
+	srcDir := filepath.Join(workDir, "_gake")
+	xtrace("mkdir -p $WORK%c_gake # internal: package tasking of gake", os.PathSeparator)
+	err := fs.WalkDir(taskingSrc, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(srcDir, filepath.FromSlash(path))
+		if d.IsDir() {
+			return os.MkdirAll(dst, 0755)
+		}
+		data, err := taskingSrc.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(dst, data, 0644)
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = os.WriteFile(filepath.Join(srcDir, "go.mod"), []byte("module "+MODULE_PATH+"\n\ngo 1.16\n"), 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd = exec.Command(pkg.GoCmd, "mod", "edit", "-modfile="+modFile,
+		"-require="+MODULE_PATH+"@v0.0.0-00010101000000-000000000000",
+		"-replace="+MODULE_PATH+"="+srcDir,
+	)
+	cmd.Dir = workDir
+	xtrace("%s", strings.Join(cmd.Args, " "))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("%s: %s", err, strings.TrimSpace(string(out)))
+	}
+	return args, nil
+}
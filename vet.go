@@ -0,0 +1,327 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"sort"
+	"strings"
+)
+
+var cmdVet = &command{
+	Name:      "vet",
+	UsageLine: "[dir]",
+	Short:     "report likely mistakes in the task files",
+	Long: `Vet examines the task files into the directory, by default the current one,
+and reports the constructs which are likely mistakes:
+
+	- a task which never uses its *tasking.T;
+	- a call to Parallel after other work, or into a block, since the task
+	  is not run in parallel until then;
+	- a temporary directory or file, created by os.MkdirTemp, os.CreateTemp
+	  or their ioutil versions, which is not removed by a deferred call;
+	- a task which is never run, since it depends on a dependency cycle;
+	- a task or resource repeated into the "gake:deps" or "gake:mutex"
+	  directives of a task.
+
+Every problem is printed with its file and line, and the exit status is 1 if
+any is found, so that it can be used in CI.`,
+	Run: runVet,
+}
+
+// vetProblem is a problem found by "gake vet".
+type vetProblem struct {
+	pos token.Position
+	msg string
+}
+
+// parallelPreamble are the methods of T which can be called before Parallel,
+// since they configure how the task is run.
+var parallelPreamble = map[string]bool{
+	"Serialize":   true,
+	"SetWeight":   true,
+	"LimitCPU":    true,
+	"LimitMemory": true,
+	"SetNice":     true,
+	"SetMeta":     true,
+	"Skip":        true,
+	"Skipf":       true,
+	"SkipNow":     true,
+}
+
+// tempFuncs are the functions which create temporary files, by package.
+var tempFuncs = map[string][]string{
+	"os":     {"MkdirTemp", "CreateTemp"},
+	"ioutil": {"TempDir", "TempFile"},
+}
+
+func runVet(cmd *command, args []string) error {
+	fs := cmd.FlagSet()
+	fs.Parse(args)
+
+	dir := "."
+	if fs.NArg() > 0 {
+		dir = fs.Arg(0)
+	}
+	dir, err := resolveDir(dir)
+	if err != nil {
+		return err
+	}
+	pkg, err := ParseDir(dir)
+	if err != nil {
+		return err
+	}
+
+	problems := make([]vetProblem, 0)
+	report := func(pos token.Position, format string, args ...interface{}) {
+		problems = append(problems, vetProblem{pos, fmt.Sprintf(format, args...)})
+	}
+
+	fset := token.NewFileSet()
+	for _, f := range pkg.Files {
+		file, err := parser.ParseFile(fset, f.Name, nil, 0)
+		if err != nil {
+			return err
+		}
+		isTask := make(map[string]bool, len(f.TaskFuncs))
+		for _, task := range f.TaskFuncs {
+			isTask[task.Name] = true
+		}
+
+		for _, decl := range file.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Recv != nil || !isTask[fn.Name.Name] || fn.Body == nil {
+				continue
+			}
+			vetTaskFunc(fset, fn, report)
+		}
+	}
+
+	for _, task := range pkg.taskFuncs() {
+		pos := token.Position{Filename: task.File, Line: task.Line}
+		if dup := duplicates(task.Deps); len(dup) != 0 {
+			report(pos, "%s: repeated into gake:deps: %s", task.Name, strings.Join(dup, ", "))
+		}
+		if dup := duplicates(task.Mutexes); len(dup) != 0 {
+			report(pos, "%s: repeated into gake:mutex: %s", task.Name, strings.Join(dup, ", "))
+		}
+	}
+	for _, u := range pkg.unrunnable() {
+		pos := token.Position{Filename: u.task.File, Line: u.task.Line}
+		if u.cycle == u.task.Name {
+			report(pos, "%s: never run, since it is into a dependency cycle", u.task.Name)
+		} else {
+			report(pos, "%s: never run, since it depends on the dependency cycle through %s", u.task.Name, u.cycle)
+		}
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool {
+		a, b := problems[i].pos, problems[j].pos
+		if a.Filename != b.Filename {
+			return a.Filename < b.Filename
+		}
+		return a.Line < b.Line
+	})
+	for _, p := range problems {
+		fmt.Printf("%s:%d: %s\n", p.pos.Filename, p.pos.Line, p.msg)
+	}
+	if len(problems) != 0 {
+		return fmt.Errorf("%d problems found", len(problems))
+	}
+	return nil
+}
+
+// vetTaskFunc checks the body of a task function.
+func vetTaskFunc(fset *token.FileSet, fn *ast.FuncDecl, report func(token.Position, string, ...interface{})) {
+	name := fn.Name.Name
+
+	// The parameter is resolved by the parser, so its uses share the object.
+	var param *ast.Object
+	if names := fn.Type.Params.List[0].Names; len(names) != 0 && names[0].Name != "_" {
+		param = names[0].Obj
+	}
+	if param == nil || !usesObject(fn.Body, param) {
+		report(fset.Position(fn.Pos()), "%s: never uses its *tasking.T", name)
+		return
+	}
+
+	// Parallel.
+	ast.Inspect(fn.Body, func(n ast.Node) bool {
+		if call, ok := n.(*ast.CallExpr); ok && isMethodCall(call, param, "Parallel") {
+			if !parallelFirst(fn.Body, call, param) {
+				report(fset.Position(call.Pos()), "%s: Parallel called after other work; call it first", name)
+			}
+		}
+		return true
+	})
+
+	// Temporary files.
+	removed := false
+	ast.Inspect(fn.Body, func(n ast.Node) bool {
+		if d, ok := n.(*ast.DeferStmt); ok {
+			ast.Inspect(d.Call, func(n ast.Node) bool {
+				if call, ok := n.(*ast.CallExpr); ok && (isPkgCall(call, "os", "RemoveAll") || isPkgCall(call, "os", "Remove")) {
+					removed = true
+				}
+				return !removed
+			})
+		}
+		return !removed
+	})
+	if removed {
+		return
+	}
+	ast.Inspect(fn.Body, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		for pkg, funcs := range tempFuncs {
+			for _, f := range funcs {
+				if isPkgCall(call, pkg, f) {
+					report(fset.Position(call.Pos()), "%s: %s.%s creates a temporary file which is never removed; add a deferred call to os.RemoveAll",
+						name, pkg, f)
+				}
+			}
+		}
+		return true
+	})
+}
+
+// usesObject reports whether the node has an identifier of the object.
+func usesObject(node ast.Node, obj *ast.Object) bool {
+	found := false
+	ast.Inspect(node, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok && id.Obj == obj {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// isMethodCall reports whether the call is recv.method().
+func isMethodCall(call *ast.CallExpr, recv *ast.Object, method string) bool {
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok || sel.Sel.Name != method {
+		return false
+	}
+	id, ok := sel.X.(*ast.Ident)
+	return ok && id.Obj == recv
+}
+
+// isPkgCall reports whether the call is pkg.name(), where pkg is an imported
+// package, not a local identifier.
+func isPkgCall(call *ast.CallExpr, pkg, name string) bool {
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok || sel.Sel.Name != name {
+		return false
+	}
+	id, ok := sel.X.(*ast.Ident)
+	return ok && id.Name == pkg && id.Obj == nil
+}
+
+// parallelFirst reports whether the call to Parallel is a statement of the body,
+// preceded only by calls to the methods of parallelPreamble.
+func parallelFirst(body *ast.BlockStmt, parallel *ast.CallExpr, t *ast.Object) bool {
+	for _, stmt := range body.List {
+		expr, ok := stmt.(*ast.ExprStmt)
+		if !ok {
+			return false
+		}
+		if expr.X == parallel {
+			return true
+		}
+		call, ok := expr.X.(*ast.CallExpr)
+		if !ok {
+			return false
+		}
+		preamble := false
+		for method := range parallelPreamble {
+			if isMethodCall(call, t, method) {
+				preamble = true
+				break
+			}
+		}
+		if !preamble {
+			return false
+		}
+	}
+	return false
+}
+
+// duplicates returns the values which are repeated into the list.
+func duplicates(list []string) []string {
+	seen := make(map[string]int, len(list))
+	dup := make([]string, 0)
+	for _, v := range list {
+		if seen[v]++; seen[v] == 2 {
+			dup = append(dup, v)
+		}
+	}
+	return dup
+}
+
+// unrunnableTask is a task which is never run.
+type unrunnableTask struct {
+	task  taskFunc
+	cycle string // A task of the cycle.
+}
+
+// unrunnable returns the tasks which are into a dependency cycle, or depend on
+// one, so they are never run.
+func (p *taskPackage) unrunnable() []unrunnableTask {
+	tasks := p.taskFuncs()
+	index := make(map[string]int, len(tasks))
+	for i, task := range tasks {
+		index[task.Name] = i
+	}
+
+	// A task is into a cycle if it is reached from itself.
+	reaches := func(from, to int) bool {
+		seen := make([]bool, len(tasks))
+		stack := []int{from}
+		for len(stack) != 0 {
+			i := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			for _, dep := range tasks[i].Deps {
+				j, ok := index[dep]
+				if !ok || seen[j] {
+					continue
+				}
+				if j == to {
+					return true
+				}
+				seen[j] = true
+				stack = append(stack, j)
+			}
+		}
+		return false
+	}
+	inCycle := make([]bool, len(tasks))
+	for i := range tasks {
+		inCycle[i] = reaches(i, i)
+	}
+
+	list := make([]unrunnableTask, 0)
+	for i, task := range tasks {
+		if inCycle[i] {
+			list = append(list, unrunnableTask{task, task.Name})
+			continue
+		}
+		for j := range tasks {
+			if inCycle[j] && reaches(i, j) {
+				list = append(list, unrunnableTask{task, tasks[j].Name})
+				break
+			}
+		}
+	}
+	return list
+}
@@ -0,0 +1,118 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SUBDIR_GOCACHE is the directory, into the cache of gake, of the build cache
+// used when the one of Go is disabled or it can not be located.
+const SUBDIR_GOCACHE = "go-build"
+
+var cmdWarm = &command{
+	Name:      "warm",
+	UsageLine: "[dir]",
+	Short:     "compile the package tasking into the build cache",
+	Long: `Warm compiles the package tasking imported by the task files into the
+directory, by default the current one, and its dependencies, so that they are
+into the build cache of Go; then, the builds of the task binaries only compile
+the task files and link them. It is run like the builds, with the flags -mod
+and -reproducible, so that they find the same objects; like into the image of
+a CI, or after a new release of Go or gake.
+
+The build cache is the one of Go, shared by all the builds. When it is disabled
+by GOCACHE=off, or it can not be located since HOME is not set, like in some
+services, gake uses instead "` + SUBDIR_GOCACHE + `" into its cache directory.`,
+	Run: runWarm,
+}
+
+func runWarm(cmd *command, args []string) error {
+	fs := cmd.FlagSet()
+	fs.Parse(args)
+
+	dir := "."
+	if fs.NArg() > 0 {
+		dir = fs.Arg(0)
+	}
+	dir, err := resolveDir(dir)
+	if err != nil {
+		return err
+	}
+
+	home, err := gakeHome()
+	if err != nil {
+		return err
+	}
+	cfg, err := loadConfig(dir)
+	if err != nil {
+		return err
+	}
+	pkg, err := ParseDir(dir)
+	if err != nil {
+		return err
+	}
+	if pkg.GoCmd, err = goTool(cfg); err != nil {
+		return err
+	}
+	pkg.BuildInfo = newBuildInfo(pkg.GoCmd)
+	pkg.GoCache = goCache(pkg.GoCmd, home)
+
+	start := time.Now()
+	if err = warmTasking(pkg); err != nil {
+		return err
+	}
+	fmt.Printf("%s compiled in %v\n", pkg.TaskingPath, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// warmTasking compiles the package tasking of the task files into the build
+// cache, with the flags used to build the task binary.
+func warmTasking(pkg *taskPackage) error {
+	cmd := exec.Command(pkg.GoCmd, "build", "--tags", "gake")
+	if *taskMod != "" {
+		cmd.Args = append(cmd.Args, "-mod="+*taskMod)
+	}
+	if *taskReproducible {
+		cmd.Args = append(cmd.Args, reproducibleArgs(pkg.BuildInfo.GoVersion)...)
+	}
+	cmd.Args = append(cmd.Args, pkg.TaskingPath)
+	cmd.Dir = pkg.Dir
+	cmd.Env = goCacheEnv(pkg.GoCache)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	xtrace("cd %s\n%s", pkg.Dir, strings.Join(cmd.Args, " "))
+	return cmd.Run()
+}
+
+// goCache returns the build cache to give to the go command, which is "" when
+// Go has its own one. Without it, like with GOCACHE=off or without HOME, every
+// build would compile again the package tasking and its dependencies; so the
+// directory SUBDIR_GOCACHE into home is used instead.
+func goCache(goCmd, home string) string {
+	out, err := exec.Command(goCmd, "env", "GOCACHE").Output()
+	if err == nil {
+		if v := strings.TrimSpace(string(out)); v != "" && v != "off" {
+			return ""
+		}
+	}
+	return filepath.Join(home, SUBDIR_GOCACHE)
+}
+
+// goCacheEnv returns the environment of the go command with the build cache, or
+// nil to inherit the one of gake.
+func goCacheEnv(cache string) []string {
+	if cache == "" {
+		return nil
+	}
+	return append(os.Environ(), "GOCACHE="+cache)
+}
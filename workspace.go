@@ -0,0 +1,245 @@
+// Copyright 2014 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// WORK_FILE is the name of the file of a Go workspace.
+const WORK_FILE = "go.work"
+
+// ErrNoWorkFile is returned by -workspace when there is not a go.work file.
+var ErrNoWorkFile = errors.New("no " + WORK_FILE + " file found")
+
+// pkgTarget is a task package of a run of several ones.
+type pkgTarget struct {
+	dir   string
+	label string   // Prefix of its output and name into the summary.
+	args  []string // Arguments of the task binary; nil for the ones of the command line.
+}
+
+// dirTargets returns the targets of the directories, labeled by themselves.
+func dirTargets(dirs []string) []pkgTarget {
+	targets := make([]pkgTarget, len(dirs))
+	for i, dir := range dirs {
+		targets[i] = pkgTarget{dir: dir, label: dir}
+	}
+	return targets
+}
+
+// workspaceTargets returns the task packages of every module of the Go
+// workspace which holds the directory. They are labeled by the path of their
+// module and their directory into it, like "example.com/api/proto", which is
+// the prefix of their tasks into -names, like "example.com/api/proto:TaskGenerate";
+// so every package only runs its tasks, and the names without prefix.
+func workspaceTargets(dir string) ([]pkgTarget, error) {
+	work, err := findWorkFile(dir)
+	if err != nil {
+		return nil, err
+	}
+	modules, err := parseWorkFile(work)
+	if err != nil {
+		return nil, err
+	}
+
+	names := taskValues.string("names")
+	targets := make([]pkgTarget, 0)
+	seen := make(map[string]bool)
+	for _, mod := range modules {
+		modPath, err := modulePath(mod)
+		if err != nil {
+			return nil, err
+		}
+		dirs, err := findTaskDirs(mod)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range dirs {
+			if seen[d] || inNestedModule(mod, d) {
+				continue
+			}
+			seen[d] = true
+
+			t := pkgTarget{dir: d, label: modPath}
+			if rel, err := filepath.Rel(mod, d); err == nil && rel != "." {
+				t.label += "/" + filepath.ToSlash(rel)
+			}
+			if names != "" {
+				own := packageNames(names, t.label)
+				if own == "" {
+					continue
+				}
+				t.args = taskArgsOf(taskValues.with("names", own))
+			}
+			targets = append(targets, t)
+		}
+	}
+	if len(targets) == 0 {
+		if names != "" {
+			return nil, fmt.Errorf("no task package of the workspace %s has the tasks of -names", work)
+		}
+		return nil, ErrNoTaskfile
+	}
+	return targets, nil
+}
+
+// packageNames returns the names of -names to pass to the package with the
+// label: the ones prefixed by it, without the prefix, and the ones without
+// prefix.
+func packageNames(names, label string) string {
+	own := make([]string, 0)
+	for _, n := range strings.Split(names, ",") {
+		i := strings.LastIndexByte(n, ':')
+		switch {
+		case i == -1:
+			own = append(own, n)
+		case n[:i] == label:
+			own = append(own, n[i+1:])
+		}
+	}
+	return strings.Join(own, ",")
+}
+
+// findWorkFile returns the go.work file given by GOWORK, or else the one into
+// the directory or its parents.
+func findWorkFile(dir string) (string, error) {
+	switch gowork := os.Getenv("GOWORK"); gowork {
+	case "off":
+		return "", fmt.Errorf("%s: workspace mode is disabled by GOWORK=off", ErrNoWorkFile)
+	case "":
+	default:
+		return filepath.Abs(gowork)
+	}
+
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for {
+		path := filepath.Join(dir, WORK_FILE)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", ErrNoWorkFile
+		}
+		dir = parent
+	}
+}
+
+// parseWorkFile returns the absolute directories of the modules given by the
+// "use" directives of the go.work file.
+func parseWorkFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	base := filepath.Dir(path)
+	modules := make([]string, 0)
+	inUse := false // Into a block "use (...)".
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := s.Text()
+		if i := strings.Index(line, "//"); i != -1 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+
+		var dir string
+		switch {
+		case line == "":
+			continue
+		case inUse:
+			if line == ")" {
+				inUse = false
+				continue
+			}
+			dir = line
+		case line == "use (" || line == "use(":
+			inUse = true
+			continue
+		case strings.HasPrefix(line, "use ") || strings.HasPrefix(line, "use\t"):
+			dir = strings.TrimSpace(line[len("use"):])
+		default:
+			continue
+		}
+
+		if uq, err := strconv.Unquote(dir); err == nil {
+			dir = uq
+		}
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(base, filepath.FromSlash(dir))
+		}
+		modules = append(modules, filepath.Clean(dir))
+	}
+	if err = s.Err(); err != nil {
+		return nil, err
+	}
+	if len(modules) == 0 {
+		return nil, fmt.Errorf("%s: no module in \"use\" directives", path)
+	}
+	return modules, nil
+}
+
+// modulePath returns the path of the module declared by the go.mod file of the
+// directory.
+func modulePath(dir string) (string, error) {
+	path := filepath.Join(dir, "go.mod")
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if i := strings.Index(line, "//"); i != -1 {
+			line = strings.TrimSpace(line[:i])
+		}
+		if !strings.HasPrefix(line, "module") {
+			continue
+		}
+		mod := strings.TrimSpace(line[len("module"):])
+		if uq, err := strconv.Unquote(mod); err == nil {
+			mod = uq
+		}
+		if mod != "" {
+			return mod, nil
+		}
+	}
+	if err = s.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("%s: no module directive", path)
+}
+
+// inNestedModule reports whether the directory, into the one of a module, is
+// into another module, which has its own go.mod file.
+func inNestedModule(mod, dir string) bool {
+	for dir != mod {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	return false
+}